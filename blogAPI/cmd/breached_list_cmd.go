@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/passpolicy"
+)
+
+const breachedListUsage = `usage: blogAPI breached-list (-hashes FILE | -passwords FILE) -out FILE [-width N]
+
+Builds the file read by password.breached_list. -hashes takes one
+uppercase or lowercase SHA-1 per line, optionally followed by :count, in
+ascending order, as in the ordered-by-hash download of Have I Been Pwned.
+-passwords takes one plaintext password per line in any order.`
+
+// runBreachedListCommand implements `blogAPI breached-list` and returns the
+// process exit code.
+func runBreachedListCommand(args []string) int {
+	fs := flag.NewFlagSet("breached-list", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, breachedListUsage)
+		fs.PrintDefaults()
+	}
+	hashes := fs.String("hashes", "", "file of sorted SHA-1 hashes")
+	passwords := fs.String("passwords", "", "file of plaintext passwords")
+	out := fs.String("out", "", "list file to write")
+	width := fs.Int("width", passpolicy.DefaultWidth, "bytes of each hash to keep")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *out == "" || (*hashes == "") == (*passwords == "") {
+		fs.Usage()
+		return 2
+	}
+
+	var count int64
+	err := writeFileAtomic(*out, func(w io.Writer) error {
+		lw, err := passpolicy.NewListWriter(w, *width)
+		if err != nil {
+			return err
+		}
+		if *hashes != "" {
+			err = addHashes(lw, *hashes)
+		} else {
+			err = addPasswords(lw, *passwords)
+		}
+		if err != nil {
+			return err
+		}
+		count = lw.Count()
+		return lw.Flush()
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error building breached-password list:", err)
+		return 1
+	}
+	fmt.Printf("wrote %d entries to %s\n", count, *out)
+	return 0
+}
+
+func addHashes(lw *passpolicy.ListWriter, path string) error {
+	return eachLine(path, func(n int, line string) error {
+		hash, _, _ := strings.Cut(line, ":")
+		decoded, err := hex.DecodeString(hash)
+		if err != nil || len(decoded) != sha1.Size {
+			return fmt.Errorf("%s:%d: not a SHA-1 hash", path, n)
+		}
+		var sum [sha1.Size]byte
+		copy(sum[:], decoded)
+		if err := lw.Add(sum); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		return nil
+	})
+}
+
+// addPasswords hashes and sorts the whole file in memory, which is fine for
+// wordlists of tens of millions of passwords.
+func addPasswords(lw *passpolicy.ListWriter, path string) error {
+	var sums [][sha1.Size]byte
+	err := eachLine(path, func(_ int, line string) error {
+		sums = append(sums, sha1.Sum([]byte(line)))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	passpolicy.SortHashes(sums)
+	for _, sum := range sums {
+		if err := lw.Add(sum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachLine calls fn with every non-empty line of the file at path, without
+// its line ending.
+func eachLine(path string, fn func(n int, line string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if err := fn(n, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// writeFileAtomic writes path through a temporary file that replaces it
+// only once write succeeds, so a running server never sees half a list.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".breached-list-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
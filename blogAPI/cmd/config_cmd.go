@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+)
+
+const configUsage = `usage: blogAPI config print [--redacted] [config flags]`
+
+// runConfigCommand implements `blogAPI config ...` and returns the process
+// exit code.
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	redact := fs.Bool("redacted", false, "replace secrets with a placeholder")
+	cfg, err := config.LoadWithFlags(fs, args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *redact {
+		cfg = cfg.Redacted()
+	}
+
+	out, err := cfg.YAML()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error rendering config:", err)
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
@@ -1,76 +1,410 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"errors"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/anoying-kid/go-apps/blogAPI/internal/handlers"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/health"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/media"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/metrics"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/middleware"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/migrations"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/outbox"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/passhash"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/passpolicy"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/privacy"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/secretbox"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/slug"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/subscribers"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/views"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/webhook"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/worker"
 	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/utils"
 
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
 )
 
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "config":
+			os.Exit(runConfigCommand(args[1:]))
+		case "migrate":
+			os.Exit(runMigrateCommand(args[1:]))
+		case "breached-list":
+			os.Exit(runBreachedListCommand(args[1:]))
+		case "serve":
+			args = args[1:]
+		}
+	}
+	serve(args)
+}
 
-	cfg, err := config.LoadConfig()
+func serve(args []string) {
+	cfg, err := config.Load(args)
 	if err != nil {
-		log.Fatal("Error loading config:", err)
+		log.Fatal("Error loading config: ", err)
 	}
 
-	// dsn := "host=localhost user=postgres password=mysecretpassword dbname=userdb port=5432 sslmode=disable"
-	dbURL := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Database.Host,
-		cfg.Database.Port,
-		cfg.Database.User,
-		cfg.Database.Password,
-		cfg.Database.DBName,
-		cfg.Database.SSLMode,
-	)
+	logger := setupLogger(cfg)
 
-	db, err := sql.Open("postgres", dbURL)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
 	if err != nil {
-		log.Fatal("Fail to connect to the database: ", err)
+		fatal("failed to set up tracing", err)
 	}
-	// Test the connection
-	err = db.Ping()
+
+	middleware.SetSecret(cfg.JWT.Secret)
+	utils.SetPasswordHasher(passwordHasher(cfg.Password))
+
+	policy, err := passwordPolicy(cfg.Password)
 	if err != nil {
-		log.Fatal("Failed to ping database:", err)
+		fatal("failed to load the password policy", err)
 	}
+	if policy.Breached != nil {
+		defer policy.Breached.Close()
+		logger.Info("loaded breached-password list", "entries", policy.Breached.Len())
+	}
+	utils.SetPasswordPolicy(policy)
 
-	log.Println("Successfully connected to database")
-	defer db.Close()
+	db, err := openDB(cfg.Database)
+	if err != nil {
+		fatal("failed to connect to the database", err)
+	}
+	logger.Info("connected to database", "host", cfg.Database.Host, "name", cfg.Database.DBName)
+
+	if cfg.Database.AutoMigrate {
+		if err := migrations.Up(context.Background(), db); err != nil {
+			fatal("failed to apply migrations", err)
+		}
+	}
+
+	// Readiness checks; /readyz also flips to not-ready on shutdown
+	checks := health.New()
+	checks.AddCheck(health.Check{Name: "database", Func: health.DatabaseCheck(db), Timeout: cfg.Health.CheckTimeout})
+	checks.AddCheck(health.Check{Name: "migrations", Func: migrations.Check(db), Timeout: cfg.Health.CheckTimeout})
+	checks.AddCheck(health.Check{
+		Name:     "mailer",
+		Func:     health.SMTPCheck(net.JoinHostPort(cfg.Email.Host, strconv.Itoa(cfg.Email.Port))),
+		Timeout:  cfg.Health.CheckTimeout,
+		Optional: !cfg.Health.RequireMailer,
+	})
+
+	// Background workers are stopped after the server has drained
+	workers := worker.NewGroup()
 
 	// Initialize repositories and handlers
 	userRepo := repository.NewUserRepository(db)
-	userHandler := handlers.NewUserHandler(userRepo)
+	loginRepo := repository.NewLoginHistoryRepository(db)
+	unlockRepo := repository.NewAccountUnlockRepository(db)
+	mfaBox, err := secretbox.New(cfg.MFA.EncryptionKey)
+	if err != nil {
+		fatal("failed to set up MFA secret encryption", err)
+	}
+	mfaRepo := repository.NewMFARepository(db, mfaBox)
+	webhookBox, err := secretbox.New(cfg.Webhooks.EncryptionKey)
+	if err != nil {
+		fatal("failed to set up webhook secret encryption", err)
+	}
+	webhookRepo := repository.NewWebhookRepository(db, webhookBox)
+	webhooks := webhook.NewDispatcher(webhookRepo, *cfg)
+	workers.Go(webhooks.Run)
+	webhookHandler := handlers.NewWebhookHandler(userRepo, webhookRepo, webhooks)
+	userHandler := handlers.NewUserHandler(userRepo, loginRepo, unlockRepo, mfaRepo, *cfg)
+	mfaHandler := handlers.NewMFAHandler(userRepo, mfaRepo, *cfg)
+	emailChangeRepo := repository.NewEmailChangeRepository(db)
+	profileHandler := handlers.NewProfileHandler(userHandler, userRepo, emailChangeRepo, *cfg)
+	tokenRepo := repository.NewAccessTokenRepository(db)
+	tokenHandler := handlers.NewAccessTokenHandler(tokenRepo)
+	middleware.SetTokenAuthenticator(tokenHandler)
+	oauthRepo := repository.NewOAuthRepository(db)
+	oauthHandler := handlers.NewOAuthHandler(oauthRepo, *cfg)
+	middleware.SetOAuthTokenChecker(oauthHandler)
+	identityRepo := repository.NewIdentityRepository(db)
+	oidcHandler := handlers.NewOIDCHandler(userHandler, userRepo, identityRepo, discoverOIDCProviders(cfg.OIDC, logger), *cfg)
+
+	blobs, err := newBlobStore(cfg.Media)
+	if err != nil {
+		fatal("failed to set up media storage", err)
+	}
+	views.SetMediaBaseURL(cfg.Media.BaseURL)
+	views.SetPermalinkScheme(cfg.Posts.Permalinks)
+	mediaRepo := repository.NewMediaRepository(db)
+	mediaProcessor := media.NewProcessor(mediaRepo, blobs, *cfg)
+	workers.Go(mediaProcessor.Run)
+	mediaHandler := handlers.NewMediaHandler(mediaRepo, blobs, mediaProcessor, *cfg)
 
 	postRepo := repository.NewPostRepository(db)
-	postHandler := handlers.NewPostHandler(postRepo)
+	reactionRepo := repository.NewReactionRepository(db)
+	postHandler := handlers.NewPostHandler(postRepo, reactionRepo, mediaRepo)
+	reactionHandler := handlers.NewReactionHandler(postRepo, reactionRepo, repository.NewBookmarkRepository(db), mediaRepo)
+	seoHandler := handlers.NewSEOHandler(postRepo, *cfg)
+	followRepo := repository.NewFollowRepository(db)
+	followHandler := handlers.NewFollowHandler(userRepo, followRepo)
+
+	magicRepo := repository.NewMagicLinkRepository(db)
+	magicHandler := handlers.NewMagicLinkHandler(userHandler, userRepo, magicRepo, *cfg)
 
 	resetRepo := repository.NewPasswordResetRepository(db)
+	// Side effects of changes run from the outbox once they commit
+	bus := outbox.NewDispatcher(repository.NewOutboxRepository(db), *cfg)
+	subscribers.New(userRepo, postRepo, mediaRepo, resetRepo, magicRepo, webhooks, *cfg).Register(bus)
+	workers.Go(bus.Run)
 	resetHandler := handlers.NewPasswordResetHandler(userRepo, resetRepo, *cfg)
 
+	exportRepo := repository.NewDataExportRepository(db)
+	privacyHandler := handlers.NewPrivacyHandler(userRepo, exportRepo, *cfg)
+	workers.Go(privacy.NewJobs(userRepo, postRepo, loginRepo, exportRepo, *cfg).Run)
+
 	// Setup router
 	r := mux.NewRouter()
+	r.Use(middleware.RouteTemplate, middleware.Tracing(cfg.Tracing.ServiceName), middleware.TraceLogger)
+
+	r.HandleFunc("/healthz", checks.Liveness).Methods("GET")
+	r.HandleFunc("/readyz", checks.Readiness).Methods("GET")
+	r.HandleFunc("/robots.txt", seoHandler.Robots).Methods("GET")
+	r.HandleFunc("/sitemap.xml", seoHandler.SitemapIndex).Methods("GET")
+	r.HandleFunc("/sitemaps/posts-{first:[0-9]+}.xml", seoHandler.PostsSitemap).Methods("GET")
+
+	// Throttle the endpoints that attract credential stuffing and email
+	// flooding
+	limits := newRateLimits(cfg.RateLimit, db, workers)
+
+	r.HandleFunc("/api/register", limits.register(userHandler.Register)).Methods("POST")
+	r.HandleFunc("/api/login", limits.login(userHandler.Login)).Methods("POST")
+	r.HandleFunc("/api/login/mfa", limits.loginMFA(userHandler.LoginMFA)).Methods("POST")
+	r.HandleFunc("/api/account/unlock", limits.passwordReset(userHandler.Unlock)).Methods("POST")
+	if cfg.MagicLink.Enabled {
+		// Sign-in links are emailed like reset links and share their
+		// limits. Completing is polled, so it gets the login limit, and
+		// each link allows only a few attempts at its code.
+		r.HandleFunc("/api/login/magic-link", limits.passwordReset(magicHandler.Request)).Methods("POST")
+		r.HandleFunc("/api/login/magic-link/confirm", limits.passwordReset(magicHandler.Confirm)).Methods("POST")
+		r.HandleFunc("/api/login/magic-link/complete", limits.login(magicHandler.Complete)).Methods("POST")
+	}
+	r.HandleFunc("/api/auth/oidc/{provider}/login", limits.login(oidcHandler.Login)).Methods("GET")
+	r.HandleFunc("/api/auth/oidc/{provider}/callback", oidcHandler.Callback).Methods("GET")
+	r.HandleFunc("/api/auth/oidc/{provider}/link", middleware.AuthMiddleware(oidcHandler.Link)).Methods("POST")
+
+	r.HandleFunc("/api/me", middleware.AuthMiddleware(profileHandler.GetMe)).Methods("GET")
+	r.HandleFunc("/api/me", middleware.AuthMiddleware(profileHandler.UpdateMe)).Methods("PATCH")
+	r.HandleFunc("/api/me", middleware.AuthMiddleware(privacyHandler.DeleteMe)).Methods("DELETE")
+	r.HandleFunc("/api/me/deletion", middleware.AuthMiddleware(privacyHandler.CancelDeletion)).Methods("DELETE")
+	r.HandleFunc("/api/me/export", middleware.AuthMiddleware(privacyHandler.RequestExport)).Methods("POST")
+	r.HandleFunc("/api/me/export/{token}", middleware.AuthMiddleware(privacyHandler.DownloadExport)).Methods("GET")
+	r.HandleFunc("/api/email-change/confirm", profileHandler.ConfirmEmailChange).Methods("POST")
+	r.HandleFunc("/api/users/{username}", profileHandler.GetUser).Methods("GET")
+	r.HandleFunc("/api/users/{username}/follow", middleware.AuthMiddleware(followHandler.Follow)).Methods("PUT")
+	r.HandleFunc("/api/users/{username}/follow", middleware.AuthMiddleware(followHandler.Unfollow)).Methods("DELETE")
+	r.HandleFunc("/api/users/{username}/followers", followHandler.Followers).Methods("GET")
+	r.HandleFunc("/api/users/{username}/following", followHandler.Following).Methods("GET")
+	r.HandleFunc("/api/admin/users/{id}", middleware.AuthMiddleware(profileHandler.AdminGetUser)).Methods("GET")
 
-	r.HandleFunc("/api/register", userHandler.Register).Methods("POST")
-	r.HandleFunc("/api/login", userHandler.Login).Methods("POST")
+	// Two-factor enrollment also accepts the enrollment token handed out
+	// when a role requires two-factor login
+	r.HandleFunc("/api/me/mfa/totp", middleware.MFAEnrollmentAuth(mfaHandler.Enroll)).Methods("POST")
+	r.HandleFunc("/api/me/mfa/totp/confirm", middleware.MFAEnrollmentAuth(limits.mfaCode(mfaHandler.Confirm))).Methods("POST")
+	r.HandleFunc("/api/me/mfa/totp", middleware.AuthMiddleware(limits.mfaCode(mfaHandler.Disable))).Methods("DELETE")
+	r.HandleFunc("/api/me/mfa/recovery-codes", middleware.AuthMiddleware(limits.mfaCode(mfaHandler.RegenerateRecoveryCodes))).Methods("POST")
+	r.HandleFunc("/api/admin/mfa-policies", middleware.AuthMiddleware(mfaHandler.ListRolePolicies)).Methods("GET")
+	r.HandleFunc("/api/admin/mfa-policies/{role}", middleware.AuthMiddleware(mfaHandler.SetRolePolicy)).Methods("PUT")
+	r.HandleFunc("/api/admin/webhooks", middleware.AuthMiddleware(webhookHandler.Create)).Methods("POST")
+	r.HandleFunc("/api/admin/webhooks", middleware.AuthMiddleware(webhookHandler.List)).Methods("GET")
+	r.HandleFunc("/api/admin/webhooks/{id}", middleware.AuthMiddleware(webhookHandler.Get)).Methods("GET")
+	r.HandleFunc("/api/admin/webhooks/{id}", middleware.AuthMiddleware(webhookHandler.Update)).Methods("PATCH")
+	r.HandleFunc("/api/admin/webhooks/{id}", middleware.AuthMiddleware(webhookHandler.Delete)).Methods("DELETE")
+	r.HandleFunc("/api/admin/webhooks/{id}/deliveries", middleware.AuthMiddleware(webhookHandler.Deliveries)).Methods("GET")
+	r.HandleFunc("/api/admin/webhooks/{id}/deliveries/{delivery_id}/replay", middleware.AuthMiddleware(webhookHandler.Replay)).Methods("POST")
 	// Protect routes with middleware
-	r.HandleFunc("/api/posts", middleware.AuthMiddleware(postHandler.Create)).Methods("POST")
-	r.HandleFunc("/api/posts/{id}", middleware.AuthMiddleware(postHandler.Update)).Methods("PUT")
-	r.HandleFunc("/api/posts/{id}", postHandler.Get).Methods("GET")
-	r.HandleFunc("/api/posts", postHandler.List).Methods("GET")
+	r.HandleFunc("/api/me/tokens", middleware.AuthMiddleware(tokenHandler.Create)).Methods("POST")
+	r.HandleFunc("/api/me/tokens", middleware.AuthMiddleware(tokenHandler.List)).Methods("GET")
+	r.HandleFunc("/api/me/tokens/{id}", middleware.AuthMiddleware(tokenHandler.Revoke)).Methods("DELETE")
 
-	r.HandleFunc("/api/password-reset", resetHandler.RequestReset).Methods("POST")
+	// OAuth2 authorization server for third-party clients. The consent
+	// page lives in the frontend and calls the authorize endpoints.
+	r.HandleFunc("/api/oauth/clients", middleware.AuthMiddleware(oauthHandler.RegisterClient)).Methods("POST")
+	r.HandleFunc("/api/oauth/clients", middleware.AuthMiddleware(oauthHandler.ListClients)).Methods("GET")
+	r.HandleFunc("/api/oauth/clients/{client_id}", middleware.AuthMiddleware(oauthHandler.DeleteClient)).Methods("DELETE")
+	r.HandleFunc("/api/oauth/authorize", middleware.AuthMiddleware(oauthHandler.Authorize)).Methods("GET")
+	r.HandleFunc("/api/oauth/authorize", middleware.AuthMiddleware(oauthHandler.Approve)).Methods("POST")
+	r.HandleFunc("/api/oauth/token", oauthHandler.Token).Methods("POST")
+	r.HandleFunc("/api/oauth/introspect", oauthHandler.Introspect).Methods("POST")
+	r.HandleFunc("/api/oauth/revoke", oauthHandler.Revoke).Methods("POST")
+	r.HandleFunc("/api/me/oauth/consents", middleware.AuthMiddleware(oauthHandler.ListConsents)).Methods("GET")
+	r.HandleFunc("/api/me/oauth/consents/{client_id}", middleware.AuthMiddleware(oauthHandler.RevokeConsent)).Methods("DELETE")
+
+	r.HandleFunc("/api/posts", middleware.RequireScope(middleware.ScopePostsWrite, middleware.AuthMiddleware(postHandler.Create))).Methods("POST")
+	r.HandleFunc("/api/posts/{id}", middleware.RequireScope(middleware.ScopePostsWrite, middleware.AuthMiddleware(postHandler.Update))).Methods("PUT")
+	r.HandleFunc("/api/posts/{id}", middleware.RequireScope(middleware.ScopePostsRead, middleware.OptionalAuth(postHandler.Get))).Methods("GET")
+	r.HandleFunc("/api/posts", middleware.RequireScope(middleware.ScopePostsRead, middleware.OptionalAuth(postHandler.List))).Methods("GET")
+	r.HandleFunc("/api/posts/by-slug/{slug}", middleware.RequireScope(middleware.ScopePostsRead, middleware.OptionalAuth(postHandler.GetBySlug))).Methods("GET")
+	if cfg.Posts.Permalinks == slug.SchemeDate {
+		r.HandleFunc("/api/posts/{year:[0-9]{4}}/{month:[0-9]{2}}/{day:[0-9]{2}}/{slug}",
+			middleware.RequireScope(middleware.ScopePostsRead, middleware.OptionalAuth(postHandler.GetByDate))).Methods("GET")
+	}
+	r.HandleFunc("/api/posts/{id}/reactions/{kind}", middleware.AuthMiddleware(reactionHandler.React)).Methods("PUT")
+	r.HandleFunc("/api/posts/{id}/reactions/{kind}", middleware.AuthMiddleware(reactionHandler.Unreact)).Methods("DELETE")
+	r.HandleFunc("/api/posts/{id}/bookmark", middleware.AuthMiddleware(reactionHandler.Bookmark)).Methods("PUT")
+	r.HandleFunc("/api/posts/{id}/bookmark", middleware.AuthMiddleware(reactionHandler.Unbookmark)).Methods("DELETE")
+	r.HandleFunc("/api/me/bookmarks", middleware.AuthMiddleware(reactionHandler.ListBookmarks)).Methods("GET")
+	r.HandleFunc("/api/timeline", middleware.AuthMiddleware(postHandler.Timeline)).Methods("GET")
+	r.HandleFunc("/api/media", middleware.RequireScope(middleware.ScopePostsWrite, middleware.AuthMiddleware(mediaHandler.Upload))).Methods("POST")
+	r.HandleFunc("/api/media/{id}", mediaHandler.Get).Methods("GET")
+	r.HandleFunc("/api/media/{id}", middleware.RequireScope(middleware.ScopePostsWrite, middleware.AuthMiddleware(mediaHandler.Delete))).Methods("DELETE")
+	if strings.HasPrefix(cfg.Media.BaseURL, "/") {
+		// Media are served by the API rather than a CDN or public bucket.
+		r.HandleFunc(strings.TrimSuffix(cfg.Media.BaseURL, "/")+"/{key:.+}", mediaHandler.Serve).Methods("GET")
+	}
+
+	r.HandleFunc("/api/password-reset", limits.passwordReset(resetHandler.RequestReset)).Methods("POST")
 	r.HandleFunc("/api/password-reset/confirm", resetHandler.ConfirmReset).Methods("POST")
 
+	if cfg.Metrics.Enabled {
+		if err := metrics.RegisterDB(db, cfg.Database.DBName); err != nil {
+			fatal("failed to register database metrics", err)
+		}
+		r.Handle(cfg.Metrics.Path, metrics.Handler()).Methods("GET")
+	}
+
+	handler := middleware.RequestID(middleware.AccessLog(middleware.Metrics(r)))
+	if cfg.Server.TrustProxyHeaders {
+		handler = middleware.RealIP(cfg.Server.TrustedProxyHops)(handler)
+	}
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Start server
-	log.Printf("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("server starting", "addr", srv.Addr, "env", cfg.Env)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			logger.Error("server error", "error", err)
+		}
+	case <-ctx.Done():
+		logger.Info("shutdown signal received, draining connections")
+	}
+	stop()
+
+	checks.SetShuttingDown()
+	if cfg.Server.ShutdownDelay > 0 {
+		logger.Info("waiting for load balancers to observe readiness change", "delay", cfg.Server.ShutdownDelay)
+		time.Sleep(cfg.Server.ShutdownDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("server shutdown did not complete", "error", err)
+	}
+	if err := workers.Stop(shutdownCtx); err != nil {
+		logger.Error("background workers did not stop in time", "error", err)
+	}
+	if err := db.Close(); err != nil {
+		logger.Error("error closing database", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("error flushing traces", "error", err)
+	}
+	logger.Info("server stopped")
+}
+
+func setupLogger(cfg *config.Config) *slog.Logger {
+	logger, err := logging.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Fatal("Error creating logger: ", err)
+	}
+	slog.SetDefault(logger)
+	return logger
+}
+
+// openDB opens the connection pool and checks that the database answers.
+func openDB(cfg config.DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	// Test the connection
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout+time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// passwordHasher hashes new passwords with the configured algorithm and
+// still verifies hashes made with the other one.
+func passwordHasher(cfg config.PasswordConfig) *passhash.Set {
+	argon := passhash.DefaultArgon2id
+	argon.Memory = uint32(cfg.Argon2Memory)
+	argon.Time = uint32(cfg.Argon2Time)
+	argon.Threads = uint8(cfg.Argon2Threads)
+	bcrypt := passhash.Bcrypt{Cost: cfg.BcryptCost}
+
+	if cfg.Algorithm == "bcrypt" {
+		return passhash.NewSet(bcrypt, argon)
+	}
+	return passhash.NewSet(argon, bcrypt)
+}
+
+// passwordPolicy builds the rules new passwords must follow, opening the
+// breached-password list when one is configured.
+func passwordPolicy(cfg config.PasswordConfig) (*passpolicy.Policy, error) {
+	policy := &passpolicy.Policy{MinLength: cfg.MinLength, MinScore: cfg.MinScore}
+	if cfg.BreachedList != "" {
+		list, err := passpolicy.OpenBreachedList(cfg.BreachedList)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = list
+	}
+	return policy, nil
+}
+
+// fatal logs err with the default logger and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/anoying-kid/go-apps/blogAPI/internal/blobstore"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+)
+
+// newBlobStore opens the store configured for uploaded media.
+func newBlobStore(cfg config.MediaConfig) (blobstore.Store, error) {
+	if cfg.Store == "s3" {
+		return blobstore.NewS3(blobstore.S3Config{
+			Endpoint:        cfg.S3.Endpoint,
+			Region:          cfg.S3.Region,
+			Bucket:          cfg.S3.Bucket,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			PathStyle:       cfg.S3.PathStyle,
+		}, nil)
+	}
+	return blobstore.NewLocal(cfg.LocalDir), nil
+}
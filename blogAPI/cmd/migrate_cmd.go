@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/migrations"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+)
+
+// runMigrateCommand implements `blogAPI migrate [--status]` and returns
+// the process exit code.
+func runMigrateCommand(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	status := fs.Bool("status", false, "list pending migrations without applying them")
+	cfg, err := config.LoadWithFlags(fs, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	logger := setupLogger(cfg)
+
+	db, err := openDB(cfg.Database)
+	if err != nil {
+		logger.Error("failed to connect to the database", "error", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if *status {
+		pending, err := migrations.Pending(ctx, db)
+		if err != nil {
+			logger.Error("failed to list migrations", "error", err)
+			return 1
+		}
+		for _, m := range pending {
+			fmt.Printf("pending %04d_%s\n", m.Version, m.Name)
+		}
+		return 0
+	}
+
+	if err := migrations.Up(ctx, db); err != nil {
+		logger.Error("failed to apply migrations", "error", err)
+		return 1
+	}
+	return 0
+}
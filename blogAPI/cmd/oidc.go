@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/oidc"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+)
+
+// discoverOIDCProviders looks up the endpoints of every configured OpenID
+// provider. A provider that cannot be reached is left out with an error
+// logged rather than keeping the API from starting.
+func discoverOIDCProviders(cfg config.OIDCConfig, logger *slog.Logger) map[string]*oidc.Provider {
+	client := &http.Client{Timeout: 10 * time.Second}
+	providers := make(map[string]*oidc.Provider)
+	for _, p := range []config.OIDCProviderConfig{cfg.Google, cfg.Generic} {
+		if !p.Enabled() {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := oidc.Discover(ctx, oidc.Config{
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+		}, client)
+		cancel()
+		if err != nil {
+			logger.Error("oidc provider disabled", "provider", p.Name, "error", err)
+			continue
+		}
+		providers[p.Name] = provider
+		logger.Info("oidc provider enabled", "provider", p.Name, "issuer", p.Issuer)
+	}
+	return providers
+}
@@ -2,25 +2,55 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/anoying-kid/go-apps/blogAPI/internal/blobstore"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/handlers"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/media"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/middleware"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/oidc"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/oidc/oidctest"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/outbox"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/secretbox"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/subscribers"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/webhook"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
 	router *mux.Router
 	db     *sql.DB
+	idp    *oidctest.Provider
+	mediaProcessor *media.Processor
+	blobs          *blobstore.Local
+	webhooks       *webhook.Dispatcher
+	bus            *outbox.Dispatcher
 )
 
 type TestUser struct {
@@ -51,18 +81,119 @@ func TestMain(m *testing.M) {
 
 	// Initialize repositories and handlers
 	userRepo := repository.NewUserRepository(db)
-	userHandler := handlers.NewUserHandler(userRepo)
+	loginRepo := repository.NewLoginHistoryRepository(db)
+	unlockRepo := repository.NewAccountUnlockRepository(db)
+	mfaBox, _ := secretbox.New("integration-test-key")
+	mfaRepo := repository.NewMFARepository(db, mfaBox)
+
+	// Retries come due at once, and two failures in a row disable a
+	// subscription
+	webhookConfig := *config.Default()
+	webhookConfig.Webhooks.BackoffBase = time.Millisecond
+	webhookConfig.Webhooks.BackoffMax = time.Millisecond
+	webhookConfig.Webhooks.DisableAfter = 2
+	// The test receivers listen on loopback
+	webhookConfig.Webhooks.AllowedNetworks = []string{"127.0.0.0/8"}
+	webhookRepo := repository.NewWebhookRepository(db, mfaBox)
+	webhooks = webhook.NewDispatcher(webhookRepo, webhookConfig)
+	webhookHandler := handlers.NewWebhookHandler(userRepo, webhookRepo, webhooks)
+	userHandler := handlers.NewUserHandler(userRepo, loginRepo, unlockRepo, mfaRepo, *config.Default())
+
+	// A local OpenID provider stands in for Google and friends
+	idp = oidctest.NewProvider(oidctest.User{Subject: "idp-1", Email: "oidc@example.com", EmailVerified: true, Name: "oidcuser"})
+	oidcProvider, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  "http://api.test/api/auth/oidc/test/callback",
+	}, nil)
+	if err != nil {
+		fmt.Printf("Failed to discover mock OpenID provider: %v\n", err)
+		os.Exit(1)
+	}
+	identityRepo := repository.NewIdentityRepository(db)
+	oidcHandler := handlers.NewOIDCHandler(userHandler, userRepo, identityRepo,
+		map[string]*oidc.Provider{"test": oidcProvider}, *config.Default())
+
+	profileHandler := handlers.NewProfileHandler(userHandler, userRepo, repository.NewEmailChangeRepository(db), *config.Default())
+	tokenHandler := handlers.NewAccessTokenHandler(repository.NewAccessTokenRepository(db))
+	middleware.SetTokenAuthenticator(tokenHandler)
+	oauthHandler := handlers.NewOAuthHandler(repository.NewOAuthRepository(db), *config.Default())
+	middleware.SetOAuthTokenChecker(oauthHandler)
+
+	mediaDir, err := os.MkdirTemp("", "blogapi-media-")
+	if err != nil {
+		fmt.Printf("Failed to create media directory: %v\n", err)
+		os.Exit(1)
+	}
+	blobs = blobstore.NewLocal(mediaDir)
+	mediaRepo := repository.NewMediaRepository(db)
+	mediaProcessor = media.NewProcessor(mediaRepo, blobs, *config.Default())
+	mediaHandler := handlers.NewMediaHandler(mediaRepo, blobs, mediaProcessor, *config.Default())
 
 	postRepo := repository.NewPostRepository(db)
-	postHandler := handlers.NewPostHandler(postRepo)
+	reactionRepo := repository.NewReactionRepository(db)
+	postHandler := handlers.NewPostHandler(postRepo, reactionRepo, mediaRepo)
+	reactionHandler := handlers.NewReactionHandler(postRepo, reactionRepo, repository.NewBookmarkRepository(db), mediaRepo)
+	seoHandler := handlers.NewSEOHandler(postRepo, *config.Default())
+
+	bus = outbox.NewDispatcher(repository.NewOutboxRepository(db), *config.Default())
+	magicRepo := repository.NewMagicLinkRepository(db)
+	magicHandler := handlers.NewMagicLinkHandler(userHandler, userRepo, magicRepo, *config.Default())
+	resetRepo := repository.NewPasswordResetRepository(db)
+	resetHandler := handlers.NewPasswordResetHandler(userRepo, resetRepo, *config.Default())
+	subscribers.New(userRepo, postRepo, mediaRepo, resetRepo, magicRepo, webhooks, *config.Default()).Register(bus)
+	followHandler := handlers.NewFollowHandler(userRepo, repository.NewFollowRepository(db))
 
 	router = mux.NewRouter()
 	router.HandleFunc("/api/register", userHandler.Register).Methods("POST")
+	router.HandleFunc("/robots.txt", seoHandler.Robots).Methods("GET")
+	router.HandleFunc("/sitemap.xml", seoHandler.SitemapIndex).Methods("GET")
+	router.HandleFunc("/sitemaps/posts-{first:[0-9]+}.xml", seoHandler.PostsSitemap).Methods("GET")
 	router.HandleFunc("/api/login", userHandler.Login).Methods("POST")
-	router.HandleFunc("/api/posts", middleware.AuthMiddleware(postHandler.Create)).Methods("POST")
-	router.HandleFunc("/api/posts/{id}", middleware.AuthMiddleware(postHandler.Update)).Methods("PUT")
-	router.HandleFunc("/api/posts/{id}", postHandler.Get).Methods("GET")
-	router.HandleFunc("/api/posts", postHandler.List).Methods("GET")
+	router.HandleFunc("/api/account/unlock", userHandler.Unlock).Methods("POST")
+	router.HandleFunc("/api/password-reset", resetHandler.RequestReset).Methods("POST")
+	router.HandleFunc("/api/password-reset/confirm", resetHandler.ConfirmReset).Methods("POST")
+	router.HandleFunc("/api/login/magic-link", magicHandler.Request).Methods("POST")
+	router.HandleFunc("/api/login/magic-link/confirm", magicHandler.Confirm).Methods("POST")
+	router.HandleFunc("/api/login/magic-link/complete", magicHandler.Complete).Methods("POST")
+	router.HandleFunc("/api/auth/oidc/{provider}/login", oidcHandler.Login).Methods("GET")
+	router.HandleFunc("/api/auth/oidc/{provider}/callback", oidcHandler.Callback).Methods("GET")
+	router.HandleFunc("/api/auth/oidc/{provider}/link", middleware.AuthMiddleware(oidcHandler.Link)).Methods("POST")
+	router.HandleFunc("/api/me", middleware.AuthMiddleware(profileHandler.GetMe)).Methods("GET")
+	router.HandleFunc("/api/me", middleware.AuthMiddleware(profileHandler.UpdateMe)).Methods("PATCH")
+	router.HandleFunc("/api/users/{username}", profileHandler.GetUser).Methods("GET")
+	router.HandleFunc("/api/me/tokens", middleware.AuthMiddleware(tokenHandler.Create)).Methods("POST")
+	router.HandleFunc("/api/me/tokens", middleware.AuthMiddleware(tokenHandler.List)).Methods("GET")
+	router.HandleFunc("/api/me/tokens/{id}", middleware.AuthMiddleware(tokenHandler.Revoke)).Methods("DELETE")
+	router.HandleFunc("/api/oauth/clients", middleware.AuthMiddleware(oauthHandler.RegisterClient)).Methods("POST")
+	router.HandleFunc("/api/oauth/authorize", middleware.AuthMiddleware(oauthHandler.Approve)).Methods("POST")
+	router.HandleFunc("/api/oauth/token", oauthHandler.Token).Methods("POST")
+	router.HandleFunc("/api/oauth/introspect", oauthHandler.Introspect).Methods("POST")
+	router.HandleFunc("/api/posts", middleware.RequireScope(middleware.ScopePostsWrite, middleware.AuthMiddleware(postHandler.Create))).Methods("POST")
+	router.HandleFunc("/api/posts/{id}", middleware.RequireScope(middleware.ScopePostsWrite, middleware.AuthMiddleware(postHandler.Update))).Methods("PUT")
+	router.HandleFunc("/api/posts/{id}", middleware.RequireScope(middleware.ScopePostsRead, middleware.OptionalAuth(postHandler.Get))).Methods("GET")
+	router.HandleFunc("/api/posts", middleware.RequireScope(middleware.ScopePostsRead, middleware.OptionalAuth(postHandler.List))).Methods("GET")
+	router.HandleFunc("/api/posts/by-slug/{slug}", middleware.OptionalAuth(postHandler.GetBySlug)).Methods("GET")
+	router.HandleFunc("/api/posts/{year:[0-9]{4}}/{month:[0-9]{2}}/{day:[0-9]{2}}/{slug}", middleware.OptionalAuth(postHandler.GetByDate)).Methods("GET")
+	router.HandleFunc("/api/posts/{id}/reactions/{kind}", middleware.AuthMiddleware(reactionHandler.React)).Methods("PUT")
+	router.HandleFunc("/api/posts/{id}/reactions/{kind}", middleware.AuthMiddleware(reactionHandler.Unreact)).Methods("DELETE")
+	router.HandleFunc("/api/posts/{id}/bookmark", middleware.AuthMiddleware(reactionHandler.Bookmark)).Methods("PUT")
+	router.HandleFunc("/api/posts/{id}/bookmark", middleware.AuthMiddleware(reactionHandler.Unbookmark)).Methods("DELETE")
+	router.HandleFunc("/api/me/bookmarks", middleware.AuthMiddleware(reactionHandler.ListBookmarks)).Methods("GET")
+	router.HandleFunc("/api/users/{username}/follow", middleware.AuthMiddleware(followHandler.Follow)).Methods("PUT")
+	router.HandleFunc("/api/users/{username}/follow", middleware.AuthMiddleware(followHandler.Unfollow)).Methods("DELETE")
+	router.HandleFunc("/api/users/{username}/followers", followHandler.Followers).Methods("GET")
+	router.HandleFunc("/api/timeline", middleware.AuthMiddleware(postHandler.Timeline)).Methods("GET")
+	router.HandleFunc("/api/media", middleware.AuthMiddleware(mediaHandler.Upload)).Methods("POST")
+	router.HandleFunc("/api/media/{id}", mediaHandler.Get).Methods("GET")
+	router.HandleFunc("/api/media/{id}", middleware.AuthMiddleware(mediaHandler.Delete)).Methods("DELETE")
+	router.HandleFunc("/media/{key:.+}", mediaHandler.Serve).Methods("GET")
+	router.HandleFunc("/api/admin/webhooks", middleware.AuthMiddleware(webhookHandler.Create)).Methods("POST")
+	router.HandleFunc("/api/admin/webhooks/{id}", middleware.AuthMiddleware(webhookHandler.Get)).Methods("GET")
+	router.HandleFunc("/api/admin/webhooks/{id}", middleware.AuthMiddleware(webhookHandler.Update)).Methods("PATCH")
+	router.HandleFunc("/api/admin/webhooks/{id}/deliveries", middleware.AuthMiddleware(webhookHandler.Deliveries)).Methods("GET")
+	router.HandleFunc("/api/admin/webhooks/{id}/deliveries/{delivery_id}/replay", middleware.AuthMiddleware(webhookHandler.Replay)).Methods("POST")
 
 	// Run tests
 	code := m.Run()
@@ -70,24 +201,77 @@ func TestMain(m *testing.M) {
 	// Cleanup
 	cleanupDatabase()
 	db.Close()
+	idp.Server.Close()
+	os.RemoveAll(mediaDir)
 
 	os.Exit(code)
 }
 
 func cleanupDatabase() {
+	db.Exec("DELETE FROM outbox_events")
+	db.Exec("DELETE FROM webhook_subscriptions")
+	db.Exec("DELETE FROM user_identities")
 	db.Exec("DELETE FROM posts")
 	db.Exec("DELETE FROM users")
 }
 
+// signUp registers username with a fixed password and returns the token
+// from logging in.
+func signUp(t *testing.T, username string) string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{
+		"username": username,
+		"email":    username + "@example.com",
+		"password": "maple-quarry-lantern-36",
+	})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/register", bytes.NewReader(body)))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/login", bytes.NewReader(body)))
+	var resp LoginResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	require.NotEmpty(t, resp.Token)
+	return resp.Token
+}
+
+// apiRequest sends payload as JSON with token as the bearer token, when
+// either is given.
+func apiRequest(token, method, path string, payload interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if payload != nil {
+		json.NewEncoder(&buf).Encode(payload)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
 func TestUserRegistrationAndLogin(t *testing.T) {
 	cleanupDatabase()
 
 	// Test user registration
 	user := TestUser{
 		Username: "testuser",
-		Password: "testpass123",
+		Password: "violet-harbor-lantern-42",
 	}
 
+	t.Run("Reject Weak Password", func(t *testing.T) {
+		body, _ := json.Marshal(TestUser{Username: "weakuser", Password: "testuser123"})
+		req := httptest.NewRequest("POST", "/api/register", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
 	// Register user
 	t.Run("Register User", func(t *testing.T) {
 		body, _ := json.Marshal(user)
@@ -124,7 +308,7 @@ func TestPostOperations(t *testing.T) {
     // First register and login to get token
     user := TestUser{
         Username: "postuser",
-        Password: "postpass123",
+        Password: "quiet-meadow-copper-17",
     }
 
     // Register
@@ -293,3 +477,1467 @@ func TestInvalidOperations(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, rr.Code)
 	})
 }
+
+func TestOIDCLogin(t *testing.T) {
+	cleanupDatabase()
+
+	// finish follows the provider URL of a started sign-in back to the
+	// callback and returns the outcome handed to the frontend
+	finish := func(t *testing.T, authURL string, cookies []*http.Cookie) url.Values {
+		// The mock provider signs the user in and redirects back
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Get(authURL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest("GET", callback.RequestURI(), nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusFound, rr.Code)
+
+		result, err := url.Parse(rr.Header().Get("Location"))
+		assert.NoError(t, err)
+		values, err := url.ParseQuery(result.Fragment)
+		assert.NoError(t, err)
+		return values
+	}
+
+	signIn := func(t *testing.T) url.Values {
+		// Start the sign-in and keep the state cookie
+		req := httptest.NewRequest("GET", "/api/auth/oidc/test/login", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusFound, rr.Code)
+		return finish(t, rr.Header().Get("Location"), rr.Result().Cookies())
+	}
+
+	t.Run("Creates and links a new user", func(t *testing.T) {
+		values := signIn(t)
+		assert.NotEmpty(t, values.Get("token"))
+	})
+
+	t.Run("Signs the linked user in again", func(t *testing.T) {
+		values := signIn(t)
+		assert.NotEmpty(t, values.Get("token"))
+
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM users WHERE email = 'oidc@example.com'").Scan(&count)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("Refuses unverified emails", func(t *testing.T) {
+		idp.SetUser(oidctest.User{Subject: "idp-2", Email: "other@example.com"})
+		defer idp.SetUser(oidctest.User{Subject: "idp-1", Email: "oidc@example.com", EmailVerified: true})
+
+		values := signIn(t)
+		assert.Equal(t, "email_not_verified", values.Get("error"))
+	})
+
+	t.Run("Does not link an account with an unverified email", func(t *testing.T) {
+		// Anyone can register someone else's email with a password
+		squatter := signUp(t, "squatter")
+		idp.SetUser(oidctest.User{Subject: "idp-3", Email: "squatter@example.com", EmailVerified: true})
+		defer idp.SetUser(oidctest.User{Subject: "idp-1", Email: "oidc@example.com", EmailVerified: true})
+
+		values := signIn(t)
+		assert.Equal(t, "account_exists", values.Get("error"))
+		assert.Empty(t, values.Get("token"))
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM user_identities WHERE subject = 'idp-3'").Scan(&count)
+		assert.Zero(t, count)
+
+		// Signed in with the password, the owner links it themselves
+		rr := apiRequest(squatter, "POST", "/api/auth/oidc/test/link", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var start struct {
+			URL string `json:"url"`
+		}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&start))
+		values = finish(t, start.URL, rr.Result().Cookies())
+		assert.Equal(t, "test", values.Get("linked"))
+
+		values = signIn(t)
+		assert.NotEmpty(t, values.Get("token"))
+		var owner string
+		db.QueryRow(`SELECT u.username FROM user_identities i JOIN users u ON u.id = i.user_id WHERE i.subject = 'idp-3'`).Scan(&owner)
+		assert.Equal(t, "squatter", owner)
+	})
+
+	t.Run("Links an account whose email is verified", func(t *testing.T) {
+		signUp(t, "verified")
+		_, err := db.Exec("UPDATE users SET email_verified_at = NOW() WHERE username = 'verified'")
+		require.NoError(t, err)
+		idp.SetUser(oidctest.User{Subject: "idp-4", Email: "verified@example.com", EmailVerified: true})
+		defer idp.SetUser(oidctest.User{Subject: "idp-1", Email: "oidc@example.com", EmailVerified: true})
+
+		values := signIn(t)
+		assert.NotEmpty(t, values.Get("token"))
+	})
+
+	t.Run("Link needs a signed-in user", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, apiRequest("", "POST", "/api/auth/oidc/test/link", nil).Code)
+	})
+
+	t.Run("Rejects a callback without the state cookie", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/auth/oidc/test/callback?code=x&state=y", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Contains(t, rr.Header().Get("Location"), "error=invalid_state")
+	})
+}
+
+func TestProfile(t *testing.T) {
+	cleanupDatabase()
+
+	credentials := map[string]string{
+		"username": "profileuser",
+		"email":    "profile@example.com",
+		"password": "amber-falcon-orchard-58",
+	}
+	body, _ := json.Marshal(credentials)
+	req := httptest.NewRequest("POST", "/api/register", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	req = httptest.NewRequest("POST", "/api/login", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var loginResp LoginResponse
+	json.NewDecoder(rr.Body).Decode(&loginResp)
+	require.NotEmpty(t, loginResp.Token)
+
+	send := func(method, path string, payload interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if payload != nil {
+			json.NewEncoder(&buf).Encode(payload)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Authorization", "Bearer "+loginResp.Token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Own profile hides the password", func(t *testing.T) {
+		rr := send("GET", "/api/me", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var me map[string]interface{}
+		json.NewDecoder(rr.Body).Decode(&me)
+		assert.Equal(t, "profile@example.com", me["email"])
+		assert.NotContains(t, me, "password")
+	})
+
+	t.Run("Updates public fields", func(t *testing.T) {
+		rr := send("PATCH", "/api/me", map[string]string{"display_name": "Profile User", "website": "https://example.com"})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		rr = send("PATCH", "/api/me", map[string]string{"website": "javascript:alert(1)"})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Public profile by username", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/users/ProfileUser", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var profile map[string]interface{}
+		json.NewDecoder(rr.Body).Decode(&profile)
+		assert.Equal(t, "Profile User", profile["display_name"])
+		assert.NotContains(t, profile, "email")
+		assert.NotContains(t, profile, "password")
+	})
+
+	t.Run("Password change needs the current password", func(t *testing.T) {
+		rr := send("PATCH", "/api/me", map[string]string{"password": "copper-lantern-meadow-93"})
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+
+		rr = send("PATCH", "/api/me", map[string]string{
+			"password":         "copper-lantern-meadow-93",
+			"current_password": credentials["password"],
+		})
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Current password guesses count towards the lockout", func(t *testing.T) {
+		session := signUp(t, "guessuser")
+		change := func(current string) int {
+			return apiRequest(session, "PATCH", "/api/me", map[string]string{
+				"password": "copper-lantern-meadow-93", "current_password": current,
+			}).Code
+		}
+		for i := 0; i <= config.Default().Lockout.FreeAttempts; i++ {
+			assert.Equal(t, http.StatusForbidden, change("wrong-password"))
+		}
+		assert.Equal(t, http.StatusTooManyRequests, change("wrong-password"))
+		assert.Equal(t, http.StatusTooManyRequests, change("maple-quarry-lantern-36"))
+	})
+}
+
+func TestFollowTimeline(t *testing.T) {
+	cleanupDatabase()
+
+	login := func(username string) string {
+		body, _ := json.Marshal(map[string]string{
+			"username": username,
+			"email":    username + "@example.com",
+			"password": "amber-falcon-orchard-58",
+		})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/register", bytes.NewReader(body)))
+		require.Equal(t, http.StatusCreated, rr.Code)
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/login", bytes.NewReader(body)))
+		var resp LoginResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		require.NotEmpty(t, resp.Token)
+		return resp.Token
+	}
+	send := func(token, method, path string, payload interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if payload != nil {
+			json.NewEncoder(&buf).Encode(payload)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	reader := login("reader")
+	writer := login("writer")
+	stranger := login("stranger")
+	for i := 1; i <= 3; i++ {
+		rr := send(writer, "POST", "/api/posts", map[string]string{"title": fmt.Sprintf("Followed %d", i), "body": "..."})
+		require.Equal(t, http.StatusCreated, rr.Code)
+	}
+	rr := send(stranger, "POST", "/api/posts", map[string]string{"title": "Not followed", "body": "..."})
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	t.Run("Follow is idempotent", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, send(reader, "PUT", "/api/users/writer/follow", nil).Code)
+		assert.Equal(t, http.StatusNoContent, send(reader, "PUT", "/api/users/Writer/follow", nil).Code)
+		assert.Equal(t, http.StatusBadRequest, send(reader, "PUT", "/api/users/reader/follow", nil).Code)
+		assert.Equal(t, http.StatusNotFound, send(reader, "PUT", "/api/users/nobody/follow", nil).Code)
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/users/writer/followers", nil))
+		var followers struct {
+			Count int64                    `json:"count"`
+			Items []map[string]interface{} `json:"items"`
+		}
+		json.NewDecoder(rr.Body).Decode(&followers)
+		assert.Equal(t, int64(1), followers.Count)
+		require.Len(t, followers.Items, 1)
+		assert.Equal(t, "reader", followers.Items[0]["username"])
+	})
+
+	t.Run("Timeline pages through followed authors", func(t *testing.T) {
+		var titles []string
+		cursor := ""
+		for pages := 0; pages < 5; pages++ {
+			rr := send(reader, "GET", "/api/timeline?limit=2&cursor="+cursor, nil)
+			require.Equal(t, http.StatusOK, rr.Code)
+			var page struct {
+				Items      []map[string]interface{} `json:"items"`
+				NextCursor string                   `json:"next_cursor"`
+			}
+			json.NewDecoder(rr.Body).Decode(&page)
+			for _, item := range page.Items {
+				titles = append(titles, item["title"].(string))
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		assert.Equal(t, []string{"Followed 3", "Followed 2", "Followed 1"}, titles)
+	})
+
+	t.Run("Unfollow empties the timeline", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, send(reader, "DELETE", "/api/users/writer/follow", nil).Code)
+		rr := send(reader, "GET", "/api/timeline", nil)
+		assert.JSONEq(t, `{"items": []}`, rr.Body.String())
+
+		rr = send(reader, "GET", "/api/timeline?cursor=not-a-cursor", nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestReactionsAndBookmarks(t *testing.T) {
+	cleanupDatabase()
+
+	register := func(username string) string {
+		body, _ := json.Marshal(map[string]string{
+			"username": username,
+			"email":    username + "@example.com",
+			"password": "amber-falcon-orchard-58",
+		})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/register", bytes.NewReader(body)))
+		require.Equal(t, http.StatusCreated, rr.Code)
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/login", bytes.NewReader(body)))
+		var resp LoginResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		require.NotEmpty(t, resp.Token)
+		return resp.Token
+	}
+	send := func(token, method, path string, payload interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if payload != nil {
+			json.NewEncoder(&buf).Encode(payload)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	type reaction struct {
+		Kind    string `json:"kind"`
+		Count   int64  `json:"count"`
+		Reacted bool   `json:"reacted"`
+	}
+	reactions := func(token, path string) []reaction {
+		var post struct {
+			Reactions []reaction `json:"reactions"`
+		}
+		json.NewDecoder(send(token, "GET", path, nil).Body).Decode(&post)
+		return post.Reactions
+	}
+
+	author := register("reactauthor")
+	rr := send(author, "POST", "/api/posts", map[string]string{"title": "React to me", "body": "..."})
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var post struct {
+		ID int64 `json:"id"`
+	}
+	json.NewDecoder(rr.Body).Decode(&post)
+	postPath := fmt.Sprintf("/api/posts/%d", post.ID)
+
+	t.Run("Reactions are idempotent and personal", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(author, "PUT", postPath+"/reactions/like", nil).Code)
+		assert.Equal(t, http.StatusOK, send(author, "PUT", postPath+"/reactions/like", nil).Code)
+		assert.Equal(t, http.StatusBadRequest, send(author, "PUT", postPath+"/reactions/angry", nil).Code)
+
+		assert.Equal(t, []reaction{{"like", 1, true}}, reactions(author, postPath))
+		assert.Equal(t, []reaction{{"like", 1, false}}, reactions("", postPath))
+	})
+
+	t.Run("Concurrent reactions are all counted", func(t *testing.T) {
+		const readers = 10
+		tokens := make([]string, readers)
+		for i := range tokens {
+			tokens[i] = register(fmt.Sprintf("reactor%d", i))
+		}
+
+		var wg sync.WaitGroup
+		for _, token := range tokens {
+			wg.Add(1)
+			go func(token string) {
+				defer wg.Done()
+				send(token, "PUT", postPath+"/reactions/love", nil)
+				send(token, "PUT", postPath+"/reactions/love", nil)
+			}(token)
+		}
+		wg.Wait()
+		assert.Equal(t, []reaction{{"like", 1, true}, {"love", readers, false}}, reactions(author, postPath))
+
+		for _, token := range tokens[:4] {
+			wg.Add(1)
+			go func(token string) {
+				defer wg.Done()
+				send(token, "DELETE", postPath+"/reactions/love", nil)
+			}(token)
+		}
+		wg.Wait()
+		assert.Equal(t, []reaction{{"like", 1, true}, {"love", readers - 4, false}}, reactions(author, postPath))
+	})
+
+	t.Run("Bookmarks are private", func(t *testing.T) {
+		reader := register("bookmarker")
+		assert.Equal(t, http.StatusNoContent, send(reader, "PUT", postPath+"/bookmark", nil).Code)
+		assert.Equal(t, http.StatusNoContent, send(reader, "PUT", postPath+"/bookmark", nil).Code)
+		assert.Equal(t, http.StatusNotFound, send(reader, "PUT", "/api/posts/0/bookmark", nil).Code)
+
+		var page struct {
+			Items []map[string]interface{} `json:"items"`
+		}
+		json.NewDecoder(send(reader, "GET", "/api/me/bookmarks", nil).Body).Decode(&page)
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, "React to me", page.Items[0]["title"])
+
+		assert.JSONEq(t, `{"items": []}`, send(author, "GET", "/api/me/bookmarks", nil).Body.String())
+
+		assert.Equal(t, http.StatusNoContent, send(reader, "DELETE", postPath+"/bookmark", nil).Code)
+		assert.JSONEq(t, `{"items": []}`, send(reader, "GET", "/api/me/bookmarks", nil).Body.String())
+	})
+}
+
+func TestMediaUploads(t *testing.T) {
+	cleanupDatabase()
+
+	login := func(username string) string {
+		body, _ := json.Marshal(map[string]string{
+			"username": username,
+			"email":    username + "@example.com",
+			"password": "copper-meadow-signal-73",
+		})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/register", bytes.NewReader(body)))
+		require.Equal(t, http.StatusCreated, rr.Code)
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/login", bytes.NewReader(body)))
+		var resp LoginResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		require.NotEmpty(t, resp.Token)
+		return resp.Token
+	}
+	upload := func(token string, file []byte) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		form := multipart.NewWriter(&buf)
+		part, _ := form.CreateFormFile("file", "photo.jpg")
+		part.Write(file)
+		form.Close()
+		req := httptest.NewRequest("POST", "/api/media", &buf)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	send := func(token, method, path string, payload interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if payload != nil {
+			json.NewEncoder(&buf).Encode(payload)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	type variant struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	}
+	type mediaView struct {
+		ID          int64     `json:"id"`
+		URL         string    `json:"url"`
+		ContentType string    `json:"content_type"`
+		Width       int       `json:"width"`
+		Status      string    `json:"status"`
+		Variants    []variant `json:"variants"`
+	}
+
+	alice := login("mediaalice")
+	bob := login("mediabob")
+
+	img := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	img.Set(0, 0, color.NRGBA{})
+	var file bytes.Buffer
+	require.NoError(t, png.Encode(&file, img))
+
+	// The type comes from the content, not the file name
+	rr := upload(alice, file.Bytes())
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var uploaded mediaView
+	json.NewDecoder(rr.Body).Decode(&uploaded)
+	assert.Equal(t, "image/png", uploaded.ContentType)
+	assert.Equal(t, "processing", uploaded.Status)
+	assert.Empty(t, uploaded.Variants)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, upload(alice, []byte("<script>alert(1)</script>")).Code)
+
+	mediaProcessor.RunOnce(context.Background())
+	rr = send("", "GET", fmt.Sprintf("/api/media/%d", uploaded.ID), nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var ready mediaView
+	json.NewDecoder(rr.Body).Decode(&ready)
+	assert.Equal(t, "ready", ready.Status)
+	require.Len(t, ready.Variants, 3)
+	assert.Equal(t, "thumbnail", ready.Variants[0].Name)
+
+	rr = send("", "GET", ready.Variants[2].URL, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "image/webp", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Header().Get("Cache-Control"), "immutable")
+
+	// Only uploads are served, not other files in the same store
+	require.NoError(t, blobs.Put(context.Background(), "private/secret.png", file.Bytes(), "image/png"))
+	assert.Equal(t, http.StatusNotFound, send("", "GET", "/media/private/secret.png", nil).Code)
+
+	// Only the uploader can attach or delete an image
+	rr = send(bob, "POST", "/api/posts", map[string]interface{}{"title": "Hi", "body": "x", "featured_media_id": uploaded.ID})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, http.StatusForbidden, send(bob, "DELETE", fmt.Sprintf("/api/media/%d", uploaded.ID), nil).Code)
+
+	rr = send(alice, "POST", "/api/posts", map[string]interface{}{"title": "Pictures", "body": "x", "featured_media_id": uploaded.ID})
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var post struct {
+		ID            int64       `json:"id"`
+		Media         []mediaView `json:"media"`
+		FeaturedImage *mediaView  `json:"featured_image"`
+	}
+	json.NewDecoder(rr.Body).Decode(&post)
+	require.Len(t, post.Media, 1)
+	require.NotNil(t, post.FeaturedImage)
+	assert.Equal(t, uploaded.ID, post.FeaturedImage.ID)
+
+	// Updates that leave out the images keep them
+	rr = send(alice, "PUT", fmt.Sprintf("/api/posts/%d", post.ID), map[string]interface{}{"title": "Pictures", "body": "y"})
+	require.Equal(t, http.StatusOK, rr.Code)
+	json.NewDecoder(rr.Body).Decode(&post)
+	assert.Len(t, post.Media, 1)
+	assert.NotNil(t, post.FeaturedImage)
+
+	// Deleting the image detaches it and removes its files
+	assert.Equal(t, http.StatusNoContent, send(alice, "DELETE", fmt.Sprintf("/api/media/%d", uploaded.ID), nil).Code)
+	mediaProcessor.RunOnce(context.Background())
+	assert.Equal(t, http.StatusNotFound, send("", "GET", uploaded.URL, nil).Code)
+
+	rr = send("", "GET", fmt.Sprintf("/api/posts/%d", post.ID), nil)
+	post.Media, post.FeaturedImage = nil, nil
+	json.NewDecoder(rr.Body).Decode(&post)
+	assert.Empty(t, post.Media)
+	assert.Nil(t, post.FeaturedImage)
+}
+
+func TestSlugs(t *testing.T) {
+	cleanupDatabase()
+
+	body, _ := json.Marshal(map[string]string{
+		"username": "slugwriter",
+		"email":    "slugwriter@example.com",
+		"password": "quartz-lagoon-ember-19",
+	})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/register", bytes.NewReader(body)))
+	require.Equal(t, http.StatusCreated, rr.Code)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/login", bytes.NewReader(body)))
+	var login LoginResponse
+	json.NewDecoder(rr.Body).Decode(&login)
+	require.NotEmpty(t, login.Token)
+
+	type slugPost struct {
+		ID        int64     `json:"id"`
+		Slug      string    `json:"slug"`
+		Permalink string    `json:"permalink"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	send := func(method, path string, payload interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if payload != nil {
+			json.NewEncoder(&buf).Encode(payload)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Authorization", "Bearer "+login.Token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	create := func(title string) slugPost {
+		rr := send("POST", "/api/posts", map[string]string{"title": title, "body": "x"})
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var post slugPost
+		json.NewDecoder(rr.Body).Decode(&post)
+		return post
+	}
+
+	first := create("Crème Brûlée, Explained")
+	second := create("Creme Brulee explained!")
+	assert.Equal(t, "creme-brulee-explained", first.Slug)
+	assert.Equal(t, "creme-brulee-explained-2", second.Slug)
+	assert.Equal(t, "/posts/creme-brulee-explained", first.Permalink)
+
+	rr = send("GET", "/api/posts/by-slug/creme-brulee-explained-2", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var got slugPost
+	json.NewDecoder(rr.Body).Decode(&got)
+	assert.Equal(t, second.ID, got.ID)
+
+	// Retitling moves the post to a new slug and the old one redirects
+	rr = send("PUT", fmt.Sprintf("/api/posts/%d", first.ID), map[string]string{"title": "Custard, Torched", "body": "x"})
+	require.Equal(t, http.StatusOK, rr.Code)
+	json.NewDecoder(rr.Body).Decode(&got)
+	assert.Equal(t, "custard-torched", got.Slug)
+
+	rr = send("GET", "/api/posts/by-slug/creme-brulee-explained", nil)
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+	assert.Equal(t, "/api/posts/by-slug/custard-torched", rr.Header().Get("Location"))
+
+	// Old slugs are never handed to another post
+	third := create("Crème brûlée explained")
+	assert.Equal(t, "creme-brulee-explained-3", third.Slug)
+
+	// Retitling back reclaims the post's own old slug
+	rr = send("PUT", fmt.Sprintf("/api/posts/%d", first.ID), map[string]string{"title": "Crème Brûlée, Explained", "body": "x"})
+	json.NewDecoder(rr.Body).Decode(&got)
+	assert.Equal(t, "creme-brulee-explained", got.Slug)
+
+	// Date permalinks redirect to the day the post was created
+	day := first.CreatedAt.UTC().Format("2006/01/02")
+	rr = send("GET", "/api/posts/"+day+"/creme-brulee-explained", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = send("GET", "/api/posts/1999/01/01/custard-torched", nil)
+	assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+	assert.Equal(t, "/api/posts/"+day+"/creme-brulee-explained", rr.Header().Get("Location"))
+
+	assert.Equal(t, http.StatusNotFound, send("GET", "/api/posts/by-slug/never-was", nil).Code)
+}
+
+func TestSitemap(t *testing.T) {
+	cleanupDatabase()
+
+	body, _ := json.Marshal(map[string]string{
+		"username": "mapmaker",
+		"email":    "mapmaker@example.com",
+		"password": "granite-willow-beacon-64",
+	})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/register", bytes.NewReader(body)))
+	require.Equal(t, http.StatusCreated, rr.Code)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/login", bytes.NewReader(body)))
+	var login LoginResponse
+	json.NewDecoder(rr.Body).Decode(&login)
+	require.NotEmpty(t, login.Token)
+
+	for _, title := range []string{"First Light", "Second Wind"} {
+		payload, _ := json.Marshal(map[string]string{"title": title, "body": "x"})
+		req := httptest.NewRequest("POST", "/api/posts", bytes.NewReader(payload))
+		req.Header.Set("Authorization", "Bearer "+login.Token)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusCreated, rr.Code)
+	}
+	site := config.Default().Frontend.URL
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/sitemap.xml", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var index struct {
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+	require.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &index))
+	require.Len(t, index.Sitemaps, 1)
+
+	// Child sitemaps are gzipped for clients that accept it
+	req := httptest.NewRequest("GET", strings.TrimPrefix(index.Sitemaps[0].Loc, strings.TrimSuffix(site, "/")), nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	zr, err := gzip.NewReader(rr.Body)
+	require.NoError(t, err)
+	var urls struct {
+		URLs []struct {
+			Loc     string `xml:"loc"`
+			LastMod string `xml:"lastmod"`
+		} `xml:"url"`
+	}
+	require.NoError(t, xml.NewDecoder(zr).Decode(&urls))
+	require.Len(t, urls.URLs, 2)
+	assert.Equal(t, strings.TrimSuffix(site, "/")+"/posts/first-light", urls.URLs[0].Loc)
+	assert.NotEmpty(t, urls.URLs[0].LastMod)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/sitemaps/posts-999999999.xml", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/robots.txt", nil))
+	assert.Contains(t, rr.Body.String(), "Disallow: /api/\n")
+	assert.Contains(t, rr.Body.String(), "Sitemap: "+strings.TrimSuffix(site, "/")+"/sitemap.xml")
+}
+
+func TestWebhooks(t *testing.T) {
+	cleanupDatabase()
+
+	login := func(username string) string {
+		body, _ := json.Marshal(map[string]string{
+			"username": username,
+			"email":    username + "@example.com",
+			"password": "cedar-harbor-violet-71",
+		})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/register", bytes.NewReader(body)))
+		require.Equal(t, http.StatusCreated, rr.Code)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/login", bytes.NewReader(body)))
+		var resp LoginResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		require.NotEmpty(t, resp.Token)
+		return resp.Token
+	}
+	send := func(token, method, path string, payload interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if payload != nil {
+			json.NewEncoder(&buf).Encode(payload)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	var mu sync.Mutex
+	var got []received
+	var status atomic.Int32
+	status.Store(http.StatusNoContent)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		buf.ReadFrom(r.Body)
+		mu.Lock()
+		got = append(got, received{r.Header.Clone(), buf.Bytes()})
+		mu.Unlock()
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer receiver.Close()
+	last := func() received {
+		mu.Lock()
+		defer mu.Unlock()
+		require.NotEmpty(t, got)
+		return got[len(got)-1]
+	}
+
+	admin := login("hookadmin")
+	_, err := db.Exec("UPDATE users SET role = 'admin' WHERE username = 'hookadmin'")
+	require.NoError(t, err)
+	author := login("hookauthor")
+	// Their registrations happened before anyone subscribed
+	bus.RunOnce(context.Background())
+
+	rr := send(author, "POST", "/api/admin/webhooks", map[string]interface{}{"url": receiver.URL, "events": []string{"post.published"}})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = send(admin, "POST", "/api/admin/webhooks", map[string]interface{}{"url": receiver.URL, "events": []string{"post.deleted"}})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = send(admin, "POST", "/api/admin/webhooks", map[string]interface{}{"url": "http://169.254.169.254/latest/meta-data", "events": []string{"post.published"}})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = send(admin, "POST", "/api/admin/webhooks", map[string]interface{}{
+		"url":    receiver.URL,
+		"events": []string{"post.published", "user.registered"},
+	})
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var sub struct {
+		ID                  int64  `json:"id"`
+		Secret              string `json:"secret"`
+		Active              bool   `json:"active"`
+		ConsecutiveFailures int    `json:"consecutive_failures"`
+		DisabledReason      string `json:"disabled_reason"`
+	}
+	json.NewDecoder(rr.Body).Decode(&sub)
+	require.NotEmpty(t, sub.Secret)
+	hook := fmt.Sprintf("/api/admin/webhooks/%d", sub.ID)
+
+	type delivery struct {
+		ID             int64  `json:"id"`
+		EventID        string `json:"event_id"`
+		EventType      string `json:"event_type"`
+		Status         string `json:"status"`
+		Attempts       int    `json:"attempts"`
+		ResponseStatus int    `json:"response_status"`
+		Error          string `json:"error"`
+	}
+	deliveries := func() []delivery {
+		rr := send(admin, "GET", hook+"/deliveries", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var page struct {
+			Items []delivery `json:"items"`
+		}
+		json.NewDecoder(rr.Body).Decode(&page)
+		return page.Items
+	}
+
+	t.Run("Signed delivery of a published post", func(t *testing.T) {
+		rr := send(author, "POST", "/api/posts", map[string]string{"title": "Hooked", "body": "x"})
+		require.Equal(t, http.StatusCreated, rr.Code)
+		bus.RunOnce(context.Background())
+		webhooks.RunOnce(context.Background())
+
+		req := last()
+		assert.Equal(t, "post.published", req.header.Get(webhook.HeaderEvent))
+		assert.NoError(t, webhook.Verify(sub.Secret, req.header.Get(webhook.HeaderSignature), req.body, time.Minute, time.Now()))
+		var envelope struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+			Data struct {
+				Title string `json:"title"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(req.body, &envelope))
+		assert.Equal(t, req.header.Get(webhook.HeaderEventID), envelope.ID)
+		assert.Equal(t, "Hooked", envelope.Data.Title)
+
+		log := deliveries()
+		require.Len(t, log, 1)
+		assert.Equal(t, "delivered", log[0].Status)
+		assert.Equal(t, http.StatusNoContent, log[0].ResponseStatus)
+	})
+
+	t.Run("Replay sends the same event again", func(t *testing.T) {
+		first := deliveries()[0]
+		rr := send(admin, "POST", fmt.Sprintf("%s/deliveries/%d/replay", hook, first.ID), nil)
+		require.Equal(t, http.StatusAccepted, rr.Code)
+		webhooks.RunOnce(context.Background())
+
+		assert.Equal(t, first.EventID, last().header.Get(webhook.HeaderEventID))
+		assert.Len(t, deliveries(), 2)
+	})
+
+	t.Run("Handling an event again queues nothing new", func(t *testing.T) {
+		_, err := db.Exec("UPDATE outbox_events SET status = 'pending', done = '{}', processed_at = NULL WHERE type = 'PostPublished'")
+		require.NoError(t, err)
+		bus.RunOnce(context.Background())
+		webhooks.RunOnce(context.Background())
+		assert.Len(t, deliveries(), 2)
+	})
+
+	t.Run("Failing endpoint is retried, then disabled", func(t *testing.T) {
+		status.Store(http.StatusInternalServerError)
+		login("hooknewcomer")
+		bus.RunOnce(context.Background())
+		for i := 0; i < 20 && sub.Active; i++ {
+			time.Sleep(5 * time.Millisecond)
+			webhooks.RunOnce(context.Background())
+			rr := send(admin, "GET", hook, nil)
+			json.NewDecoder(rr.Body).Decode(&sub)
+		}
+		assert.False(t, sub.Active)
+		assert.Equal(t, 2, sub.ConsecutiveFailures)
+		assert.NotEmpty(t, sub.DisabledReason)
+
+		newest := deliveries()[0]
+		assert.Equal(t, "user.registered", newest.EventType)
+		assert.Equal(t, "pending", newest.Status)
+		assert.Equal(t, 2, newest.Attempts)
+		assert.Contains(t, newest.Error, "500")
+	})
+
+	t.Run("Reactivating sends what is pending", func(t *testing.T) {
+		status.Store(http.StatusOK)
+		rr := send(admin, "PATCH", hook, map[string]bool{"active": true})
+		require.Equal(t, http.StatusOK, rr.Code)
+		json.NewDecoder(rr.Body).Decode(&sub)
+		assert.True(t, sub.Active)
+		assert.Zero(t, sub.ConsecutiveFailures)
+		assert.Empty(t, sub.DisabledReason)
+
+		time.Sleep(5 * time.Millisecond)
+		webhooks.RunOnce(context.Background())
+		assert.Equal(t, "delivered", deliveries()[0].Status)
+		assert.Equal(t, "user.registered", last().header.Get(webhook.HeaderEvent))
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	cleanupDatabase()
+
+	credentials := map[string]string{
+		"username": "lockeduser",
+		"email":    "lockeduser@example.com",
+		"password": "amber-canyon-willow-58",
+	}
+	body, _ := json.Marshal(credentials)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/register", bytes.NewReader(body)))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	login := func(email, password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/login", bytes.NewReader(body)))
+		return rr
+	}
+
+	t.Run("Parallel guesses cannot pass the delay", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Equal(t, http.StatusUnauthorized, login(credentials["email"], "wrong-password").Code)
+			}()
+		}
+		wg.Wait()
+
+		// The free attempts and the one that earned the delay were
+		// checked; the rest were refused without a look at the password
+		var failures int
+		require.NoError(t, db.QueryRow("SELECT failed_login_attempts FROM users WHERE username = 'lockeduser'").Scan(&failures))
+		assert.Equal(t, config.Default().Lockout.FreeAttempts+1, failures)
+	})
+
+	t.Run("Locked account looks like an unknown email", func(t *testing.T) {
+		locked := login(credentials["email"], credentials["password"])
+		unknown := login("nobody@example.com", credentials["password"])
+		assert.Equal(t, unknown.Code, locked.Code)
+		assert.Equal(t, unknown.Body.String(), locked.Body.String())
+		assert.Empty(t, locked.Header().Get("Retry-After"))
+	})
+
+	t.Run("Unlock token can be used once", func(t *testing.T) {
+		// Tokens are stored hashed; stand in for the account locked email
+		token := "unlock-token-for-test"
+		sum := sha256.Sum256([]byte(token))
+		_, err := db.Exec(`
+			INSERT INTO account_unlock_tokens (user_id, token_hash, expired_at)
+			SELECT id, $1, NOW() + INTERVAL '1 hour' FROM users WHERE username = 'lockeduser'`, hex.EncodeToString(sum[:]))
+		require.NoError(t, err)
+
+		unlock := func(token string) int {
+			return apiRequest("", "POST", "/api/account/unlock", map[string]string{"token": token}).Code
+		}
+		assert.Equal(t, http.StatusBadRequest, unlock(hex.EncodeToString(sum[:])))
+
+		var wg sync.WaitGroup
+		var unlocked atomic.Int32
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if unlock(token) == http.StatusOK {
+					unlocked.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.EqualValues(t, 1, unlocked.Load())
+		assert.Equal(t, http.StatusOK, login(credentials["email"], credentials["password"]).Code)
+	})
+}
+
+func TestAccessTokens(t *testing.T) {
+	cleanupDatabase()
+	owner := signUp(t, "tokenowner")
+	other := signUp(t, "tokenother")
+
+	type accessToken struct {
+		ID         int64      `json:"id"`
+		Name       string     `json:"name"`
+		Prefix     string     `json:"prefix"`
+		Scopes     []string   `json:"scopes"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+	}
+	list := func(session string) []accessToken {
+		rr := apiRequest(session, "GET", "/api/me/tokens", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var tokens []accessToken
+		json.NewDecoder(rr.Body).Decode(&tokens)
+		return tokens
+	}
+
+	t.Run("Create validates the request", func(t *testing.T) {
+		rr := apiRequest(owner, "POST", "/api/me/tokens", map[string]interface{}{"name": " ", "scopes": []string{"posts:read"}})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		rr = apiRequest(owner, "POST", "/api/me/tokens", map[string]interface{}{"name": "ci"})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		rr = apiRequest(owner, "POST", "/api/me/tokens", map[string]interface{}{"name": "ci", "scopes": []string{"admin"}})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		rr = apiRequest(owner, "POST", "/api/me/tokens", map[string]interface{}{
+			"name": "ci", "scopes": []string{"posts:read"}, "expires_at": time.Now().Add(-time.Hour),
+		})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	var secret string
+	var created accessToken
+	t.Run("Create shows the token once", func(t *testing.T) {
+		rr := apiRequest(owner, "POST", "/api/me/tokens", map[string]interface{}{"name": "ci", "scopes": []string{"posts:read"}})
+		require.Equal(t, http.StatusCreated, rr.Code)
+		var resp struct {
+			Token       string      `json:"token"`
+			AccessToken accessToken `json:"access_token"`
+		}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		secret, created = resp.Token, resp.AccessToken
+		assert.True(t, strings.HasPrefix(secret, middleware.AccessTokenPrefix))
+		assert.True(t, strings.HasPrefix(secret, created.Prefix))
+
+		tokens := list(owner)
+		require.Len(t, tokens, 1)
+		assert.Equal(t, "ci", tokens[0].Name)
+		assert.Nil(t, tokens[0].LastUsedAt)
+		assert.NotContains(t, apiRequest(owner, "GET", "/api/me/tokens", nil).Body.String(), secret)
+		assert.Empty(t, list(other))
+	})
+
+	t.Run("Token reaches only routes within its scopes", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, apiRequest(secret, "GET", "/api/posts", nil).Code)
+		assert.Equal(t, http.StatusForbidden, apiRequest(secret, "POST", "/api/posts", map[string]string{"title": "t", "body": "b"}).Code)
+		assert.Equal(t, http.StatusForbidden, apiRequest(secret, "GET", "/api/me", nil).Code)
+	})
+
+	t.Run("Last use is written at most once a minute", func(t *testing.T) {
+		first := list(owner)[0].LastUsedAt
+		require.NotNil(t, first)
+		require.Equal(t, http.StatusOK, apiRequest(secret, "GET", "/api/posts", nil).Code)
+		assert.Equal(t, first.UnixNano(), list(owner)[0].LastUsedAt.UnixNano())
+
+		_, err := db.Exec("UPDATE access_tokens SET last_used_at = NOW() - INTERVAL '2 minutes' WHERE id = $1", created.ID)
+		require.NoError(t, err)
+		stale := list(owner)[0].LastUsedAt
+		require.Equal(t, http.StatusOK, apiRequest(secret, "GET", "/api/posts", nil).Code)
+		assert.True(t, list(owner)[0].LastUsedAt.After(*stale))
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		path := fmt.Sprintf("/api/me/tokens/%d", created.ID)
+		assert.Equal(t, http.StatusNotFound, apiRequest(other, "DELETE", path, nil).Code)
+		assert.Equal(t, http.StatusBadRequest, apiRequest(owner, "DELETE", "/api/me/tokens/x", nil).Code)
+		assert.Equal(t, http.StatusNoContent, apiRequest(owner, "DELETE", path, nil).Code)
+
+		assert.Equal(t, http.StatusUnauthorized, apiRequest(secret, "GET", "/api/posts", nil).Code)
+		assert.Empty(t, list(owner))
+		assert.Equal(t, http.StatusNotFound, apiRequest(owner, "DELETE", path, nil).Code)
+	})
+}
+
+func TestOAuthTokenEndpoint(t *testing.T) {
+	cleanupDatabase()
+	owner := signUp(t, "oauthowner")
+
+	const redirectURI = "https://app.example.com/callback"
+	register := func(name string) (string, string) {
+		rr := apiRequest(owner, "POST", "/api/oauth/clients", map[string]interface{}{
+			"name": name, "redirect_uris": []string{redirectURI}, "scopes": []string{"posts:read"}, "confidential": true,
+		})
+		require.Equal(t, http.StatusCreated, rr.Code)
+		var resp struct {
+			Client struct {
+				ClientID string `json:"client_id"`
+			} `json:"client"`
+			ClientSecret string `json:"client_secret"`
+		}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		return resp.Client.ClientID, resp.ClientSecret
+	}
+	clientID, clientSecret := register("reader")
+	otherID, otherSecret := register("other reader")
+
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk-oauth-test"
+	challenge := sha256.Sum256([]byte(verifier))
+	authorize := func() string {
+		rr := apiRequest(owner, "POST", "/api/oauth/authorize", map[string]interface{}{
+			"response_type": "code", "client_id": clientID, "redirect_uri": redirectURI, "scope": "posts:read",
+			"code_challenge": base64.RawURLEncoding.EncodeToString(challenge[:]), "code_challenge_method": "S256",
+			"approve": true,
+		})
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp struct {
+			RedirectTo string `json:"redirect_to"`
+		}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		target, err := url.Parse(resp.RedirectTo)
+		require.NoError(t, err)
+		code := target.Query().Get("code")
+		require.NotEmpty(t, code)
+		return code
+	}
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	redeem := func(code, redirect, codeVerifier string) *httptest.ResponseRecorder {
+		return post("/api/oauth/token", url.Values{
+			"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirect},
+			"code_verifier": {codeVerifier}, "client_id": {clientID}, "client_secret": {clientSecret},
+		})
+	}
+	refresh := func(token string) *httptest.ResponseRecorder {
+		return post("/api/oauth/token", url.Values{
+			"grant_type": {"refresh_token"}, "refresh_token": {token},
+			"client_id": {clientID}, "client_secret": {clientSecret},
+		})
+	}
+	introspect := func(id, secret, token string) bool {
+		rr := post("/api/oauth/introspect", url.Values{"token": {token}, "client_id": {id}, "client_secret": {secret}})
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp struct {
+			Active bool `json:"active"`
+		}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		return resp.Active
+	}
+	tokens := func(rr *httptest.ResponseRecorder) handlers.TokenResponse {
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp handlers.TokenResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		require.NotEmpty(t, resp.AccessToken)
+		require.NotEmpty(t, resp.RefreshToken)
+		return resp
+	}
+	assertInvalidGrant := func(t *testing.T, rr *httptest.ResponseRecorder) {
+		t.Helper()
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid_grant")
+	}
+
+	t.Run("Code verifier must match the challenge", func(t *testing.T) {
+		code := authorize()
+		assertInvalidGrant(t, redeem(code, redirectURI, strings.Repeat("x", 43)))
+		// The failed attempt used the code up
+		assertInvalidGrant(t, redeem(code, redirectURI, verifier))
+	})
+
+	t.Run("Redirect URI must match the authorization", func(t *testing.T) {
+		assertInvalidGrant(t, redeem(authorize(), "https://app.example.com/other", verifier))
+	})
+
+	t.Run("Client must be authenticated", func(t *testing.T) {
+		rr := post("/api/oauth/token", url.Values{
+			"grant_type": {"authorization_code"}, "code": {authorize()}, "redirect_uri": {redirectURI},
+			"code_verifier": {verifier}, "client_id": {clientID}, "client_secret": {"wrong"},
+		})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid_client")
+	})
+
+	t.Run("Code can be redeemed once", func(t *testing.T) {
+		code := authorize()
+		issued := tokens(redeem(code, redirectURI, verifier))
+		assert.Equal(t, "posts:read", issued.Scope)
+		assert.Equal(t, http.StatusOK, apiRequest(issued.AccessToken, "GET", "/api/posts", nil).Code)
+		assertInvalidGrant(t, redeem(code, redirectURI, verifier))
+	})
+
+	t.Run("Refresh rotates the refresh token", func(t *testing.T) {
+		first := tokens(redeem(authorize(), redirectURI, verifier))
+		second := tokens(refresh(first.RefreshToken))
+		assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+		assert.False(t, introspect(clientID, clientSecret, first.RefreshToken))
+		assert.True(t, introspect(clientID, clientSecret, second.RefreshToken))
+		assert.True(t, introspect(clientID, clientSecret, second.AccessToken))
+	})
+
+	t.Run("Reusing a refresh token revokes the grant", func(t *testing.T) {
+		first := tokens(redeem(authorize(), redirectURI, verifier))
+		second := tokens(refresh(first.RefreshToken))
+
+		assertInvalidGrant(t, refresh(first.RefreshToken))
+		assert.False(t, introspect(clientID, clientSecret, second.RefreshToken))
+		assert.False(t, introspect(clientID, clientSecret, second.AccessToken))
+		assertInvalidGrant(t, refresh(second.RefreshToken))
+		assert.Equal(t, http.StatusUnauthorized, apiRequest(second.AccessToken, "GET", "/api/posts", nil).Code)
+	})
+
+	t.Run("Clients can only introspect their own tokens", func(t *testing.T) {
+		issued := tokens(redeem(authorize(), redirectURI, verifier))
+		assert.True(t, introspect(clientID, clientSecret, issued.AccessToken))
+		assert.False(t, introspect(otherID, otherSecret, issued.AccessToken))
+		assert.False(t, introspect(otherID, otherSecret, issued.RefreshToken))
+	})
+}
+
+func TestMagicLink(t *testing.T) {
+	cleanupDatabase()
+	signUp(t, "magicuser")
+
+	request := func(email string) (map[string]string, int) {
+		rr := apiRequest("", "POST", "/api/login/magic-link", map[string]string{"email": email})
+		var resp map[string]string
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return resp, rr.Code
+	}
+	hash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	// emailed stands in for the outbox subscriber, which makes the token
+	// and emails it
+	emailed := func(t *testing.T, requestID string) string {
+		t.Helper()
+		token := "token-for-" + requestID
+		result, err := db.Exec("UPDATE magic_link_tokens SET token_hash = $1 WHERE request_hash = $2", hash(token), hash(requestID))
+		require.NoError(t, err)
+		n, _ := result.RowsAffected()
+		require.EqualValues(t, 1, n)
+		return token
+	}
+	confirm := func(t *testing.T, token string) string {
+		t.Helper()
+		rr := apiRequest("", "POST", "/api/login/magic-link/confirm", map[string]string{"token": token})
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp map[string]string
+		json.NewDecoder(rr.Body).Decode(&resp)
+		require.Len(t, resp["code"], 6)
+		return resp["code"]
+	}
+	complete := func(requestID, code string) *httptest.ResponseRecorder {
+		return apiRequest("", "POST", "/api/login/magic-link/complete", map[string]string{"request_id": requestID, "code": code})
+	}
+	start := func(t *testing.T) string {
+		t.Helper()
+		resp, code := request("magicuser@example.com")
+		require.Equal(t, http.StatusOK, code)
+		require.NotEmpty(t, resp["request_id"])
+		return resp["request_id"]
+	}
+	requested := func() int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM outbox_events WHERE type = 'MagicLinkRequested'").Scan(&n)
+		return n
+	}
+
+	t.Run("Unknown email gets the same response", func(t *testing.T) {
+		before := requested()
+		known, knownCode := request("magicuser@example.com")
+		unknown, unknownCode := request("nobody@example.com")
+
+		assert.Equal(t, knownCode, unknownCode)
+		assert.Equal(t, known["message"], unknown["message"])
+		assert.NotEmpty(t, unknown["request_id"])
+		assert.NotEqual(t, known["request_id"], unknown["request_id"])
+		// Only the known email is sent, from the outbox
+		assert.Equal(t, before+1, requested())
+
+		rr := complete(unknown["request_id"], "000000")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Complete before confirming is pending", func(t *testing.T) {
+		requestID := start(t)
+		emailed(t, requestID)
+		rr := complete(requestID, "000000")
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+		assert.Contains(t, rr.Body.String(), "pending")
+	})
+
+	t.Run("Link without an emailed token cannot be confirmed", func(t *testing.T) {
+		start(t)
+		rr := apiRequest("", "POST", "/api/login/magic-link/confirm", map[string]string{"token": ""})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Wrong codes end the sign-in", func(t *testing.T) {
+		requestID := start(t)
+		code := confirm(t, emailed(t, requestID))
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+		for i := 0; i < config.Default().MagicLink.CodeAttempts; i++ {
+			assert.Equal(t, http.StatusBadRequest, complete(requestID, wrong).Code)
+		}
+		assert.Equal(t, http.StatusBadRequest, complete(requestID, code).Code)
+	})
+
+	t.Run("Parallel guesses share the attempts", func(t *testing.T) {
+		requestID := start(t)
+		code := confirm(t, emailed(t, requestID))
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 3*config.Default().MagicLink.CodeAttempts; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Equal(t, http.StatusBadRequest, complete(requestID, wrong).Code)
+			}()
+		}
+		wg.Wait()
+
+		var attempts int
+		require.NoError(t, db.QueryRow("SELECT attempts FROM magic_link_tokens WHERE request_hash = $1", hash(requestID)).Scan(&attempts))
+		assert.Equal(t, config.Default().MagicLink.CodeAttempts, attempts)
+		assert.Equal(t, http.StatusBadRequest, complete(requestID, code).Code)
+	})
+
+	t.Run("Confirming again keeps the attempts used", func(t *testing.T) {
+		requestID := start(t)
+		token := emailed(t, requestID)
+		confirm(t, token)
+		for i := 0; i < config.Default().MagicLink.CodeAttempts; i++ {
+			complete(requestID, "not-a-code")
+		}
+		code := confirm(t, token)
+		assert.Equal(t, http.StatusBadRequest, complete(requestID, code).Code)
+	})
+
+	t.Run("Used link is refused", func(t *testing.T) {
+		requestID := start(t)
+		token := emailed(t, requestID)
+		code := confirm(t, token)
+
+		rr := complete(requestID, code)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp LoginResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		assert.NotEmpty(t, resp.Token)
+
+		assert.Equal(t, http.StatusBadRequest, complete(requestID, code).Code)
+		rr = apiRequest("", "POST", "/api/login/magic-link/confirm", map[string]string{"token": token})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestEraseUser(t *testing.T) {
+	cleanupDatabase()
+	// cleanupDatabase removes the placeholder the migration created
+	_, err := db.Exec(`
+		INSERT INTO users (username, email, password, display_name, is_placeholder)
+		SELECT 'deleted-user', 'deleted-user@placeholder.invalid', '', 'Deleted user', TRUE
+		WHERE NOT EXISTS (SELECT 1 FROM users WHERE is_placeholder)`)
+	require.NoError(t, err)
+	var placeholderID int64
+	require.NoError(t, db.QueryRow("SELECT id FROM users WHERE is_placeholder").Scan(&placeholderID))
+
+	users := repository.NewUserRepository(db)
+	ctx := context.Background()
+	past := time.Now().Add(-time.Minute)
+
+	// account signs username up with a post and schedules its deletion
+	account := func(t *testing.T, username string) (int64, int64) {
+		t.Helper()
+		token := signUp(t, username)
+		rr := apiRequest(token, "POST", "/api/posts", map[string]string{"title": "Post by " + username, "body": "Body"})
+		require.Equal(t, http.StatusCreated, rr.Code)
+		var post Post
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&post))
+
+		var userID int64
+		require.NoError(t, db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&userID))
+		require.NoError(t, users.ScheduleDeletion(ctx, userID, &past))
+		return userID, post.ID
+	}
+	exists := func(table string, id int64) bool {
+		var n int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE id = $1", id).Scan(&n))
+		return n > 0
+	}
+
+	t.Run("Posts are moved to the placeholder", func(t *testing.T) {
+		userID, postID := account(t, "erasekeep")
+		erased, err := users.Erase(ctx, userID, true)
+		require.NoError(t, err)
+		assert.True(t, erased)
+
+		assert.False(t, exists("users", userID))
+		var authorID int64
+		require.NoError(t, db.QueryRow("SELECT author_id FROM posts WHERE id = $1", postID).Scan(&authorID))
+		assert.Equal(t, placeholderID, authorID)
+	})
+
+	t.Run("Posts are deleted", func(t *testing.T) {
+		userID, postID := account(t, "erasedrop")
+		erased, err := users.Erase(ctx, userID, false)
+		require.NoError(t, err)
+		assert.True(t, erased)
+
+		assert.False(t, exists("users", userID))
+		assert.False(t, exists("posts", postID))
+	})
+
+	t.Run("Deletion that is not due is left alone", func(t *testing.T) {
+		userID, postID := account(t, "erasefuture")
+		future := time.Now().Add(time.Hour)
+		require.NoError(t, users.ScheduleDeletion(ctx, userID, &future))
+
+		erased, err := users.Erase(ctx, userID, false)
+		require.NoError(t, err)
+		assert.False(t, erased)
+		assert.True(t, exists("posts", postID))
+
+		erased, err = users.Erase(ctx, placeholderID, false)
+		require.NoError(t, err)
+		assert.False(t, erased)
+	})
+
+	t.Run("Cancellation racing erasure wins", func(t *testing.T) {
+		userID, postID := account(t, "eraserace")
+
+		// Cancel in a transaction that holds the row while Erase starts
+		tx, err := db.Begin()
+		require.NoError(t, err)
+		defer tx.Rollback()
+		_, err = tx.Exec("UPDATE users SET deletion_scheduled_at = NULL WHERE id = $1", userID)
+		require.NoError(t, err)
+
+		type result struct {
+			erased bool
+			err    error
+		}
+		done := make(chan result, 1)
+		go func() {
+			erased, err := users.Erase(ctx, userID, false)
+			done <- result{erased, err}
+		}()
+		select {
+		case <-done:
+			t.Fatal("Erase did not wait for the cancellation")
+		case <-time.After(200 * time.Millisecond):
+		}
+		require.NoError(t, tx.Commit())
+
+		res := <-done
+		require.NoError(t, res.err)
+		assert.False(t, res.erased)
+		assert.True(t, exists("users", userID))
+		assert.True(t, exists("posts", postID))
+	})
+}
+
+func TestPasswordReset(t *testing.T) {
+	cleanupDatabase()
+	signUp(t, "resetuser")
+
+	rr := apiRequest("", "POST", "/api/password-reset", map[string]string{"email": "resetuser@example.com"})
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// The token is made by the outbox subscriber that emails it, and only
+	// its hash is stored; stand in for the subscriber
+	var resetID int64
+	var tokenHash sql.NullString
+	require.NoError(t, db.QueryRow("SELECT id, token_hash FROM password_reset_tokens ORDER BY id DESC LIMIT 1").Scan(&resetID, &tokenHash))
+	assert.False(t, tokenHash.Valid)
+	token := "reset-token-for-test"
+	sum := sha256.Sum256([]byte(token))
+	_, err := db.Exec("UPDATE password_reset_tokens SET token_hash = $1 WHERE id = $2", hex.EncodeToString(sum[:]), resetID)
+	require.NoError(t, err)
+
+	confirm := func(token string) int {
+		return apiRequest("", "POST", "/api/password-reset/confirm", map[string]string{"token": token, "password": "harbor-violet-tundra-58"}).Code
+	}
+	assert.Equal(t, http.StatusBadRequest, confirm(hex.EncodeToString(sum[:])))
+	assert.Equal(t, http.StatusOK, confirm(token))
+	assert.Equal(t, http.StatusBadRequest, confirm(token))
+
+	rr = apiRequest("", "POST", "/api/login", map[string]string{"email": "resetuser@example.com", "password": "harbor-violet-tundra-58"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
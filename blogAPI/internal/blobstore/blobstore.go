@@ -0,0 +1,47 @@
+// Package blobstore stores uploaded files, such as media, behind a small
+// interface with a local filesystem backend and an S3-compatible one.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned by Open for a key that holds no blob.
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs under slash-separated keys. Blobs are written whole
+// and never modified, so callers use a new key for new content.
+type Store interface {
+	// Put stores data under key, replacing any blob already there.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Open returns the blob under key, or ErrNotFound.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob under key. Deleting a missing blob is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidKey reports whether key is a relative slash-separated path without
+// empty, "." or ".." segments, so it cannot escape the store.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 512 {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." || strings.ContainsAny(seg, "\\\x00") {
+			return false
+		}
+	}
+	return true
+}
+
+func checkKey(key string) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
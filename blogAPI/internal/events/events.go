@@ -0,0 +1,57 @@
+// Package events defines the domain events the API records when its
+// state changes. Repositories write them to the outbox in the same
+// transaction as the change, and the outbox dispatcher hands them to
+// subscribers, so an event is seen if and only if its change committed.
+//
+// Events carry IDs rather than copies of the data, which subscribers load
+// when they run; the outbox never holds personal data or secrets.
+package events
+
+// Event is a fact about the domain. Type names it in the outbox.
+type Event interface {
+	Type() string
+}
+
+// UserRegistered is recorded when an account is created, by sign-up or by
+// a first OpenID Connect login.
+type UserRegistered struct {
+	UserID int64 `json:"user_id"`
+}
+
+func (UserRegistered) Type() string { return "UserRegistered" }
+
+// PostPublished is recorded when a post is created. Posts have no drafts,
+// so they are published as soon as they are created.
+type PostPublished struct {
+	PostID   int64 `json:"post_id"`
+	AuthorID int64 `json:"author_id"`
+}
+
+func (PostPublished) Type() string { return "PostPublished" }
+
+// PostUpdated is recorded when the author edits a post.
+type PostUpdated struct {
+	PostID   int64 `json:"post_id"`
+	AuthorID int64 `json:"author_id"`
+}
+
+func (PostUpdated) Type() string { return "PostUpdated" }
+
+// PasswordResetRequested is recorded when a reset token is issued. ResetID
+// identifies the token to email.
+type PasswordResetRequested struct {
+	UserID  int64 `json:"user_id"`
+	ResetID int64 `json:"reset_id"`
+}
+
+func (PasswordResetRequested) Type() string { return "PasswordResetRequested" }
+
+// MagicLinkRequested is recorded when a passwordless sign-in is requested
+// for an existing account. LinkID identifies the sign-in to email a link
+// for.
+type MagicLinkRequested struct {
+	UserID int64 `json:"user_id"`
+	LinkID int64 `json:"link_id"`
+}
+
+func (MagicLinkRequested) Type() string { return "MagicLinkRequested" }
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/middleware"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/gorilla/mux"
+)
+
+type AccessTokenHandler struct {
+	tokenRepo *repository.AccessTokenRepository
+}
+
+func NewAccessTokenHandler(tokenRepo *repository.AccessTokenRepository) *AccessTokenHandler {
+	return &AccessTokenHandler{tokenRepo: tokenRepo}
+}
+
+type CreateAccessTokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type CreateAccessTokenResponse struct {
+	Token       string              `json:"token"`
+	AccessToken *models.AccessToken `json:"access_token"`
+}
+
+func (h *AccessTokenHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateAccessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !middleware.ValidScope(scope) {
+			http.Error(w, "Unknown scope "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "Expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := generateAccessToken()
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+	token := &models.AccessToken{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    secret[:len(middleware.AccessTokenPrefix)+6],
+		TokenHash: middleware.HashAccessToken(secret),
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := h.tokenRepo.Create(r.Context(), token); err != nil {
+		http.Error(w, "Error saving token", http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(r.Context()).Info("access token created", "token_id", token.ID)
+
+	// The token is only ever shown in this response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAccessTokenResponse{Token: secret, AccessToken: token})
+}
+
+func (h *AccessTokenHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := h.tokenRepo.ListByUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func (h *AccessTokenHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.tokenRepo.Revoke(r.Context(), userID, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(r.Context()).Info("access token revoked", "token_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AuthenticateToken implements middleware.TokenAuthenticator.
+func (h *AccessTokenHandler) AuthenticateToken(ctx context.Context, secret string) (int64, []string, error) {
+	token, err := h.tokenRepo.GetByHash(ctx, middleware.HashAccessToken(secret))
+	if err != nil {
+		return 0, nil, err
+	}
+	if token == nil || !token.Active(time.Now()) {
+		return 0, nil, middleware.ErrInvalidAccessToken
+	}
+
+	if err := h.tokenRepo.TouchLastUsed(ctx, token.ID); err != nil {
+		logging.FromContext(ctx).Error("failed to record access token use", "token_id", token.ID, "error", err)
+	}
+	return token.UserID, token.Scopes, nil
+}
+
+// generateAccessToken returns a new personal access token.
+func generateAccessToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return middleware.AccessTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/middleware"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/views"
+	"github.com/gorilla/mux"
+)
+
+type FollowHandler struct {
+	userRepo   *repository.UserRepository
+	followRepo *repository.FollowRepository
+}
+
+func NewFollowHandler(userRepo *repository.UserRepository, followRepo *repository.FollowRepository) *FollowHandler {
+	return &FollowHandler{userRepo: userRepo, followRepo: followRepo}
+}
+
+// Follow makes the signed-in user follow {username}. Following someone
+// already followed succeeds without changing anything.
+func (h *FollowHandler) Follow(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	target, ok := h.pathUser(w, r)
+	if !ok {
+		return
+	}
+	if target.ID == userID {
+		http.Error(w, "You cannot follow yourself", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.followRepo.Follow(r.Context(), userID, target.ID); err != nil {
+		http.Error(w, "Error following user", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Unfollow stops the signed-in user following {username}, if they did.
+func (h *FollowHandler) Unfollow(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	target, ok := h.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.followRepo.Unfollow(r.Context(), userID, target.ID); err != nil {
+		http.Error(w, "Error unfollowing user", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Followers lists the users following {username}, most recent first.
+func (h *FollowHandler) Followers(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, true)
+}
+
+// Following lists the users {username} follows, most recent first.
+func (h *FollowHandler) Following(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, false)
+}
+
+func (h *FollowHandler) list(w http.ResponseWriter, r *http.Request, followers bool) {
+	user, ok := h.pathUser(w, r)
+	if !ok {
+		return
+	}
+	cursor, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	followerCount, followingCount, err := h.followRepo.Counts(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	count, fetch := followingCount, h.followRepo.ListFollowing
+	if followers {
+		count, fetch = followerCount, h.followRepo.ListFollowers
+	}
+	follows, err := fetch(r.Context(), user.ID, cursor, limit+1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var next string
+	if len(follows) > limit {
+		follows = follows[:limit]
+		last := follows[limit-1]
+		next = repository.Cursor{Time: last.CreatedAt, ID: last.User.ID}.String()
+	}
+	views.JSON(w, http.StatusOK, views.NewFollows(count, follows, next))
+}
+
+// pathUser loads the user named in the path, responding with 404 when
+// there is none.
+func (h *FollowHandler) pathUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, err := h.userRepo.GetByUsername(r.Context(), mux.Vars(r)["username"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil || user.IsPlaceholder {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	return user, true
+}
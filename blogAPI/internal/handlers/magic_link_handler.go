@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/metrics"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tokens"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+)
+
+// MagicLinkHandler signs users in with a link sent by email, in three
+// steps:
+//
+//  1. Request: the device that wants to sign in gets a request ID and the
+//     user gets an email with a link, sent from the outbox.
+//  2. Confirm: the link opens a page in the frontend that asks the user to
+//     confirm with a click, which posts the emailed token and shows a
+//     short code. Mail scanners that prefetch links never post, so they
+//     cannot use the link up.
+//  3. Complete: the requesting device sends its request ID and the code
+//     and receives the session. Whoever opens the link on another device
+//     therefore cannot sign that device in, only pass the code on.
+type MagicLinkHandler struct {
+	users     *UserHandler
+	userRepo  *repository.UserRepository
+	magicRepo *repository.MagicLinkRepository
+	config    config.Config
+}
+
+func NewMagicLinkHandler(
+	users *UserHandler,
+	userRepo *repository.UserRepository,
+	magicRepo *repository.MagicLinkRepository,
+	config config.Config) *MagicLinkHandler {
+
+	return &MagicLinkHandler{
+		users:     users,
+		userRepo:  userRepo,
+		magicRepo: magicRepo,
+		config:    config}
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+func (h *MagicLinkHandler) Request(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
+	var req MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The request ID is returned whether or not the email exists, and the
+	// email is sent from the outbox after responding, so neither the
+	// response nor its timing reveals which emails have accounts
+	requestID, err := tokens.Generate()
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.userRepo.GetByEmail(r.Context(), req.Email)
+	if err != nil {
+		http.Error(w, "Error looking up account", http.StatusInternalServerError)
+		return
+	}
+	if user != nil {
+		link := &models.MagicLinkToken{
+			UserID:      user.ID,
+			RequestHash: tokens.Hash(requestID),
+			ExpiredAt:   time.Now().Add(h.config.MagicLink.TTL),
+		}
+		if err := h.magicRepo.Create(r.Context(), link); err != nil {
+			logger.Error("error creating magic link", "user_id", user.ID, "error", err)
+			http.Error(w, "Error creating sign-in link", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"request_id": requestID,
+		"message":    "If your email exists in our system, you will receive a sign-in link",
+	})
+}
+
+type MagicLinkConfirmRequest struct {
+	Token string `json:"token"`
+}
+
+// Confirm is called by the page the emailed link opens, once the user
+// clicks to confirm. It returns the code to enter on the requesting
+// device.
+func (h *MagicLinkHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	var req MagicLinkConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	link, err := h.magicRepo.GetByToken(r.Context(), tokens.Hash(req.Token))
+	if err != nil {
+		http.Error(w, "Error validating token", http.StatusInternalServerError)
+		return
+	}
+	if link == nil || link.Used || time.Now().After(link.ExpiredAt) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	code, err := generateShortCode()
+	if err != nil {
+		http.Error(w, "Error generating code", http.StatusInternalServerError)
+		return
+	}
+	if err := h.magicRepo.Confirm(r.Context(), link.ID, tokens.Hash(code)); err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"code":    code,
+		"message": "Enter this code on the device where you asked to sign in",
+	})
+}
+
+type MagicLinkCompleteRequest struct {
+	RequestID string `json:"request_id"`
+	Code      string `json:"code"`
+}
+
+// Complete signs the requesting device in once it presents the code. The
+// response is the same as for a password login, including any second
+// factor challenge.
+func (h *MagicLinkHandler) Complete(w http.ResponseWriter, r *http.Request) {
+	var req MagicLinkCompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	link, err := h.magicRepo.GetByRequest(r.Context(), tokens.Hash(req.RequestID))
+	if err != nil {
+		http.Error(w, "Error validating code", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	if link == nil || link.Used || now.After(link.ExpiredAt) || link.Attempts >= h.config.MagicLink.CodeAttempts {
+		http.Error(w, "Invalid or expired code", http.StatusBadRequest)
+		return
+	}
+	if link.ConfirmedAt == nil {
+		// Still waiting for the user to open the email; clients poll
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"status": "pending"})
+		return
+	}
+	// Use up an attempt before comparing, so parallel guesses share the
+	// same budget
+	attempts := h.config.MagicLink.CodeAttempts
+	codeHash, ok, err := h.magicRepo.TakeAttempt(r.Context(), link.ID, attempts)
+	if err != nil {
+		http.Error(w, "Error validating code", http.StatusInternalServerError)
+		return
+	}
+	if !ok || subtle.ConstantTimeCompare([]byte(tokens.Hash(req.Code)), []byte(codeHash)) != 1 {
+		metrics.Logins.WithLabelValues("failure").Inc()
+		http.Error(w, "Invalid or expired code", http.StatusBadRequest)
+		return
+	}
+
+	used, err := h.magicRepo.MarkAsUsed(r.Context(), link.ID, attempts)
+	if err != nil {
+		http.Error(w, "Error updating token status", http.StatusInternalServerError)
+		return
+	}
+	if !used {
+		http.Error(w, "Invalid or expired code", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userRepo.GetByID(r.Context(), link.UserID)
+	if err != nil || user == nil {
+		http.Error(w, "Invalid or expired code", http.StatusBadRequest)
+		return
+	}
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		metrics.Logins.WithLabelValues("locked").Inc()
+		tooManyAttempts(w, user.LockedUntil.Sub(now))
+		return
+	}
+	// The link was emailed, so the address is the user's
+	if err := h.userRepo.MarkEmailVerified(r.Context(), user.ID, user.Email); err != nil {
+		logging.FromContext(r.Context()).Error("error marking email verified", "user_id", user.ID, "error", err)
+	}
+
+	result, err := h.users.afterFirstFactor(r, user, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// generateShortCode returns a six digit code that is easy to type on
+// another device.
+func generateShortCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/blobstore"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/media"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/middleware"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/views"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"github.com/gorilla/mux"
+)
+
+// multipartOverhead is allowed on top of MaxUploadBytes for the multipart
+// boundaries and part headers around the file.
+const multipartOverhead = 64 << 10
+
+// MediaHandler accepts image uploads and serves stored media.
+type MediaHandler struct {
+	mediaRepo *repository.MediaRepository
+	store     blobstore.Store
+	processor *media.Processor
+	config    config.Config
+}
+
+func NewMediaHandler(
+	mediaRepo *repository.MediaRepository,
+	store blobstore.Store,
+	processor *media.Processor,
+	config config.Config) *MediaHandler {
+
+	return &MediaHandler{mediaRepo: mediaRepo, store: store, processor: processor, config: config}
+}
+
+// Upload stores the image in the "file" field of a multipart form. The
+// type is sniffed from the content, metadata such as EXIF location is
+// removed before anything is stored, and the variants follow in the
+// background.
+func (h *MediaHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := h.config.Media.MaxUploadBytes
+	r.Body = http.MaxBytesReader(w, r.Body, limit+multipartOverhead)
+	data, err := readUpload(r, limit)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr) || errors.Is(err, errUploadTooLarge):
+		http.Error(w, "File is too large", http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	info, err := media.Inspect(data, h.config.Media.MaxPixels)
+	switch {
+	case errors.Is(err, media.ErrUnsupportedType):
+		http.Error(w, media.ErrUnsupportedType.Error(), http.StatusUnsupportedMediaType)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, info, err = media.StripMetadata(data, info)
+	if err != nil {
+		http.Error(w, "Invalid image file", http.StatusBadRequest)
+		return
+	}
+
+	dir, err := randomHex(16)
+	if err != nil {
+		http.Error(w, "Error storing upload", http.StatusInternalServerError)
+		return
+	}
+	upload := &models.Media{
+		OwnerID:     userID,
+		Key:         mediaKeyPrefix + dir + "/original" + media.Extensions[info.ContentType],
+		ContentType: info.ContentType,
+		Size:        int64(len(data)),
+		Width:       info.Width,
+		Height:      info.Height,
+	}
+	if err := h.store.Put(r.Context(), upload.Key, data, upload.ContentType); err != nil {
+		logging.FromContext(r.Context()).Error("error storing upload", "key", upload.Key, "error", err)
+		http.Error(w, "Error storing upload", http.StatusInternalServerError)
+		return
+	}
+	if err := h.mediaRepo.Create(r.Context(), upload); err != nil {
+		h.store.Delete(r.Context(), upload.Key)
+		http.Error(w, "Error storing upload", http.StatusInternalServerError)
+		return
+	}
+	h.processor.Notify()
+
+	views.JSON(w, http.StatusCreated, views.NewMedia(upload))
+}
+
+var errUploadTooLarge = errors.New("upload too large")
+
+// readUpload returns the contents of the "file" part of a multipart
+// request, reading at most limit bytes of it.
+func readUpload(r *http.Request, limit int64) ([]byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("expected a multipart/form-data upload")
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("missing file field")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(part, limit+1))
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > limit {
+			return nil, errUploadTooLarge
+		}
+		if len(data) == 0 {
+			return nil, errors.New("file is empty")
+		}
+		return data, nil
+	}
+}
+
+// Get returns an upload and its variants, so clients can poll until the
+// variants are ready.
+func (h *MediaHandler) Get(w http.ResponseWriter, r *http.Request) {
+	upload, ok := h.pathMedia(w, r)
+	if !ok {
+		return
+	}
+	views.JSON(w, http.StatusOK, views.NewMedia(upload))
+}
+
+// Delete removes one of the signed-in user's uploads, detaching it from
+// any posts. Its files are removed in the background.
+func (h *MediaHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	upload, ok := h.pathMedia(w, r)
+	if !ok {
+		return
+	}
+	if upload.OwnerID != userID {
+		http.Error(w, "Forbidden: you did not upload this image", http.StatusForbidden)
+		return
+	}
+
+	if err := h.mediaRepo.Delete(r.Context(), upload.ID, userID); err != nil {
+		http.Error(w, "Error deleting upload", http.StatusInternalServerError)
+		return
+	}
+	h.processor.Notify()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *MediaHandler) pathMedia(w http.ResponseWriter, r *http.Request) (*models.Media, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid media ID", http.StatusBadRequest)
+		return nil, false
+	}
+	upload, err := h.mediaRepo.GetByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if upload == nil {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return nil, false
+	}
+	return upload, true
+}
+
+// mediaKeyPrefix starts the storage keys of uploads and their variants.
+// Serve refuses other keys, so nothing else kept under the same root can
+// be read through it.
+const mediaKeyPrefix = "media/"
+
+// Serve writes a stored upload. Keys are never reused, so the response can
+// be cached forever. It is only routed when media are served by the API
+// rather than from a CDN or public bucket.
+func (h *MediaHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if !strings.HasPrefix(key, mediaKeyPrefix) || !blobstore.ValidKey(key) || contentType == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	rc, err := h.store.Open(r.Context(), key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	io.Copy(w, rc)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/totp"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"github.com/gorilla/mux"
+)
+
+// recoveryCodeCount is how many recovery codes a user receives.
+const recoveryCodeCount = 10
+
+type MFAHandler struct {
+	userRepo *repository.UserRepository
+	mfaRepo  *repository.MFARepository
+	config   config.Config
+}
+
+func NewMFAHandler(
+	userRepo *repository.UserRepository,
+	mfaRepo *repository.MFARepository,
+	config config.Config) *MFAHandler {
+
+	return &MFAHandler{
+		userRepo: userRepo,
+		mfaRepo:  mfaRepo,
+		config:   config}
+}
+
+type TOTPEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// Enroll starts TOTP enrollment. The provisioning URI is meant to be shown
+// as a QR code; the authenticator is not used for logins until Confirm.
+func (h *MFAHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "Error generating secret", http.StatusInternalServerError)
+		return
+	}
+	if err := h.mfaRepo.SavePending(r.Context(), user.ID, secret); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Error saving secret", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(TOTPEnrollResponse{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(h.config.MFA.Issuer, user.Email, secret),
+	})
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// Confirm activates the pending authenticator once the user proves it
+// works, and returns a fresh set of recovery codes. They are shown only
+// this once.
+func (h *MFAHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mfa, err := h.mfaRepo.Get(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Error loading authenticator", http.StatusInternalServerError)
+		return
+	}
+	if mfa == nil {
+		http.Error(w, "Start enrollment first", http.StatusNotFound)
+		return
+	}
+	if mfa.Confirmed() {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if !h.checkCode(w, r, mfa, req.Code) {
+		return
+	}
+
+	if err := h.mfaRepo.Confirm(r.Context(), user.ID); err != nil {
+		http.Error(w, "Error enabling two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	h.issueRecoveryCodes(w, r, user.ID)
+}
+
+// Disable removes the authenticator after checking a current code. Users
+// whose role requires two-factor login cannot disable it.
+func (h *MFAHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	required, err := h.mfaRepo.RoleRequiresMFA(r.Context(), user.Role)
+	if err != nil {
+		http.Error(w, "Error checking two-factor policy", http.StatusInternalServerError)
+		return
+	}
+	if required {
+		http.Error(w, "Two-factor authentication is required for your role", http.StatusForbidden)
+		return
+	}
+
+	mfa, ok := h.confirmedMFA(w, r, user.ID)
+	if !ok || !h.checkCode(w, r, mfa, req.Code) {
+		return
+	}
+	if err := h.mfaRepo.Delete(r.Context(), user.ID); err != nil {
+		http.Error(w, "Error disabling two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces every recovery code after checking a
+// current code.
+func (h *MFAHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mfa, ok := h.confirmedMFA(w, r, user.ID)
+	if !ok || !h.checkCode(w, r, mfa, req.Code) {
+		return
+	}
+	h.issueRecoveryCodes(w, r, user.ID)
+}
+
+func (h *MFAHandler) ListRolePolicies(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	policies, err := h.mfaRepo.ListRolePolicies(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policies)
+}
+
+type MFARolePolicyRequest struct {
+	Required bool `json:"required"`
+}
+
+// SetRolePolicy lets admins require two-factor login for a role. Users of
+// that role without an authenticator must enroll at their next login.
+func (h *MFAHandler) SetRolePolicy(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	var req MFARolePolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	role := mux.Vars(r)["role"]
+	if role != models.RoleUser && role != models.RoleAdmin {
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+	policy := &models.MFARolePolicy{Role: role, Required: req.Required}
+	if err := h.mfaRepo.SetRolePolicy(r.Context(), policy); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+func (h *MFAHandler) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	user, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return false
+	}
+	if user.Role != models.RoleAdmin {
+		http.Error(w, "Admin access required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (h *MFAHandler) confirmedMFA(w http.ResponseWriter, r *http.Request, userID int64) (*models.UserMFA, bool) {
+	mfa, err := h.mfaRepo.Get(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Error loading authenticator", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !mfa.Confirmed() {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusNotFound)
+		return nil, false
+	}
+	return mfa, true
+}
+
+// checkCode validates a TOTP code and consumes its time step, writing an
+// error response when it is not accepted.
+func (h *MFAHandler) checkCode(w http.ResponseWriter, r *http.Request, mfa *models.UserMFA, code string) bool {
+	step, ok := totp.Validate(mfa.Secret, code, time.Now())
+	if ok {
+		var err error
+		ok, err = h.mfaRepo.UseStep(r.Context(), mfa.UserID, step)
+		if err != nil {
+			http.Error(w, "Error verifying code", http.StatusInternalServerError)
+			return false
+		}
+	}
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (h *MFAHandler) issueRecoveryCodes(w http.ResponseWriter, r *http.Request, userID int64) {
+	codes, hashes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+	if err := h.mfaRepo.ReplaceRecoveryCodes(r.Context(), userID, hashes); err != nil {
+		http.Error(w, "Error saving recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recovery_codes": codes,
+		"message":        "Store these codes somewhere safe. Each can be used once and they will not be shown again.",
+	})
+}
+
+// generateRecoveryCodes returns n random codes formatted as XXXXX-XXXXX
+// along with the hashes to store.
+func generateRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, n)
+	hashes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := base32.StdEncoding.EncodeToString(b)[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalises a recovery code as typed by a user and
+// hashes it. The codes carry 50 random bits, so a fast hash is enough.
+func hashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/middleware"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tokens"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"github.com/gorilla/mux"
+)
+
+// OAuthHandler makes the API an OAuth2 authorization server, so
+// third-party clients can act for users without their password. Clients
+// send users to the frontend's consent page, which calls Authorize to
+// describe the request and Approve to record the user's answer. Clients
+// then redeem the code at Token; see oauth_token_handler.go.
+type OAuthHandler struct {
+	oauthRepo *repository.OAuthRepository
+	config    config.Config
+}
+
+func NewOAuthHandler(oauthRepo *repository.OAuthRepository, config config.Config) *OAuthHandler {
+	return &OAuthHandler{oauthRepo: oauthRepo, config: config}
+}
+
+type RegisterClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	// Confidential clients run on a server and get a secret. Mobile and
+	// browser apps cannot keep one and rely on PKCE alone.
+	Confidential bool `json:"confidential"`
+}
+
+type RegisterClientResponse struct {
+	Client       *models.OAuthClient `json:"client"`
+	ClientSecret string              `json:"client_secret,omitempty"`
+}
+
+// RegisterClient registers a client owned by the current user. The secret
+// of a confidential client is only shown in this response.
+func (h *OAuthHandler) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req RegisterClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(req.RedirectURIs) == 0 {
+		http.Error(w, "At least one redirect URI is required", http.StatusBadRequest)
+		return
+	}
+	for _, uri := range req.RedirectURIs {
+		if !validRedirectURI(uri) {
+			http.Error(w, "Invalid redirect URI "+uri, http.StatusBadRequest)
+			return
+		}
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !middleware.ValidScope(scope) {
+			http.Error(w, "Unknown scope "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+
+	clientID, err := randomToken(16)
+	if err != nil {
+		http.Error(w, "Error generating client ID", http.StatusInternalServerError)
+		return
+	}
+	client := &models.OAuthClient{
+		ClientID:     clientID,
+		Name:         req.Name,
+		OwnerID:      userID,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+	}
+	var secret string
+	if req.Confidential {
+		if secret, err = randomToken(32); err != nil {
+			http.Error(w, "Error generating client secret", http.StatusInternalServerError)
+			return
+		}
+		client.SecretHash = tokens.Hash(secret)
+	}
+
+	if err := h.oauthRepo.CreateClient(r.Context(), client); err != nil {
+		http.Error(w, "Error saving client", http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(r.Context()).Info("oauth client registered", "client_id", client.ClientID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(RegisterClientResponse{Client: client, ClientSecret: secret})
+}
+
+func (h *OAuthHandler) ListClients(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	clients, err := h.oauthRepo.ListClientsByOwner(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clients)
+}
+
+func (h *OAuthHandler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.oauthRepo.DeleteClient(r.Context(), userID, mux.Vars(r)["client_id"]); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AuthorizeRequest carries the parameters of an authorization request,
+// as the client put them in the consent page URL.
+type AuthorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	// Approve is the user's answer, only read by Approve.
+	Approve bool `json:"approve"`
+}
+
+type AuthorizeResponse struct {
+	ClientID    string   `json:"client_id"`
+	ClientName  string   `json:"client_name"`
+	RedirectURI string   `json:"redirect_uri"`
+	Scopes      []string `json:"scopes"`
+	// ConsentRequired is false when the user has already granted every
+	// requested scope, in which case the page may approve right away.
+	ConsentRequired bool `json:"consent_required"`
+}
+
+// Authorize validates an authorization request for the consent page and
+// describes what the client is asking for.
+func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	q := r.URL.Query()
+	req := AuthorizeRequest{
+		ResponseType:        q.Get("response_type"),
+		ClientID:            q.Get("client_id"),
+		RedirectURI:         q.Get("redirect_uri"),
+		Scope:               q.Get("scope"),
+		State:               q.Get("state"),
+		CodeChallenge:       q.Get("code_challenge"),
+		CodeChallengeMethod: q.Get("code_challenge_method"),
+	}
+
+	client, scopes, redirectURI, ok := h.validateAuthorize(w, r, req)
+	if !ok {
+		return
+	}
+	consent, err := h.oauthRepo.GetConsent(r.Context(), userID, client.ClientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuthorizeResponse{
+		ClientID:        client.ClientID,
+		ClientName:      client.Name,
+		RedirectURI:     redirectURI,
+		Scopes:          scopes,
+		ConsentRequired: consent == nil || !containsAll(consent.Scopes, scopes),
+	})
+}
+
+// Approve records the user's answer to an authorization request and
+// returns where to send the browser: back to the client with either an
+// authorization code or an access_denied error.
+func (h *OAuthHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req AuthorizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, scopes, redirectURI, ok := h.validateAuthorize(w, r, req)
+	if !ok {
+		return
+	}
+	if !req.Approve {
+		writeRedirect(w, redirectURI, url.Values{"error": {"access_denied"}}, req.State)
+		return
+	}
+
+	consent, err := h.oauthRepo.GetConsent(r.Context(), userID, client.ClientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	granted := scopes
+	if consent != nil {
+		granted = union(consent.Scopes, scopes)
+	}
+	if err := h.oauthRepo.SaveConsent(r.Context(), userID, client.ClientID, granted); err != nil {
+		http.Error(w, "Error saving consent", http.StatusInternalServerError)
+		return
+	}
+
+	code, err := randomToken(32)
+	if err != nil {
+		http.Error(w, "Error generating code", http.StatusInternalServerError)
+		return
+	}
+	err = h.oauthRepo.CreateCode(r.Context(), &models.OAuthAuthorizationCode{
+		CodeHash:      tokens.Hash(code),
+		ClientID:      client.ClientID,
+		UserID:        userID,
+		RedirectURI:   redirectURI,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().Add(h.config.OAuth.CodeTTL),
+	})
+	if err != nil {
+		http.Error(w, "Error saving code", http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(r.Context()).Info("oauth authorization granted", "client_id", client.ClientID, "scopes", scopes)
+
+	writeRedirect(w, redirectURI, url.Values{"code": {code}}, req.State)
+}
+
+// validateAuthorize checks an authorization request. Problems with the
+// client or redirect URI are reported to the user directly, since the
+// redirect URI cannot be trusted; anything else is reported to the client
+// through its redirect URI, as RFC 6749 section 4.1.2.1 requires.
+func (h *OAuthHandler) validateAuthorize(w http.ResponseWriter, r *http.Request, req AuthorizeRequest) (*models.OAuthClient, []string, string, bool) {
+	client, err := h.oauthRepo.GetClient(r.Context(), req.ClientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, "", false
+	}
+	if client == nil {
+		http.Error(w, "Unknown client", http.StatusBadRequest)
+		return nil, nil, "", false
+	}
+
+	redirectURI := req.RedirectURI
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !contains(client.RedirectURIs, redirectURI) {
+		http.Error(w, "Redirect URI is not registered for this client", http.StatusBadRequest)
+		return nil, nil, "", false
+	}
+
+	fail := func(code, description string) (*models.OAuthClient, []string, string, bool) {
+		writeRedirect(w, redirectURI, url.Values{"error": {code}, "error_description": {description}}, req.State)
+		return nil, nil, "", false
+	}
+	if req.ResponseType != "code" {
+		return fail("unsupported_response_type", "only the code response type is supported")
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return fail("invalid_request", "PKCE with the S256 method is required")
+	}
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	if !containsAll(client.Scopes, scopes) {
+		return fail("invalid_scope", "the client may not request these scopes")
+	}
+	return client, scopes, redirectURI, true
+}
+
+// writeRedirect answers the consent page with the client URL to send the
+// browser to.
+func writeRedirect(w http.ResponseWriter, redirectURI string, params url.Values, state string) {
+	if state != "" {
+		params.Set("state", state)
+	}
+	target, _ := url.Parse(redirectURI)
+	q := target.Query()
+	for key, values := range params {
+		q[key] = values
+	}
+	target.RawQuery = q.Encode()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"redirect_to": target.String()})
+}
+
+func (h *OAuthHandler) ListConsents(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	consents, err := h.oauthRepo.ListConsents(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(consents)
+}
+
+// RevokeConsent withdraws an application's access, revoking its tokens.
+func (h *OAuthHandler) RevokeConsent(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.oauthRepo.RevokeConsent(r.Context(), userID, mux.Vars(r)["client_id"]); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Consent not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validRedirectURI accepts absolute https URLs, http URLs on the loopback
+// interface for local tools, and private-use schemes such as
+// com.example.app:/callback for mobile apps (RFC 8252).
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	case "javascript", "data", "file":
+		return false
+	default:
+		return strings.Contains(u.Scheme, ".")
+	}
+}
+
+// randomToken returns n random bytes encoded for use in URLs.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(list, items []string) bool {
+	for _, item := range items {
+		if !contains(list, item) {
+			return false
+		}
+	}
+	return true
+}
+
+func union(a, b []string) []string {
+	out := append([]string{}, a...)
+	for _, item := range b {
+		if !contains(out, item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
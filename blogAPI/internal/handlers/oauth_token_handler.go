@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/middleware"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tokens"
+)
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// Token is the token endpoint (RFC 6749 section 3.2). It redeems
+// authorization codes and rotates refresh tokens: each refresh token can
+// be used once, and using one twice revokes everything issued from the
+// same authorization.
+func (h *OAuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		h.redeemCode(w, r, client)
+	case "refresh_token":
+		h.refresh(w, r, client)
+	default:
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+func (h *OAuthHandler) redeemCode(w http.ResponseWriter, r *http.Request, client *models.OAuthClient) {
+	code, err := h.oauthRepo.TakeCode(r.Context(), tokens.Hash(r.PostForm.Get("code")))
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if code == nil || code.ClientID != client.ClientID || time.Now().After(code.ExpiresAt) ||
+		code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+		return
+	}
+	if !verifyCodeChallenge(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "code verifier does not match")
+		return
+	}
+
+	grantID, err := randomToken(16)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	h.issueTokens(w, r, client, code.UserID, code.Scopes, grantID)
+}
+
+func (h *OAuthHandler) refresh(w http.ResponseWriter, r *http.Request, client *models.OAuthClient) {
+	logger := logging.FromContext(r.Context())
+	old, err := h.oauthRepo.GetToken(r.Context(), tokens.Hash(r.PostForm.Get("refresh_token")))
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if old == nil || old.Kind != models.OAuthTokenRefresh || old.ClientID != client.ClientID {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+		return
+	}
+	if old.RevokedAt != nil {
+		// A rotated token coming back means it was copied; cut off
+		// whoever holds the current one too
+		logger.Warn("oauth refresh token reused", "client_id", client.ClientID, "user_id", old.UserID)
+		if err := h.oauthRepo.RevokeGrant(r.Context(), old.GrantID); err != nil {
+			logger.Error("error revoking oauth grant", "error", err)
+		}
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+		return
+	}
+	if !old.Active(time.Now()) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "refresh token expired")
+		return
+	}
+
+	scopes := old.Scopes
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		if !containsAll(old.Scopes, requested) {
+			oauthError(w, http.StatusBadRequest, "invalid_scope", "scope exceeds the original grant")
+			return
+		}
+		scopes = requested
+	}
+
+	revoked, err := h.oauthRepo.RevokeToken(r.Context(), old.ID)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if !revoked {
+		// Lost a race with another request using the same token
+		if err := h.oauthRepo.RevokeGrant(r.Context(), old.GrantID); err != nil {
+			logger.Error("error revoking oauth grant", "error", err)
+		}
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+		return
+	}
+	h.issueTokens(w, r, client, old.UserID, scopes, old.GrantID)
+}
+
+func (h *OAuthHandler) issueTokens(w http.ResponseWriter, r *http.Request, client *models.OAuthClient, userID int64, scopes []string, grantID string) {
+	now := time.Now()
+	accessID, err := randomToken(16)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	refresh, err := randomToken(32)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	accessTTL := h.config.OAuth.AccessTokenTTL
+	access, err := middleware.GenerateOAuthAccessToken(userID, client.ClientID, accessID, scopes, accessTTL)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	records := []*models.OAuthToken{
+		{TokenID: accessID, Kind: models.OAuthTokenAccess, ExpiresAt: now.Add(accessTTL)},
+		{TokenID: tokens.Hash(refresh), Kind: models.OAuthTokenRefresh, ExpiresAt: now.Add(h.config.OAuth.RefreshTokenTTL)},
+	}
+	for _, record := range records {
+		record.GrantID = grantID
+		record.ClientID = client.ClientID
+		record.UserID = userID
+		record.Scopes = scopes
+		if err := h.oauthRepo.CreateToken(r.Context(), record); err != nil {
+			oauthError(w, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTTL.Seconds()),
+		RefreshToken: refresh,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
+
+// Introspect implements token introspection (RFC 7662). Clients may only
+// introspect their own tokens; any other token is reported inactive.
+func (h *OAuthHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := h.findToken(r.Context(), r.PostForm.Get("token"))
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	response := map[string]interface{}{"active": false}
+	if token != nil && token.ClientID == client.ClientID && token.Active(time.Now()) {
+		response = map[string]interface{}{
+			"active":     true,
+			"scope":      strings.Join(token.Scopes, " "),
+			"client_id":  token.ClientID,
+			"sub":        strconv.FormatInt(token.UserID, 10),
+			"exp":        token.ExpiresAt.Unix(),
+			"iat":        token.CreatedAt.Unix(),
+			"token_type": token.Kind + "_token",
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Revoke implements token revocation (RFC 7009). Revoking a refresh token
+// also revokes the access tokens issued alongside it. Unknown tokens are
+// not an error.
+func (h *OAuthHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := h.findToken(r.Context(), r.PostForm.Get("token"))
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if token != nil && token.ClientID == client.ClientID {
+		if token.Kind == models.OAuthTokenRefresh {
+			err = h.oauthRepo.RevokeGrant(r.Context(), token.GrantID)
+		} else {
+			_, err = h.oauthRepo.RevokeToken(r.Context(), token.ID)
+		}
+		if err != nil {
+			oauthError(w, http.StatusServiceUnavailable, "server_error", "")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// OAuthTokenRevoked implements middleware.OAuthTokenChecker. Access tokens
+// the server has no record of are treated as revoked.
+func (h *OAuthHandler) OAuthTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	token, err := h.oauthRepo.GetToken(ctx, tokenID)
+	if err != nil {
+		return false, err
+	}
+	return token == nil || token.Kind != models.OAuthTokenAccess || !token.Active(time.Now()), nil
+}
+
+// findToken looks up an access token by its JWT ID or a refresh token by
+// its hash.
+func (h *OAuthHandler) findToken(ctx context.Context, raw string) (*models.OAuthToken, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	if strings.Count(raw, ".") == 2 {
+		claims, err := middleware.ValidateToken(raw)
+		if err != nil || claims.Purpose != middleware.PurposeOAuthAccess {
+			return nil, nil
+		}
+		return h.oauthRepo.GetToken(ctx, claims.TokenID)
+	}
+	token, err := h.oauthRepo.GetToken(ctx, tokens.Hash(raw))
+	if err != nil || token == nil || token.Kind != models.OAuthTokenRefresh {
+		return nil, err
+	}
+	return token, nil
+}
+
+// authenticateClient identifies the client from HTTP Basic credentials or
+// client_id and client_secret form fields. Public clients only give their
+// client_id.
+func (h *OAuthHandler) authenticateClient(w http.ResponseWriter, r *http.Request) (*models.OAuthClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form-encodes Basic credentials
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	client, err := h.oauthRepo.GetClient(r.Context(), clientID)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return nil, false
+	}
+	valid := client != nil
+	if valid && client.Confidential() {
+		valid = subtle.ConstantTimeCompare([]byte(tokens.Hash(secret)), []byte(client.SecretHash)) == 1
+	}
+	if !valid {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "")
+		return nil, false
+	}
+	return client, true
+}
+
+func verifyCodeChallenge(verifier, challenge string) bool {
+	// RFC 7636 section 4.1 allows verifiers of 43 to 128 characters
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// oauthError writes an error response in the format of RFC 6749 section
+// 5.2.
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	body := map[string]string{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/metrics"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/middleware"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/oidc"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"github.com/gorilla/mux"
+)
+
+// oidcStateCookie ties a sign-in to the browser that started it, so a
+// victim cannot be made to finish a sign-in started by someone else.
+const oidcStateCookie = "oidc_state"
+
+var (
+	errEmailNotVerified = errors.New("email not verified")
+	// errAccountExists is returned when an account has the identity's
+	// email but has not verified it, so its owner must sign in and link
+	// the identity themselves.
+	errAccountExists = errors.New("account exists with unverified email")
+)
+
+// OIDCHandler signs users in through OpenID providers. The outcome is
+// handed to the frontend in the fragment of a redirect to
+// <frontend>/auth/callback, using the same fields as a password login.
+type OIDCHandler struct {
+	users        *UserHandler
+	userRepo     *repository.UserRepository
+	identityRepo *repository.IdentityRepository
+	providers    map[string]*oidc.Provider
+	config       config.Config
+}
+
+func NewOIDCHandler(
+	users *UserHandler,
+	userRepo *repository.UserRepository,
+	identityRepo *repository.IdentityRepository,
+	providers map[string]*oidc.Provider,
+	config config.Config) *OIDCHandler {
+
+	return &OIDCHandler{
+		users:        users,
+		userRepo:     userRepo,
+		identityRepo: identityRepo,
+		providers:    providers,
+		config:       config}
+}
+
+// Login redirects the browser to the provider.
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	authURL, ok := h.start(w, r, nil)
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Link starts a sign-in at the provider that links the identity to the
+// signed-in user instead of signing in. It answers with the URL to send
+// the browser to, as the request carries the user's token rather than
+// being a navigation.
+func (h *OIDCHandler) Link(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	authURL, ok := h.start(w, r, &userID)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"url": authURL})
+}
+
+// start records a sign-in at the provider in the path, sets the state
+// cookie and returns the provider URL to send the browser to.
+func (h *OIDCHandler) start(w http.ResponseWriter, r *http.Request, linkUserID *int64) (string, bool) {
+	name := mux.Vars(r)["provider"]
+	provider, ok := h.providers[name]
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return "", false
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			http.Error(w, "Error starting sign-in", http.StatusInternalServerError)
+			return "", false
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	req := &models.OIDCAuthRequest{
+		State:        state,
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(h.config.OIDC.StateTTL),
+	}
+	if err := h.identityRepo.CreateAuthRequest(r.Context(), req); err != nil {
+		http.Error(w, "Error starting sign-in", http.StatusInternalServerError)
+		return "", false
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(h.config.OIDC.StateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.config.IsProduction(),
+		// Lax lets the cookie come back on the provider's top-level
+		// redirect
+		SameSite: http.SameSiteLaxMode,
+	})
+	return provider.AuthCodeURL(state, nonce, verifier), true
+}
+
+// Callback finishes the sign-in when the provider redirects back.
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	name := mux.Vars(r)["provider"]
+	provider, ok := h.providers[name]
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc", MaxAge: -1})
+	if e := q.Get("error"); e != "" {
+		h.redirectResult(w, r, map[string]interface{}{"error": "provider_error"})
+		return
+	}
+
+	state := q.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		h.redirectResult(w, r, map[string]interface{}{"error": "invalid_state"})
+		return
+	}
+	req, err := h.identityRepo.TakeAuthRequest(r.Context(), state)
+	if err != nil {
+		http.Error(w, "Error finishing sign-in", http.StatusInternalServerError)
+		return
+	}
+	if req == nil || req.Provider != name || time.Now().After(req.ExpiresAt) {
+		h.redirectResult(w, r, map[string]interface{}{"error": "invalid_state"})
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(r.Context(), q.Get("code"), req.CodeVerifier)
+	if err != nil {
+		logger.Warn("oidc code exchange failed", "provider", name, "error", err)
+		h.redirectResult(w, r, map[string]interface{}{"error": "exchange_failed"})
+		return
+	}
+	claims, err := provider.VerifyIDToken(r.Context(), rawIDToken, req.Nonce)
+	if err != nil {
+		logger.Warn("oidc id token rejected", "provider", name, "error", err)
+		h.redirectResult(w, r, map[string]interface{}{"error": "invalid_id_token"})
+		return
+	}
+
+	if req.LinkUserID != nil {
+		h.link(w, r, *req.LinkUserID, name, claims)
+		return
+	}
+
+	user, err := h.resolveUser(r, name, claims)
+	if errors.Is(err, errEmailNotVerified) {
+		h.redirectResult(w, r, map[string]interface{}{"error": "email_not_verified"})
+		return
+	}
+	if errors.Is(err, errAccountExists) {
+		h.redirectResult(w, r, map[string]interface{}{"error": "account_exists"})
+		return
+	}
+	if err != nil {
+		logger.Error("error resolving oidc identity", "provider", name, "error", err)
+		http.Error(w, "Error finishing sign-in", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		metrics.Logins.WithLabelValues("locked").Inc()
+		h.redirectResult(w, r, map[string]interface{}{"error": "account_locked"})
+		return
+	}
+
+	result, err := h.users.afterFirstFactor(r, user, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.redirectResult(w, r, result)
+}
+
+// resolveUser finds the user behind an identity. Unknown identities are
+// linked to the user with the same email, or get a new user, but only
+// when the provider has verified the email; otherwise anyone could claim
+// an existing account by registering its email at a provider. The
+// account must have verified the email too, or whoever registered it
+// with a password would share the account; its owner links the identity
+// after signing in instead.
+func (h *OIDCHandler) resolveUser(r *http.Request, provider string, claims *oidc.Claims) (*models.User, error) {
+	ctx := r.Context()
+	identity, err := h.identityRepo.Get(ctx, provider, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		user, err := h.userRepo.GetByID(ctx, identity.UserID)
+		if err == nil && user == nil {
+			err = fmt.Errorf("user %d of identity %d not found", identity.UserID, identity.ID)
+		}
+		return user, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errEmailNotVerified
+	}
+	user, err := h.userRepo.GetByEmail(ctx, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil && user.EmailVerifiedAt == nil {
+		return nil, errAccountExists
+	}
+	if user == nil {
+		// The password is left empty, which no password matches. The user
+		// can set one through a password reset.
+		now := time.Now()
+		user = &models.User{
+			Email:           claims.Email,
+			EmailVerifiedAt: &now,
+			Role:            models.RoleUser,
+		}
+		if err := h.createWithFreeUsername(ctx, user, usernameFromClaims(claims)); err != nil {
+			return nil, err
+		}
+		metrics.Registrations.Inc()
+	}
+
+	identity = &models.UserIdentity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := h.identityRepo.Create(ctx, identity); err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).Info("oidc identity linked", "user_id", user.ID, "provider", provider)
+	return user, nil
+}
+
+// link adds the identity to the user who started the sign-in with Link,
+// who has already proven who they are, and reports the outcome to the
+// frontend.
+func (h *OIDCHandler) link(w http.ResponseWriter, r *http.Request, userID int64, provider string, claims *oidc.Claims) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+
+	identity, err := h.identityRepo.Get(ctx, provider, claims.Subject)
+	if err != nil {
+		logger.Error("error linking oidc identity", "provider", provider, "error", err)
+		http.Error(w, "Error finishing sign-in", http.StatusInternalServerError)
+		return
+	}
+	if identity != nil {
+		if identity.UserID != userID {
+			h.redirectResult(w, r, map[string]interface{}{"error": "identity_in_use"})
+			return
+		}
+		h.redirectResult(w, r, map[string]interface{}{"linked": provider})
+		return
+	}
+
+	identity = &models.UserIdentity{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := h.identityRepo.Create(ctx, identity); err != nil {
+		logger.Error("error linking oidc identity", "provider", provider, "error", err)
+		http.Error(w, "Error finishing sign-in", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("oidc identity linked", "user_id", userID, "provider", provider)
+	h.redirectResult(w, r, map[string]interface{}{"linked": provider})
+}
+
+// maxUsernameSuffix bounds the numbered usernames tried when the one from
+// the provider is taken.
+const maxUsernameSuffix = 20
+
+// createWithFreeUsername creates user with username, or username2,
+// username3 and so on when it is taken by another account.
+func (h *OIDCHandler) createWithFreeUsername(ctx context.Context, user *models.User, username string) error {
+	for n := 1; ; n++ {
+		user.Username = username
+		if n > 1 {
+			user.Username = fmt.Sprintf("%s%d", username, n)
+		}
+		err := h.userRepo.Create(ctx, user)
+		if !errors.Is(err, repository.ErrUsernameTaken) || n == maxUsernameSuffix {
+			return err
+		}
+	}
+}
+
+func usernameFromClaims(claims *oidc.Claims) string {
+	switch {
+	case claims.PreferredUsername != "":
+		return claims.PreferredUsername
+	case claims.Name != "":
+		return claims.Name
+	default:
+		return strings.SplitN(claims.Email, "@", 2)[0]
+	}
+}
+
+// redirectResult sends the browser to the frontend with the outcome in
+// the URL fragment, which browsers do not send to servers or in Referer
+// headers.
+func (h *OIDCHandler) redirectResult(w http.ResponseWriter, r *http.Request, result map[string]interface{}) {
+	v := url.Values{}
+	for key, value := range result {
+		v.Set(key, fmt.Sprint(value))
+	}
+	target := strings.TrimSuffix(h.config.Frontend.URL, "/") + "/auth/callback#" + v.Encode()
+	http.Redirect(w, r, target, http.StatusFound)
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// pageParams reads the cursor and limit query parameters of a keyset
+// paginated list, responding with an error and returning false when they
+// are invalid.
+func pageParams(w http.ResponseWriter, r *http.Request) (*repository.Cursor, int, bool) {
+	limit := defaultPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+			return nil, 0, false
+		}
+		limit = n
+	}
+
+	var cursor *repository.Cursor
+	if s := r.URL.Query().Get("cursor"); s != "" {
+		c, err := repository.ParseCursor(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, 0, false
+		}
+		cursor = c
+	}
+	return cursor, limit, true
+}
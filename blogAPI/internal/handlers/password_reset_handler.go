@@ -1,15 +1,15 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
-	"log"
 	"net/http"
 	"time"
 
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tokens"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
 	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
 	"github.com/anoying-kid/go-apps/blogAPI/pkg/utils"
 )
@@ -36,6 +36,8 @@ type PasswordResetRequest struct {
 }
 
 func (h *PasswordResetHandler) RequestReset(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
 	var req PasswordResetRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -43,7 +45,7 @@ func (h *PasswordResetHandler) RequestReset(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Get user by email
-	user, err := h.userRepo.GetByEmail(req.Email)
+	user, err := h.userRepo.GetByEmail(r.Context(), req.Email)
 	if err != nil {
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
@@ -57,30 +59,15 @@ func (h *PasswordResetHandler) RequestReset(w http.ResponseWriter, r *http.Reque
         return
 		}
 
-	// Generate secure random token
-	tokenBytes := make([]byte, 32)
-	if _, err := rand.Read(tokenBytes); err != nil {
-		http.Error(w, "Error generating token", http.StatusInternalServerError)
-		return
-	}
-	token := base64.URLEncoding.EncodeToString(tokenBytes)
-
-	// Create reset token record
+	// Create the reset; its token is made and emailed by the
+	// PasswordResetRequested subscriber, so only its hash is stored
 	resetToken := &models.PasswordResetToken{
 		UserID:    user.ID,
-		Token:     token,
 		ExpiredAt: time.Now().Add(time.Hour), // Token expires in 1 hour
 	}
-	if err := h.resetRepo.Create(resetToken); err != nil {
+	if err := h.resetRepo.Create(r.Context(), resetToken); err != nil {
+		logger.Error("error creating reset token", "user_id", user.ID, "error", err)
 		http.Error(w, "Error creating reset token", http.StatusInternalServerError)
-		log.Fatal("Error creating reset token: ", err)
-		return
-	}
-
-	// Send reset email
-	if err := utils.SendPasswordResetEmail(user.Email, token, h.config); err != nil {
-		http.Error(w, "Error sending email", http.StatusInternalServerError)
-		log.Fatal("Error sending email: ", err)
 		return
 	}
 
@@ -103,7 +90,7 @@ func (h *PasswordResetHandler) ConfirmReset(w http.ResponseWriter, r *http.Reque
     }
 
     // Validate token
-    resetToken, err := h.resetRepo.GetByToken(req.Token)
+    resetToken, err := h.resetRepo.GetByToken(r.Context(), tokens.Hash(req.Token))
     if err != nil {
         http.Error(w, "Error validating token", http.StatusInternalServerError)
         return
@@ -113,24 +100,39 @@ func (h *PasswordResetHandler) ConfirmReset(w http.ResponseWriter, r *http.Reque
         return
     }
 
+    user, err := h.userRepo.GetByID(r.Context(), resetToken.UserID)
+    if err != nil || user == nil {
+        http.Error(w, "Error validating token", http.StatusInternalServerError)
+        return
+    }
+    if !checkNewPassword(w, r, req.Password, user.Username, user.Email) {
+        return
+    }
+
     // Hash new password
+    _, span := tracing.Start(r.Context(), "password.Hash")
     hashedPassword, err := utils.HashPassword(req.Password)
+    span.End()
     if err != nil {
         http.Error(w, "Error processing password", http.StatusInternalServerError)
         return
     }
 
     // Update user's password
-    if err := h.userRepo.UpdatePassword(resetToken.UserID, hashedPassword); err != nil {
+    if err := h.userRepo.UpdatePassword(r.Context(), resetToken.UserID, hashedPassword); err != nil {
         http.Error(w, "Error updating password", http.StatusInternalServerError)
         return
     }
 
     // Mark token as used
-    if err := h.resetRepo.MarkAsUsed(resetToken.ID); err != nil {
+    if err := h.resetRepo.MarkAsUsed(r.Context(), resetToken.ID); err != nil {
         http.Error(w, "Error updating token status", http.StatusInternalServerError)
         return
     }
+    // The token was emailed, so the address is the user's
+    if err := h.userRepo.MarkEmailVerified(r.Context(), user.ID, user.Email); err != nil {
+        logging.FromContext(r.Context()).Error("error marking email verified", "user_id", user.ID, "error", err)
+    }
 
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{
@@ -1,32 +1,54 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strconv"
 
+	"github.com/anoying-kid/go-apps/blogAPI/internal/metrics"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/middleware"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/slug"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/views"
 	"github.com/gorilla/mux"
 )
 
+// maxPostMedia bounds how many images can be attached to one post.
+const maxPostMedia = 20
+
 type PostHandler struct {
-	postRepo *repository.PostRepository
+	postRepo     *repository.PostRepository
+	reactionRepo *repository.ReactionRepository
+	mediaRepo    *repository.MediaRepository
 }
 
+// UpdatePostRequest replaces the title and body. The attached images and
+// featured image are left alone unless given; a featured_media_id of 0
+// clears the featured image.
 type UpdatePostRequest struct {
     Title string `json:"title"`
     Body  string `json:"body"`
+    MediaIDs        *[]int64 `json:"media_ids"`
+    FeaturedMediaID *int64   `json:"featured_media_id"`
 }
 
-func NewPostHandler(postRepo *repository.PostRepository) *PostHandler {
-	return &PostHandler{postRepo: postRepo}
+func NewPostHandler(
+	postRepo *repository.PostRepository,
+	reactionRepo *repository.ReactionRepository,
+	mediaRepo *repository.MediaRepository) *PostHandler {
+
+	return &PostHandler{postRepo: postRepo, reactionRepo: reactionRepo, mediaRepo: mediaRepo}
 }
 
+// CreatePostRequest may attach the author's uploads to the post. The
+// featured image is attached too if it is not listed.
 type CreatePostRequest struct {
 	Title   string `json:"title"`
 	Body    string `json:"body"`
+	MediaIDs        []int64 `json:"media_ids"`
+	FeaturedMediaID int64   `json:"featured_media_id"`
 }
 
 func (h *PostHandler) Create(w http.ResponseWriter, r *http.Request) {
@@ -49,27 +71,59 @@ func (h *PostHandler) Create(w http.ResponseWriter, r *http.Request) {
 		Body:      req.Body,
 		AuthorID:  userID,
 	}
+	if !h.attachMedia(w, r, userID, post, &req.MediaIDs, &req.FeaturedMediaID) {
+		return
+	}
 
-	if err := h.postRepo.Create(post); err != nil {
+	if err := h.postRepo.Create(r.Context(), post); err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
+	metrics.PostsCreated.Inc()
 
-	w.Header().Set("Content-Type", "application/json")
-    w.WriteHeader(http.StatusCreated)
-    json.NewEncoder(w).Encode(post)
+    views.JSON(w, http.StatusCreated, views.NewPost(post))
 
 }
 
 func (h *PostHandler) Get(w http.ResponseWriter, r *http.Request) {
-    vars := mux.Vars(r)
-    id, err := strconv.ParseInt(vars["id"], 10, 64)
-    if err != nil {
-        http.Error(w, "Invalid post ID", http.StatusBadRequest)
+    post, ok := pathPost(w, r, h.postRepo)
+    if !ok {
+        return
+    }
+    if err := loadPostDetails(r.Context(), h.reactionRepo, h.mediaRepo, viewerID(r), post); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
 
-    post, err := h.postRepo.GetByID(id)
+    views.JSON(w, http.StatusOK, views.NewPost(post))
+}
+
+// GetBySlug returns the post with the slug in the path. The old slug of a
+// retitled post redirects permanently to the current one.
+func (h *PostHandler) GetBySlug(w http.ResponseWriter, r *http.Request) {
+    h.getPermalink(w, r, func(post *models.Post) string {
+        return "/api/posts/by-slug/" + post.Slug
+    })
+}
+
+// GetByDate returns the post at a date permalink. An old slug, or a date
+// other than the day the post was created, redirects permanently to the
+// post's current permalink.
+func (h *PostHandler) GetByDate(w http.ResponseWriter, r *http.Request) {
+    h.getPermalink(w, r, func(post *models.Post) string {
+        return "/api/posts" + slug.Path(slug.SchemeDate, post.Slug, post.CreatedAt)
+    })
+}
+
+// getPermalink looks up the post with the slug in the path and writes it,
+// or redirects when the request path is not the canonical one.
+func (h *PostHandler) getPermalink(w http.ResponseWriter, r *http.Request, canonical func(*models.Post) string) {
+    s := mux.Vars(r)["slug"]
+    if !slug.Valid(s) {
+        http.Error(w, "Post not found", http.StatusNotFound)
+        return
+    }
+    post, err := h.postRepo.GetBySlug(r.Context(), s)
     if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
@@ -78,9 +132,16 @@ func (h *PostHandler) Get(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "Post not found", http.StatusNotFound)
         return
     }
+    if path := canonical(post); r.URL.Path != path {
+        http.Redirect(w, r, path, http.StatusMovedPermanently)
+        return
+    }
 
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(post)
+    if err := loadPostDetails(r.Context(), h.reactionRepo, h.mediaRepo, viewerID(r), post); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    views.JSON(w, http.StatusOK, views.NewPost(post))
 }
 
 func (h *PostHandler) Update(w http.ResponseWriter, r *http.Request) {
@@ -97,7 +158,7 @@ func (h *PostHandler) Update(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    existingPost, err := h.postRepo.GetByID(postID)
+    existingPost, err := h.postRepo.GetByID(r.Context(), postID)
     if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
@@ -123,14 +184,24 @@ func (h *PostHandler) Update(w http.ResponseWriter, r *http.Request) {
     // Update the post
     existingPost.Title = req.Title
     existingPost.Body = req.Body
+    if err := h.mediaRepo.LoadForPosts(r.Context(), existingPost); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if !h.attachMedia(w, r, userID, existingPost, req.MediaIDs, req.FeaturedMediaID) {
+        return
+    }
 
-    if err := h.postRepo.Update(existingPost); err != nil {
+    if err := h.postRepo.Update(r.Context(), existingPost); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if err := loadPostDetails(r.Context(), h.reactionRepo, h.mediaRepo, userID, existingPost); err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
 
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(existingPost)
+    views.JSON(w, http.StatusOK, views.NewPost(existingPost))
 }
 
 func (h *PostHandler) List(w http.ResponseWriter, r *http.Request) {
@@ -148,12 +219,140 @@ func (h *PostHandler) List(w http.ResponseWriter, r *http.Request) {
         }
     }
 
-    posts, err := h.postRepo.List(limit, offset)
+    posts, err := h.postRepo.List(r.Context(), limit, offset)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if err := loadPostDetails(r.Context(), h.reactionRepo, h.mediaRepo, viewerID(r), posts...); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    views.JSON(w, http.StatusOK, views.NewList(posts, views.NewPost))
+}
+// Timeline lists the posts of the authors the signed-in user follows,
+// newest first. Pass next_cursor back as cursor for the following page.
+func (h *PostHandler) Timeline(w http.ResponseWriter, r *http.Request) {
+    userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+    cursor, limit, ok := pageParams(w, r)
+    if !ok {
+        return
+    }
+
+    posts, err := h.postRepo.Timeline(r.Context(), userID, cursor, limit+1)
     if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
 
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(posts)
-}
\ No newline at end of file
+    page := views.Page[views.Post]{}
+    if len(posts) > limit {
+        posts = posts[:limit]
+        last := posts[limit-1]
+        page.NextCursor = repository.Cursor{Time: last.CreatedAt, ID: last.ID}.String()
+    }
+    if err := loadPostDetails(r.Context(), h.reactionRepo, h.mediaRepo, userID, posts...); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    page.Items = views.NewList(posts, views.NewPost)
+    views.JSON(w, http.StatusOK, page)
+}
+
+// attachMedia sets the images of post from the IDs in a request, leaving
+// them alone when ids or featuredID is nil, and responds with an error and
+// returns false if any is not one of userID's uploads.
+func (h *PostHandler) attachMedia(w http.ResponseWriter, r *http.Request, userID int64, post *models.Post, ids *[]int64, featuredID *int64) bool {
+    if ids != nil {
+        seen := make(map[int64]bool, len(*ids))
+        unique := make([]int64, 0, len(*ids))
+        for _, id := range *ids {
+            if !seen[id] {
+                seen[id] = true
+                unique = append(unique, id)
+            }
+        }
+        if len(unique) > maxPostMedia {
+            http.Error(w, "Too many images attached", http.StatusBadRequest)
+            return false
+        }
+        media, err := h.mediaRepo.GetOwned(r.Context(), userID, unique)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return false
+        }
+        if len(media) != len(unique) {
+            http.Error(w, "Unknown media ID", http.StatusBadRequest)
+            return false
+        }
+        post.Media = media
+        if featuredID == nil && post.FeaturedImage != nil && !seen[post.FeaturedImage.ID] {
+            post.FeaturedImage = nil
+        }
+    }
+
+    if featuredID != nil {
+        post.FeaturedImage = nil
+        if *featuredID == 0 {
+            return true
+        }
+        for _, m := range post.Media {
+            if m.ID == *featuredID {
+                post.FeaturedImage = m
+                return true
+            }
+        }
+        media, err := h.mediaRepo.GetOwned(r.Context(), userID, []int64{*featuredID})
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return false
+        }
+        if len(media) != 1 {
+            http.Error(w, "Unknown media ID", http.StatusBadRequest)
+            return false
+        }
+        post.FeaturedImage = media[0]
+    }
+    return true
+}
+
+// loadPostDetails fills in the reactions and images of posts before they
+// are shown to viewerID.
+func loadPostDetails(ctx context.Context, reactions *repository.ReactionRepository, media *repository.MediaRepository, viewerID int64, posts ...*models.Post) error {
+    if err := reactions.Load(ctx, viewerID, posts...); err != nil {
+        return err
+    }
+    return media.LoadForPosts(ctx, posts...)
+}
+
+// pathPost loads the post with the ID in the path, responding with an
+// error and returning false when there is none.
+func pathPost(w http.ResponseWriter, r *http.Request, posts *repository.PostRepository) (*models.Post, bool) {
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "Invalid post ID", http.StatusBadRequest)
+        return nil, false
+    }
+    post, err := posts.GetByID(r.Context(), id)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return nil, false
+    }
+    if post == nil {
+        http.Error(w, "Post not found", http.StatusNotFound)
+        return nil, false
+    }
+    return post, true
+}
+
+// viewerID returns the signed-in user on routes behind OptionalAuth, or 0
+// for an anonymous request.
+func viewerID(r *http.Request) int64 {
+    userID, _ := r.Context().Value(middleware.UserIDKey).(int64)
+    return userID
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tokens"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/views"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+// PrivacyHandler lets users download their data and delete their account.
+// The archives are built, and due accounts erased, by privacy.Jobs.
+type PrivacyHandler struct {
+	userRepo   *repository.UserRepository
+	exportRepo *repository.DataExportRepository
+	config     config.Config
+}
+
+func NewPrivacyHandler(
+	userRepo *repository.UserRepository,
+	exportRepo *repository.DataExportRepository,
+	config config.Config) *PrivacyHandler {
+
+	return &PrivacyHandler{
+		userRepo:   userRepo,
+		exportRepo: exportRepo,
+		config:     config,
+	}
+}
+
+// RequestExport queues an archive of the user's data. The download link is
+// emailed once it is ready; asking again while one is being built returns
+// that one.
+func (h *PrivacyHandler) RequestExport(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	export, err := h.exportRepo.GetInProgress(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if export == nil {
+		export = &models.DataExport{UserID: user.ID}
+		if err := h.exportRepo.Create(r.Context(), export); err != nil {
+			http.Error(w, "Error requesting export", http.StatusInternalServerError)
+			return
+		}
+		logging.FromContext(r.Context()).Info("data export requested", "export_id", export.ID, "user_id", user.ID)
+	}
+
+	views.JSON(w, http.StatusAccepted, views.NewDataExport(export))
+}
+
+// DownloadExport serves a ready archive. It takes both the emailed token
+// and the owner's session, so a forwarded link alone is not enough.
+func (h *PrivacyHandler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	export, err := h.exportRepo.GetByToken(r.Context(), tokens.Hash(mux.Vars(r)["token"]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if export == nil || export.UserID != user.ID || export.Status != models.ExportReady ||
+		export.ExpiresAt == nil || time.Now().After(*export.ExpiresAt) {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(export.FilePath)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("error opening data export", "export_id", export.ID, "error", err)
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-data-%s.zip"`,
+		user.Username, export.CreatedAt.UTC().Format("2006-01-02")))
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, "", export.CreatedAt, f)
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// DeleteMe schedules the account for erasure once the grace period is
+// over. Accounts without a password, such as those created through an
+// identity provider, only need the session.
+func (h *PrivacyHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if user.Password != "" {
+		_, span := tracing.Start(r.Context(), "password.Verify")
+		valid, _ := utils.VerifyPassword(req.Password, user.Password)
+		span.End()
+		if req.Password == "" || !valid {
+			http.Error(w, "Password is incorrect", http.StatusForbidden)
+			return
+		}
+	}
+
+	at := time.Now().Add(h.config.Privacy.DeletionGracePeriod)
+	if err := h.userRepo.ScheduleDeletion(r.Context(), user.ID, &at); err != nil {
+		http.Error(w, "Error scheduling deletion", http.StatusInternalServerError)
+		return
+	}
+
+	logger := logging.FromContext(r.Context())
+	logger.Info("account deletion scheduled", "user_id", user.ID, "at", at)
+	if err := utils.SendAccountDeletionScheduledEmail(r.Context(), user.Email, at, h.config); err != nil {
+		logger.Warn("error sending deletion notice", "user_id", user.ID, "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]time.Time{
+		"deletion_scheduled_at": at,
+	})
+}
+
+// CancelDeletion keeps an account that was scheduled for deletion.
+func (h *PrivacyHandler) CancelDeletion(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+	if err := h.userRepo.ScheduleDeletion(r.Context(), user.ID, nil); err != nil {
+		http.Error(w, "Error cancelling deletion", http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(r.Context()).Info("account deletion cancelled", "user_id", user.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
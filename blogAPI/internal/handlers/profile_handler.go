@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/middleware"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tokens"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/views"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+const (
+	emailChangeTTL    = 24 * time.Hour
+	maxDisplayNameLen = 100
+	maxBioLen         = 2000
+	maxURLLen         = 255
+)
+
+type ProfileHandler struct {
+	users           *UserHandler
+	userRepo        *repository.UserRepository
+	emailChangeRepo *repository.EmailChangeRepository
+	config          config.Config
+}
+
+func NewProfileHandler(
+	users *UserHandler,
+	userRepo *repository.UserRepository,
+	emailChangeRepo *repository.EmailChangeRepository,
+	config config.Config) *ProfileHandler {
+
+	return &ProfileHandler{
+		users:           users,
+		userRepo:        userRepo,
+		emailChangeRepo: emailChangeRepo,
+		config:          config,
+	}
+}
+
+func (h *ProfileHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	views.JSON(w, http.StatusOK, views.NewSelf(user))
+}
+
+func (h *ProfileHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.userRepo.GetByUsername(r.Context(), mux.Vars(r)["username"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	views.JSON(w, http.StatusOK, views.NewAuthor(user))
+}
+
+// AdminGetUser shows an administrator any account, including its login
+// protection state.
+func (h *ProfileHandler) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+	if admin.Role != models.RoleAdmin {
+		http.Error(w, "Admin access required", http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	user, err := h.userRepo.GetByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	views.JSON(w, http.StatusOK, views.NewAdmin(user))
+}
+
+// UpdateProfileRequest changes only the fields that are present. A new
+// email takes effect once confirmed from that address; a new password
+// needs the current one.
+type UpdateProfileRequest struct {
+	DisplayName     *string `json:"display_name"`
+	Bio             *string `json:"bio"`
+	Website         *string `json:"website"`
+	AvatarURL       *string `json:"avatar_url"`
+	Email           *string `json:"email"`
+	Password        *string `json:"password"`
+	CurrentPassword string  `json:"current_password"`
+}
+
+func (h *ProfileHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
+	user, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Checked before anything else reads user, which the check updates
+	if req.Password != nil && !h.users.checkCurrentPassword(w, r, user, req.CurrentPassword) {
+		return
+	}
+
+	profile := *user
+	if msg := applyProfileFields(&profile, &req); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var newEmail string
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+		if !strings.EqualFold(email, user.Email) {
+			existing, err := h.userRepo.GetByEmail(r.Context(), email)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if existing != nil {
+				http.Error(w, repository.ErrEmailTaken.Error(), http.StatusConflict)
+				return
+			}
+			newEmail = email
+		}
+	}
+
+	var newHash string
+	if req.Password != nil {
+		if !checkNewPassword(w, r, *req.Password, user.Username, user.Email) {
+			return
+		}
+
+		_, span := tracing.Start(r.Context(), "password.Hash")
+		hash, err := utils.HashPassword(*req.Password)
+		span.End()
+		if err != nil {
+			http.Error(w, "Error processing password", http.StatusInternalServerError)
+			return
+		}
+		newHash = hash
+	}
+
+	if profile != *user {
+		if err := h.userRepo.UpdateProfile(r.Context(), &profile); err != nil {
+			http.Error(w, "Error updating profile", http.StatusInternalServerError)
+			return
+		}
+	}
+	if newHash != "" {
+		if err := h.userRepo.UpdatePassword(r.Context(), user.ID, newHash); err != nil {
+			http.Error(w, "Error updating password", http.StatusInternalServerError)
+			return
+		}
+	}
+	if newEmail != "" {
+		if err := h.requestEmailChange(r, user, newEmail); err != nil {
+			logger.Error("error requesting email change", "user_id", user.ID, "error", err)
+			http.Error(w, "Error sending confirmation email", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := views.NewSelf(&profile)
+	response.PendingEmail = newEmail
+	views.JSON(w, http.StatusOK, response)
+}
+
+// applyProfileFields copies the profile fields present in req to user,
+// returning why they are invalid if they are.
+func applyProfileFields(user *models.User, req *UpdateProfileRequest) string {
+	if req.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(user.DisplayName) > maxDisplayNameLen {
+			return "Display name must be at most 100 characters"
+		}
+	}
+	if req.Bio != nil {
+		user.Bio = strings.TrimSpace(*req.Bio)
+		if utf8.RuneCountInString(user.Bio) > maxBioLen {
+			return "Bio must be at most 2000 characters"
+		}
+	}
+	if req.Website != nil {
+		user.Website = strings.TrimSpace(*req.Website)
+		if !validProfileURL(user.Website) {
+			return "Website must be an http or https URL"
+		}
+	}
+	if req.AvatarURL != nil {
+		user.AvatarURL = strings.TrimSpace(*req.AvatarURL)
+		if !validProfileURL(user.AvatarURL) {
+			return "Avatar URL must be an http or https URL"
+		}
+	}
+	return ""
+}
+
+// validProfileURL accepts an empty string, which clears the field, or an
+// absolute http or https URL.
+func validProfileURL(raw string) bool {
+	if raw == "" {
+		return true
+	}
+	if len(raw) > maxURLLen {
+		return false
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// requestEmailChange emails a confirmation link to the new address and
+// warns the current one.
+func (h *ProfileHandler) requestEmailChange(r *http.Request, user *models.User, newEmail string) error {
+	ctx := r.Context()
+
+	token, err := tokens.Generate()
+	if err != nil {
+		return err
+	}
+	change := &models.EmailChangeToken{
+		UserID:    user.ID,
+		NewEmail:  newEmail,
+		TokenHash: tokens.Hash(token),
+		ExpiredAt: time.Now().Add(emailChangeTTL),
+	}
+	if err := h.emailChangeRepo.Create(ctx, change); err != nil {
+		return err
+	}
+
+	if err := utils.SendEmailChangeEmail(ctx, newEmail, token, change.ExpiredAt, h.config); err != nil {
+		return err
+	}
+	if err := utils.SendEmailChangeRequestedEmail(ctx, user.Email, newEmail, h.config); err != nil {
+		logging.FromContext(ctx).Warn("error notifying current email of change", "user_id", user.ID, "error", err)
+	}
+	return nil
+}
+
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token"`
+}
+
+// ConfirmEmailChange switches the account to the new email address once
+// its owner follows the link sent to it.
+func (h *ProfileHandler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req ConfirmEmailChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	change, err := h.emailChangeRepo.GetByToken(r.Context(), tokens.Hash(req.Token))
+	if err != nil {
+		http.Error(w, "Error validating token", http.StatusInternalServerError)
+		return
+	}
+	if change == nil || change.Used || time.Now().After(change.ExpiredAt) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	applied, err := h.emailChangeRepo.Apply(r.Context(), change)
+	if errors.Is(err, repository.ErrEmailTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error updating email", http.StatusInternalServerError)
+		return
+	}
+	if !applied {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("email change confirmed", "user_id", change.UserID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Email address has been changed",
+	})
+}
+
+// currentUser loads the signed-in user, responding with an error and
+// returning false when that fails.
+func currentUser(w http.ResponseWriter, r *http.Request, users *repository.UserRepository) (*models.User, bool) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	user, err := users.GetByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/middleware"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/views"
+	"github.com/gorilla/mux"
+)
+
+// ReactionHandler lets users react to and bookmark posts. Every change is
+// idempotent, so clients can retry freely.
+type ReactionHandler struct {
+	postRepo     *repository.PostRepository
+	reactionRepo *repository.ReactionRepository
+	bookmarkRepo *repository.BookmarkRepository
+	mediaRepo    *repository.MediaRepository
+}
+
+func NewReactionHandler(
+	postRepo *repository.PostRepository,
+	reactionRepo *repository.ReactionRepository,
+	bookmarkRepo *repository.BookmarkRepository,
+	mediaRepo *repository.MediaRepository) *ReactionHandler {
+
+	return &ReactionHandler{
+		postRepo:     postRepo,
+		reactionRepo: reactionRepo,
+		bookmarkRepo: bookmarkRepo,
+		mediaRepo:    mediaRepo,
+	}
+}
+
+// React adds the signed-in user's {kind} reaction to the post and returns
+// the post's updated reactions.
+func (h *ReactionHandler) React(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, h.reactionRepo.Add)
+}
+
+// Unreact takes back the signed-in user's {kind} reaction and returns the
+// post's updated reactions.
+func (h *ReactionHandler) Unreact(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, h.reactionRepo.Remove)
+}
+
+func (h *ReactionHandler) changeReaction(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, postID, userID int64, kind string) error) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	kind := mux.Vars(r)["kind"]
+	if !models.ValidReaction(kind) {
+		http.Error(w, "Unknown reaction", http.StatusBadRequest)
+		return
+	}
+	post, ok := pathPost(w, r, h.postRepo)
+	if !ok {
+		return
+	}
+
+	if err := change(r.Context(), post.ID, userID, kind); err != nil {
+		http.Error(w, "Error updating reaction", http.StatusInternalServerError)
+		return
+	}
+	if err := h.reactionRepo.Load(r.Context(), userID, post); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	views.JSON(w, http.StatusOK, views.NewList(post.Reactions, views.NewReaction))
+}
+
+// Bookmark saves the post to the signed-in user's bookmarks.
+func (h *ReactionHandler) Bookmark(w http.ResponseWriter, r *http.Request) {
+	h.changeBookmark(w, r, h.bookmarkRepo.Add)
+}
+
+// Unbookmark removes the post from the signed-in user's bookmarks.
+func (h *ReactionHandler) Unbookmark(w http.ResponseWriter, r *http.Request) {
+	h.changeBookmark(w, r, h.bookmarkRepo.Remove)
+}
+
+func (h *ReactionHandler) changeBookmark(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, userID, postID int64) error) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	post, ok := pathPost(w, r, h.postRepo)
+	if !ok {
+		return
+	}
+
+	if err := change(r.Context(), userID, post.ID); err != nil {
+		http.Error(w, "Error updating bookmark", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListBookmarks lists the signed-in user's bookmarked posts, most
+// recently saved first. Bookmarks are never shown to anyone else.
+func (h *ReactionHandler) ListBookmarks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	cursor, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	bookmarks, err := h.bookmarkRepo.List(r.Context(), userID, cursor, limit+1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page := views.Page[views.Post]{}
+	if len(bookmarks) > limit {
+		bookmarks = bookmarks[:limit]
+		last := bookmarks[limit-1]
+		page.NextCursor = repository.Cursor{Time: last.CreatedAt, ID: last.Post.ID}.String()
+	}
+	posts := make([]*models.Post, 0, len(bookmarks))
+	for _, b := range bookmarks {
+		posts = append(posts, b.Post)
+	}
+	if err := loadPostDetails(r.Context(), h.reactionRepo, h.mediaRepo, userID, posts...); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page.Items = views.NewList(posts, views.NewPost)
+	views.JSON(w, http.StatusOK, page)
+}
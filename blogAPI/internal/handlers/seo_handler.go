@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"cmp"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/sitemap"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/slug"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"github.com/gorilla/mux"
+)
+
+// sitemapCacheControl lets crawlers and caches keep sitemaps for an hour.
+const sitemapCacheControl = "public, max-age=3600"
+
+// SEOHandler serves the sitemap and robots.txt that let search engines
+// find posts.
+type SEOHandler struct {
+	postRepo *repository.PostRepository
+	config   config.Config
+	siteURL  string
+}
+
+func NewSEOHandler(postRepo *repository.PostRepository, config config.Config) *SEOHandler {
+	siteURL := strings.TrimSuffix(cmp.Or(config.SEO.SiteURL, config.Frontend.URL), "/")
+	return &SEOHandler{postRepo: postRepo, config: config, siteURL: siteURL}
+}
+
+// SitemapIndex lists one sitemap per sitemap.MaxURLs posts, each dated by
+// the latest change to a post in it.
+func (h *SEOHandler) SitemapIndex(w http.ResponseWriter, r *http.Request) {
+	chunks, err := h.postRepo.SitemapChunks(r.Context(), sitemap.MaxURLs)
+	if err != nil {
+		http.Error(w, "Error building sitemap", http.StatusInternalServerError)
+		return
+	}
+
+	body := startXML(w, r)
+	index, err := sitemap.NewIndex(body)
+	if err == nil {
+		for _, c := range chunks {
+			loc := fmt.Sprintf("%s/sitemaps/posts-%d.xml", h.siteURL, c.FirstID)
+			if err = index.Add(loc, c.LastModified); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = index.Close()
+	}
+	if err == nil {
+		err = body.Close()
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("error writing sitemap index", "error", err)
+	}
+}
+
+// PostsSitemap lists the permalinks of up to sitemap.MaxURLs posts
+// starting at the ID in the path, streaming them from the database.
+func (h *SEOHandler) PostsSitemap(w http.ResponseWriter, r *http.Request) {
+	firstID, err := strconv.ParseInt(mux.Vars(r)["first"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The response is started with the first post, so a sitemap with no
+	// posts can still be answered with a 404.
+	var body io.WriteCloser
+	var urls *sitemap.Writer
+	err = h.postRepo.EachForSitemap(r.Context(), firstID, sitemap.MaxURLs, func(post *models.Post) error {
+		if urls == nil {
+			body = startXML(w, r)
+			var err error
+			if urls, err = sitemap.NewURLSet(body); err != nil {
+				return err
+			}
+		}
+		return urls.Add(h.siteURL+slug.Path(h.config.Posts.Permalinks, post.Slug, post.CreatedAt), post.UpdatedAt)
+	})
+	switch {
+	case body == nil && err != nil:
+		http.Error(w, "Error building sitemap", http.StatusInternalServerError)
+		return
+	case body == nil:
+		http.NotFound(w, r)
+		return
+	}
+	if err == nil {
+		err = urls.Close()
+	}
+	if closeErr := body.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("error writing sitemap", "first_id", firstID, "error", err)
+	}
+}
+
+// Robots serves robots.txt, pointing crawlers at the sitemap.
+func (h *SEOHandler) Robots(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if h.config.SEO.RobotsBlockAll {
+		b.WriteString("Disallow: /\n")
+	} else {
+		for _, path := range h.config.SEO.RobotsDisallow {
+			fmt.Fprintf(&b, "Disallow: %s\n", path)
+		}
+		if len(h.config.SEO.RobotsDisallow) == 0 {
+			b.WriteString("Disallow:\n")
+		}
+		fmt.Fprintf(&b, "\nSitemap: %s/sitemap.xml\n", h.siteURL)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", sitemapCacheControl)
+	io.WriteString(w, b.String())
+}
+
+// startXML writes the headers of an XML response and returns its body,
+// gzipped when the client accepts it. The body must be closed.
+func startXML(w http.ResponseWriter, r *http.Request) io.WriteCloser {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", sitemapCacheControl)
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return nopCloser{w}
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	return gzip.NewWriter(w)
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -2,22 +2,57 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/anoying-kid/go-apps/blogAPI/internal/lockout"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/metrics"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/middleware"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/passpolicy"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tokens"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/totp"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
 
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
 	"github.com/anoying-kid/go-apps/blogAPI/pkg/utils"
 )
 
 type UserHandler struct {
 	userRepo *repository.UserRepository
+	loginRepo *repository.LoginHistoryRepository
+	unlockRepo *repository.AccountUnlockRepository
+	mfaRepo *repository.MFARepository
+	lockout lockout.Policy
+	config config.Config
 }
 
-func NewUserHandler(userRepo *repository.UserRepository) *UserHandler {
-	return &UserHandler{userRepo: userRepo}
+func NewUserHandler(
+	userRepo *repository.UserRepository,
+	loginRepo *repository.LoginHistoryRepository,
+	unlockRepo *repository.AccountUnlockRepository,
+	mfaRepo *repository.MFARepository,
+	config config.Config) *UserHandler {
+
+	return &UserHandler{
+		userRepo: userRepo,
+		loginRepo: loginRepo,
+		unlockRepo: unlockRepo,
+		mfaRepo: mfaRepo,
+		lockout: lockout.Policy{
+			FreeAttempts: config.Lockout.FreeAttempts,
+			BaseDelay: config.Lockout.BaseDelay,
+			MaxDelay: config.Lockout.MaxDelay,
+			Threshold: config.Lockout.Threshold,
+			Duration: config.Lockout.Duration,
+		},
+		config: config}
 }
 
 type RegisterRequest struct {
@@ -33,7 +68,13 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    if !checkNewPassword(w, r, registerReq.Password, registerReq.Username, registerReq.Email) {
+        return
+    }
+
+    _, span := tracing.Start(r.Context(), "password.Hash")
     hashedPassword, err := utils.HashPassword(registerReq.Password)
+    span.End()
     if err != nil {
         http.Error(w, "Error processing password", http.StatusInternalServerError)
         return
@@ -47,11 +88,17 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
     	UpdatedAt: time.Now().Format(time.RFC3339),
     }
 
-    if err := h.userRepo.Create(user); err != nil {
+    if err := h.userRepo.Create(r.Context(), user); err != nil {
+        if errors.Is(err, repository.ErrUsernameTaken) || errors.Is(err, repository.ErrEmailTaken) {
+            http.Error(w, err.Error(), http.StatusConflict)
+            return
+        }
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
 
+    metrics.Registrations.Inc()
+
     response := map[string]interface{}{
         "id":      user.ID,
         "username": user.Username,
@@ -66,6 +113,25 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
     }
 }
 
+// checkNewPassword responds with 400 and what is wrong with password when
+// it breaks the password policy for the account described by userInputs,
+// and reports whether it may be used.
+func checkNewPassword(w http.ResponseWriter, r *http.Request, password string, userInputs ...string) bool {
+	err := utils.ValidatePassword(password, userInputs...)
+	if err == nil {
+		return true
+	}
+
+	var violation *passpolicy.Violation
+	if errors.As(err, &violation) {
+		http.Error(w, violation.Error(), http.StatusBadRequest)
+		return false
+	}
+	logging.FromContext(r.Context()).Error("failed to check password policy", "error", err)
+	http.Error(w, "Error processing password", http.StatusInternalServerError)
+	return false
+}
+
 type LoginRequest struct {
     Email    string `json:"email"`
     Password string `json:"password"`
@@ -79,30 +145,397 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get user by email
-	user, err := h.userRepo.GetByEmail(req.Email)
+	user, err := h.userRepo.GetByEmail(r.Context(), req.Email)
 	if err != nil {
+		metrics.Logins.WithLabelValues("failure").Inc()
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 	if user == nil {
+		metrics.Logins.WithLabelValues("failure").Inc()
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 
+	// Refuse attempts while a delay or lockout is in force, before
+	// spending any time on the password. A locked account gets the same
+	// answer as an unknown email, so neither reveals that it exists.
+	now := time.Now()
+	failures, err := h.beginAttempt(r, user, now)
+	if errors.Is(err, repository.ErrLoginLocked) {
+		metrics.Logins.WithLabelValues("locked").Inc()
+		h.recordLogin(r, user.ID, false)
+		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error checking login attempts", http.StatusInternalServerError)
+		return
+	}
+
 	// Verify password
-	if !utils.CheckPasswordHash(req.Password, user.Password) {
+	_, span := tracing.Start(r.Context(), "password.Verify")
+	valid, needsRehash := utils.VerifyPassword(req.Password, user.Password)
+	span.End()
+	if !valid {
+		metrics.Logins.WithLabelValues("failure").Inc()
+		h.recordLogin(r, user.ID, false)
+		h.recordFailure(r, user, failures, now)
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
+	// The second factor, if any, is yet to be proven and is counted on its
+	// own
+	h.endAttempt(r, user, failures)
+	if needsRehash {
+		h.rehashPassword(r, user, req.Password)
+	}
+
+	result, err := h.afterFirstFactor(r, user, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// afterFirstFactor decides what a user who has proven their password, or
+// signed in through an OpenID provider, gets next: a challenge for their
+// second factor, a token that only allows enrolling one when their role
+// requires it, or the access token itself.
+func (h *UserHandler) afterFirstFactor(r *http.Request, user *models.User, now time.Time) (map[string]interface{}, error) {
+	mfa, err := h.mfaRepo.Get(r.Context(), user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error checking two-factor status: %w", err)
+	}
+	if mfa.Confirmed() {
+		token, err := middleware.GeneratePurposeToken(user.ID, middleware.PurposeMFAChallenge, h.config.MFA.ChallengeTTL)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    token,
+		}, nil
+	}
+	required, err := h.mfaRepo.RoleRequiresMFA(r.Context(), user.Role)
+	if err != nil {
+		return nil, fmt.Errorf("error checking two-factor status: %w", err)
+	}
+	if required {
+		token, err := middleware.GeneratePurposeToken(user.ID, middleware.PurposeMFAEnrollment, h.config.MFA.EnrollmentTTL)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"mfa_enrollment_required": true,
+			"enrollment_token":        token,
+		}, nil
+	}
+
+	return h.completeLogin(r, user, now)
+}
+
+// rehashPassword replaces a hash made with an older algorithm or weaker
+// parameters, now that the plain password is at hand. Failing to do so
+// does not stop the login.
+func (h *UserHandler) rehashPassword(r *http.Request, user *models.User, password string) {
+	logger := logging.FromContext(r.Context())
+
+	_, span := tracing.Start(r.Context(), "password.Hash")
+	hash, err := utils.HashPassword(password)
+	span.End()
+	if err != nil {
+		logger.Error("error rehashing password", "user_id", user.ID, "error", err)
+		return
+	}
+	if err := h.userRepo.UpdatePassword(r.Context(), user.ID, hash); err != nil {
+		logger.Error("error storing rehashed password", "user_id", user.ID, "error", err)
+		return
+	}
+	user.Password = hash
+	logger.Info("password rehashed with current parameters", "user_id", user.ID)
+}
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// LoginMFA finishes a login that returned an MFA challenge, accepting
+// either a TOTP code or an unused recovery code.
+func (h *UserHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, err := middleware.ValidatePurposeToken(req.MFAToken, middleware.PurposeMFAChallenge)
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+	user, err := h.userRepo.GetByID(r.Context(), userID)
+	if err != nil || user == nil {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	// The token already shows the password was right, so the lock can be
+	// reported as such
+	now := time.Now()
+	failures, err := h.beginAttempt(r, user, now)
+	if errors.Is(err, repository.ErrLoginLocked) {
+		metrics.Logins.WithLabelValues("locked").Inc()
+		tooManyAttempts(w, user.LockedUntil.Sub(now))
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error checking login attempts", http.StatusInternalServerError)
+		return
+	}
+
+	mfa, err := h.mfaRepo.Get(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Error checking two-factor status", http.StatusInternalServerError)
+		return
+	}
+	if !mfa.Confirmed() {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	var valid bool
+	switch {
+	case req.Code != "":
+		valid, err = h.useTOTPCode(r, mfa, req.Code, now)
+	case req.RecoveryCode != "":
+		valid, err = h.mfaRepo.UseRecoveryCode(r.Context(), user.ID, hashRecoveryCode(req.RecoveryCode))
+	}
+	if err != nil {
+		http.Error(w, "Error verifying code", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		metrics.Logins.WithLabelValues("failure").Inc()
+		h.recordLogin(r, user.ID, false)
+		h.recordFailure(r, user, failures, now)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	result, err := h.completeLogin(r, user, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// useTOTPCode checks code and consumes its time step so it cannot be
+// replayed.
+func (h *UserHandler) useTOTPCode(r *http.Request, mfa *models.UserMFA, code string, now time.Time) (bool, error) {
+	step, ok := totp.Validate(mfa.Secret, code, now)
+	if !ok {
+		return false, nil
+	}
+	return h.mfaRepo.UseStep(r.Context(), mfa.UserID, step)
+}
+
+// completeLogin issues the access token once every factor has been
+// verified.
+func (h *UserHandler) completeLogin(r *http.Request, user *models.User, now time.Time) (map[string]interface{}, error) {
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		if err := h.userRepo.ResetFailedLogins(r.Context(), user.ID); err != nil {
+			logging.FromContext(r.Context()).Error("error resetting failed logins", "user_id", user.ID, "error", err)
+		}
+	}
+	h.notifyNewDevice(r, user, now)
+	h.recordLogin(r, user.ID, true)
 
 	// Generate JWT token
 	token, err := middleware.GenerateToken(user.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+
+	metrics.Logins.WithLabelValues("success").Inc()
+	return map[string]interface{}{"token": token}, nil
+}
+
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+}
+
+// beginAttempt counts a login attempt against user before their
+// credentials are checked, applying the lock it earns should they be
+// wrong, and returns the count. While the account is locked it returns
+// repository.ErrLoginLocked and sets user.LockedUntil.
+func (h *UserHandler) beginAttempt(r *http.Request, user *models.User, now time.Time) (int, error) {
+	failures, lockedUntil, err := h.userRepo.BeginLoginAttempt(r.Context(), user.ID, func(failures int) time.Time {
+		return h.lockout.AfterFailure(failures, now).LockedUntil
+	})
+	if errors.Is(err, repository.ErrLoginLocked) {
+		user.LockedUntil = &lockedUntil
+	}
+	if err != nil {
+		return 0, err
+	}
+	user.FailedLoginAttempts = failures
+	return failures, nil
+}
+
+// endAttempt takes back an attempt begun with beginAttempt whose
+// credentials were right.
+func (h *UserHandler) endAttempt(r *http.Request, user *models.User, failures int) {
+	if err := h.userRepo.EndLoginAttempt(r.Context(), user.ID, failures); err != nil {
+		logging.FromContext(r.Context()).Error("error ending login attempt", "user_id", user.ID, "error", err)
+		return
+	}
+	user.FailedLoginAttempts = failures - 1
+	user.LockedUntil = nil
+}
+
+// recordFailure emails an unlock link when the failed attempt begun with
+// beginAttempt was the one that locked the account.
+func (h *UserHandler) recordFailure(r *http.Request, user *models.User, failures int, now time.Time) {
+	logger := logging.FromContext(r.Context())
+
+	decision := h.lockout.AfterFailure(failures, now)
+	if !decision.LockedOut {
+		return
+	}
+
+	logger.Warn("account locked after failed logins", "user_id", user.ID, "failures", failures)
+	token, err := tokens.Generate()
+	if err != nil {
+		logger.Error("error generating unlock token", "user_id", user.ID, "error", err)
+		return
+	}
+	unlock := &models.AccountUnlockToken{
+		UserID:    user.ID,
+		TokenHash: tokens.Hash(token),
+		ExpiredAt: decision.LockedUntil,
+	}
+	if err := h.unlockRepo.Create(r.Context(), unlock); err != nil {
+		logger.Error("error creating unlock token", "user_id", user.ID, "error", err)
+		return
+	}
+	if err := utils.SendAccountLockedEmail(r.Context(), user.Email, token, decision.LockedUntil, h.config); err != nil {
+		logger.Error("error sending account locked email", "user_id", user.ID, "error", err)
+	}
+}
+
+// checkCurrentPassword verifies the password a signed-in user gives to
+// confirm a sensitive change. Wrong passwords count towards the same
+// lockout as logins, so a stolen session cannot be used to guess it. It
+// writes the error response when the password is not accepted.
+func (h *UserHandler) checkCurrentPassword(w http.ResponseWriter, r *http.Request, user *models.User, password string) bool {
+	now := time.Now()
+	failures, err := h.beginAttempt(r, user, now)
+	if errors.Is(err, repository.ErrLoginLocked) {
+		tooManyAttempts(w, user.LockedUntil.Sub(now))
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Error checking login attempts", http.StatusInternalServerError)
+		return false
+	}
+
+	_, span := tracing.Start(r.Context(), "password.Verify")
+	valid, _ := utils.VerifyPassword(password, user.Password)
+	span.End()
+	if password == "" || !valid {
+		h.recordFailure(r, user, failures, now)
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return false
+	}
+	h.endAttempt(r, user, failures)
+	return true
+}
+
+// notifyNewDevice emails the user when a successful login comes from an IP
+// address or user agent not seen in their earlier successful logins. The
+// very first login is not reported.
+func (h *UserHandler) notifyNewDevice(r *http.Request, user *models.User, now time.Time) {
+	if !h.config.Lockout.NotifyNewLogin {
+		return
+	}
+	logger := logging.FromContext(r.Context())
+
+	ip, userAgent := middleware.ClientIP(r), r.UserAgent()
+	known, err := h.loginRepo.KnownDevice(r.Context(), user.ID, ip, userAgent)
+	if err != nil {
+		logger.Error("error checking login history", "user_id", user.ID, "error", err)
+		return
+	}
+	if known.PreviousLogins == 0 || (known.KnownIP && known.KnownUserAgent) {
+		return
+	}
+
+	logger.Info("login from new device", "user_id", user.ID, "new_ip", !known.KnownIP, "new_user_agent", !known.KnownUserAgent)
+	if err := utils.SendNewLoginEmail(r.Context(), user.Email, ip, userAgent, now, h.config); err != nil {
+		logger.Error("error sending new login email", "user_id", user.ID, "error", err)
+	}
+}
+
+func (h *UserHandler) recordLogin(r *http.Request, userID int64, success bool) {
+	event := &models.LoginEvent{
+		UserID:    userID,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Success:   success,
+	}
+	if err := h.loginRepo.Create(r.Context(), event); err != nil {
+		logging.FromContext(r.Context()).Error("error recording login", "user_id", userID, "error", err)
+	}
+}
+
+type UnlockAccountRequest struct {
+	Token string `json:"token"`
+}
+
+// Unlock lifts a lockout using the token from the account locked email.
+func (h *UserHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	var req UnlockAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	unlock, err := h.unlockRepo.GetByToken(r.Context(), tokens.Hash(req.Token))
+	if err != nil {
+		http.Error(w, "Error validating token", http.StatusInternalServerError)
+		return
+	}
+	if unlock == nil || unlock.Used || time.Now().After(unlock.ExpiredAt) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	// Use the token up first, so only one of two racing requests unlocks
+	used, err := h.unlockRepo.MarkAsUsed(r.Context(), unlock.ID)
+	if err != nil {
+		http.Error(w, "Error updating token status", http.StatusInternalServerError)
+		return
+	}
+	if !used {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err := h.userRepo.ResetFailedLogins(r.Context(), unlock.UserID); err != nil {
+		http.Error(w, "Error unlocking account", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
-}
\ No newline at end of file
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Your account has been unlocked",
+	})
+}
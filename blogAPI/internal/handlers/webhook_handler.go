@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/views"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/webhook"
+	"github.com/gorilla/mux"
+)
+
+// WebhookHandler lets admins manage webhook subscriptions and inspect and
+// replay their deliveries.
+type WebhookHandler struct {
+	userRepo    *repository.UserRepository
+	webhookRepo *repository.WebhookRepository
+	dispatcher  *webhook.Dispatcher
+}
+
+func NewWebhookHandler(
+	userRepo *repository.UserRepository,
+	webhookRepo *repository.WebhookRepository,
+	dispatcher *webhook.Dispatcher) *WebhookHandler {
+
+	return &WebhookHandler{userRepo: userRepo, webhookRepo: webhookRepo, dispatcher: dispatcher}
+}
+
+type CreateWebhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description"`
+}
+
+// Create subscribes an endpoint to events. The response holds the secret
+// that signs its payloads, which is not shown again.
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sub := &models.WebhookSubscription{
+		URL:         strings.TrimSpace(req.URL),
+		Events:      req.Events,
+		Description: strings.TrimSpace(req.Description),
+		CreatedBy:   &admin.ID,
+	}
+	if !h.validWebhook(w, sub) {
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		http.Error(w, "Error generating secret", http.StatusInternalServerError)
+		return
+	}
+	sub.Secret = secret
+	if err := h.webhookRepo.CreateSubscription(r.Context(), sub); err != nil {
+		http.Error(w, "Error saving webhook", http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(r.Context()).Info("webhook subscription created", "subscription_id", sub.ID, "url", sub.URL)
+
+	views.JSON(w, http.StatusCreated, views.NewWebhookSubscriptionCreated(sub))
+}
+
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+	subs, err := h.webhookRepo.ListSubscriptions(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	views.JSON(w, http.StatusOK, views.NewList(subs, views.NewWebhookSubscription))
+}
+
+func (h *WebhookHandler) Get(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.pathSubscription(w, r)
+	if !ok {
+		return
+	}
+	views.JSON(w, http.StatusOK, views.NewWebhookSubscription(sub))
+}
+
+// UpdateWebhookRequest changes only the fields that are present. Setting
+// active to true reactivates a subscription that was disabled after
+// failing, and its pending deliveries are sent again.
+type UpdateWebhookRequest struct {
+	URL         *string   `json:"url"`
+	Events      *[]string `json:"events"`
+	Description *string   `json:"description"`
+	Active      *bool     `json:"active"`
+}
+
+func (h *WebhookHandler) Update(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.pathSubscription(w, r)
+	if !ok {
+		return
+	}
+	var req UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.URL != nil {
+		sub.URL = strings.TrimSpace(*req.URL)
+	}
+	if req.Events != nil {
+		sub.Events = *req.Events
+	}
+	if req.Description != nil {
+		sub.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.Active != nil {
+		sub.Active = *req.Active
+	}
+	if !h.validWebhook(w, sub) {
+		return
+	}
+
+	err := h.webhookRepo.UpdateSubscription(r.Context(), sub)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error saving webhook", http.StatusInternalServerError)
+		return
+	}
+	if sub.Active {
+		h.dispatcher.Notify()
+	}
+	views.JSON(w, http.StatusOK, views.NewWebhookSubscription(sub))
+}
+
+// Delete removes a subscription along with its delivery log.
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+	err = h.webhookRepo.DeleteSubscription(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error deleting webhook", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries lists the delivery log of a subscription, newest first.
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.pathSubscription(w, r)
+	if !ok {
+		return
+	}
+	cursor, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.webhookRepo.ListDeliveries(r.Context(), sub.ID, cursor, limit+1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page := views.Page[views.WebhookDelivery]{}
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		last := deliveries[limit-1]
+		page.NextCursor = repository.Cursor{Time: last.CreatedAt, ID: last.ID}.String()
+	}
+	page.Items = views.NewList(deliveries, views.NewWebhookDelivery)
+	views.JSON(w, http.StatusOK, page)
+}
+
+// Replay sends the payload of a logged delivery again as a new delivery,
+// whatever became of the original.
+func (h *WebhookHandler) Replay(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.pathSubscription(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["delivery_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+	delivery, err := h.webhookRepo.GetDelivery(r.Context(), sub.ID, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if delivery == nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	replay, err := h.webhookRepo.Replay(r.Context(), delivery)
+	if err != nil {
+		http.Error(w, "Error queueing delivery", http.StatusInternalServerError)
+		return
+	}
+	h.dispatcher.Notify()
+	views.JSON(w, http.StatusAccepted, views.NewWebhookDelivery(replay))
+}
+
+func (h *WebhookHandler) requireAdmin(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	admin, ok := currentUser(w, r, h.userRepo)
+	if !ok {
+		return nil, false
+	}
+	if admin.Role != models.RoleAdmin {
+		http.Error(w, "Admin access required", http.StatusForbidden)
+		return nil, false
+	}
+	return admin, true
+}
+
+// pathSubscription checks the caller is an admin and loads the
+// subscription in the path.
+func (h *WebhookHandler) pathSubscription(w http.ResponseWriter, r *http.Request) (*models.WebhookSubscription, bool) {
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return nil, false
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return nil, false
+	}
+	sub, err := h.webhookRepo.GetSubscription(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if sub == nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+	return sub, true
+}
+
+// validWebhook responds with 400 when the URL, events or description of
+// sub are not acceptable, and reports whether they are.
+func (h *WebhookHandler) validWebhook(w http.ResponseWriter, sub *models.WebhookSubscription) bool {
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil {
+		http.Error(w, "URL must be an absolute http or https URL", http.StatusBadRequest)
+		return false
+	}
+	if err := h.dispatcher.CheckURL(sub.URL); err != nil {
+		http.Error(w, "URL must not point to a private or local address", http.StatusBadRequest)
+		return false
+	}
+	if len(sub.Events) == 0 {
+		http.Error(w, "At least one event is required", http.StatusBadRequest)
+		return false
+	}
+	seen := make(map[string]bool)
+	events := sub.Events[:0:0]
+	for _, event := range sub.Events {
+		if !webhook.ValidEvent(event) {
+			http.Error(w, "Unknown event "+event, http.StatusBadRequest)
+			return false
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	sub.Events = events
+	if len(sub.Description) > 500 {
+		http.Error(w, "Description must be at most 500 characters", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
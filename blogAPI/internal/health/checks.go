@@ -0,0 +1,38 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/smtp"
+)
+
+// DatabaseCheck pings the database.
+func DatabaseCheck(db *sql.DB) CheckFunc {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// SMTPCheck connects to the mail server at host:port and waits for its
+// greeting, without authenticating or sending anything.
+func SMTPCheck(addr string) CheckFunc {
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+
+		host, _, _ := net.SplitHostPort(addr)
+		client, err := smtp.NewClient(conn, host)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		return client.Quit()
+	}
+}
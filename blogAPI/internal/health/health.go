@@ -0,0 +1,149 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+)
+
+// CheckFunc reports whether a dependency is usable. It must honour ctx.
+type CheckFunc func(ctx context.Context) error
+
+// Check is one named readiness check. A failing optional check is
+// reported but does not make the service unready.
+type Check struct {
+	Name     string
+	Func     CheckFunc
+	Timeout  time.Duration
+	Optional bool
+}
+
+const defaultTimeout = 2 * time.Second
+
+// Health serves the liveness and readiness endpoints.
+type Health struct {
+	mu           sync.RWMutex
+	checks       []Check
+	shuttingDown atomic.Bool
+}
+
+func New() *Health {
+	return &Health{}
+}
+
+// AddCheck registers a readiness check.
+func (h *Health) AddCheck(check Check) {
+	if check.Timeout <= 0 {
+		check.Timeout = defaultTimeout
+	}
+	h.mu.Lock()
+	h.checks = append(h.checks, check)
+	h.mu.Unlock()
+}
+
+// SetShuttingDown makes readiness fail from now on, so load balancers stop
+// routing new requests while in-flight ones drain.
+func (h *Health) SetShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+type checkResult struct {
+	Status   string `json:"status"`
+	Optional bool   `json:"optional,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type report struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+const (
+	statusOK          = "ok"
+	statusDegraded    = "degraded"
+	statusFailing     = "failing"
+	statusUnavailable = "unavailable"
+	statusShutdown    = "shutting_down"
+)
+
+// Liveness answers /healthz. It only shows that the process is serving
+// requests and never touches dependencies, so a database outage does not
+// get the process restarted.
+func (h *Health) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, report{Status: statusOK})
+}
+
+// Readiness answers /readyz by running every registered check
+// concurrently. The endpoint is public, so why a check failed is logged
+// rather than shown.
+func (h *Health) Readiness(w http.ResponseWriter, r *http.Request) {
+	if h.shuttingDown.Load() {
+		writeReport(w, http.StatusServiceUnavailable, report{Status: statusShutdown})
+		return
+	}
+
+	h.mu.RLock()
+	checks := append([]Check(nil), h.checks...)
+	h.mu.RUnlock()
+
+	results := make([]checkResult, len(checks))
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i], errs[i] = run(r.Context(), check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	rep := report{Status: statusOK, Checks: make(map[string]checkResult, len(checks))}
+	code := http.StatusOK
+	for i, check := range checks {
+		result := results[i]
+		rep.Checks[check.Name] = result
+		if result.Status == statusOK {
+			continue
+		}
+		logging.FromContext(r.Context()).Warn("readiness check failed", "check", check.Name, "optional", check.Optional, "error", errs[i])
+		if check.Optional {
+			if rep.Status == statusOK {
+				rep.Status = statusDegraded
+			}
+			continue
+		}
+		rep.Status = statusUnavailable
+		code = http.StatusServiceUnavailable
+	}
+	writeReport(w, code, rep)
+}
+
+func run(ctx context.Context, check Check) (checkResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Func(ctx)
+	result := checkResult{
+		Status:   statusOK,
+		Optional: check.Optional,
+		Duration: time.Since(start).Round(time.Microsecond).String(),
+	}
+	if err != nil {
+		result.Status = statusFailing
+	}
+	return result, err
+}
+
+func writeReport(w http.ResponseWriter, code int, rep report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,69 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readiness(t *testing.T, h *Health) (int, report) {
+	rr := httptest.NewRecorder()
+	h.Readiness(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+	var rep report
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&rep))
+	return rr.Code, rep
+}
+
+func TestReadiness(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	failing := func(context.Context) error { return errors.New("dial tcp 10.0.0.5:5432: connection refused") }
+
+	t.Run("All Checks Pass", func(t *testing.T) {
+		h := New()
+		h.AddCheck(Check{Name: "database", Func: ok})
+		code, rep := readiness(t, h)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, statusOK, rep.Status)
+		assert.Equal(t, statusOK, rep.Checks["database"].Status)
+	})
+
+	t.Run("Optional Check Fails", func(t *testing.T) {
+		h := New()
+		h.AddCheck(Check{Name: "database", Func: ok})
+		h.AddCheck(Check{Name: "mailer", Func: failing, Optional: true})
+		code, rep := readiness(t, h)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, statusDegraded, rep.Status)
+		assert.Equal(t, statusFailing, rep.Checks["mailer"].Status)
+	})
+
+	t.Run("Required Check Fails", func(t *testing.T) {
+		h := New()
+		h.AddCheck(Check{Name: "database", Func: failing})
+		rr := httptest.NewRecorder()
+		h.Readiness(rr, httptest.NewRequest("GET", "/readyz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		// The endpoint is public, so the cause stays in the logs
+		assert.NotContains(t, rr.Body.String(), "10.0.0.5")
+
+		var rep report
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&rep))
+		assert.Equal(t, statusUnavailable, rep.Status)
+		assert.Equal(t, statusFailing, rep.Checks["database"].Status)
+	})
+
+	t.Run("Shutting Down", func(t *testing.T) {
+		h := New()
+		h.AddCheck(Check{Name: "database", Func: ok})
+		h.SetShuttingDown()
+		code, rep := readiness(t, h)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, statusShutdown, rep.Status)
+	})
+}
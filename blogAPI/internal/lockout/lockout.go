@@ -0,0 +1,54 @@
+package lockout
+
+import (
+	"time"
+)
+
+// Policy decides how long an account must wait before its next login
+// attempt after a run of consecutive failures.
+type Policy struct {
+	// FreeAttempts failures are allowed before any delay applies.
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure past FreeAttempts;
+	// each further failure doubles it, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Threshold failures lock the account for Duration, or until it is
+	// unlocked from the emailed link.
+	Threshold int
+	Duration  time.Duration
+}
+
+// Decision is the outcome of a failed attempt.
+type Decision struct {
+	// LockedUntil is when the next attempt will be accepted; zero when no
+	// wait is required.
+	LockedUntil time.Time
+	// LockedOut is true when failures just reached the threshold and the
+	// account was locked.
+	LockedOut bool
+}
+
+// AfterFailure returns the decision after the account has failed failures
+// times in a row.
+func (p Policy) AfterFailure(failures int, now time.Time) Decision {
+	if p.Threshold > 0 && failures >= p.Threshold {
+		return Decision{
+			LockedUntil: now.Add(p.Duration),
+			LockedOut:   failures == p.Threshold,
+		}
+	}
+	if failures <= p.FreeAttempts || p.BaseDelay <= 0 {
+		return Decision{}
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			delay = p.MaxDelay
+			break
+		}
+	}
+	return Decision{LockedUntil: now.Add(delay)}
+}
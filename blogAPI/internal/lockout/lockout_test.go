@@ -0,0 +1,43 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAfterFailure(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	p := Policy{
+		FreeAttempts: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     4 * time.Second,
+		Threshold:    8,
+		Duration:     15 * time.Minute,
+	}
+
+	cases := []struct {
+		failures int
+		wait     time.Duration
+		locked   bool
+	}{
+		{1, 0, false},
+		{2, 0, false},
+		{3, time.Second, false},
+		{4, 2 * time.Second, false},
+		{5, 4 * time.Second, false},
+		{7, 4 * time.Second, false},
+		{8, 15 * time.Minute, true},
+		{9, 15 * time.Minute, false},
+	}
+	for _, c := range cases {
+		d := p.AfterFailure(c.failures, now)
+		if c.wait == 0 {
+			assert.True(t, d.LockedUntil.IsZero(), "failures=%d", c.failures)
+		} else {
+			assert.Equal(t, now.Add(c.wait), d.LockedUntil, "failures=%d", c.failures)
+		}
+		assert.Equal(t, c.locked, d.LockedOut, "failures=%d", c.failures)
+	}
+}
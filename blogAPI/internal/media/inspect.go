@@ -0,0 +1,71 @@
+// Package media checks uploaded images, strips their metadata and makes
+// the resized variants that are served alongside them.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	_ "golang.org/x/image/webp"
+)
+
+// The image types that can be uploaded.
+const (
+	TypeJPEG = "image/jpeg"
+	TypePNG  = "image/png"
+	TypeGIF  = "image/gif"
+	TypeWebP = "image/webp"
+)
+
+var (
+	// ErrUnsupportedType is returned for content that is not an image of
+	// a supported type, whatever it claims to be.
+	ErrUnsupportedType = errors.New("unsupported media type; upload a JPEG, PNG, GIF or WebP image")
+	// ErrTooManyPixels is returned for images larger than the configured
+	// number of pixels.
+	ErrTooManyPixels = errors.New("image dimensions are too large")
+)
+
+// Extensions maps the supported types to the file extension used in
+// storage keys.
+var Extensions = map[string]string{
+	TypeJPEG: ".jpg",
+	TypePNG:  ".png",
+	TypeGIF:  ".gif",
+	TypeWebP: ".webp",
+}
+
+// Info describes an image that passed inspection.
+type Info struct {
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Inspect sniffs the type of data from its content, ignoring anything the
+// client said about it, and reads its dimensions without decoding the
+// pixels, so oversized images are refused cheaply.
+func Inspect(data []byte, maxPixels int) (Info, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := Extensions[contentType]; !ok {
+		return Info{}, ErrUnsupportedType
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Info{}, fmt.Errorf("%w: %v", ErrUnsupportedType, err)
+	}
+	if "image/"+format != contentType {
+		return Info{}, ErrUnsupportedType
+	}
+	if config.Width < 1 || config.Height < 1 || int64(config.Width)*int64(config.Height) > int64(maxPixels) {
+		return Info{}, ErrTooManyPixels
+	}
+	return Info{ContentType: contentType, Width: config.Width, Height: config.Height}, nil
+}
@@ -0,0 +1,223 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), 80, 255})
+		}
+	}
+	return img
+}
+
+// withEXIF inserts an EXIF segment holding orientation and a GPS marker
+// string after the SOI marker of a JPEG.
+func withEXIF(t *testing.T, data []byte, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+	tiff.WriteString("GPS 51.5N 0.1W")
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	require.True(t, bytes.HasPrefix(data, []byte{0xff, 0xd8}))
+	return append(append([]byte{0xff, 0xd8}, segment...), data[2:]...)
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+	return buf.Bytes()
+}
+
+func TestInspect(t *testing.T) {
+	data := encodeJPEG(t, testImage(40, 30))
+	info, err := Inspect(data, 1200)
+	require.NoError(t, err)
+	assert.Equal(t, Info{ContentType: TypeJPEG, Width: 40, Height: 30}, info)
+
+	_, err = Inspect(data, 1199)
+	assert.ErrorIs(t, err, ErrTooManyPixels)
+
+	_, err = Inspect([]byte("<svg xmlns='http://www.w3.org/2000/svg'></svg>"), 1200)
+	assert.ErrorIs(t, err, ErrUnsupportedType)
+	_, err = Inspect(data[:20], 1200)
+	assert.ErrorIs(t, err, ErrUnsupportedType)
+}
+
+func TestStripJPEG(t *testing.T) {
+	plain := encodeJPEG(t, testImage(40, 30))
+	data := withEXIF(t, plain, 1)
+
+	out, info, err := StripMetadata(data, Info{ContentType: TypeJPEG, Width: 40, Height: 30})
+	require.NoError(t, err)
+	assert.Equal(t, plain, out)
+	assert.Equal(t, 40, info.Width)
+	assert.NotContains(t, string(out), "GPS")
+}
+
+func TestStripJPEGAppliesOrientation(t *testing.T) {
+	data := withEXIF(t, encodeJPEG(t, testImage(40, 30)), 6)
+
+	out, info, err := StripMetadata(data, Info{ContentType: TypeJPEG, Width: 40, Height: 30})
+	require.NoError(t, err)
+	assert.Equal(t, Info{ContentType: TypeJPEG, Width: 30, Height: 40}, info)
+	assert.NotContains(t, string(out), "Exif")
+
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 30, 40), img.Bounds())
+}
+
+func TestOrient(t *testing.T) {
+	// 2x1: red, green.
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	red, green := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 255, 0, 255}
+	src.Set(0, 0, red)
+	src.Set(1, 0, green)
+
+	rgba := func(c color.NRGBA) color.RGBA { return color.RGBA{c.R, c.G, c.B, c.A} }
+	for _, tt := range []struct {
+		orientation int
+		want        [][]color.NRGBA
+	}{
+		{2, [][]color.NRGBA{{green, red}}},
+		{3, [][]color.NRGBA{{green, red}}},
+		{4, [][]color.NRGBA{{red, green}}},
+		{5, [][]color.NRGBA{{red}, {green}}},
+		{6, [][]color.NRGBA{{red}, {green}}},
+		{7, [][]color.NRGBA{{green}, {red}}},
+		{8, [][]color.NRGBA{{green}, {red}}},
+	} {
+		dst := orient(src, tt.orientation)
+		require.Equal(t, len(tt.want[0]), dst.Rect.Dx(), "orientation %d", tt.orientation)
+		for y, row := range tt.want {
+			for x, c := range row {
+				assert.Equal(t, rgba(c), dst.RGBAAt(x, y), "orientation %d at %d,%d", tt.orientation, x, y)
+			}
+		}
+	}
+}
+
+func pngChunk(kind string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, testImage(8, 8)))
+	plain := buf.Bytes()
+
+	// Insert text chunks after IHDR (8 byte signature + 25 byte chunk).
+	data := append([]byte(nil), plain[:33]...)
+	data = append(data, pngChunk("tEXt", []byte("Author\x00Jane"))...)
+	data = append(data, pngChunk("eXIf", []byte("MM\x00\x2a"))...)
+	data = append(data, plain[33:]...)
+
+	out, _, err := StripMetadata(data, Info{ContentType: TypePNG, Width: 8, Height: 8})
+	require.NoError(t, err)
+	assert.Equal(t, plain, out)
+}
+
+func TestStripGIF(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	require.NoError(t, gif.Encode(&buf, img, nil))
+	plain := buf.Bytes()
+
+	// Insert a comment extension before the trailer.
+	comment := append([]byte{0x21, 0xfe, 5}, "hello\x00"...)
+	data := append(append(append([]byte(nil), plain[:len(plain)-1]...), comment...), 0x3b)
+
+	out, _, err := StripMetadata(data, Info{ContentType: TypeGIF, Width: 4, Height: 4})
+	require.NoError(t, err)
+	assert.Equal(t, plain, out)
+}
+
+func TestStripWebP(t *testing.T) {
+	chunk := func(kind string, data []byte) []byte {
+		c := append([]byte(kind), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+		c = append(c, data...)
+		if len(data)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	vp8x := []byte{0x08 | 0x04 | 0x10, 0, 0, 0, 3, 0, 0, 3, 0, 0}
+	body := append([]byte("WEBP"), chunk("VP8X", vp8x)...)
+	body = append(body, chunk("VP8L", []byte{0x2f, 1, 2})...)
+	body = append(body, chunk("EXIF", []byte("GPS"))...)
+	data := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	data = append(data, body...)
+
+	out, _, err := StripMetadata(data, Info{ContentType: TypeWebP})
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "EXIF")
+	assert.Equal(t, uint32(len(out)-8), binary.LittleEndian.Uint32(out[4:]))
+	assert.Equal(t, byte(0x10), out[20], "EXIF and XMP flags cleared")
+}
+
+func TestMakeVariants(t *testing.T) {
+	data := encodeJPEG(t, testImage(800, 400))
+
+	variants, err := MakeVariants(data, 200, 600)
+	require.NoError(t, err)
+	require.Len(t, variants, 3)
+
+	assert.Equal(t, "thumbnail", variants[0].Name)
+	assert.Equal(t, TypeJPEG, variants[0].ContentType)
+	assert.Equal(t, [2]int{200, 100}, [2]int{variants[0].Width, variants[0].Height})
+
+	assert.Equal(t, "thumbnail_webp", variants[1].Name)
+	assert.Equal(t, [2]int{200, 100}, [2]int{variants[1].Width, variants[1].Height})
+
+	assert.Equal(t, "webp", variants[2].Name)
+	assert.Equal(t, [2]int{600, 300}, [2]int{variants[2].Width, variants[2].Height})
+
+	for _, v := range variants {
+		info, err := Inspect(v.Data, 1<<20)
+		require.NoError(t, err, v.Name)
+		assert.Equal(t, v.ContentType, info.ContentType, v.Name)
+		assert.Equal(t, v.Width, info.Width, v.Name)
+	}
+}
+
+func TestMakeVariantsKeepsTransparencyAndSmallImages(t *testing.T) {
+	img := testImage(50, 20)
+	img.Set(0, 0, color.NRGBA{})
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+
+	variants, err := MakeVariants(buf.Bytes(), 200, 600)
+	require.NoError(t, err)
+	assert.Equal(t, TypePNG, variants[0].ContentType)
+	for _, v := range variants {
+		assert.Equal(t, [2]int{50, 20}, [2]int{v.Width, v.Height}, v.Name)
+	}
+}
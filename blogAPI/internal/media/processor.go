@@ -0,0 +1,171 @@
+package media
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/blobstore"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+)
+
+const (
+	// lease is how long a claimed upload is hidden from other workers. An
+	// instance that dies mid-way leaves the upload to be retried after it.
+	lease = 5 * time.Minute
+	// maxAttempts is how many times an upload is tried before it is
+	// marked failed.
+	maxAttempts = 3
+	// deletionBatch bounds how many files one pass removes.
+	deletionBatch = 100
+)
+
+// variantExtensions are the file extensions of the variant types.
+var variantExtensions = map[string]string{
+	TypeJPEG: ".jpg",
+	TypePNG:  ".png",
+	TypeWebP: ".webp",
+}
+
+// Processor makes the variants of new uploads and removes the files of
+// deleted ones.
+type Processor struct {
+	media  *repository.MediaRepository
+	store  blobstore.Store
+	config config.Config
+	notify chan struct{}
+}
+
+func NewProcessor(media *repository.MediaRepository, store blobstore.Store, config config.Config) *Processor {
+	return &Processor{media: media, store: store, config: config, notify: make(chan struct{}, 1)}
+}
+
+// Notify wakes the processor after an upload so its variants are made
+// without waiting for the next tick. It never blocks.
+func (p *Processor) Notify() {
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes uploads when notified and every WorkerInterval until ctx
+// is done.
+func (p *Processor) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.config.Media.WorkerInterval)
+	defer ticker.Stop()
+	for {
+		p.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.notify:
+		}
+	}
+}
+
+// RunOnce makes the variants of every waiting upload and removes the
+// files of deleted ones.
+func (p *Processor) RunOnce(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+
+	for ctx.Err() == nil {
+		media, attempts, err := p.media.Claim(ctx, lease)
+		if err != nil {
+			logger.Warn("error claiming upload", "error", err)
+			break
+		}
+		if media == nil {
+			break
+		}
+		if err := p.process(ctx, media); err != nil {
+			logger.Error("error making image variants", "media_id", media.ID, "attempt", attempts, "error", err)
+			if attempts >= maxAttempts {
+				err = p.media.Fail(ctx, media.ID, err.Error())
+			} else {
+				err = p.media.Release(ctx, media.ID)
+			}
+			if err != nil {
+				logger.Warn("error returning upload to the queue", "media_id", media.ID, "error", err)
+			}
+		}
+	}
+
+	if err := p.removeDeleted(ctx); err != nil && ctx.Err() == nil {
+		logger.Warn("error removing deleted media files", "error", err)
+	}
+}
+
+func (p *Processor) process(ctx context.Context, media *models.Media) error {
+	rc, err := p.store.Open(ctx, media.Key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+
+	made, err := MakeVariants(data, p.config.Media.ThumbnailSize, p.config.Media.DisplaySize)
+	if err != nil {
+		return fmt.Errorf("decoding image: %w", err)
+	}
+
+	dir := path.Dir(media.Key)
+	variants := make([]models.MediaVariant, 0, len(made))
+	for _, v := range made {
+		key := dir + "/" + v.Name + variantExtensions[v.ContentType]
+		if err := p.store.Put(ctx, key, v.Data, v.ContentType); err != nil {
+			return err
+		}
+		variants = append(variants, models.MediaVariant{
+			Name:        v.Name,
+			Key:         key,
+			ContentType: v.ContentType,
+			Size:        int64(len(v.Data)),
+			Width:       v.Width,
+			Height:      v.Height,
+		})
+	}
+	err = p.media.Ready(ctx, media.ID, variants)
+	if err == sql.ErrNoRows {
+		// Deleted while its variants were being made; the trigger only
+		// queued the original.
+		for _, v := range variants {
+			if err := p.store.Delete(ctx, v.Key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// removeDeleted removes the files queued when uploads were deleted.
+func (p *Processor) removeDeleted(ctx context.Context) error {
+	for ctx.Err() == nil {
+		deletions, err := p.media.ListBlobDeletions(ctx, deletionBatch)
+		if err != nil || len(deletions) == 0 {
+			return err
+		}
+		ids := make([]int64, 0, len(deletions))
+		for _, d := range deletions {
+			if err := p.store.Delete(ctx, d.Key); err != nil {
+				return err
+			}
+			ids = append(ids, d.ID)
+		}
+		if err := p.media.ForgetBlobDeletions(ctx, ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+)
+
+// errTruncated is returned when a file ends in the middle of a structure
+// its header promised.
+var errTruncated = errors.New("image file is truncated")
+
+// StripMetadata removes the metadata of an image that passed Inspect:
+// EXIF (which can hold the location a photo was taken and the camera's
+// serial number), XMP, IPTC, comments and text chunks. The pixel data is
+// copied as is, except for JPEG photos with an EXIF orientation, which
+// are rotated upright and re-encoded since the orientation is lost with
+// the EXIF block. The returned Info reflects any rotation.
+func StripMetadata(data []byte, info Info) ([]byte, Info, error) {
+	var out []byte
+	var err error
+	switch info.ContentType {
+	case TypeJPEG:
+		var orientation int
+		out, orientation, err = stripJPEG(data)
+		if err == nil && orientation > 1 && orientation <= 8 {
+			return rotateJPEG(out, orientation)
+		}
+	case TypePNG:
+		out, err = stripPNG(data)
+	case TypeGIF:
+		out, err = stripGIF(data)
+	case TypeWebP:
+		out, err = stripWebP(data)
+	default:
+		err = ErrUnsupportedType
+	}
+	return out, info, err
+}
+
+// stripJPEG drops every segment before the image data except the ones
+// needed to decode it correctly: JFIF, ICC profiles and the Adobe color
+// transform. It returns the EXIF orientation, or 0.
+func stripJPEG(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, 0, ErrUnsupportedType
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+	orientation := 0
+
+	i := 2
+	for {
+		if i >= len(data) || data[i] != 0xff {
+			return nil, 0, errTruncated
+		}
+		for i < len(data) && data[i] == 0xff {
+			i++
+		}
+		if i >= len(data) {
+			return nil, 0, errTruncated
+		}
+		marker := data[i]
+		start := i - 1
+		i++
+
+		if marker == 0xd9 || (marker >= 0xd0 && marker <= 0xd7) || marker == 0x01 {
+			out = append(out, 0xff, marker)
+			if marker == 0xd9 {
+				return out, orientation, nil
+			}
+			continue
+		}
+		if i+2 > len(data) {
+			return nil, 0, errTruncated
+		}
+		end := i + int(binary.BigEndian.Uint16(data[i:]))
+		if end > len(data) || end < i+2 {
+			return nil, 0, errTruncated
+		}
+		payload := data[i+2 : end]
+
+		if marker == 0xda {
+			// Start of scan: the entropy-coded data and anything after it
+			// is copied as is. Encoders put metadata before the first
+			// scan.
+			out = append(out, data[start:]...)
+			return out, orientation, nil
+		}
+
+		keep := true
+		switch {
+		case marker == 0xe1:
+			if bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				orientation = exifOrientation(payload[6:])
+			}
+			keep = false
+		case marker == 0xe2:
+			keep = bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+		case marker == 0xe0, marker == 0xee:
+		case marker >= 0xe3 && marker <= 0xef, marker == 0xfe:
+			keep = false
+		}
+		if keep {
+			out = append(out, 0xff, marker)
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+}
+
+// exifOrientation reads the Orientation tag from the first IFD of a TIFF
+// structure, returning 0 when there is none.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// rotateJPEG decodes a JPEG, turns it upright and encodes it again.
+func rotateJPEG(data []byte, orientation int) ([]byte, Info, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, Info{}, err
+	}
+	upright := orient(img, orientation)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, upright, &jpeg.Options{Quality: 92}); err != nil {
+		return nil, Info{}, err
+	}
+	b := upright.Bounds()
+	return buf.Bytes(), Info{ContentType: TypeJPEG, Width: b.Dx(), Height: b.Dy()}, nil
+}
+
+// orient applies an EXIF orientation (2-8) to img.
+func orient(img image.Image, orientation int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Rect, img, b.Min, draw.Src)
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
+
+// stripPNG drops the chunks that carry metadata rather than pixels.
+func stripPNG(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, ErrUnsupportedType
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, signature...)
+
+	for i := len(signature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, errTruncated
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i+12 {
+			return nil, errTruncated
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// stripWebP drops the EXIF and XMP chunks and clears the flags that
+// announce them.
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrUnsupportedType
+	}
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errTruncated
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size&1
+		if end > len(data) || size < 0 {
+			// The padding byte of the last chunk is sometimes missing
+			if end == len(data)+1 && size&1 == 1 {
+				end = len(data)
+			} else {
+				return nil, errTruncated
+			}
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// stripGIF drops comment extensions and application extensions other than
+// the ones that control animation.
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return nil, ErrUnsupportedType
+	}
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&7 + 1)
+	}
+	if i > len(data) {
+		return nil, errTruncated
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:i]...)
+
+	// subBlocks returns the end of the sub-blocks starting at j.
+	subBlocks := func(j int) (int, error) {
+		for {
+			if j >= len(data) {
+				return 0, errTruncated
+			}
+			n := int(data[j])
+			j += 1 + n
+			if n == 0 {
+				return j, nil
+			}
+		}
+	}
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x3b: // trailer
+			return append(out, 0x3b), nil
+		case 0x21: // extension
+			if i+2 > len(data) {
+				return nil, errTruncated
+			}
+			end, err := subBlocks(i + 2)
+			if err != nil {
+				return nil, err
+			}
+			keep := true
+			switch data[i+1] {
+			case 0xfe:
+				keep = false
+			case 0xff:
+				id := data[i+2 : min(end, i+14)]
+				keep = bytes.HasPrefix(id, []byte("\x0bNETSCAPE2.0")) || bytes.HasPrefix(id, []byte("\x0bANIMEXTS1.0"))
+			}
+			if keep {
+				out = append(out, data[i:end]...)
+			}
+			i = end
+		case 0x2c: // image
+			j := i + 10
+			if j > len(data) {
+				return nil, errTruncated
+			}
+			if flags := data[i+9]; flags&0x80 != 0 {
+				j += 3 << (flags&7 + 1)
+			}
+			end, err := subBlocks(j + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[i:end]...)
+			i = end
+		default:
+			return nil, ErrUnsupportedType
+		}
+	}
+	return nil, errTruncated
+}
@@ -0,0 +1,97 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/webp"
+)
+
+// Variant is an encoded copy of an image made by MakeVariants.
+type Variant struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	Width       int
+	Height      int
+}
+
+// MakeVariants decodes an image and makes the copies served in its place:
+// a thumbnail whose longer side is at most thumbnailSize, as JPEG (PNG if
+// the image has transparency) and as WebP, and a WebP copy at most
+// displaySize across for full-width display. Images are never enlarged.
+// Only the first frame of an animated GIF is used.
+func MakeVariants(data []byte, thumbnailSize, displaySize int) ([]Variant, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail := fit(img, thumbnailSize)
+	var variants []Variant
+
+	fallback := Variant{Name: "thumbnail"}
+	var buf bytes.Buffer
+	if opaque(img) {
+		fallback.ContentType = TypeJPEG
+		err = jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: 85})
+	} else {
+		fallback.ContentType = TypePNG
+		err = png.Encode(&buf, thumbnail)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fallback.Data = buf.Bytes()
+	variants = append(variants, sized(fallback, thumbnail))
+
+	for _, v := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"thumbnail_webp", thumbnail},
+		{"webp", fit(img, displaySize)},
+	} {
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, v.img); err != nil {
+			return nil, err
+		}
+		variants = append(variants, sized(Variant{Name: v.name, ContentType: TypeWebP, Data: buf.Bytes()}, v.img))
+	}
+	return variants, nil
+}
+
+func sized(v Variant, img image.Image) Variant {
+	v.Width, v.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	return v
+}
+
+// fit scales img down so that neither side is longer than size, keeping
+// its aspect ratio.
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	if w >= h {
+		w, h = size, max(1, h*size/w)
+	} else {
+		w, h = max(1, w*size/h), size
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Rect, img, b, draw.Src, nil)
+	return dst
+}
+
+// opaque reports whether img has no transparent pixels.
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
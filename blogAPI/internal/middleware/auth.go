@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/http"
 	"strings"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
 )
 
 // Custom type for context keys
@@ -13,9 +15,45 @@ const UserIDKey contextKey = "user_id"
 
 type Claims struct {
 	UserID int64 `json:"user_id"`
+	// Purpose is empty for access tokens. Tokens issued for a single step
+	// of a flow, such as an MFA challenge, carry that step's purpose and
+	// are refused everywhere else.
+	Purpose string `json:"purpose,omitempty"`
+	// ClientID and Scope are set on tokens issued to OAuth clients.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// TokenID is the JWT ID, filled in by ValidateToken.
+	TokenID string `json:"-"`
 }
 
+// AuthMiddleware accepts access tokens from a login, and personal access
+// tokens and OAuth access tokens on routes wrapped in RequireScope.
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+    return authenticate(next, "")
+}
+
+// MFAEnrollmentAuth accepts access tokens as well as the enrollment tokens
+// given to users whose role requires two-factor login but who have not
+// set it up yet.
+func MFAEnrollmentAuth(next http.HandlerFunc) http.HandlerFunc {
+    return authenticate(next, "", PurposeMFAEnrollment)
+}
+
+// OptionalAuth identifies the caller like AuthMiddleware when a token is
+// sent, so public routes can personalize their response, and lets
+// anonymous requests through without a user ID.
+func OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+    authed := authenticate(next, "")
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("Authorization") == "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        authed.ServeHTTP(w, r)
+    }
+}
+
+func authenticate(next http.HandlerFunc, purposes ...string) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         // Get the Authorization header
         authHeader := r.Header.Get("Authorization")
@@ -31,15 +69,48 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
             return
         }
 
-        // Validate the JWT token
-        claims, err := ValidateToken(bearerToken[1])
-        if err != nil {
-            http.Error(w, "Invalid token", http.StatusUnauthorized)
-            return
+        var userID int64
+        if isAccessToken(bearerToken[1]) {
+            id, status, msg := authenticateAccessToken(r, bearerToken[1])
+            if status != 0 {
+                http.Error(w, msg, status)
+                return
+            }
+            userID = id
+        } else {
+            // Validate the JWT token
+            claims, err := ValidateToken(bearerToken[1])
+            if err != nil {
+                http.Error(w, "Invalid token", http.StatusUnauthorized)
+                return
+            }
+            if claims.Purpose == PurposeOAuthAccess {
+                if status, msg := authenticateOAuthToken(r, claims); status != 0 {
+                    http.Error(w, msg, status)
+                    return
+                }
+            } else if !allowedPurpose(claims.Purpose, purposes) {
+                http.Error(w, "Invalid token", http.StatusUnauthorized)
+                return
+            }
+            userID = claims.UserID
         }
 
         // Add the user ID to the request context
-        ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+        ctx := context.WithValue(r.Context(), UserIDKey, userID)
+        ctx = logging.With(ctx, "user_id", userID)
+        if info := GetRequestInfo(ctx); info != nil {
+            info.UserID = userID
+        }
         next.ServeHTTP(w, r.WithContext(ctx))
     }
 }
+
+func allowedPurpose(purpose string, allowed []string) bool {
+    for _, p := range allowed {
+        if p == purpose {
+            return true
+        }
+    }
+    return false
+}
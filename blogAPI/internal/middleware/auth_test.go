@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeTokens struct {
+	scopes  []string
+	revoked bool
+}
+
+func (f fakeTokens) AuthenticateToken(ctx context.Context, token string) (int64, []string, error) {
+	if token != AccessTokenPrefix+"good" {
+		return 0, nil, ErrInvalidAccessToken
+	}
+	return 7, f.scopes, nil
+}
+
+func (f fakeTokens) OAuthTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	return f.revoked, nil
+}
+
+func serve(handler http.HandlerFunc, token string) int {
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr.Code
+}
+
+func TestAuthMiddlewareTokenKinds(t *testing.T) {
+	var gotUser int64
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		gotUser = r.Context().Value(UserIDKey).(int64)
+	}
+	plain := AuthMiddleware(ok)
+	scoped := RequireScope(ScopePostsWrite, AuthMiddleware(ok))
+
+	session, err := GenerateToken(7)
+	require.NoError(t, err)
+	challenge, err := GeneratePurposeToken(7, PurposeMFAChallenge, time.Minute)
+	require.NoError(t, err)
+	oauthWrite, err := GenerateOAuthAccessToken(7, "client", "jti-1", []string{ScopePostsWrite}, time.Minute)
+	require.NoError(t, err)
+	oauthRead, err := GenerateOAuthAccessToken(7, "client", "jti-2", []string{ScopePostsRead}, time.Minute)
+	require.NoError(t, err)
+
+	SetTokenAuthenticator(fakeTokens{scopes: []string{ScopePostsWrite}})
+	SetOAuthTokenChecker(fakeTokens{})
+	defer SetTokenAuthenticator(nil)
+	defer SetOAuthTokenChecker(nil)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		token   string
+		status  int
+	}{
+		{"session", plain, session, http.StatusOK},
+		{"session on scoped route", scoped, session, http.StatusOK},
+		{"purpose token", plain, challenge, http.StatusUnauthorized},
+		{"personal token on unscoped route", plain, AccessTokenPrefix + "good", http.StatusForbidden},
+		{"personal token with scope", scoped, AccessTokenPrefix + "good", http.StatusOK},
+		{"unknown personal token", scoped, AccessTokenPrefix + "bad", http.StatusUnauthorized},
+		{"oauth token on unscoped route", plain, oauthWrite, http.StatusForbidden},
+		{"oauth token with scope", scoped, oauthWrite, http.StatusOK},
+		{"oauth token without scope", scoped, oauthRead, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUser = 0
+			status := serve(tt.handler, tt.token)
+			assert.Equal(t, tt.status, status)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, int64(7), gotUser)
+			}
+		})
+	}
+
+	t.Run("revoked oauth token", func(t *testing.T) {
+		SetOAuthTokenChecker(fakeTokens{revoked: true})
+		status := serve(scoped, oauthWrite)
+		assert.Equal(t, http.StatusUnauthorized, status)
+	})
+}
+
+func TestOptionalAuth(t *testing.T) {
+	var gotUser int64
+	var authed bool
+	handler := OptionalAuth(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, authed = r.Context().Value(UserIDKey).(int64)
+	})
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.False(t, authed)
+
+	session, err := GenerateToken(7)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, serve(handler, session))
+	assert.True(t, authed)
+	assert.Equal(t, int64(7), gotUser)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, "not-a-token"))
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP replaces the request's RemoteAddr with the client address reported
+// by the reverse proxies in front of the API. trustedHops is how many
+// proxies append to X-Forwarded-For on the way in; the client is the entry
+// that many from the right, as anything to its left was sent by the client
+// and can be forged. X-Real-IP is used when there is no X-Forwarded-For.
+// Only use it when the API is reachable exclusively through those proxies.
+func RealIP(trustedHops int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedIP(r, trustedHops); ip != "" {
+				r.RemoteAddr = net.JoinHostPort(ip, "0")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func forwardedIP(r *http.Request, trustedHops int) string {
+	// Proxies may add the header more than once rather than append to it
+	var hops []string
+	for _, xff := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(xff, ",")...)
+	}
+	if len(hops) > 0 {
+		// Fewer entries than proxies means every entry was added by one
+		// of them, the leftmost by the first
+		i := max(len(hops)-trustedHops, 0)
+		if ip := net.ParseIP(strings.TrimSpace(hops[i])); ip != nil {
+			return ip.String()
+		}
+		return ""
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// ClientIP returns the IP address of the client that sent r.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRealIP(t *testing.T) {
+	clientIP := func(trustedHops int, header http.Header) string {
+		var got string
+		handler := RealIP(trustedHops)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = ClientIP(r)
+		}))
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:4000"
+		req.Header = header
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return got
+	}
+
+	t.Run("Address Appended By The Proxy", func(t *testing.T) {
+		assert.Equal(t, "203.0.113.7", clientIP(1, http.Header{"X-Forwarded-For": {"203.0.113.7"}}))
+	})
+
+	t.Run("Spoofed Leading Entry Is Ignored", func(t *testing.T) {
+		assert.Equal(t, "203.0.113.7", clientIP(1, http.Header{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7"}}))
+		assert.Equal(t, "203.0.113.7", clientIP(1, http.Header{"X-Forwarded-For": {"198.51.100.1", "203.0.113.7"}}))
+	})
+
+	t.Run("Several Trusted Proxies", func(t *testing.T) {
+		header := http.Header{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7, 10.0.0.2"}}
+		assert.Equal(t, "203.0.113.7", clientIP(2, header))
+		assert.Equal(t, "198.51.100.1", clientIP(5, header))
+	})
+
+	t.Run("Malformed Entry Keeps The Connection Address", func(t *testing.T) {
+		assert.Equal(t, "10.0.0.1", clientIP(1, http.Header{"X-Forwarded-For": {"203.0.113.7, nonsense"}}))
+	})
+
+	t.Run("X-Real-IP", func(t *testing.T) {
+		assert.Equal(t, "203.0.113.9", clientIP(1, http.Header{"X-Real-Ip": {"203.0.113.9"}}))
+	})
+
+	t.Run("No Headers", func(t *testing.T) {
+		assert.Equal(t, "10.0.0.1", clientIP(1, http.Header{}))
+	})
+}
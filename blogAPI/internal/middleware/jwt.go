@@ -17,7 +17,12 @@ type TokenPair struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
-var jwtSecret = []byte("super-secret-key") // In production, use environment variable
+var jwtSecret = []byte("super-secret-key") // Replaced at boot by SetSecret
+
+// SetSecret sets the key used to sign and validate tokens.
+func SetSecret(secret string) {
+	jwtSecret = []byte(secret)
+}
 
 func GenerateToken(userID int64) (string, error) {
 	claims := &JWTClaim{
@@ -46,6 +51,42 @@ func GenerateRefreshToken(userID int64) (string, error) {
 	return token.SignedString(jwtSecret)
 }
 
+const (
+	// PurposeMFAChallenge tokens are returned by a password login that
+	// still needs a second factor.
+	PurposeMFAChallenge = "mfa_challenge"
+	// PurposeMFAEnrollment tokens only allow setting up two-factor login.
+	PurposeMFAEnrollment = "mfa_enrollment"
+)
+
+// GeneratePurposeToken issues a short-lived token that is only accepted by
+// the step of a flow named by purpose.
+func GeneratePurposeToken(userID int64, purpose string, ttl time.Duration) (string, error) {
+	claims := &JWTClaim{
+		Claims: Claims{UserID: userID, Purpose: purpose},
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+// ValidatePurposeToken validates a token issued by GeneratePurposeToken
+// for purpose and returns its user ID.
+func ValidatePurposeToken(tokenString, purpose string) (int64, error) {
+	claims, err := ValidateToken(tokenString)
+	if err != nil {
+		return 0, err
+	}
+	if claims.Purpose != purpose {
+		return 0, fmt.Errorf("token is not valid for %s", purpose)
+	}
+	return claims.UserID, nil
+}
+
 func ValidateToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenString,
@@ -64,6 +105,7 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	}
 
 	if claims, ok := token.Claims.(*JWTClaim); ok && token.Valid {
+		claims.Claims.TokenID = claims.StandardClaims.Id
 		return &claims.Claims, nil
 	}
 
@@ -94,6 +136,9 @@ func ValidateRefreshToken(tokenString string) (int64, error) {
 	if err != nil {
 		return 0, fmt.Errorf("invalid refresh token: %v", err)
 	}
+	if claims.Purpose != "" {
+		return 0, fmt.Errorf("invalid refresh token: token is for %s", claims.Purpose)
+	}
 
 	return claims.UserID, nil
 }
\ No newline at end of file
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/metrics"
+)
+
+// Metrics records request counts and latencies labelled by route template.
+// Like AccessLog it must run inside RequestID.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		metrics.ObserveHTTP(r.Method, routeLabel(r), rec.Status(), time.Since(start))
+	})
+}
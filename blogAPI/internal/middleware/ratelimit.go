@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/metrics"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/ratelimit"
+)
+
+// RateLimitRule throttles requests that share a key, such as the client IP
+// or the account named in the request body.
+type RateLimitRule struct {
+	// Name identifies the rule in bucket keys and metrics, e.g. "login:ip".
+	Name  string
+	Limit ratelimit.Limit
+	// Key returns the bucket key for r, or "" to skip the rule.
+	Key func(r *http.Request) string
+}
+
+// RateLimit takes a token from every rule's bucket and rejects the request
+// with 429 Too Many Requests when any of them is empty. The RateLimit-*
+// headers describe the most restrictive bucket. Store errors let the
+// request through rather than locking everyone out.
+func RateLimit(store ratelimit.Store, rules ...RateLimitRule) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var tightest *ratelimit.Result
+			var tightestRule RateLimitRule
+			var denied *ratelimit.Result
+
+			for _, rule := range rules {
+				key := rule.Key(r)
+				if key == "" {
+					continue
+				}
+				res, err := store.Take(r.Context(), rule.Name+":"+key, rule.Limit)
+				if err != nil {
+					logging.FromContext(r.Context()).Warn("rate limit store error", "rule", rule.Name, "error", err)
+					continue
+				}
+				if !res.Allowed {
+					metrics.RateLimited.WithLabelValues(rule.Name).Inc()
+					if denied == nil || res.RetryAfter > denied.RetryAfter {
+						denied = &res
+					}
+				}
+				if tightest == nil || res.Remaining < tightest.Remaining {
+					tightest, tightestRule = &res, rule
+				}
+			}
+
+			if tightest != nil {
+				h := w.Header()
+				h.Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+				h.Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+				h.Set("RateLimit-Reset", ceilSeconds(tightest.Reset))
+				h.Set("RateLimit-Policy", strconv.Itoa(tightestRule.Limit.Burst)+";w="+ceilSeconds(tightestRule.Limit.Period))
+			}
+			if denied != nil {
+				w.Header().Set("Retry-After", ceilSeconds(denied.RetryAfter))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// KeyByIP keys buckets by client IP.
+func KeyByIP(r *http.Request) string {
+	return ClientIP(r)
+}
+
+// maxPeekBody bounds how much of a request body KeyByJSONField buffers.
+const maxPeekBody = 1 << 20
+
+// KeyByJSONField keys buckets by a string field of the JSON request body,
+// such as the email of the account being logged into. The body is
+// restored for the handler. Values are hashed so bucket keys do not store
+// personal data.
+func KeyByJSONField(field string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		value := strings.ToLower(strings.TrimSpace(jsonField(r, field)))
+		if value == "" {
+			return ""
+		}
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:16])
+	}
+}
+
+// KeyByPurposeToken keys buckets by the user a purpose token in a field
+// of the JSON request body was issued to, such as the mfa_token of the
+// second login step. Requests without a valid token are not keyed; the
+// handler turns them away.
+func KeyByPurposeToken(field, purpose string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		userID, err := ValidatePurposeToken(jsonField(r, field), purpose)
+		if err != nil {
+			return ""
+		}
+		return strconv.FormatInt(userID, 10)
+	}
+}
+
+// KeyByUser keys buckets by the signed-in user, so it must run inside
+// the authentication middleware.
+func KeyByUser(r *http.Request) string {
+	userID, ok := r.Context().Value(UserIDKey).(int64)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatInt(userID, 10)
+}
+
+// jsonField returns a string field of the JSON request body, restoring
+// the body for the handler.
+func jsonField(r *http.Request, field string) string {
+	if r.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPeekBody))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return ""
+	}
+	value, _ := fields[field].(string)
+	return value
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyByJSONField(t *testing.T) {
+	key := KeyByJSONField("email")
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"email":" Alice@Example.com ","password":"x"}`))
+	got := key(req)
+	assert.NotEmpty(t, got)
+	assert.NotContains(t, got, "alice")
+	// The handler still sees the whole body
+	body, _ := io.ReadAll(req.Body)
+	assert.JSONEq(t, `{"email":" Alice@Example.com ","password":"x"}`, string(body))
+
+	assert.Equal(t, got, key(httptest.NewRequest("POST", "/", strings.NewReader(`{"email":"alice@example.com"}`))))
+	assert.Empty(t, key(httptest.NewRequest("POST", "/", strings.NewReader(`{"mfa_token":"x"}`))))
+}
+
+func TestKeyByPurposeToken(t *testing.T) {
+	key := KeyByPurposeToken("mfa_token", PurposeMFAChallenge)
+	request := func(token string) string {
+		return key(httptest.NewRequest("POST", "/", strings.NewReader(`{"mfa_token":"`+token+`","code":"123456"}`)))
+	}
+
+	token, err := GeneratePurposeToken(42, PurposeMFAChallenge, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "42", request(token))
+
+	// A fresh token for the same user shares its bucket
+	again, err := GeneratePurposeToken(42, PurposeMFAChallenge, 2*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "42", request(again))
+
+	other, err := GeneratePurposeToken(42, PurposeMFAEnrollment, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, request(other))
+	assert.Empty(t, request("not-a-token"))
+}
+
+func TestKeyByUser(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", nil)
+	assert.Empty(t, KeyByUser(req))
+	assert.Equal(t, "42", KeyByUser(req.WithContext(context.WithValue(req.Context(), UserIDKey, int64(42)))))
+}
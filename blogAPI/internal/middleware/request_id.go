@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+const requestInfoKey contextKey = "request_info"
+
+// RequestInfo collects per-request details that are only known deeper in
+// the handler chain, such as the matched route or the authenticated user,
+// so outer middleware can report them once the request has been served.
+type RequestInfo struct {
+	ID     string
+	Route  string
+	UserID int64
+}
+
+// GetRequestInfo returns the RequestInfo stored by RequestID, or nil.
+func GetRequestInfo(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*RequestInfo)
+	return info
+}
+
+// RequestID propagates the caller's X-Request-ID, or generates one, echoes
+// it on the response and attaches it to the request-scoped logger.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestInfoKey, &RequestInfo{ID: id})
+		ctx = logging.With(ctx, "request_id", id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts short printable IDs so a caller cannot inject
+// arbitrary content into our logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span for every matched route, named after its
+// template, continuing any trace passed in a W3C traceparent header.
+func Tracing(service string) mux.MiddlewareFunc {
+	return otelmux.Middleware(service)
+}
+
+// TraceLogger adds the active trace and span IDs to the request-scoped
+// logger so log lines can be correlated with traces. Register it after
+// Tracing.
+func TraceLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc := trace.SpanContextFromContext(r.Context())
+		if sc.IsValid() {
+			ctx := logging.With(r.Context(), "trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
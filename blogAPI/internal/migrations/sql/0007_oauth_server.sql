@@ -0,0 +1,53 @@
+-- Third-party applications allowed to act on behalf of users. Public
+-- clients (mobile and browser apps) have no secret and rely on PKCE.
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id                 BIGSERIAL PRIMARY KEY,
+    client_id          VARCHAR(64) NOT NULL UNIQUE,
+    client_secret_hash CHAR(64),
+    name               VARCHAR(100) NOT NULL,
+    owner_id           BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uris      TEXT[] NOT NULL,
+    scopes             TEXT[] NOT NULL,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Scopes a user has agreed to give a client.
+CREATE TABLE IF NOT EXISTS oauth_consents (
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id  VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    scopes     TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+    code_hash      CHAR(64) PRIMARY KEY,
+    client_id      VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    user_id        BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri   TEXT NOT NULL,
+    scopes         TEXT[] NOT NULL,
+    code_challenge VARCHAR(128) NOT NULL,
+    expires_at     TIMESTAMPTZ NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Every access and refresh token issued to a client. token_id is the JWT
+-- ID of access tokens and the SHA-256 hash of refresh tokens. Tokens
+-- descending from one authorization share grant_id, so revoking one
+-- refresh token revokes all of them.
+CREATE TABLE IF NOT EXISTS oauth_tokens (
+    id         BIGSERIAL PRIMARY KEY,
+    token_id   VARCHAR(64) NOT NULL UNIQUE,
+    kind       VARCHAR(16) NOT NULL,
+    grant_id   VARCHAR(64) NOT NULL,
+    client_id  VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scopes     TEXT[] NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS oauth_tokens_grant_id_idx ON oauth_tokens (grant_id);
+CREATE INDEX IF NOT EXISTS oauth_tokens_user_client_idx ON oauth_tokens (user_id, client_id);
@@ -0,0 +1,16 @@
+-- Passwordless sign-in links. The emailed token only confirms the
+-- sign-in; the session goes to the device holding the request secret,
+-- once it presents the short code shown after confirming. All three are
+-- stored hashed.
+CREATE TABLE IF NOT EXISTS magic_link_tokens (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash   CHAR(64) NOT NULL UNIQUE,
+    request_hash CHAR(64) NOT NULL UNIQUE,
+    code_hash    CHAR(64),
+    confirmed_at TIMESTAMPTZ,
+    attempts     INT NOT NULL DEFAULT 0,
+    used         BOOLEAN NOT NULL DEFAULT FALSE,
+    expired_at   TIMESTAMPTZ NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
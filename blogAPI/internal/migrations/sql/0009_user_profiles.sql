@@ -0,0 +1,22 @@
+-- Public profile fields, and usernames unique regardless of case so
+-- /api/users/{username} names one account. Accounts that already share a
+-- username must be renamed before this migration runs.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS display_name VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS bio          TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS website      VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS avatar_url   VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_key ON users (LOWER(username));
+
+-- A new email address waiting to be verified. Only a SHA-256 hash of the
+-- emailed token is stored.
+CREATE TABLE IF NOT EXISTS email_change_tokens (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    new_email  VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expired_at TIMESTAMPTZ NOT NULL,
+    used       BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,30 @@
+-- Account deletion is scheduled first and carried out once the grace
+-- period has passed, unless the user cancels.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS is_placeholder        BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS users_deletion_scheduled_at_idx ON users (deletion_scheduled_at)
+    WHERE deletion_scheduled_at IS NOT NULL;
+
+-- Posts of erased accounts can be kept under this placeholder. Its empty
+-- password matches no login.
+INSERT INTO users (username, email, password, display_name, is_placeholder)
+SELECT 'deleted-user', 'deleted-user@placeholder.invalid', '', 'Deleted user', TRUE
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE is_placeholder);
+
+-- Data export archives, built in the background. Only a SHA-256 hash of
+-- the download token is stored.
+CREATE TABLE IF NOT EXISTS data_exports (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status       VARCHAR(16) NOT NULL DEFAULT 'pending',
+    token_hash   CHAR(64) UNIQUE,
+    file_path    TEXT NOT NULL DEFAULT '',
+    error        TEXT NOT NULL DEFAULT '',
+    expires_at   TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_status_idx ON data_exports (status, id);
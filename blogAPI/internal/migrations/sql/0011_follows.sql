@@ -0,0 +1,16 @@
+-- Who follows whom. Both directions are listed, so each has an index
+-- that leads with its user.
+CREATE TABLE IF NOT EXISTS follows (
+    follower_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    followee_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX IF NOT EXISTS follows_follower_idx ON follows (follower_id, created_at DESC, followee_id DESC);
+CREATE INDEX IF NOT EXISTS follows_followee_idx ON follows (followee_id, created_at DESC, follower_id DESC);
+
+-- The timeline reads the newest posts of each followed author from this
+-- index, in the order it pages by.
+CREATE INDEX IF NOT EXISTS posts_author_created_idx ON posts (author_id, created_at DESC, id DESC);
@@ -0,0 +1,51 @@
+-- A user can give a post each kind of reaction once. The kinds are fixed
+-- by the API; see models.ReactionKinds.
+CREATE TABLE IF NOT EXISTS post_reactions (
+    post_id    BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind       VARCHAR(16) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (post_id, user_id, kind)
+);
+
+CREATE INDEX IF NOT EXISTS post_reactions_user_idx ON post_reactions (user_id);
+
+-- Reaction totals, kept by a trigger so they stay right however a
+-- reaction is added or removed, including when an account is erased.
+-- Each change is an atomic increment of one row, so concurrent reactions
+-- queue on that row instead of losing updates.
+CREATE TABLE IF NOT EXISTS post_reaction_counts (
+    post_id BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    kind    VARCHAR(16) NOT NULL,
+    count   BIGINT NOT NULL CHECK (count >= 0),
+    PRIMARY KEY (post_id, kind)
+);
+
+CREATE OR REPLACE FUNCTION count_post_reaction() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO post_reaction_counts (post_id, kind, count)
+        VALUES (NEW.post_id, NEW.kind, 1)
+        ON CONFLICT (post_id, kind) DO UPDATE SET count = post_reaction_counts.count + 1;
+    ELSE
+        UPDATE post_reaction_counts SET count = count - 1
+        WHERE post_id = OLD.post_id AND kind = OLD.kind;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS post_reactions_count ON post_reactions;
+CREATE TRIGGER post_reactions_count
+    AFTER INSERT OR DELETE ON post_reactions
+    FOR EACH ROW EXECUTE FUNCTION count_post_reaction();
+
+-- Bookmarks are private to the user who saved them.
+CREATE TABLE IF NOT EXISTS bookmarks (
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    post_id    BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, post_id)
+);
+
+CREATE INDEX IF NOT EXISTS bookmarks_user_created_idx ON bookmarks (user_id, created_at DESC, post_id DESC);
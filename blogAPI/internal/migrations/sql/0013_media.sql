@@ -0,0 +1,59 @@
+-- Uploaded images. The original is stored under storage_key and the
+-- generated variants are listed in variants as
+-- [{name, key, content_type, size, width, height}].
+CREATE TABLE IF NOT EXISTS media (
+    id            BIGSERIAL PRIMARY KEY,
+    owner_id      BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    storage_key   TEXT NOT NULL UNIQUE,
+    content_type  VARCHAR(32) NOT NULL,
+    size          BIGINT NOT NULL,
+    width         INT NOT NULL,
+    height        INT NOT NULL,
+    status        VARCHAR(16) NOT NULL DEFAULT 'processing',
+    variants      JSONB NOT NULL DEFAULT '[]',
+    error         TEXT NOT NULL DEFAULT '',
+    -- The variant worker leases an upload until locked_until, so another
+    -- instance retries it if the worker dies.
+    attempts      INT NOT NULL DEFAULT 0,
+    locked_until  TIMESTAMPTZ,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS media_owner_idx ON media (owner_id, id);
+CREATE INDEX IF NOT EXISTS media_processing_idx ON media (id) WHERE status = 'processing';
+
+-- Images attached to posts. At most one of a post's images is featured.
+CREATE TABLE IF NOT EXISTS post_media (
+    post_id  BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    media_id BIGINT NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    featured BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (post_id, media_id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS post_media_featured_idx ON post_media (post_id) WHERE featured;
+CREATE INDEX IF NOT EXISTS post_media_media_idx ON post_media (media_id);
+
+-- Files of deleted media, removed from the blob store by the variant
+-- worker. A trigger fills it, so files are cleaned up however the row
+-- goes, including when its owner's account is erased.
+CREATE TABLE IF NOT EXISTS blob_deletions (
+    id          BIGSERIAL PRIMARY KEY,
+    storage_key TEXT NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE FUNCTION queue_media_blob_deletion() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO blob_deletions (storage_key)
+    SELECT OLD.storage_key
+    UNION ALL
+    SELECT v->>'key' FROM jsonb_array_elements(OLD.variants) v;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS media_blob_deletion ON media;
+CREATE TRIGGER media_blob_deletion
+    AFTER DELETE ON media
+    FOR EACH ROW EXECUTE FUNCTION queue_media_blob_deletion();
@@ -0,0 +1,25 @@
+-- Every slug a post has had, so links to an old title redirect to the
+-- current one. A slug always stays with the post that first took it.
+CREATE TABLE IF NOT EXISTS post_slugs (
+    slug       TEXT PRIMARY KEY,
+    post_id    BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS post_slugs_post_idx ON post_slugs (post_id);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS slug TEXT;
+
+-- Existing posts get an ASCII-only slug ending in their ID, which cannot
+-- collide. New posts are given transliterated slugs by the API.
+UPDATE posts
+SET slug = COALESCE(NULLIF(rtrim(left(trim(BOTH '-' FROM lower(regexp_replace(title, '[^A-Za-z0-9]+', '-', 'g'))), 80), '-'), ''), 'post')
+    || '-' || id
+WHERE slug IS NULL;
+
+INSERT INTO post_slugs (slug, post_id)
+SELECT slug, id FROM posts
+ON CONFLICT (slug) DO NOTHING;
+
+ALTER TABLE posts ALTER COLUMN slug SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS posts_slug_idx ON posts (slug);
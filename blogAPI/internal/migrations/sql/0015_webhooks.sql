@@ -0,0 +1,42 @@
+-- Endpoints that are sent post and user events. The signing secret is
+-- encrypted like TOTP secrets. A subscription that keeps failing is
+-- deactivated and says why in disabled_reason.
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id                   BIGSERIAL PRIMARY KEY,
+    url                  TEXT NOT NULL,
+    events               TEXT[] NOT NULL,
+    secret               TEXT NOT NULL,
+    description          TEXT NOT NULL DEFAULT '',
+    active               BOOLEAN NOT NULL DEFAULT TRUE,
+    consecutive_failures INT NOT NULL DEFAULT 0,
+    disabled_reason      TEXT NOT NULL DEFAULT '',
+    created_by           BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at           TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at           TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- One row per event sent to a subscription, kept as its delivery log.
+-- Pending rows are the retry queue: the worker leases a due row until
+-- locked_until and, if the attempt fails, sets next_attempt_at to the
+-- next backoff step. payload holds the exact bytes that were signed.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    event_id        VARCHAR(64) NOT NULL,
+    event_type      VARCHAR(64) NOT NULL,
+    payload         TEXT NOT NULL,
+    status          VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts        INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until    TIMESTAMPTZ,
+    response_status INT NOT NULL DEFAULT 0,
+    response_body   TEXT NOT NULL DEFAULT '',
+    error           TEXT NOT NULL DEFAULT '',
+    duration_ms     INT NOT NULL DEFAULT 0,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_idx ON webhook_deliveries (subscription_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_completed_idx ON webhook_deliveries (completed_at) WHERE completed_at IS NOT NULL;
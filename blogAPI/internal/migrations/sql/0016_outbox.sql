@@ -0,0 +1,25 @@
+-- Domain events, written in the same transaction as the change they
+-- describe and handed to in-process subscribers by the outbox dispatcher.
+-- done lists the subscribers that have handled an event, so a retry only
+-- runs the ones that failed. The dispatcher leases an event until
+-- locked_until, so another instance retries it if one dies.
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id              BIGSERIAL PRIMARY KEY,
+    type            VARCHAR(64) NOT NULL,
+    payload         JSONB NOT NULL,
+    status          VARCHAR(16) NOT NULL DEFAULT 'pending',
+    done            TEXT[] NOT NULL DEFAULT '{}',
+    attempts        INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until    TIMESTAMPTZ,
+    error           TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    processed_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_events_due_idx ON outbox_events (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS outbox_events_processed_idx ON outbox_events (processed_at) WHERE processed_at IS NOT NULL;
+
+-- Webhook subscribers may queue an event again after a retry; its
+-- deliveries are found by event ID so they are not duplicated.
+CREATE INDEX IF NOT EXISTS webhook_deliveries_event_idx ON webhook_deliveries (event_id);
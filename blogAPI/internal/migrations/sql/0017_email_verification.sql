@@ -0,0 +1,19 @@
+-- When the account's email was shown to belong to its owner, by a link or
+-- code sent to it or by an OpenID provider that verified it. An OpenID
+-- identity is only linked to an existing account with the same email
+-- once this is set; otherwise whoever registered the email first would
+-- share the account.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+-- Accounts created by an OpenID sign-in have no password and took the
+-- email the provider verified
+UPDATE users u
+SET email_verified_at = NOW()
+WHERE u.email_verified_at IS NULL
+  AND u.password = ''
+  AND EXISTS (SELECT 1 FROM user_identities i WHERE i.user_id = u.id AND i.email = u.email);
+
+-- Set on sign-ins started by a signed-in user to link an identity to
+-- their account
+ALTER TABLE oidc_auth_requests
+    ADD COLUMN IF NOT EXISTS link_user_id BIGINT REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,4 @@
+-- The emailed token is now made by the outbox subscriber that sends the
+-- email, after the request has been answered, so the link exists before
+-- its token does
+ALTER TABLE magic_link_tokens ALTER COLUMN token_hash DROP NOT NULL;
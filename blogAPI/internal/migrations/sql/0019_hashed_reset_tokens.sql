@@ -0,0 +1,10 @@
+-- Reset tokens are stored hashed, like the other emailed tokens. The
+-- token is made by the outbox subscriber that sends the email, so a reset
+-- exists before its token does. Tokens already sent keep working.
+ALTER TABLE password_reset_tokens ADD COLUMN IF NOT EXISTS token_hash CHAR(64) UNIQUE;
+
+UPDATE password_reset_tokens
+SET token_hash = encode(sha256(convert_to(token, 'UTF8')), 'hex')
+WHERE token_hash IS NULL;
+
+ALTER TABLE password_reset_tokens DROP COLUMN IF EXISTS token;
@@ -0,0 +1,10 @@
+-- Unlock tokens are stored hashed, like the other emailed tokens. Tokens
+-- already sent keep working.
+ALTER TABLE account_unlock_tokens ADD COLUMN IF NOT EXISTS token_hash CHAR(64) UNIQUE;
+
+UPDATE account_unlock_tokens
+SET token_hash = encode(sha256(convert_to(token, 'UTF8')), 'hex')
+WHERE token_hash IS NULL;
+
+ALTER TABLE account_unlock_tokens ALTER COLUMN token_hash SET NOT NULL;
+ALTER TABLE account_unlock_tokens DROP COLUMN IF EXISTS token;
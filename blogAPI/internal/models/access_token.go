@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// AccessToken is a personal access token. The token itself is only shown
+// when it is created; TokenHash is what gets stored.
+type AccessToken struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+	Name string `json:"name"`
+	Prefix string `json:"prefix"`
+	TokenHash string `json:"-"`
+	Scopes []string `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Active reports whether the token can still be used at the given time.
+func (t *AccessToken) Active(at time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || at.Before(*t.ExpiresAt))
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	ExportPending = "pending"
+	ExportProcessing = "processing"
+	ExportReady = "ready"
+	ExportFailed = "failed"
+	ExportExpired = "expired"
+)
+
+type DataExport struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+	Status string `json:"status"`
+	TokenHash string `json:"-"`
+	FilePath string `json:"-"`
+	Error string `json:"-"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type EmailChangeToken struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+	NewEmail string `json:"new_email"`
+	TokenHash string `json:"-"`
+	ExpiredAt time.Time `json:"expired_at"`
+	Used bool `json:"used"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Follow is one side of a follow relationship: the user who follows, or
+// is followed, and since when.
+type Follow struct {
+    User      *User
+    CreatedAt time.Time
+}
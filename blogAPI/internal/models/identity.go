@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to their account at an OpenID provider.
+type UserIdentity struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+	Provider string `json:"provider"`
+	Subject string `json:"subject"`
+	Email string `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCAuthRequest is a sign-in in progress at an OpenID provider.
+type OIDCAuthRequest struct {
+	State string
+	Provider string
+	Nonce string
+	CodeVerifier string
+	// LinkUserID is set when a signed-in user started the sign-in to link
+	// the identity to their account.
+	LinkUserID *int64
+	ExpiresAt time.Time
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+type LoginEvent struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+	IP string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Success bool `json:"success"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AccountUnlockToken struct {
+	ID 	int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+	TokenHash string `json:"-"`
+	ExpiredAt time.Time `json:"expired_at"`
+	Used bool `json:"used"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+type MagicLinkToken struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+	TokenHash string `json:"-"`
+	RequestHash string `json:"-"`
+	CodeHash string `json:"-"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	Attempts int `json:"attempts"`
+	Used bool `json:"used"`
+	ExpiredAt time.Time `json:"expired_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
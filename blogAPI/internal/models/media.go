@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+const (
+    MediaProcessing = "processing"
+    MediaReady = "ready"
+    MediaFailed = "failed"
+)
+
+// Media is an uploaded image. The original is served as soon as it is
+// uploaded; its variants are added once the background worker has made
+// them.
+type Media struct {
+    ID int64
+    OwnerID int64
+    Key string
+    ContentType string
+    Size int64
+    Width int
+    Height int
+    Status string
+    Variants []MediaVariant
+    CreatedAt time.Time
+}
+
+// MediaVariant is a resized or re-encoded copy of an upload. It is stored
+// as JSON alongside the upload.
+type MediaVariant struct {
+    Name string `json:"name"`
+    Key string `json:"key"`
+    ContentType string `json:"content_type"`
+    Size int64 `json:"size"`
+    Width int `json:"width"`
+    Height int `json:"height"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// UserMFA is a user's TOTP authenticator. Secret is decrypted.
+type UserMFA struct {
+	UserID int64 `json:"user_id"`
+	Secret string `json:"-"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	LastUsedStep int64 `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Confirmed reports whether the authenticator is active for logins.
+func (m *UserMFA) Confirmed() bool {
+	return m != nil && m.ConfirmedAt != nil
+}
+
+type MFARolePolicy struct {
+	Role string `json:"role"`
+	Required bool `json:"required"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
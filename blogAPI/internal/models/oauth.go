@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// OAuthClient is a third-party application registered to use the API on
+// behalf of users.
+type OAuthClient struct {
+	ID int64 `json:"-"`
+	ClientID string `json:"client_id"`
+	SecretHash string `json:"-"`
+	Name string `json:"name"`
+	OwnerID int64 `json:"owner_id"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes []string `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Confidential reports whether the client authenticates with a secret.
+func (c *OAuthClient) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+type OAuthConsent struct {
+	UserID int64 `json:"user_id"`
+	ClientID string `json:"client_id"`
+	ClientName string `json:"client_name"`
+	Scopes []string `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type OAuthAuthorizationCode struct {
+	CodeHash string
+	ClientID string
+	UserID int64
+	RedirectURI string
+	Scopes []string
+	CodeChallenge string
+	ExpiresAt time.Time
+}
+
+const (
+	OAuthTokenAccess = "access"
+	OAuthTokenRefresh = "refresh"
+)
+
+// OAuthToken records an access or refresh token issued to a client.
+type OAuthToken struct {
+	ID int64
+	TokenID string
+	Kind string
+	GrantID string
+	ClientID string
+	UserID int64
+	Scopes []string
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// Active reports whether the token can still be used at t.
+func (t *OAuthToken) Active(at time.Time) bool {
+	return t.RevokedAt == nil && at.Before(t.ExpiresAt)
+}
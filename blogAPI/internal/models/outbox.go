@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+const (
+    OutboxPending   = "pending"
+    OutboxProcessed = "processed"
+    OutboxFailed    = "failed"
+)
+
+// OutboxEvent is a domain event as stored in the outbox. Payload is the
+// JSON of one of the types in package events, named by Type. Done lists
+// the subscribers that have already handled it.
+type OutboxEvent struct {
+    ID        int64
+    Type      string
+    Payload   []byte
+    Status    string
+    Done      []string
+    Attempts  int
+    CreatedAt time.Time
+}
@@ -2,12 +2,21 @@ package models
 
 import "time"
 
+// Post is a post as stored; views.Post is its API representation.
 type Post struct {
-    ID        int64     `json:"id"`
-    Title     string    `json:"title"`
-    Body      string    `json:"body"`
-    AuthorID  int64     `json:"author_id"`
-    Author    *User     `json:"author,omitempty"`
-    CreatedAt time.Time `json:"created_at"`
-    UpdatedAt time.Time `json:"updated_at"`
-}
\ No newline at end of file
+    ID        int64
+    Title     string
+    // Slug identifies the post in permalinks. It follows the title, and
+    // the post keeps answering to its old slugs.
+    Slug      string
+    Body      string
+    AuthorID  int64
+    Author    *User
+    Reactions []ReactionCount
+    // Media are the images attached to the post, in order. FeaturedImage
+    // is one of them, or nil.
+    Media         []*Media
+    FeaturedImage *Media
+    CreatedAt time.Time
+    UpdatedAt time.Time
+}
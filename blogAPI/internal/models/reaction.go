@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// The reactions a post can get, in the order they are shown.
+const (
+    ReactionLike      = "like"      // 👍
+    ReactionLove      = "love"      // ❤️
+    ReactionLaugh     = "laugh"     // 😂
+    ReactionWow       = "wow"       // 😮
+    ReactionSad       = "sad"       // 😢
+    ReactionCelebrate = "celebrate" // 🎉
+)
+
+var ReactionKinds = []string{ReactionLike, ReactionLove, ReactionLaugh, ReactionWow, ReactionSad, ReactionCelebrate}
+
+func ValidReaction(kind string) bool {
+    for _, k := range ReactionKinds {
+        if k == kind {
+            return true
+        }
+    }
+    return false
+}
+
+// ReactionCount is how many users gave a post one kind of reaction, and
+// whether the user viewing it is one of them.
+type ReactionCount struct {
+    Kind    string
+    Count   int64
+    Reacted bool
+}
+
+// Bookmark is a post a user saved for later.
+type Bookmark struct {
+    Post      *Post
+    CreatedAt time.Time
+}
@@ -1,10 +1,49 @@
 package models
 
+import (
+	"errors"
+	"time"
+)
+
+const (
+	RoleUser = "user"
+	RoleAdmin = "admin"
+)
+
+// User is the account as stored. It holds the password hash and login
+// protection state, so it is never written to a response directly; the
+// types in the views package decide what each audience sees.
 type User struct {
-	ID int64 `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Email string `json:"email"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
-}
\ No newline at end of file
+	ID int64
+	Username string
+	Password string
+	Email string
+	// EmailVerifiedAt is when Email was shown to belong to the user; nil
+	// until then.
+	EmailVerifiedAt *time.Time
+	Role string
+	DisplayName string
+	Bio string
+	Website string
+	AvatarURL string
+	CreatedAt string
+	UpdatedAt string
+	// DeletionScheduledAt is when the account will be erased, if its
+	// owner asked for that.
+	DeletionScheduledAt *time.Time
+	// IsPlaceholder marks the account that keeps the posts of erased
+	// accounts.
+	IsPlaceholder bool
+
+	// Login protection state
+	FailedLoginAttempts int
+	LockedUntil *time.Time
+}
+
+// ErrUserNotSerializable is returned when a User is marshalled directly
+// instead of through a view.
+var ErrUserNotSerializable = errors.New("models.User must not be serialized; use a type from the views package")
+
+func (User) MarshalJSON() ([]byte, error) {
+	return nil, ErrUserNotSerializable
+}
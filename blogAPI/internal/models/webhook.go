@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+const (
+    WebhookPending   = "pending"
+    WebhookDelivered = "delivered"
+    WebhookFailed    = "failed"
+)
+
+// WebhookSubscription is an endpoint that is sent the events it lists.
+// Secret signs every payload; it is only shown when the subscription is
+// created.
+type WebhookSubscription struct {
+    ID          int64
+    URL         string
+    Events      []string
+    Secret      string
+    Description string
+    Active      bool
+    // ConsecutiveFailures counts failed attempts since the last success.
+    // DisabledReason says why the subscription was deactivated
+    // automatically.
+    ConsecutiveFailures int
+    DisabledReason      string
+    CreatedBy           *int64
+    CreatedAt           time.Time
+    UpdatedAt           time.Time
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a subscription,
+// with the outcome of its latest attempt.
+type WebhookDelivery struct {
+    ID             int64
+    SubscriptionID int64
+    EventID        string
+    EventType      string
+    Payload        string
+    Status         string
+    Attempts       int
+    NextAttemptAt  time.Time
+    ResponseStatus int
+    ResponseBody   string
+    Error          string
+    Duration       time.Duration
+    CreatedAt      time.Time
+    CompletedAt    *time.Time
+}
@@ -0,0 +1,159 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/oidc/oidctest"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "http://localhost:8080/api/auth/oidc/test/callback"
+
+func setup(t *testing.T) (*oidctest.Provider, *Provider) {
+	mock := oidctest.NewProvider(oidctest.User{
+		Subject:       "user-1",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+		Name:          "Jane",
+	})
+	t.Cleanup(mock.Server.Close)
+
+	provider, err := Discover(context.Background(), Config{
+		Issuer:       mock.Issuer(),
+		ClientID:     mock.ClientID,
+		ClientSecret: mock.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"email", "profile"},
+	}, nil)
+	require.NoError(t, err)
+	return mock, provider
+}
+
+// authorize follows the authorization URL and returns the code and state
+// from the redirect back to the client.
+func authorize(t *testing.T, authURL string) (code, state string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	_, provider := setup(t)
+	ctx := context.Background()
+
+	code, state := authorize(t, provider.AuthCodeURL("the-state", "the-nonce", "the-verifier-0123456789012345678901234567"))
+	assert.Equal(t, "the-state", state)
+
+	idToken, err := provider.Exchange(ctx, code, "the-verifier-0123456789012345678901234567")
+	require.NoError(t, err)
+
+	claims, err := provider.VerifyIDToken(ctx, idToken, "the-nonce")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+	assert.Equal(t, "jane@example.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+}
+
+func TestExchangeRequiresMatchingVerifier(t *testing.T) {
+	_, provider := setup(t)
+
+	code, _ := authorize(t, provider.AuthCodeURL("s", "n", "the-verifier-0123456789012345678901234567"))
+	_, err := provider.Exchange(context.Background(), code, "another-verifier-012345678901234567890123")
+	assert.Error(t, err)
+}
+
+func TestVerifyIDTokenRejectsWrongNonce(t *testing.T) {
+	mock, provider := setup(t)
+	mock.ForceNonce("replayed-nonce")
+	ctx := context.Background()
+
+	code, _ := authorize(t, provider.AuthCodeURL("s", "n", "verifier"))
+	idToken, err := provider.Exchange(ctx, code, "verifier")
+	require.NoError(t, err)
+
+	_, err = provider.VerifyIDToken(ctx, idToken, "n")
+	assert.Error(t, err)
+}
+
+func TestVerifyIDTokenChecksClaims(t *testing.T) {
+	mock, provider := setup(t)
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   mock.Issuer(),
+			"aud":   mock.ClientID,
+			"sub":   "user-1",
+			"nonce": "n",
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		ok     bool
+	}{
+		{"valid", func(jwt.MapClaims) {}, true},
+		{"audience list", func(c jwt.MapClaims) { c["aud"] = []string{"other", mock.ClientID} }, true},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, false},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }, false},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Hour).Unix() }, false},
+		{"missing subject", func(c jwt.MapClaims) { delete(c, "sub") }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(claims)
+			raw, err := mock.SignIDToken(claims)
+			require.NoError(t, err)
+
+			_, err = provider.VerifyIDToken(context.Background(), raw, "n")
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsForeignSignature(t *testing.T) {
+	_, provider := setup(t)
+	other := oidctest.NewProvider(oidctest.User{Subject: "x"})
+	defer other.Server.Close()
+
+	raw, err := other.SignIDToken(jwt.MapClaims{
+		"iss":   other.Issuer(),
+		"aud":   other.ClientID,
+		"sub":   "x",
+		"nonce": "n",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	require.NoError(t, err)
+
+	_, err = provider.VerifyIDToken(context.Background(), raw, "n")
+	assert.Error(t, err)
+}
+
+func TestDiscoverRejectsIssuerMismatch(t *testing.T) {
+	mock := oidctest.NewProvider(oidctest.User{Subject: "x"})
+	defer mock.Server.Close()
+
+	_, err := Discover(context.Background(), Config{Issuer: mock.Issuer() + "/"}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,146 @@
+// Package outbox hands the domain events recorded in the outbox to the
+// in-process subscribers of their type. Each subscriber sees an event at
+// least once: a subscriber that fails is given the event again with
+// exponential backoff, while those that succeeded are not.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/events"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/retry"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+)
+
+// lease is how long a claimed event is hidden from other instances. One
+// that dies mid-way leaves the event to be retried after it.
+const lease = 5 * time.Minute
+
+// Message is an event as handed to a subscriber. ID and OccurredAt are
+// the same each time the event is handed over, so subscribers can use
+// them to avoid repeating work.
+type Message[E events.Event] struct {
+	ID         int64
+	OccurredAt time.Time
+	Event      E
+}
+
+type subscriber struct {
+	name   string
+	handle func(context.Context, *models.OutboxEvent) error
+}
+
+// Dispatcher reads the outbox and calls the subscribers of each event.
+type Dispatcher struct {
+	outbox      *repository.OutboxRepository
+	config      config.Config
+	subscribers map[string][]subscriber
+}
+
+func NewDispatcher(outbox *repository.OutboxRepository, config config.Config) *Dispatcher {
+	return &Dispatcher{outbox: outbox, config: config, subscribers: make(map[string][]subscriber)}
+}
+
+// Subscribe calls fn with every event of type E. name identifies the
+// subscriber in the outbox and must be unique among those of E. It must
+// be called before the dispatcher runs.
+func Subscribe[E events.Event](d *Dispatcher, name string, fn func(context.Context, Message[E]) error) {
+	var zero E
+	typ := zero.Type()
+	for _, s := range d.subscribers[typ] {
+		if s.name == name {
+			panic(fmt.Sprintf("outbox: %s already has a subscriber named %q", typ, name))
+		}
+	}
+	d.subscribers[typ] = append(d.subscribers[typ], subscriber{
+		name: name,
+		handle: func(ctx context.Context, stored *models.OutboxEvent) error {
+			m := Message[E]{ID: stored.ID, OccurredAt: stored.CreatedAt}
+			if err := json.Unmarshal(stored.Payload, &m.Event); err != nil {
+				return fmt.Errorf("decoding %s: %w", typ, err)
+			}
+			return fn(ctx, m)
+		},
+	})
+}
+
+// Run dispatches events every PollInterval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.Events.PollInterval)
+	defer ticker.Stop()
+	for {
+		d.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce dispatches every due event and prunes the events handled more
+// than Retention ago.
+func (d *Dispatcher) RunOnce(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+
+	for ctx.Err() == nil {
+		event, err := d.outbox.Claim(ctx, lease)
+		if err != nil {
+			logger.Warn("error claiming outbox event", "error", err)
+			break
+		}
+		if event == nil {
+			break
+		}
+		d.dispatch(ctx, event)
+	}
+
+	pruned, err := d.outbox.Prune(ctx, time.Now().Add(-d.config.Events.Retention))
+	if err != nil && ctx.Err() == nil {
+		logger.Warn("error pruning outbox", "error", err)
+	} else if pruned > 0 {
+		logger.Info("pruned outbox events", "count", pruned)
+	}
+}
+
+// dispatch hands a claimed event to the subscribers that have not handled
+// it yet and records the outcome.
+func (d *Dispatcher) dispatch(ctx context.Context, event *models.OutboxEvent) {
+	logger := logging.FromContext(ctx).With("event_id", event.ID, "event_type", event.Type)
+
+	var failures []string
+	for _, s := range d.subscribers[event.Type] {
+		if slices.Contains(event.Done, s.name) {
+			continue
+		}
+		if err := s.handle(ctx, event); err != nil {
+			logger.Warn("event subscriber failed", "subscriber", s.name, "attempt", event.Attempts, "error", err)
+			failures = append(failures, s.name+": "+err.Error())
+			continue
+		}
+		event.Done = append(event.Done, s.name)
+	}
+
+	var err error
+	switch {
+	case len(failures) == 0:
+		err = d.outbox.Processed(ctx, event)
+	case event.Attempts >= d.config.Events.MaxAttempts:
+		logger.Error("giving up on event subscribers", "attempts", event.Attempts, "error", strings.Join(failures, "; "))
+		err = d.outbox.Fail(ctx, event, strings.Join(failures, "; "))
+	default:
+		wait := retry.Jitter(retry.Backoff(event.Attempts, d.config.Events.BackoffBase, d.config.Events.BackoffMax))
+		err = d.outbox.Retry(ctx, event, time.Now().Add(wait), strings.Join(failures, "; "))
+	}
+	if err != nil {
+		logger.Warn("error recording outbox event", "error", err)
+	}
+}
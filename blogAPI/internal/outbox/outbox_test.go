@@ -0,0 +1,50 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/events"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribe(t *testing.T) {
+	d := NewDispatcher(nil, *config.Default())
+	var got Message[events.PostPublished]
+	Subscribe(d, "test", func(_ context.Context, m Message[events.PostPublished]) error {
+		got = m
+		return nil
+	})
+
+	subs := d.subscribers[events.PostPublished{}.Type()]
+	require.Len(t, subs, 1)
+	assert.Empty(t, d.subscribers[events.PostUpdated{}.Type()])
+
+	at := time.Unix(1700000000, 0)
+	err := subs[0].handle(context.Background(), &models.OutboxEvent{
+		ID:        7,
+		Type:      "PostPublished",
+		Payload:   []byte(`{"post_id":3,"author_id":5}`),
+		CreatedAt: at,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Message[events.PostPublished]{ID: 7, OccurredAt: at, Event: events.PostPublished{PostID: 3, AuthorID: 5}}, got)
+
+	err = subs[0].handle(context.Background(), &models.OutboxEvent{ID: 8, Payload: []byte(`{`)})
+	assert.Error(t, err)
+}
+
+func TestSubscribeRejectsDuplicateNames(t *testing.T) {
+	d := NewDispatcher(nil, *config.Default())
+	noop := func(context.Context, Message[events.UserRegistered]) error { return nil }
+	Subscribe(d, "welcome", noop)
+	assert.Panics(t, func() { Subscribe(d, "welcome", noop) })
+	// Names only need to be unique per event type
+	assert.NotPanics(t, func() {
+		Subscribe(d, "welcome", func(context.Context, Message[events.PostPublished]) error { return nil })
+	})
+}
@@ -0,0 +1,94 @@
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id hashes passwords with Argon2id and encodes them in the PHC
+// string format, e.g. $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>.
+type Argon2id struct {
+	// Memory is in KiB.
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultArgon2id uses the second recommended option of RFC 9106.
+var DefaultArgon2id = Argon2id{
+	Memory:  64 * 1024,
+	Time:    3,
+	Threads: 4,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+var b64 = base64.RawStdEncoding
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Time, a.Threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	computed := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+func (a Argon2id) Recognizes(encoded string) bool {
+	fields := phcFields(encoded)
+	return len(fields) > 0 && fields[0] == "argon2id"
+}
+
+func (a Argon2id) Current(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+	return params.Memory == a.Memory && params.Time == a.Time && params.Threads == a.Threads &&
+		uint32(len(salt)) == a.SaltLen && uint32(len(key)) == a.KeyLen
+}
+
+func decodeArgon2id(encoded string) (Argon2id, []byte, []byte, error) {
+	var params Argon2id
+	fields := phcFields(encoded)
+	if len(fields) != 5 || fields[0] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("passhash: malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(fields[1], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("passhash: unsupported argon2 version %q", fields[1])
+	}
+	if _, err := fmt.Sscanf(fields[2], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("passhash: malformed argon2id parameters: %w", err)
+	}
+	salt, err := b64.DecodeString(fields[3])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("passhash: malformed argon2id salt: %w", err)
+	}
+	key, err := b64.DecodeString(fields[4])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("passhash: malformed argon2id key")
+	}
+	if params.Time == 0 || params.Threads == 0 {
+		return params, nil, nil, fmt.Errorf("passhash: invalid argon2id parameters")
+	}
+	params.SaltLen, params.KeyLen = uint32(len(salt)), uint32(len(key))
+	return params, salt, key, nil
+}
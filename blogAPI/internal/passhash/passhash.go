@@ -0,0 +1,69 @@
+// Package passhash hashes passwords into self-describing strings that
+// name the algorithm and its parameters, so hashes made with older
+// settings keep verifying and can be upgraded when users sign in.
+package passhash
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrUnknownFormat is returned for hashes no configured Hasher produced.
+var ErrUnknownFormat = errors.New("passhash: unknown hash format")
+
+// Hasher is one password hashing algorithm with fixed parameters.
+type Hasher interface {
+	// Hash returns the encoded hash of password.
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded, which Recognizes
+	// has accepted.
+	Verify(password, encoded string) (bool, error)
+	// Recognizes reports whether encoded was made by this algorithm,
+	// whatever its parameters.
+	Recognizes(encoded string) bool
+	// Current reports whether encoded uses this hasher's parameters.
+	Current(encoded string) bool
+}
+
+// Set hashes new passwords with its preferred Hasher and verifies hashes
+// made by any of its Hashers.
+type Set struct {
+	preferred Hasher
+	legacy    []Hasher
+}
+
+// NewSet returns a Set hashing with preferred that still verifies hashes
+// made by legacy.
+func NewSet(preferred Hasher, legacy ...Hasher) *Set {
+	return &Set{preferred: preferred, legacy: legacy}
+}
+
+func (s *Set) Hash(password string) (string, error) {
+	return s.preferred.Hash(password)
+}
+
+// Verify checks password against encoded. needsRehash is true when the
+// password matched but encoded was not made by the preferred hasher with
+// its current parameters, so the caller should store a fresh Hash.
+func (s *Set) Verify(password, encoded string) (ok, needsRehash bool, err error) {
+	for _, h := range append([]Hasher{s.preferred}, s.legacy...) {
+		if !h.Recognizes(encoded) {
+			continue
+		}
+		ok, err := h.Verify(password, encoded)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, h != s.preferred || !h.Current(encoded), nil
+	}
+	return false, false, ErrUnknownFormat
+}
+
+// phcFields splits a PHC string, $id$v=19$m=1,t=2$salt$hash, into its
+// fields.
+func phcFields(encoded string) []string {
+	if !strings.HasPrefix(encoded, "$") {
+		return nil
+	}
+	return strings.Split(encoded[1:], "$")
+}
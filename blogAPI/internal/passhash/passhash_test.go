@@ -0,0 +1,76 @@
+package passhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Small parameters keep the tests fast
+var testArgon2id = Argon2id{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	set := NewSet(testArgon2id, Bcrypt{Cost: bcrypt.MinCost})
+
+	hash, err := set.Hash("correct horse")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+
+	ok, rehash, err := set.Verify("correct horse", hash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _, err = set.Verify("wrong horse", hash)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestLegacyBcryptNeedsRehash(t *testing.T) {
+	set := NewSet(testArgon2id, Bcrypt{Cost: bcrypt.MinCost})
+	legacy, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	ok, rehash, err := set.Verify("hunter2", string(legacy))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	ok, rehash, err = set.Verify("hunter3", string(legacy))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, rehash)
+}
+
+func TestOutdatedParametersNeedRehash(t *testing.T) {
+	old, err := testArgon2id.Hash("pw")
+	require.NoError(t, err)
+
+	stronger := testArgon2id
+	stronger.Time = 2
+	ok, rehash, err := NewSet(stronger).Verify("pw", old)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+}
+
+func TestVerifyRejectsUnknownAndMalformedHashes(t *testing.T) {
+	set := NewSet(testArgon2id, Bcrypt{Cost: bcrypt.MinCost})
+
+	_, _, err := set.Verify("pw", "plaintext")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+
+	for _, bad := range []string{
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$garbage$c2FsdA$a2V5",
+	} {
+		ok, _, err := set.Verify("pw", bad)
+		assert.False(t, ok, bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,167 @@
+package passpolicy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// A breached-password list file starts with a header made of listMagic, a
+// version byte, the width of each entry in bytes and two reserved bytes,
+// followed by the leading width bytes of the SHA-1 of every password,
+// sorted ascending with duplicates removed. Lookups binary search the file
+// in place, so a list of hundreds of millions of passwords needs no memory
+// and works offline. With the default width of 8 bytes, the chance that a
+// password not in the list is reported as breached is negligible.
+const (
+	listMagic    = "BPWD"
+	listVersion  = 1
+	headerSize   = 8
+	DefaultWidth = 8
+)
+
+var (
+	ErrInvalidList = errors.New("not a breached-password list")
+	// ErrUnsorted is returned by ListWriter.Add when hashes are not added
+	// in ascending order.
+	ErrUnsorted = errors.New("hashes must be added in ascending order")
+)
+
+// BreachedList is an open breached-password list file. It is safe for
+// concurrent use.
+type BreachedList struct {
+	file  *os.File
+	width int
+	count int64
+}
+
+// OpenBreachedList opens a list written by ListWriter.
+func OpenBreachedList(path string) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	info, err := file.Stat()
+	if err == nil {
+		_, err = io.ReadFull(file, header)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	width := int(header[5])
+	if string(header[:4]) != listMagic || header[4] != listVersion || width < 4 || width > sha1.Size ||
+		(info.Size()-headerSize)%int64(width) != 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, ErrInvalidList)
+	}
+
+	return &BreachedList{
+		file:  file,
+		width: width,
+		count: (info.Size() - headerSize) / int64(width),
+	}, nil
+}
+
+// Len returns the number of entries in the list.
+func (l *BreachedList) Len() int64 {
+	return l.count
+}
+
+// Contains reports whether password is in the list.
+func (l *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	key := sum[:l.width]
+
+	entry := make([]byte, l.width)
+	lo, hi := int64(0), l.count
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, err := l.file.ReadAt(entry, headerSize+mid*int64(l.width)); err != nil {
+			return false, err
+		}
+		switch c := bytes.Compare(entry, key); {
+		case c == 0:
+			return true, nil
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+func (l *BreachedList) Close() error {
+	return l.file.Close()
+}
+
+// ListWriter writes a breached-password list from SHA-1 hashes added in
+// ascending order, such as the ordered-by-hash download of Have I Been
+// Pwned, without holding them in memory.
+type ListWriter struct {
+	w     *bufio.Writer
+	width int
+	last  []byte
+	count int64
+}
+
+// NewListWriter writes the list header to w and keeps width bytes of
+// every hash added.
+func NewListWriter(w io.Writer, width int) (*ListWriter, error) {
+	if width < 4 || width > sha1.Size {
+		return nil, fmt.Errorf("width must be between 4 and %d bytes", sha1.Size)
+	}
+	bw := bufio.NewWriter(w)
+	header := []byte{listMagic[0], listMagic[1], listMagic[2], listMagic[3], listVersion, byte(width), 0, 0}
+	if _, err := bw.Write(header); err != nil {
+		return nil, err
+	}
+	return &ListWriter{w: bw, width: width}, nil
+}
+
+// Add appends a hash. Hashes that are the same as the previous one once
+// shortened to the list's width are skipped.
+func (lw *ListWriter) Add(sum [sha1.Size]byte) error {
+	entry := sum[:lw.width]
+	if lw.last != nil {
+		switch c := bytes.Compare(entry, lw.last); {
+		case c == 0:
+			return nil
+		case c < 0:
+			return ErrUnsorted
+		}
+	}
+	if _, err := lw.w.Write(entry); err != nil {
+		return err
+	}
+	lw.last = append(lw.last[:0], entry...)
+	lw.count++
+	return nil
+}
+
+// Count returns the number of entries written so far.
+func (lw *ListWriter) Count() int64 {
+	return lw.count
+}
+
+// Flush writes any buffered entries to the underlying writer.
+func (lw *ListWriter) Flush() error {
+	return lw.w.Flush()
+}
+
+// SortHashes sorts hashes ascending so they can be added to a ListWriter,
+// for lists built from plaintext passwords.
+func SortHashes(hashes [][sha1.Size]byte) {
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+}
@@ -0,0 +1,143 @@
+package passpolicy
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		password string
+		maxScore int
+		minScore int
+	}{
+		{"password", 0, 0},
+		{"P4ssw0rd", 0, 0},
+		{"qwertyuiop", 0, 0},
+		{"1234567890", 0, 0},
+		{"aaaaaaaaaaaa", 0, 0},
+		{"abcabcabcabc", 0, 0},
+		{"iloveyou123", 1, 0},
+		{"summer2023", 1, 0},
+		{"correcthorsebatterystaple", 4, 4},
+		{"purple-monkey-dishwasher-42", 4, 4},
+		{"kH7#pQ2m!vR9", 4, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			s := Estimate(tt.password)
+			assert.GreaterOrEqual(t, s.Score, tt.minScore, "guesses %g", s.Guesses)
+			assert.LessOrEqual(t, s.Score, tt.maxScore, "guesses %g", s.Guesses)
+		})
+	}
+}
+
+func TestEstimateUserInputs(t *testing.T) {
+	without := Estimate("wombatferry")
+	with := Estimate("wombatferry", "wombatferry@example.com")
+	assert.Less(t, with.Guesses, without.Guesses)
+}
+
+func TestCheck(t *testing.T) {
+	p := &Policy{MinLength: 10, MinScore: 3}
+
+	tests := []struct {
+		name     string
+		password string
+		problems int
+	}{
+		{"empty", "", 1},
+		{"short", "kH7#pQ2", 1},
+		{"common", "password123", 1},
+		{"username", "alice2024!", 2},
+		{"reversed username", "ecila2024!!", 2},
+		{"email", "alice@example.com", 2},
+		{"leet username", "4l1c3-1234567", 2},
+		{"strong", "purple-monkey-dishwasher-42", 0},
+		{"strong with username", "alice-wombat-ferry-zucchini", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.password, "alice", "alice@example.com")
+			if tt.problems == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var v *Violation
+			require.True(t, errors.As(err, &v), "got %v", err)
+			assert.Len(t, v.Problems, tt.problems, "%v", v.Problems)
+		})
+	}
+}
+
+func writeList(t *testing.T, width int, passwords ...string) string {
+	t.Helper()
+
+	hashes := make([][sha1.Size]byte, len(passwords))
+	for i, p := range passwords {
+		hashes[i] = sha1.Sum([]byte(p))
+	}
+	SortHashes(hashes)
+
+	var buf bytes.Buffer
+	lw, err := NewListWriter(&buf, width)
+	require.NoError(t, err)
+	for _, h := range hashes {
+		require.NoError(t, lw.Add(h))
+	}
+	require.NoError(t, lw.Flush())
+
+	path := filepath.Join(t.TempDir(), "breached.bin")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+	return path
+}
+
+func TestBreachedList(t *testing.T) {
+	breached := []string{"correct horse battery staple", "purple-monkey-dishwasher-42", "hunter2", "hunter2"}
+	list, err := OpenBreachedList(writeList(t, DefaultWidth, breached...))
+	require.NoError(t, err)
+	defer list.Close()
+
+	assert.EqualValues(t, 3, list.Len())
+	for _, p := range breached {
+		ok, err := list.Contains(p)
+		require.NoError(t, err)
+		assert.True(t, ok, p)
+	}
+	for _, p := range []string{"hunter3", "", "blue kettle orbit lantern"} {
+		ok, err := list.Contains(p)
+		require.NoError(t, err)
+		assert.False(t, ok, p)
+	}
+
+	p := &Policy{MinLength: 10, MinScore: 3, Breached: list}
+	var v *Violation
+	require.True(t, errors.As(p.Check("purple-monkey-dishwasher-42"), &v))
+	assert.Len(t, v.Problems, 1)
+	assert.NoError(t, p.Check("blue kettle orbit lantern"))
+}
+
+func TestListWriterRejectsUnsorted(t *testing.T) {
+	lw, err := NewListWriter(&bytes.Buffer{}, DefaultWidth)
+	require.NoError(t, err)
+
+	require.NoError(t, lw.Add([sha1.Size]byte{2}))
+	assert.ErrorIs(t, lw.Add([sha1.Size]byte{1}), ErrUnsorted)
+}
+
+func TestOpenBreachedListInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	require.NoError(t, os.WriteFile(path, []byte("password\nhunter2\n"), 0o600))
+
+	_, err := OpenBreachedList(path)
+	assert.ErrorIs(t, err, ErrInvalidList)
+}
@@ -0,0 +1,103 @@
+// Package passpolicy decides whether a new password is acceptable: long
+// enough, hard enough to guess, not built from the account's own username
+// or email and not in a list of passwords known from data breaches.
+package passpolicy
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy is the set of rules new passwords must follow.
+type Policy struct {
+	MinLength int
+	// MinScore is the lowest Strength.Score accepted, from 0 to 4.
+	MinScore int
+	// Breached, when set, refuses passwords found in the list.
+	Breached *BreachedList
+}
+
+// DefaultPolicy is the policy used until one is built from configuration.
+var DefaultPolicy = &Policy{MinLength: 10, MinScore: 3}
+
+// Violation lists every rule a password breaks. The problems are meant to
+// be shown to the user.
+type Violation struct {
+	Problems []string
+}
+
+func (v *Violation) Error() string {
+	return "password is not allowed: " + strings.Join(v.Problems, "; ")
+}
+
+// Check returns a *Violation when password breaks the policy for an account
+// described by userInputs, such as its username and email. Any other error
+// means the breached-password list could not be read.
+func (p *Policy) Check(password string, userInputs ...string) error {
+	var problems []string
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		problems = append(problems, "must be at least "+strconv.Itoa(p.MinLength)+" characters long")
+	}
+	if length > 0 && derivedFrom(password, userInputs) {
+		problems = append(problems, "must not be based on your username or email")
+	}
+	if length >= p.MinLength {
+		if strength := Estimate(password, userInputs...); strength.Score < p.MinScore {
+			problems = append(problems, "is too easy to guess; try a longer phrase of unrelated words")
+		}
+	}
+	if p.Breached != nil && length > 0 {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			problems = append(problems, "has appeared in a data breach and must not be used")
+		}
+	}
+
+	if len(problems) > 0 {
+		return &Violation{Problems: problems}
+	}
+	return nil
+}
+
+// derivedFrom reports whether password is one of the user inputs, or one
+// of them with a few characters added, ignoring case and look-alike
+// substitutions.
+func derivedFrom(password string, userInputs []string) bool {
+	lower := []rune(password)
+	for i, r := range lower {
+		lower[i] = unicode.ToLower(r)
+	}
+	plain, _ := unleet(lower)
+	for _, token := range inputTokens(userInputs) {
+		token, _ = unleet([]rune(token))
+		if len([]rune(token)) < 4 {
+			continue
+		}
+		if len([]rune(plain)) >= 4 && strings.Contains(token, plain) {
+			return true
+		}
+		for _, candidate := range []string{token, reverse(token)} {
+			i := strings.Index(plain, candidate)
+			if i < 0 {
+				continue
+			}
+			// Whatever surrounds the input must be hard to guess on
+			// its own, so alice2024! is derived but alice-plus-a-
+			// strong-passphrase is not.
+			start := utf8.RuneCountInString(plain[:i])
+			end := start + utf8.RuneCountInString(candidate)
+			rest := string(lower[:start]) + string(lower[end:])
+			if Estimate(rest).Score < 3 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,397 @@
+package passpolicy
+
+import (
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Strength is an estimate of how hard a password is to guess.
+type Strength struct {
+	// Guesses is roughly how many attempts an attacker who knows common
+	// passwords, words and patterns needs to find the password.
+	Guesses float64
+	// Score buckets Guesses from 0 (trivially guessable) to 4 (very
+	// unguessable), with the same thresholds as zxcvbn.
+	Score int
+}
+
+// scoreGuesses holds the number of guesses a password must need to reach
+// each score above 0.
+var scoreGuesses = [...]float64{1e3, 1e6, 1e8, 1e10}
+
+const (
+	// bruteforceCardinality is what each character not covered by a
+	// pattern adds to the guesses.
+	bruteforceCardinality = 10
+	minGuessesSingleChar  = 10
+	minGuessesMultiChar   = 50
+	// maxEstimateLength bounds the work done per password; characters
+	// past it are counted as brute force.
+	maxEstimateLength = 64
+	// yearSpace is how many years a year pattern is picked from.
+	yearSpace = 150
+)
+
+//go:embed common.txt
+var commonPasswords string
+
+//go:embed words.txt
+var englishWords string
+
+// dictionaries rank words by how early an attacker tries them.
+var dictionaries = []map[string]int{
+	rankedList(strings.Fields(commonPasswords)),
+	rankedList(strings.Fields(englishWords)),
+}
+
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+}
+
+var leetSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i',
+	'!': 'i', '|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't',
+	'2': 'z',
+}
+
+// Estimate measures password the way zxcvbn does: it finds the common
+// passwords, dictionary words, keyboard runs, sequences, repeats and years
+// it is made of and picks the split into those patterns and brute-forced
+// characters that is cheapest to guess. userInputs, such as the username
+// and email, are treated as the first words an attacker tries.
+func Estimate(password string, userInputs ...string) Strength {
+	e := estimator{
+		dictionaries: append([]map[string]int{rankedList(inputTokens(userInputs))}, dictionaries...),
+		memo:         make(map[string]float64),
+	}
+
+	runes := []rune(password)
+	extra := 1.0
+	if len(runes) > maxEstimateLength {
+		extra = math.Pow(bruteforceCardinality, float64(len(runes)-maxEstimateLength))
+		runes = runes[:maxEstimateLength]
+	}
+
+	guesses := e.guesses(runes) * extra
+	return Strength{Guesses: guesses, Score: score(guesses)}
+}
+
+func score(guesses float64) int {
+	s := 0
+	for _, threshold := range scoreGuesses {
+		if guesses > threshold {
+			s++
+		}
+	}
+	return s
+}
+
+type match struct {
+	i, j    int
+	guesses float64
+}
+
+type estimator struct {
+	dictionaries []map[string]int
+	memo         map[string]float64
+}
+
+// guesses returns the guesses needed for the cheapest split of runes
+// into patterns and brute-forced characters.
+func (e *estimator) guesses(runes []rune) float64 {
+	if g, ok := e.memo[string(runes)]; ok {
+		return g
+	}
+
+	n := len(runes)
+	lower := make([]rune, n)
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	var matches []match
+	matches = append(matches, e.dictionaryMatches(runes, lower)...)
+	matches = append(matches, sequenceMatches(lower)...)
+	matches = append(matches, keyboardMatches(lower)...)
+	matches = append(matches, yearMatches(lower)...)
+	matches = append(matches, e.repeatMatches(runes, lower)...)
+
+	byEnd := make([][]match, n+1)
+	for _, m := range matches {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	best := make([]float64, n+1)
+	best[0] = 1
+	for k := 1; k <= n; k++ {
+		best[k] = best[k-1] * bruteforceCardinality
+		for _, m := range byEnd[k] {
+			min := float64(minGuessesMultiChar)
+			if m.j-m.i == 1 {
+				min = minGuessesSingleChar
+			}
+			if g := best[m.i] * math.Max(m.guesses, min); g < best[k] {
+				best[k] = g
+			}
+		}
+	}
+
+	e.memo[string(runes)] = best[n]
+	return best[n]
+}
+
+func (e *estimator) dictionaryMatches(runes, lower []rune) []match {
+	var matches []match
+	for i := 0; i < len(lower); i++ {
+		for j := i + 3; j <= len(lower); j++ {
+			word := string(lower[i:j])
+			plain, subs := unleet(lower[i:j])
+			for _, dict := range e.dictionaries {
+				if rank, ok := dict[word]; ok {
+					matches = append(matches, match{i, j, float64(rank) * caseVariations(runes[i:j])})
+				}
+				if rank, ok := dict[reverse(word)]; ok {
+					matches = append(matches, match{i, j, float64(rank) * caseVariations(runes[i:j]) * 2})
+				}
+				if len(subs) > 0 {
+					if rank, ok := dict[plain]; ok {
+						matches = append(matches, match{i, j, float64(rank) * caseVariations(runes[i:j]) * leetVariations(lower[i:j], subs)})
+					}
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// sequenceMatches finds runs such as abcd, 4321 or efgh.
+func sequenceMatches(lower []rune) []match {
+	var matches []match
+	for i := 0; i+2 < len(lower); {
+		delta := lower[i+1] - lower[i]
+		if (delta != 1 && delta != -1) || !sameClass(lower[i], lower[i+1]) {
+			i++
+			continue
+		}
+		j := i + 2
+		for j < len(lower) && lower[j]-lower[j-1] == delta && sameClass(lower[j-1], lower[j]) {
+			j++
+		}
+		if j-i >= 3 {
+			base := 26.0
+			switch {
+			case strings.ContainsRune("az019", lower[i]):
+				base = 4
+			case unicode.IsDigit(lower[i]):
+				base = 10
+			}
+			if delta < 0 {
+				base *= 2
+			}
+			matches = append(matches, match{i, j, base * float64(j-i)})
+		}
+		i = j - 1
+	}
+	return matches
+}
+
+// keyboardMatches finds runs of neighbouring keys on a row of a US
+// keyboard, in either direction.
+func keyboardMatches(lower []rune) []match {
+	keys := 0
+	for _, row := range keyboardRows {
+		keys += len(row)
+	}
+
+	var matches []match
+	for i := 0; i < len(lower); {
+		j := i + 1
+		for j < len(lower) && adjacentKeys(lower[j-1], lower[j]) {
+			j++
+		}
+		if j-i >= 4 {
+			matches = append(matches, match{i, j, float64(keys*2) * float64(j-i)})
+		}
+		i = j
+	}
+	return matches
+}
+
+func adjacentKeys(a, b rune) bool {
+	for _, row := range keyboardRows {
+		x, y := strings.IndexRune(row, a), strings.IndexRune(row, b)
+		if x >= 0 && y >= 0 && (x-y == 1 || y-x == 1) {
+			return true
+		}
+	}
+	return false
+}
+
+func yearMatches(lower []rune) []match {
+	var matches []match
+	for i := 0; i+4 <= len(lower); i++ {
+		year := 0
+		for _, r := range lower[i : i+4] {
+			if r < '0' || r > '9' {
+				year = -1
+				break
+			}
+			year = year*10 + int(r-'0')
+		}
+		if year >= 1900 && year < 1900+yearSpace {
+			matches = append(matches, match{i, i + 4, yearSpace})
+		}
+	}
+	return matches
+}
+
+// repeatMatches finds a unit repeated back to back, such as aaaa or
+// abcabcabc, and prices it as guessing the unit plus how often it repeats.
+func (e *estimator) repeatMatches(runes, lower []rune) []match {
+	var matches []match
+	for i := 0; i < len(lower); i++ {
+		for size := 1; i+2*size <= len(lower); size++ {
+			unit := string(lower[i : i+size])
+			count := 1
+			for i+(count+1)*size <= len(lower) && string(lower[i+count*size:i+(count+1)*size]) == unit {
+				count++
+			}
+			if count < 2 {
+				continue
+			}
+			unitGuesses := e.guesses(runes[i : i+size])
+			matches = append(matches, match{i, i + count*size, unitGuesses * float64(count)})
+			break
+		}
+	}
+	return matches
+}
+
+// caseVariations is how many ways of capitalising word an attacker tries
+// before reaching the one used.
+func caseVariations(word []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range word {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	if lower == 0 || (upper == 1 && (unicode.IsUpper(word[0]) || unicode.IsUpper(word[len(word)-1]))) {
+		return 2
+	}
+	return variations(upper, lower)
+}
+
+// leetVariations is how many ways of substituting look-alike characters
+// an attacker tries before reaching the one used in word.
+func leetVariations(word []rune, subs map[rune]int) float64 {
+	v := 1.0
+	for letter, subbed := range subs {
+		unsubbed := 0
+		for _, r := range word {
+			if r == letter {
+				unsubbed++
+			}
+		}
+		if unsubbed == 0 {
+			v *= 2
+		} else {
+			v *= variations(subbed, unsubbed)
+		}
+	}
+	return v
+}
+
+// variations sums the ways of choosing up to min(a, b) of a+b positions.
+func variations(a, b int) float64 {
+	v := 0.0
+	for k := 1; k <= a && k <= b; k++ {
+		v += binomial(a+b, k)
+	}
+	return math.Max(v, 1)
+}
+
+func binomial(n, k int) float64 {
+	r := 1.0
+	for i := 1; i <= k; i++ {
+		r = r * float64(n-k+i) / float64(i)
+	}
+	return r
+}
+
+// unleet undoes look-alike substitutions such as p4ssw0rd, returning the
+// plain word and how many characters were substituted for each letter.
+func unleet(word []rune) (string, map[rune]int) {
+	var subs map[rune]int
+	plain := make([]rune, len(word))
+	for i, r := range word {
+		if letter, ok := leetSubstitutions[r]; ok {
+			if subs == nil {
+				subs = make(map[rune]int)
+			}
+			subs[letter]++
+			r = letter
+		}
+		plain[i] = r
+	}
+	return string(plain), subs
+}
+
+func sameClass(a, b rune) bool {
+	return unicode.IsDigit(a) == unicode.IsDigit(b) && unicode.IsLetter(a) == unicode.IsLetter(b)
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+func rankedList(words []string) map[string]int {
+	ranked := make(map[string]int, len(words))
+	for i, w := range words {
+		w = strings.ToLower(w)
+		if _, ok := ranked[w]; !ok {
+			ranked[w] = i + 1
+		}
+	}
+	return ranked
+}
+
+// inputTokens splits user inputs such as a username or email address into
+// the words an attacker would try: the whole input and its alphanumeric
+// parts.
+func inputTokens(inputs []string) []string {
+	var tokens []string
+	for _, input := range inputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if input == "" {
+			continue
+		}
+		tokens = append(tokens, input)
+		if local, _, ok := strings.Cut(input, "@"); ok {
+			tokens = append(tokens, local)
+		}
+		for _, part := range strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len([]rune(part)) >= 3 {
+				tokens = append(tokens, part)
+			}
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,134 @@
+// Package privacy builds account data exports and erases accounts whose
+// deletion is due.
+package privacy
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/views"
+)
+
+// Archive is everything the API stores about a user. Each part is written
+// as JSON for machines and as Markdown for people.
+type Archive struct {
+	Profile      views.Self
+	Posts        []views.Post
+	LoginHistory []*models.LoginEvent
+	CreatedAt    time.Time
+}
+
+// WriteZip writes the archive as a ZIP file to w.
+func (a *Archive) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"README.md", a.writeReadme},
+		{"profile.json", jsonFile(a.Profile)},
+		{"profile.md", a.writeProfile},
+		{"posts.json", jsonFile(a.Posts)},
+		{"posts.md", a.writePosts},
+		{"login_history.json", jsonFile(a.LoginHistory)},
+		{"login_history.md", a.writeLoginHistory},
+	}
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: a.CreatedAt})
+		if err != nil {
+			return err
+		}
+		if err := f.write(fw); err != nil {
+			return fmt.Errorf("writing %s: %w", f.name, err)
+		}
+	}
+	return zw.Close()
+}
+
+func jsonFile(v interface{}) func(io.Writer) error {
+	return func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+}
+
+func (a *Archive) writeReadme(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `# Your data
+
+Exported for %s on %s.
+
+- profile: your account and public profile
+- posts: the %d posts you wrote
+- login_history: the %d sign-ins to your account, successful or not
+
+Each part comes as JSON (.json) and as a readable Markdown file (.md).
+`, a.Profile.Username, a.CreatedAt.UTC().Format(time.RFC1123), len(a.Posts), len(a.LoginHistory))
+	return err
+}
+
+func (a *Archive) writeProfile(w io.Writer) error {
+	p := a.Profile
+	_, err := fmt.Fprintf(w, `# Profile
+
+| Field | Value |
+| --- | --- |
+| Username | %s |
+| Display name | %s |
+| Email | %s |
+| Role | %s |
+| Website | %s |
+| Avatar | %s |
+| Joined | %s |
+
+## Bio
+
+%s
+`, cell(p.Username), cell(p.DisplayName), cell(p.Email), cell(p.Role), cell(p.Website), cell(p.AvatarURL),
+		cell(p.CreatedAt), p.Bio)
+	return err
+}
+
+func (a *Archive) writePosts(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "# Posts"); err != nil {
+		return err
+	}
+	for _, p := range a.Posts {
+		_, err := fmt.Fprintf(w, "\n## %s\n\n_Published %s, last updated %s_\n\n%s\n",
+			p.Title, p.CreatedAt.UTC().Format(time.RFC3339), p.UpdatedAt.UTC().Format(time.RFC3339), p.Body)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Archive) writeLoginHistory(w io.Writer) error {
+	if _, err := fmt.Fprint(w, "# Login history\n\n| Time | IP address | Browser | Result |\n| --- | --- | --- | --- |\n"); err != nil {
+		return err
+	}
+	for _, e := range a.LoginHistory {
+		result := "failed"
+		if e.Success {
+			result = "succeeded"
+		}
+		_, err := fmt.Fprintf(w, "| %s | %s | %s | %s |\n",
+			e.CreatedAt.UTC().Format(time.RFC3339), cell(e.IP), cell(e.UserAgent), result)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cell keeps a value on one line of a Markdown table.
+func cell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,64 @@
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/views"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteZip(t *testing.T) {
+	user := &models.User{
+		ID:       7,
+		Username: "alice",
+		Password: "$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA",
+		Email:    "alice@example.com",
+		Bio:      "Writes | about Go.",
+	}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	archive := &Archive{
+		Profile: views.NewSelf(user),
+		Posts:   views.NewList([]*models.Post{{ID: 1, Title: "Hello", Body: "First post", Author: user}}, views.NewPost),
+		LoginHistory: []*models.LoginEvent{
+			{UserID: user.ID, IP: "192.0.2.1", UserAgent: "curl/8.0", Success: true, CreatedAt: now},
+		},
+		CreatedAt: now,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, archive.WriteZip(&buf))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		files[f.Name] = string(data)
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	assert.ElementsMatch(t, []string{
+		"README.md", "profile.json", "profile.md", "posts.json", "posts.md", "login_history.json", "login_history.md",
+	}, names)
+
+	assert.Contains(t, files["profile.json"], "alice@example.com")
+	assert.Contains(t, files["posts.md"], "## Hello")
+	assert.Contains(t, files["login_history.md"], "| 192.0.2.1 | curl/8.0 | succeeded |")
+	for name, content := range files {
+		assert.NotContains(t, content, user.Password, name)
+	}
+}
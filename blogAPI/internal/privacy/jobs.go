@@ -0,0 +1,190 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tokens"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/views"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/utils"
+)
+
+// erasureBatch bounds how many accounts one run erases.
+const erasureBatch = 100
+
+// Jobs does the background half of data exports and account deletion:
+// building queued archives, removing expired ones and erasing accounts
+// whose grace period is over.
+type Jobs struct {
+	users   *repository.UserRepository
+	posts   *repository.PostRepository
+	logins  *repository.LoginHistoryRepository
+	exports *repository.DataExportRepository
+	config  config.Config
+}
+
+func NewJobs(
+	users *repository.UserRepository,
+	posts *repository.PostRepository,
+	logins *repository.LoginHistoryRepository,
+	exports *repository.DataExportRepository,
+	config config.Config) *Jobs {
+
+	return &Jobs{users: users, posts: posts, logins: logins, exports: exports, config: config}
+}
+
+// Run does the work that is due every JobInterval until ctx is done.
+func (j *Jobs) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.config.Privacy.JobInterval)
+	defer ticker.Stop()
+	for {
+		j.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce builds every queued export, removes expired archives and erases
+// the accounts that are due.
+func (j *Jobs) RunOnce(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+
+	for ctx.Err() == nil {
+		export, err := j.exports.Claim(ctx)
+		if err != nil {
+			logger.Warn("error claiming data export", "error", err)
+			break
+		}
+		if export == nil {
+			break
+		}
+		if err := j.buildExport(ctx, export); err != nil {
+			logger.Error("error building data export", "export_id", export.ID, "user_id", export.UserID, "error", err)
+			if err := j.exports.Fail(ctx, export.ID, err.Error()); err != nil {
+				logger.Warn("error marking data export failed", "export_id", export.ID, "error", err)
+			}
+		}
+	}
+
+	paths, err := j.exports.Expire(ctx, time.Now())
+	if err != nil && ctx.Err() == nil {
+		logger.Warn("error expiring data exports", "error", err)
+	}
+	removeFiles(ctx, paths)
+
+	if err := j.eraseDueAccounts(ctx); err != nil && ctx.Err() == nil {
+		logger.Warn("error erasing deleted accounts", "error", err)
+	}
+}
+
+func (j *Jobs) buildExport(ctx context.Context, export *models.DataExport) error {
+	user, err := j.users.GetByID(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user %d not found", export.UserID)
+	}
+	posts, err := j.posts.ListByAuthor(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	logins, err := j.logins.ListByUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	archive := &Archive{
+		Profile:      views.NewSelf(user),
+		Posts:        views.NewList(posts, views.NewPost),
+		LoginHistory: logins,
+		CreatedAt:    now,
+	}
+
+	token, err := tokens.Generate()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(j.config.Privacy.ExportDir, fmt.Sprintf("export-%d-%d.zip", user.ID, export.ID))
+	if err := writeArchive(path, archive); err != nil {
+		return err
+	}
+
+	expiresAt := now.Add(j.config.Privacy.ExportTTL)
+	if err := j.exports.Complete(ctx, export.ID, tokens.Hash(token), path, expiresAt); err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err := utils.SendDataExportEmail(ctx, user.Email, token, expiresAt, j.config); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("sending download link: %w", err)
+	}
+
+	logging.FromContext(ctx).Info("data export ready", "export_id", export.ID, "user_id", user.ID)
+	return nil
+}
+
+// writeArchive writes the archive through a temporary file, readable only
+// by the server, so a partial archive is never served.
+func writeArchive(path string, archive *Archive) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := archive.WriteZip(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (j *Jobs) eraseDueAccounts(ctx context.Context) error {
+	ids, err := j.users.ListDueForDeletion(ctx, time.Now(), erasureBatch)
+	if err != nil {
+		return err
+	}
+
+	keepPosts := j.config.Privacy.DeletedPosts == "reassign"
+	for _, id := range ids {
+		paths, err := j.exports.ListFilesByUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		erased, err := j.users.Erase(ctx, id, keepPosts)
+		if err != nil {
+			return fmt.Errorf("erasing user %d: %w", id, err)
+		}
+		if erased {
+			removeFiles(ctx, paths)
+		}
+	}
+	return nil
+}
+
+func removeFiles(ctx context.Context, paths []string) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logging.FromContext(ctx).Warn("error removing data export", "path", path, "error", err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+)
+
+// PostgresStore keeps buckets in the rate_limit_buckets table so every
+// replica shares the same limits.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	ctx, span := tracing.StartQuery(ctx, "PostgresStore.Take", "UPDATE", "rate_limit_buckets")
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	// Create the bucket full if this is the first request for key, then
+	// lock the row so concurrent requests from other replicas queue up.
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO rate_limit_buckets (key, tokens, updated_at, expires_at)
+		VALUES ($1, $2, NOW(), NOW())
+		ON CONFLICT (key) DO NOTHING`,
+		key, limit.Burst,
+	); err != nil {
+		return Result{}, err
+	}
+
+	var tokens float64
+	var last, now time.Time
+	if err := tx.QueryRowContext(ctx, `
+		SELECT tokens, updated_at, NOW()
+		FROM rate_limit_buckets
+		WHERE key = $1
+		FOR UPDATE`,
+		key,
+	).Scan(&tokens, &last, &now); err != nil {
+		return Result{}, err
+	}
+
+	tokens, res := take(tokens, last, now, limit)
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE rate_limit_buckets
+		SET tokens = $2, updated_at = $3, expires_at = $4
+		WHERE key = $1`,
+		key, tokens, now, now.Add(res.Reset),
+	); err != nil {
+		return Result{}, err
+	}
+	return res, tx.Commit()
+}
+
+// Cleanup deletes buckets that have refilled completely every interval
+// until ctx is cancelled.
+func (s *PostgresStore) Cleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.db.ExecContext(ctx,
+				`DELETE FROM rate_limit_buckets WHERE expires_at < NOW()`,
+			); err != nil && ctx.Err() == nil {
+				logging.FromContext(ctx).Warn("error deleting expired rate limit buckets", "error", err)
+			}
+		}
+	}
+}
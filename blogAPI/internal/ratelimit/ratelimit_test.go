@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("5/1m")
+	assert.NoError(t, err)
+	assert.Equal(t, Limit{Burst: 5, Period: time.Minute}, limit)
+
+	for _, bad := range []string{"", "5", "0/1m", "x/1m", "5/soon", "5/-1s"} {
+		_, err := ParseLimit(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestMemoryStoreRefills(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Burst: 2, Period: 10 * time.Second}
+	ctx := context.Background()
+
+	res, _ := store.Take(ctx, "k", limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+
+	res, _ = store.Take(ctx, "k", limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	res, _ = store.Take(ctx, "k", limit)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 5*time.Second, res.RetryAfter)
+
+	// Other keys have their own bucket
+	res, _ = store.Take(ctx, "other", limit)
+	assert.True(t, res.Allowed)
+
+	now = now.Add(5 * time.Second)
+	res, _ = store.Take(ctx, "k", limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+}
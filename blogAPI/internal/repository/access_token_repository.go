@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+	"github.com/lib/pq"
+)
+
+type AccessTokenRepository struct {
+	db *sql.DB
+}
+
+func NewAccessTokenRepository(db *sql.DB) *AccessTokenRepository {
+	return &AccessTokenRepository{db: db}
+}
+
+const accessTokenColumns = `id, user_id, name, prefix, token_hash, scopes, expires_at, last_used_at, revoked_at, created_at`
+
+func (r *AccessTokenRepository) Create(ctx context.Context, token *models.AccessToken) error {
+	ctx, span := tracing.StartQuery(ctx, "AccessTokenRepository.Create", "INSERT", "access_tokens")
+	defer span.End()
+
+	query := `
+		INSERT INTO access_tokens (user_id, name, prefix, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		token.UserID,
+		token.Name,
+		token.Prefix,
+		token.TokenHash,
+		pq.Array(token.Scopes),
+		token.ExpiresAt,
+	).Scan(&token.ID, &token.CreatedAt)
+}
+
+// GetByHash returns the token with the given hash, or nil if there is none.
+// Revoked and expired tokens are returned too; callers check Active.
+func (r *AccessTokenRepository) GetByHash(ctx context.Context, hash string) (*models.AccessToken, error) {
+	ctx, span := tracing.StartQuery(ctx, "AccessTokenRepository.GetByHash", "SELECT", "access_tokens")
+	defer span.End()
+
+	query := `SELECT ` + accessTokenColumns + ` FROM access_tokens WHERE token_hash = $1`
+	token, err := scanAccessToken(r.db.QueryRowContext(ctx, query, hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return token, err
+}
+
+// ListByUser returns the user's tokens that have not been revoked, newest
+// first.
+func (r *AccessTokenRepository) ListByUser(ctx context.Context, userID int64) ([]*models.AccessToken, error) {
+	ctx, span := tracing.StartQuery(ctx, "AccessTokenRepository.ListByUser", "SELECT", "access_tokens")
+	defer span.End()
+
+	query := `
+		SELECT ` + accessTokenColumns + `
+		FROM access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*models.AccessToken{}
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// Revoke revokes one of the user's tokens. It returns sql.ErrNoRows if the
+// user has no such active token.
+func (r *AccessTokenRepository) Revoke(ctx context.Context, userID, id int64) error {
+	ctx, span := tracing.StartQuery(ctx, "AccessTokenRepository.Revoke", "UPDATE", "access_tokens")
+	defer span.End()
+
+	query := `
+		UPDATE access_tokens SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// TouchLastUsed records that the token was used. The timestamp is only
+// written once a minute so busy scripts don't turn every request into a
+// write.
+func (r *AccessTokenRepository) TouchLastUsed(ctx context.Context, id int64) error {
+	ctx, span := tracing.StartQuery(ctx, "AccessTokenRepository.TouchLastUsed", "UPDATE", "access_tokens")
+	defer span.End()
+
+	query := `
+		UPDATE access_tokens SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAccessToken(row rowScanner) (*models.AccessToken, error) {
+	token := &models.AccessToken{}
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.Prefix,
+		&token.TokenHash,
+		pq.Array(&token.Scopes),
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+)
+
+type AccountUnlockRepository struct {
+	db *sql.DB
+}
+
+func NewAccountUnlockRepository(db *sql.DB) *AccountUnlockRepository {
+    return &AccountUnlockRepository{db: db}
+}
+
+func (r *AccountUnlockRepository) Create(ctx context.Context, unlock *models.AccountUnlockToken) error {
+    ctx, span := tracing.StartQuery(ctx, "AccountUnlockRepository.Create", "INSERT", "account_unlock_tokens")
+    defer span.End()
+
+    query := `
+        INSERT INTO account_unlock_tokens (user_id, token_hash, expired_at, created_at)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id`
+
+    return r.db.QueryRowContext(
+        ctx,
+        query,
+        unlock.UserID,
+        unlock.TokenHash,
+        unlock.ExpiredAt,
+        time.Now(),
+    ).Scan(&unlock.ID)
+}
+
+// GetByToken finds an unlock token by the hash of the emailed token, or
+// returns nil.
+func (r *AccountUnlockRepository) GetByToken(ctx context.Context, tokenHash string) (*models.AccountUnlockToken, error) {
+	ctx, span := tracing.StartQuery(ctx, "AccountUnlockRepository.GetByToken", "SELECT", "account_unlock_tokens")
+	defer span.End()
+
+	unlock := &models.AccountUnlockToken{}
+	query := `
+	SELECT id, user_id, token_hash, expired_at, used, created_at
+	FROM account_unlock_tokens
+	WHERE token_hash = $1`
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&unlock.ID,
+		&unlock.UserID,
+		&unlock.TokenHash,
+		&unlock.ExpiredAt,
+		&unlock.Used,
+		&unlock.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return unlock, err
+}
+
+// MarkAsUsed consumes the token. It reports false if the token had
+// already been used, so two racing requests cannot both unlock.
+func (r *AccountUnlockRepository) MarkAsUsed(ctx context.Context, id int64) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "AccountUnlockRepository.MarkAsUsed", "UPDATE", "account_unlock_tokens")
+	defer span.End()
+
+	query := `UPDATE account_unlock_tokens SET used = true WHERE id = $1 AND NOT used`
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
\ No newline at end of file
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+)
+
+type BookmarkRepository struct {
+	db *sql.DB
+}
+
+func NewBookmarkRepository(db *sql.DB) *BookmarkRepository {
+	return &BookmarkRepository{db: db}
+}
+
+// Add bookmarks the post for the user. Bookmarking it again changes
+// nothing.
+func (r *BookmarkRepository) Add(ctx context.Context, userID, postID int64) error {
+	ctx, span := tracing.StartQuery(ctx, "BookmarkRepository.Add", "INSERT", "bookmarks")
+	defer span.End()
+
+	query := `
+		INSERT INTO bookmarks (user_id, post_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+	_, err := r.db.ExecContext(ctx, query, userID, postID)
+	return err
+}
+
+// Remove deletes the user's bookmark of the post, if there is one.
+func (r *BookmarkRepository) Remove(ctx context.Context, userID, postID int64) error {
+	ctx, span := tracing.StartQuery(ctx, "BookmarkRepository.Remove", "DELETE", "bookmarks")
+	defer span.End()
+
+	query := `DELETE FROM bookmarks WHERE user_id = $1 AND post_id = $2`
+	_, err := r.db.ExecContext(ctx, query, userID, postID)
+	return err
+}
+
+// List returns up to limit of the user's bookmarks, most recently saved
+// first, starting after the cursor.
+func (r *BookmarkRepository) List(ctx context.Context, userID int64, after *Cursor, limit int) ([]*models.Bookmark, error) {
+	ctx, span := tracing.StartQuery(ctx, "BookmarkRepository.List", "SELECT", "bookmarks")
+	defer span.End()
+
+	query := `
+		SELECT b.created_at, p.id, p.title, p.slug, p.body, p.author_id, p.created_at, p.updated_at,
+		       u.username, u.display_name, u.avatar_url
+		FROM bookmarks b
+		JOIN posts p ON p.id = b.post_id
+		JOIN users u ON u.id = p.author_id
+		WHERE b.user_id = $1 AND (b.created_at, b.post_id) < ($2, $3)
+		ORDER BY b.created_at DESC, b.post_id DESC
+		LIMIT $4`
+	at, id := after.after()
+	rows, err := r.db.QueryContext(ctx, query, userID, at, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bookmarks []*models.Bookmark
+	for rows.Next() {
+		post := &models.Post{Author: &models.User{}}
+		bookmark := &models.Bookmark{Post: post}
+		err := rows.Scan(
+			&bookmark.CreatedAt,
+			&post.ID,
+			&post.Title,
+			&post.Slug,
+			&post.Body,
+			&post.AuthorID,
+			&post.CreatedAt,
+			&post.UpdatedAt,
+			&post.Author.Username,
+			&post.Author.DisplayName,
+			&post.Author.AvatarURL,
+		)
+		if err != nil {
+			return nil, err
+		}
+		post.Author.ID = post.AuthorID
+		bookmarks = append(bookmarks, bookmark)
+	}
+	return bookmarks, rows.Err()
+}
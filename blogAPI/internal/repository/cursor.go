@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned by ParseCursor for a cursor it did not
+// issue.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in a list ordered newest first by time, with
+// ties broken by ID. The next page starts after it.
+type Cursor struct {
+	Time time.Time
+	ID   int64
+}
+
+// String encodes the cursor as an opaque token for clients.
+func (c Cursor) String() string {
+	raw := strconv.FormatInt(c.Time.UnixMicro(), 10) + "." + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes a token from Cursor.String.
+func ParseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	at, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	micros, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &Cursor{Time: time.UnixMicro(micros)}
+	if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// after returns the position to start from: just past c, or the very
+// beginning when c is nil.
+func (c *Cursor) after() (time.Time, int64) {
+	if c == nil {
+		return time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC), 0
+	}
+	return c.Time, c.ID
+}
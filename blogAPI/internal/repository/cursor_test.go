@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := Cursor{Time: time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.UTC), ID: 42}
+
+	parsed, err := ParseCursor(c.String())
+	require.NoError(t, err)
+	assert.True(t, c.Time.Equal(parsed.Time))
+	assert.Equal(t, c.ID, parsed.ID)
+
+	for _, s := range []string{"", "not base64!", "MTIz", "YS5i"} {
+		_, err := ParseCursor(s)
+		assert.ErrorIs(t, err, ErrInvalidCursor, s)
+	}
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+)
+
+type DataExportRepository struct {
+	db *sql.DB
+}
+
+func NewDataExportRepository(db *sql.DB) *DataExportRepository {
+	return &DataExportRepository{db: db}
+}
+
+// Create queues an export for the background job.
+func (r *DataExportRepository) Create(ctx context.Context, export *models.DataExport) error {
+	ctx, span := tracing.StartQuery(ctx, "DataExportRepository.Create", "INSERT", "data_exports")
+	defer span.End()
+
+	query := `
+		INSERT INTO data_exports (user_id, status, created_at)
+		VALUES ($1, $2, $3)
+		RETURNING id`
+
+	export.Status = models.ExportPending
+	export.CreatedAt = time.Now()
+	return r.db.QueryRowContext(ctx, query, export.UserID, export.Status, export.CreatedAt).Scan(&export.ID)
+}
+
+const dataExportColumns = `id, user_id, status, COALESCE(token_hash, ''), file_path, error, expires_at, completed_at, created_at`
+
+// GetInProgress returns the user's export that is still being built, or
+// nil.
+func (r *DataExportRepository) GetInProgress(ctx context.Context, userID int64) (*models.DataExport, error) {
+	ctx, span := tracing.StartQuery(ctx, "DataExportRepository.GetInProgress", "SELECT", "data_exports")
+	defer span.End()
+
+	query := `SELECT ` + dataExportColumns + ` FROM data_exports
+		WHERE user_id = $1 AND status IN ('pending', 'processing')
+		ORDER BY id DESC LIMIT 1`
+	return scanDataExport(r.db.QueryRowContext(ctx, query, userID))
+}
+
+// GetByToken finds an export by the hash of its download token, or
+// returns nil.
+func (r *DataExportRepository) GetByToken(ctx context.Context, tokenHash string) (*models.DataExport, error) {
+	ctx, span := tracing.StartQuery(ctx, "DataExportRepository.GetByToken", "SELECT", "data_exports")
+	defer span.End()
+
+	query := `SELECT ` + dataExportColumns + ` FROM data_exports WHERE token_hash = $1`
+	return scanDataExport(r.db.QueryRowContext(ctx, query, tokenHash))
+}
+
+// Claim marks the oldest pending export as processing and returns it, or
+// nil when there is none. Concurrent callers never claim the same export.
+func (r *DataExportRepository) Claim(ctx context.Context) (*models.DataExport, error) {
+	ctx, span := tracing.StartQuery(ctx, "DataExportRepository.Claim", "UPDATE", "data_exports")
+	defer span.End()
+
+	query := `
+		UPDATE data_exports SET status = 'processing'
+		WHERE id = (
+			SELECT id FROM data_exports
+			WHERE status = 'pending'
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + dataExportColumns
+	return scanDataExport(r.db.QueryRowContext(ctx, query))
+}
+
+// Complete records that the archive for an export was written to path and
+// can be downloaded with the token until expiresAt.
+func (r *DataExportRepository) Complete(ctx context.Context, id int64, tokenHash, path string, expiresAt time.Time) error {
+	ctx, span := tracing.StartQuery(ctx, "DataExportRepository.Complete", "UPDATE", "data_exports")
+	defer span.End()
+
+	query := `
+		UPDATE data_exports
+		SET status = 'ready', token_hash = $1, file_path = $2, expires_at = $3, completed_at = $4
+		WHERE id = $5`
+	result, err := r.db.ExecContext(ctx, query, tokenHash, path, expiresAt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (r *DataExportRepository) Fail(ctx context.Context, id int64, reason string) error {
+	ctx, span := tracing.StartQuery(ctx, "DataExportRepository.Fail", "UPDATE", "data_exports")
+	defer span.End()
+
+	query := `UPDATE data_exports SET status = 'failed', error = $1, completed_at = $2 WHERE id = $3`
+	_, err := r.db.ExecContext(ctx, query, reason, time.Now(), id)
+	return err
+}
+
+// Expire marks ready exports whose download window has closed at now as
+// expired and returns their archive paths so the files can be removed.
+func (r *DataExportRepository) Expire(ctx context.Context, now time.Time) ([]string, error) {
+	ctx, span := tracing.StartQuery(ctx, "DataExportRepository.Expire", "UPDATE", "data_exports")
+	defer span.End()
+
+	query := `
+		UPDATE data_exports SET status = 'expired'
+		WHERE status = 'ready' AND expires_at < $1
+		RETURNING file_path`
+	rows, err := r.db.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// ListFilesByUser returns the archive paths of the user's exports, so
+// they can be removed with the account.
+func (r *DataExportRepository) ListFilesByUser(ctx context.Context, userID int64) ([]string, error) {
+	ctx, span := tracing.StartQuery(ctx, "DataExportRepository.ListFilesByUser", "SELECT", "data_exports")
+	defer span.End()
+
+	query := `SELECT file_path FROM data_exports WHERE user_id = $1 AND file_path <> ''`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+func scanDataExport(row *sql.Row) (*models.DataExport, error) {
+	export := &models.DataExport{}
+	err := row.Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.TokenHash,
+		&export.FilePath,
+		&export.Error,
+		&export.ExpiresAt,
+		&export.CompletedAt,
+		&export.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return export, err
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+)
+
+type EmailChangeRepository struct {
+	db *sql.DB
+}
+
+func NewEmailChangeRepository(db *sql.DB) *EmailChangeRepository {
+	return &EmailChangeRepository{db: db}
+}
+
+// Create stores a pending change. Changes the user asked for earlier and
+// has not confirmed are cancelled, so only the latest link works.
+func (r *EmailChangeRepository) Create(ctx context.Context, change *models.EmailChangeToken) error {
+	ctx, span := tracing.StartQuery(ctx, "EmailChangeRepository.Create", "INSERT", "email_change_tokens")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE email_change_tokens SET used = true WHERE user_id = $1 AND NOT used`,
+		change.UserID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO email_change_tokens (user_id, new_email, token_hash, expired_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+	err = tx.QueryRowContext(
+		ctx,
+		query,
+		change.UserID,
+		change.NewEmail,
+		change.TokenHash,
+		change.ExpiredAt,
+		time.Now(),
+	).Scan(&change.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetByToken finds a change by the hash of its emailed token, or returns
+// nil.
+func (r *EmailChangeRepository) GetByToken(ctx context.Context, tokenHash string) (*models.EmailChangeToken, error) {
+	ctx, span := tracing.StartQuery(ctx, "EmailChangeRepository.GetByToken", "SELECT", "email_change_tokens")
+	defer span.End()
+
+	change := &models.EmailChangeToken{}
+	query := `
+		SELECT id, user_id, new_email, token_hash, expired_at, used, created_at
+		FROM email_change_tokens
+		WHERE token_hash = $1`
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&change.ID,
+		&change.UserID,
+		&change.NewEmail,
+		&change.TokenHash,
+		&change.ExpiredAt,
+		&change.Used,
+		&change.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return change, err
+}
+
+// Apply marks the change as used and gives the user the new email in one
+// transaction. The email counts as verified, as the change was confirmed
+// from it. It returns false when the change was already used, and
+// ErrEmailTaken when another account took the email in the meantime.
+func (r *EmailChangeRepository) Apply(ctx context.Context, change *models.EmailChangeToken) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "EmailChangeRepository.Apply", "UPDATE", "email_change_tokens")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE email_change_tokens SET used = true WHERE id = $1 AND NOT used`, change.ID)
+	if err != nil {
+		return false, err
+	}
+	if err := requireRow(result); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	result, err = tx.ExecContext(ctx,
+		`UPDATE users SET email = $1, email_verified_at = $2, updated_at = $2 WHERE id = $3`,
+		change.NewEmail, time.Now(), change.UserID)
+	if err != nil {
+		return false, userConflict(err)
+	}
+	if err := requireRow(result); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
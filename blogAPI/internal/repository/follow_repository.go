@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+)
+
+type FollowRepository struct {
+	db *sql.DB
+}
+
+func NewFollowRepository(db *sql.DB) *FollowRepository {
+	return &FollowRepository{db: db}
+}
+
+// Follow makes followerID follow followeeID. Following someone again
+// changes nothing.
+func (r *FollowRepository) Follow(ctx context.Context, followerID, followeeID int64) error {
+	ctx, span := tracing.StartQuery(ctx, "FollowRepository.Follow", "INSERT", "follows")
+	defer span.End()
+
+	query := `
+		INSERT INTO follows (follower_id, followee_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+	_, err := r.db.ExecContext(ctx, query, followerID, followeeID)
+	return err
+}
+
+// Unfollow stops followerID following followeeID, if it did.
+func (r *FollowRepository) Unfollow(ctx context.Context, followerID, followeeID int64) error {
+	ctx, span := tracing.StartQuery(ctx, "FollowRepository.Unfollow", "DELETE", "follows")
+	defer span.End()
+
+	query := `DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2`
+	_, err := r.db.ExecContext(ctx, query, followerID, followeeID)
+	return err
+}
+
+// Counts returns how many users follow userID and how many it follows.
+func (r *FollowRepository) Counts(ctx context.Context, userID int64) (followers, following int64, err error) {
+	ctx, span := tracing.StartQuery(ctx, "FollowRepository.Counts", "SELECT", "follows")
+	defer span.End()
+
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM follows WHERE followee_id = $1),
+			(SELECT COUNT(*) FROM follows WHERE follower_id = $1)`
+	err = r.db.QueryRowContext(ctx, query, userID).Scan(&followers, &following)
+	return followers, following, err
+}
+
+// ListFollowers returns up to limit users following userID, most recent
+// first, starting after the cursor.
+func (r *FollowRepository) ListFollowers(ctx context.Context, userID int64, after *Cursor, limit int) ([]*models.Follow, error) {
+	ctx, span := tracing.StartQuery(ctx, "FollowRepository.ListFollowers", "SELECT", "follows")
+	defer span.End()
+
+	query := `
+		SELECT f.created_at, u.id, u.username, u.display_name, u.bio, u.website, u.avatar_url, u.created_at
+		FROM follows f
+		JOIN users u ON u.id = f.follower_id
+		WHERE f.followee_id = $1 AND (f.created_at, f.follower_id) < ($2, $3)
+		ORDER BY f.created_at DESC, f.follower_id DESC
+		LIMIT $4`
+	at, id := after.after()
+	return r.listFollows(ctx, query, userID, at, id, limit)
+}
+
+// ListFollowing returns up to limit users that userID follows, most
+// recently followed first, starting after the cursor.
+func (r *FollowRepository) ListFollowing(ctx context.Context, userID int64, after *Cursor, limit int) ([]*models.Follow, error) {
+	ctx, span := tracing.StartQuery(ctx, "FollowRepository.ListFollowing", "SELECT", "follows")
+	defer span.End()
+
+	query := `
+		SELECT f.created_at, u.id, u.username, u.display_name, u.bio, u.website, u.avatar_url, u.created_at
+		FROM follows f
+		JOIN users u ON u.id = f.followee_id
+		WHERE f.follower_id = $1 AND (f.created_at, f.followee_id) < ($2, $3)
+		ORDER BY f.created_at DESC, f.followee_id DESC
+		LIMIT $4`
+	at, id := after.after()
+	return r.listFollows(ctx, query, userID, at, id, limit)
+}
+
+func (r *FollowRepository) listFollows(ctx context.Context, query string, args ...interface{}) ([]*models.Follow, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var follows []*models.Follow
+	for rows.Next() {
+		user := &models.User{}
+		follow := &models.Follow{User: user}
+		err := rows.Scan(
+			&follow.CreatedAt,
+			&user.ID,
+			&user.Username,
+			&user.DisplayName,
+			&user.Bio,
+			&user.Website,
+			&user.AvatarURL,
+			&user.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		follows = append(follows, follow)
+	}
+	return follows, rows.Err()
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+)
+
+// IdentityRepository stores linked OpenID identities and the sign-ins in
+// progress at providers.
+type IdentityRepository struct {
+	db *sql.DB
+}
+
+func NewIdentityRepository(db *sql.DB) *IdentityRepository {
+	return &IdentityRepository{db: db}
+}
+
+// Get returns the identity for a provider's subject, or nil if it has not
+// been linked.
+func (r *IdentityRepository) Get(ctx context.Context, provider, subject string) (*models.UserIdentity, error) {
+	ctx, span := tracing.StartQuery(ctx, "IdentityRepository.Get", "SELECT", "user_identities")
+	defer span.End()
+
+	identity := &models.UserIdentity{}
+	query := `
+		SELECT id, user_id, provider, subject, email, created_at
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2`
+	err := r.db.QueryRowContext(ctx, query, provider, subject).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return identity, err
+}
+
+func (r *IdentityRepository) Create(ctx context.Context, identity *models.UserIdentity) error {
+	ctx, span := tracing.StartQuery(ctx, "IdentityRepository.Create", "INSERT", "user_identities")
+	defer span.End()
+
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+	).Scan(&identity.ID, &identity.CreatedAt)
+}
+
+func (r *IdentityRepository) CreateAuthRequest(ctx context.Context, req *models.OIDCAuthRequest) error {
+	ctx, span := tracing.StartQuery(ctx, "IdentityRepository.CreateAuthRequest", "INSERT", "oidc_auth_requests")
+	defer span.End()
+
+	query := `
+		INSERT INTO oidc_auth_requests (state, provider, nonce, code_verifier, link_user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := r.db.ExecContext(ctx, query, req.State, req.Provider, req.Nonce, req.CodeVerifier, req.LinkUserID, req.ExpiresAt)
+	return err
+}
+
+// TakeAuthRequest deletes and returns the sign-in started with state, so
+// each one can complete only once. It returns nil if there is none.
+// Expired requests are removed along the way.
+func (r *IdentityRepository) TakeAuthRequest(ctx context.Context, state string) (*models.OIDCAuthRequest, error) {
+	ctx, span := tracing.StartQuery(ctx, "IdentityRepository.TakeAuthRequest", "DELETE", "oidc_auth_requests")
+	defer span.End()
+
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM oidc_auth_requests WHERE expires_at < NOW()`); err != nil {
+		return nil, err
+	}
+
+	req := &models.OIDCAuthRequest{}
+	query := `
+		DELETE FROM oidc_auth_requests
+		WHERE state = $1
+		RETURNING state, provider, nonce, code_verifier, link_user_id, expires_at`
+	err := r.db.QueryRowContext(ctx, query, state).Scan(
+		&req.State,
+		&req.Provider,
+		&req.Nonce,
+		&req.CodeVerifier,
+		&req.LinkUserID,
+		&req.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return req, err
+}
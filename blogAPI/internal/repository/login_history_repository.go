@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+)
+
+type LoginHistoryRepository struct {
+	db *sql.DB
+}
+
+func NewLoginHistoryRepository(db *sql.DB) *LoginHistoryRepository {
+	return &LoginHistoryRepository{db: db}
+}
+
+func (r *LoginHistoryRepository) Create(ctx context.Context, event *models.LoginEvent) error {
+	ctx, span := tracing.StartQuery(ctx, "LoginHistoryRepository.Create", "INSERT", "login_history")
+	defer span.End()
+
+	query := `
+		INSERT INTO login_history (user_id, ip, user_agent, success, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+
+	event.CreatedAt = time.Now()
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		event.UserID,
+		event.IP,
+		event.UserAgent,
+		event.Success,
+		event.CreatedAt,
+	).Scan(&event.ID)
+}
+
+// KnownDevice describes how a login compares to the user's earlier
+// successful logins.
+type KnownDevice struct {
+	PreviousLogins int
+	KnownIP        bool
+	KnownUserAgent bool
+}
+
+func (r *LoginHistoryRepository) KnownDevice(ctx context.Context, userID int64, ip, userAgent string) (*KnownDevice, error) {
+	ctx, span := tracing.StartQuery(ctx, "LoginHistoryRepository.KnownDevice", "SELECT", "login_history")
+	defer span.End()
+
+	query := `
+		SELECT COUNT(*),
+		       COUNT(*) FILTER (WHERE ip = $2),
+		       COUNT(*) FILTER (WHERE user_agent = $3)
+		FROM login_history
+		WHERE user_id = $1 AND success`
+
+	var total, byIP, byAgent int
+	if err := r.db.QueryRowContext(ctx, query, userID, ip, userAgent).Scan(&total, &byIP, &byAgent); err != nil {
+		return nil, err
+	}
+	return &KnownDevice{
+		PreviousLogins: total,
+		KnownIP:        byIP > 0,
+		KnownUserAgent: byAgent > 0,
+	}, nil
+}
+
+// ListByUser returns the user's whole login history, newest first.
+func (r *LoginHistoryRepository) ListByUser(ctx context.Context, userID int64) ([]*models.LoginEvent, error) {
+	ctx, span := tracing.StartQuery(ctx, "LoginHistoryRepository.ListByUser", "SELECT", "login_history")
+	defer span.End()
+
+	query := `
+		SELECT id, user_id, ip, user_agent, success, created_at
+		FROM login_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.LoginEvent
+	for rows.Next() {
+		event := &models.LoginEvent{}
+		if err := rows.Scan(&event.ID, &event.UserID, &event.IP, &event.UserAgent, &event.Success, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
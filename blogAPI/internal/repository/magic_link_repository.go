@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/events"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+)
+
+type MagicLinkRepository struct {
+	db *sql.DB
+}
+
+func NewMagicLinkRepository(db *sql.DB) *MagicLinkRepository {
+	return &MagicLinkRepository{db: db}
+}
+
+// Create stores a sign-in without its emailed token and records
+// MagicLinkRequested, whose subscriber makes the token and emails it.
+func (r *MagicLinkRepository) Create(ctx context.Context, link *models.MagicLinkToken) error {
+	ctx, span := tracing.StartQuery(ctx, "MagicLinkRepository.Create", "INSERT", "magic_link_tokens")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO magic_link_tokens (user_id, request_hash, expired_at, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`
+
+	err = tx.QueryRowContext(
+		ctx,
+		query,
+		link.UserID,
+		link.RequestHash,
+		link.ExpiredAt,
+		time.Now(),
+	).Scan(&link.ID)
+	if err != nil {
+		return err
+	}
+	if err := recordEvent(ctx, tx, events.MagicLinkRequested{UserID: link.UserID, LinkID: link.ID}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetToken stores the hash of the token emailed for a sign-in that has
+// not been confirmed or used yet, replacing any earlier one.
+func (r *MagicLinkRepository) SetToken(ctx context.Context, id int64, tokenHash string) error {
+	ctx, span := tracing.StartQuery(ctx, "MagicLinkRepository.SetToken", "UPDATE", "magic_link_tokens")
+	defer span.End()
+
+	query := `
+		UPDATE magic_link_tokens
+		SET token_hash = $2
+		WHERE id = $1 AND confirmed_at IS NULL AND NOT used`
+	result, err := r.db.ExecContext(ctx, query, id, tokenHash)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+const magicLinkColumns = `id, user_id, COALESCE(token_hash, ''), request_hash, COALESCE(code_hash, ''), confirmed_at, attempts, used, expired_at, created_at`
+
+// GetByID returns the sign-in with the given ID, or nil if there is none.
+func (r *MagicLinkRepository) GetByID(ctx context.Context, id int64) (*models.MagicLinkToken, error) {
+	ctx, span := tracing.StartQuery(ctx, "MagicLinkRepository.GetByID", "SELECT", "magic_link_tokens")
+	defer span.End()
+
+	query := `SELECT ` + magicLinkColumns + ` FROM magic_link_tokens WHERE id = $1`
+	return scanMagicLink(r.db.QueryRowContext(ctx, query, id))
+}
+
+// GetByToken finds a link by the hash of its emailed token, or returns nil.
+func (r *MagicLinkRepository) GetByToken(ctx context.Context, tokenHash string) (*models.MagicLinkToken, error) {
+	ctx, span := tracing.StartQuery(ctx, "MagicLinkRepository.GetByToken", "SELECT", "magic_link_tokens")
+	defer span.End()
+
+	query := `SELECT ` + magicLinkColumns + ` FROM magic_link_tokens WHERE token_hash = $1`
+	return scanMagicLink(r.db.QueryRowContext(ctx, query, tokenHash))
+}
+
+// GetByRequest finds a link by the hash of the requesting device's secret,
+// or returns nil.
+func (r *MagicLinkRepository) GetByRequest(ctx context.Context, requestHash string) (*models.MagicLinkToken, error) {
+	ctx, span := tracing.StartQuery(ctx, "MagicLinkRepository.GetByRequest", "SELECT", "magic_link_tokens")
+	defer span.End()
+
+	query := `SELECT ` + magicLinkColumns + ` FROM magic_link_tokens WHERE request_hash = $1`
+	return scanMagicLink(r.db.QueryRowContext(ctx, query, requestHash))
+}
+
+func scanMagicLink(row *sql.Row) (*models.MagicLinkToken, error) {
+	link := &models.MagicLinkToken{}
+	err := row.Scan(
+		&link.ID,
+		&link.UserID,
+		&link.TokenHash,
+		&link.RequestHash,
+		&link.CodeHash,
+		&link.ConfirmedAt,
+		&link.Attempts,
+		&link.Used,
+		&link.ExpiredAt,
+		&link.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return link, err
+}
+
+// Confirm records that the emailed link was confirmed and stores the hash
+// of the code to show. Confirming again replaces the code but keeps the
+// attempts already used.
+func (r *MagicLinkRepository) Confirm(ctx context.Context, id int64, codeHash string) error {
+	ctx, span := tracing.StartQuery(ctx, "MagicLinkRepository.Confirm", "UPDATE", "magic_link_tokens")
+	defer span.End()
+
+	query := `
+		UPDATE magic_link_tokens
+		SET confirmed_at = NOW(), code_hash = $2
+		WHERE id = $1 AND NOT used`
+	result, err := r.db.ExecContext(ctx, query, id, codeHash)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// TakeAttempt uses up one of the max attempts at entering the code of a
+// confirmed link and returns the hash to compare against. It reports false
+// once the attempts are spent, so parallel guesses cannot exceed max.
+func (r *MagicLinkRepository) TakeAttempt(ctx context.Context, id int64, max int) (string, bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "MagicLinkRepository.TakeAttempt", "UPDATE", "magic_link_tokens")
+	defer span.End()
+
+	var codeHash string
+	query := `
+		UPDATE magic_link_tokens
+		SET attempts = attempts + 1
+		WHERE id = $1 AND attempts < $2 AND NOT used AND code_hash IS NOT NULL
+		RETURNING code_hash`
+	err := r.db.QueryRowContext(ctx, query, id, max).Scan(&codeHash)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return codeHash, err == nil, err
+}
+
+// MarkAsUsed consumes the link if no more than max attempts were made at
+// its code. It reports false if the link had already been used, so two
+// racing requests cannot both sign in.
+func (r *MagicLinkRepository) MarkAsUsed(ctx context.Context, id int64, max int) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "MagicLinkRepository.MarkAsUsed", "UPDATE", "magic_link_tokens")
+	defer span.End()
+
+	query := `UPDATE magic_link_tokens SET used = true WHERE id = $1 AND NOT used AND attempts <= $2`
+	result, err := r.db.ExecContext(ctx, query, id, max)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
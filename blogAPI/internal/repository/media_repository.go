@@ -0,0 +1,280 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+	"github.com/lib/pq"
+)
+
+type MediaRepository struct {
+	db *sql.DB
+}
+
+func NewMediaRepository(db *sql.DB) *MediaRepository {
+	return &MediaRepository{db: db}
+}
+
+// Create records an upload whose original is already in the blob store.
+// Its variants are made later by the worker.
+func (r *MediaRepository) Create(ctx context.Context, media *models.Media) error {
+	ctx, span := tracing.StartQuery(ctx, "MediaRepository.Create", "INSERT", "media")
+	defer span.End()
+
+	query := `
+		INSERT INTO media (owner_id, storage_key, content_type, size, width, height, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`
+
+	media.Status = models.MediaProcessing
+	media.CreatedAt = time.Now()
+	return r.db.QueryRowContext(ctx, query,
+		media.OwnerID,
+		media.Key,
+		media.ContentType,
+		media.Size,
+		media.Width,
+		media.Height,
+		media.Status,
+		media.CreatedAt,
+	).Scan(&media.ID)
+}
+
+const mediaColumns = `m.id, m.owner_id, m.storage_key, m.content_type, m.size, m.width, m.height, m.status, m.variants, m.created_at`
+
+func (r *MediaRepository) GetByID(ctx context.Context, id int64) (*models.Media, error) {
+	ctx, span := tracing.StartQuery(ctx, "MediaRepository.GetByID", "SELECT", "media")
+	defer span.End()
+
+	query := `SELECT ` + mediaColumns + ` FROM media m WHERE m.id = $1`
+	media, err := scanMedia(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return media, err
+}
+
+// GetOwned returns the uploads with the given IDs, in the same order,
+// skipping any that do not exist or belong to someone else.
+func (r *MediaRepository) GetOwned(ctx context.Context, ownerID int64, ids []int64) ([]*models.Media, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	ctx, span := tracing.StartQuery(ctx, "MediaRepository.GetOwned", "SELECT", "media")
+	defer span.End()
+
+	query := `
+		SELECT ` + mediaColumns + `
+		FROM unnest($2::BIGINT[]) WITH ORDINALITY AS wanted(id, n)
+		JOIN media m ON m.id = wanted.id
+		WHERE m.owner_id = $1
+		ORDER BY wanted.n`
+	rows, err := r.db.QueryContext(ctx, query, ownerID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var media []*models.Media
+	for rows.Next() {
+		m, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, m)
+	}
+	return media, rows.Err()
+}
+
+// Delete removes an upload of ownerID. Its files are queued for removal
+// from the blob store by a trigger.
+func (r *MediaRepository) Delete(ctx context.Context, id, ownerID int64) error {
+	ctx, span := tracing.StartQuery(ctx, "MediaRepository.Delete", "DELETE", "media")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM media WHERE id = $1 AND owner_id = $2`, id, ownerID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// Claim leases the oldest upload still waiting for variants until
+// now+lease and returns it with the number of times it has been claimed,
+// or nil when there is none. Concurrent callers never claim the same
+// upload.
+func (r *MediaRepository) Claim(ctx context.Context, lease time.Duration) (*models.Media, int, error) {
+	ctx, span := tracing.StartQuery(ctx, "MediaRepository.Claim", "UPDATE", "media")
+	defer span.End()
+
+	query := `
+		UPDATE media m SET attempts = attempts + 1, locked_until = $1
+		WHERE m.id = (
+			SELECT id FROM media
+			WHERE status = 'processing' AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + mediaColumns + `, m.attempts`
+
+	var attempts int
+	media, err := scanMedia(r.db.QueryRowContext(ctx, query, time.Now().Add(lease)), &attempts)
+	if err == sql.ErrNoRows {
+		return nil, 0, nil
+	}
+	return media, attempts, err
+}
+
+// Ready records the variants made for an upload. It returns
+// sql.ErrNoRows if the upload was deleted in the meantime.
+func (r *MediaRepository) Ready(ctx context.Context, id int64, variants []models.MediaVariant) error {
+	ctx, span := tracing.StartQuery(ctx, "MediaRepository.Ready", "UPDATE", "media")
+	defer span.End()
+
+	data, err := json.Marshal(variants)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE media SET status = 'ready', variants = $1, locked_until = NULL WHERE id = $2`
+	result, err := r.db.ExecContext(ctx, query, data, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// Release returns a claimed upload to the queue so it is tried again.
+func (r *MediaRepository) Release(ctx context.Context, id int64) error {
+	ctx, span := tracing.StartQuery(ctx, "MediaRepository.Release", "UPDATE", "media")
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx, `UPDATE media SET locked_until = NULL WHERE id = $1`, id)
+	return err
+}
+
+// Fail gives up on making variants for an upload. The original is still
+// served.
+func (r *MediaRepository) Fail(ctx context.Context, id int64, reason string) error {
+	ctx, span := tracing.StartQuery(ctx, "MediaRepository.Fail", "UPDATE", "media")
+	defer span.End()
+
+	query := `UPDATE media SET status = 'failed', error = $1, locked_until = NULL WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, reason, id)
+	return err
+}
+
+// BlobDeletion is a file of a deleted upload still to be removed from the
+// blob store.
+type BlobDeletion struct {
+	ID  int64
+	Key string
+}
+
+// ListBlobDeletions returns up to limit files waiting to be removed.
+func (r *MediaRepository) ListBlobDeletions(ctx context.Context, limit int) ([]BlobDeletion, error) {
+	ctx, span := tracing.StartQuery(ctx, "MediaRepository.ListBlobDeletions", "SELECT", "blob_deletions")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, `SELECT id, storage_key FROM blob_deletions ORDER BY id LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deletions []BlobDeletion
+	for rows.Next() {
+		var d BlobDeletion
+		if err := rows.Scan(&d.ID, &d.Key); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
+
+// ForgetBlobDeletions drops files that have been removed from the queue.
+func (r *MediaRepository) ForgetBlobDeletions(ctx context.Context, ids []int64) error {
+	ctx, span := tracing.StartQuery(ctx, "MediaRepository.ForgetBlobDeletions", "DELETE", "blob_deletions")
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM blob_deletions WHERE id = ANY($1)`, pq.Array(ids))
+	return err
+}
+
+// LoadForPosts fills in the attached images and featured image of posts
+// in one query.
+func (r *MediaRepository) LoadForPosts(ctx context.Context, posts ...*models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ctx, span := tracing.StartQuery(ctx, "MediaRepository.LoadForPosts", "SELECT", "post_media")
+	defer span.End()
+
+	byID := make(map[int64]*models.Post, len(posts))
+	ids := make([]int64, 0, len(posts))
+	for _, post := range posts {
+		post.Media, post.FeaturedImage = nil, nil
+		byID[post.ID] = post
+		ids = append(ids, post.ID)
+	}
+
+	query := `
+		SELECT ` + mediaColumns + `, pm.post_id, pm.featured
+		FROM post_media pm
+		JOIN media m ON m.id = pm.media_id
+		WHERE pm.post_id = ANY($1)
+		ORDER BY pm.post_id, pm.position`
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int64
+		var featured bool
+		media, err := scanMedia(rows, &postID, &featured)
+		if err != nil {
+			return err
+		}
+		post := byID[postID]
+		if post == nil {
+			continue
+		}
+		post.Media = append(post.Media, media)
+		if featured {
+			post.FeaturedImage = media
+		}
+	}
+	return rows.Err()
+}
+
+// scanMedia scans a row selected with mediaColumns followed by extra.
+func scanMedia(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*models.Media, error) {
+	media := &models.Media{}
+	var variants []byte
+	dest := append([]interface{}{
+		&media.ID,
+		&media.OwnerID,
+		&media.Key,
+		&media.ContentType,
+		&media.Size,
+		&media.Width,
+		&media.Height,
+		&media.Status,
+		&variants,
+		&media.CreatedAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(variants, &media.Variants); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
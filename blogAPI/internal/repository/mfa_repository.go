@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/secretbox"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+)
+
+// MFARepository stores TOTP authenticators, recovery codes and the roles
+// that must use two-factor login. TOTP secrets are encrypted with box.
+type MFARepository struct {
+	db  *sql.DB
+	box *secretbox.Box
+}
+
+func NewMFARepository(db *sql.DB, box *secretbox.Box) *MFARepository {
+	return &MFARepository{db: db, box: box}
+}
+
+// Get returns the user's authenticator, or nil if they have none.
+func (r *MFARepository) Get(ctx context.Context, userID int64) (*models.UserMFA, error) {
+	ctx, span := tracing.StartQuery(ctx, "MFARepository.Get", "SELECT", "user_mfa")
+	defer span.End()
+
+	mfa := &models.UserMFA{}
+	var sealed string
+	query := `
+		SELECT user_id, secret, confirmed_at, last_used_step, created_at
+		FROM user_mfa
+		WHERE user_id = $1`
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(
+		&mfa.UserID,
+		&sealed,
+		&mfa.ConfirmedAt,
+		&mfa.LastUsedStep,
+		&mfa.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if mfa.Secret, err = r.box.Open(sealed); err != nil {
+		return nil, fmt.Errorf("error decrypting TOTP secret: %w", err)
+	}
+	return mfa, nil
+}
+
+// SavePending stores a new, unconfirmed secret for the user, replacing any
+// earlier unconfirmed one. A confirmed authenticator is left untouched and
+// sql.ErrNoRows returned.
+func (r *MFARepository) SavePending(ctx context.Context, userID int64, secret string) error {
+	ctx, span := tracing.StartQuery(ctx, "MFARepository.SavePending", "INSERT", "user_mfa")
+	defer span.End()
+
+	sealed, err := r.box.Seal(secret)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO user_mfa (user_id, secret, created_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_mfa.confirmed_at IS NULL`
+	result, err := r.db.ExecContext(ctx, query, userID, sealed)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// Confirm activates the user's authenticator.
+func (r *MFARepository) Confirm(ctx context.Context, userID int64) error {
+	ctx, span := tracing.StartQuery(ctx, "MFARepository.Confirm", "UPDATE", "user_mfa")
+	defer span.End()
+
+	query := `UPDATE user_mfa SET confirmed_at = NOW() WHERE user_id = $1`
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// UseStep records that the code for step was accepted. It returns false
+// when that step or a later one was already used, so each code works once.
+func (r *MFARepository) UseStep(ctx context.Context, userID, step int64) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "MFARepository.UseStep", "UPDATE", "user_mfa")
+	defer span.End()
+
+	query := `UPDATE user_mfa SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`
+	result, err := r.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// Delete removes the user's authenticator and recovery codes.
+func (r *MFARepository) Delete(ctx context.Context, userID int64) error {
+	ctx, span := tracing.StartQuery(ctx, "MFARepository.Delete", "DELETE", "user_mfa")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes discards the user's recovery codes and stores the
+// given hashes instead.
+func (r *MFARepository) ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string) error {
+	ctx, span := tracing.StartQuery(ctx, "MFARepository.ReplaceRecoveryCodes", "INSERT", "mfa_recovery_codes")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, hash,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports
+// whether one matched.
+func (r *MFARepository) UseRecoveryCode(ctx context.Context, userID int64, hash string) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "MFARepository.UseRecoveryCode", "UPDATE", "mfa_recovery_codes")
+	defer span.End()
+
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	result, err := r.db.ExecContext(ctx, query, userID, hash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// RoleRequiresMFA reports whether users with role must use two-factor login.
+func (r *MFARepository) RoleRequiresMFA(ctx context.Context, role string) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "MFARepository.RoleRequiresMFA", "SELECT", "mfa_role_policies")
+	defer span.End()
+
+	var required bool
+	err := r.db.QueryRowContext(ctx, `SELECT required FROM mfa_role_policies WHERE role = $1`, role).Scan(&required)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return required, err
+}
+
+func (r *MFARepository) SetRolePolicy(ctx context.Context, policy *models.MFARolePolicy) error {
+	ctx, span := tracing.StartQuery(ctx, "MFARepository.SetRolePolicy", "INSERT", "mfa_role_policies")
+	defer span.End()
+
+	query := `
+		INSERT INTO mfa_role_policies (role, required, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (role) DO UPDATE SET required = EXCLUDED.required, updated_at = NOW()
+		RETURNING updated_at`
+	return r.db.QueryRowContext(ctx, query, policy.Role, policy.Required).Scan(&policy.UpdatedAt)
+}
+
+func (r *MFARepository) ListRolePolicies(ctx context.Context) ([]*models.MFARolePolicy, error) {
+	ctx, span := tracing.StartQuery(ctx, "MFARepository.ListRolePolicies", "SELECT", "mfa_role_policies")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, `SELECT role, required, updated_at FROM mfa_role_policies ORDER BY role`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []*models.MFARolePolicy{}
+	for rows.Next() {
+		policy := &models.MFARolePolicy{}
+		if err := rows.Scan(&policy.Role, &policy.Required, &policy.UpdatedAt); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+// requireRow returns sql.ErrNoRows when result affected no rows.
+func requireRow(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+	"github.com/lib/pq"
+)
+
+// OAuthRepository stores the state of the OAuth2 authorization server:
+// registered clients, user consents, authorization codes and issued
+// tokens.
+type OAuthRepository struct {
+	db *sql.DB
+}
+
+func NewOAuthRepository(db *sql.DB) *OAuthRepository {
+	return &OAuthRepository{db: db}
+}
+
+const oauthClientColumns = `id, client_id, COALESCE(client_secret_hash, ''), name, owner_id, redirect_uris, scopes, created_at`
+
+func (r *OAuthRepository) CreateClient(ctx context.Context, client *models.OAuthClient) error {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.CreateClient", "INSERT", "oauth_clients")
+	defer span.End()
+
+	query := `
+		INSERT INTO oauth_clients (client_id, client_secret_hash, name, owner_id, redirect_uris, scopes)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6)
+		RETURNING id, created_at`
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		client.ClientID,
+		client.SecretHash,
+		client.Name,
+		client.OwnerID,
+		pq.Array(client.RedirectURIs),
+		pq.Array(client.Scopes),
+	).Scan(&client.ID, &client.CreatedAt)
+}
+
+// GetClient returns the client with the given client ID, or nil.
+func (r *OAuthRepository) GetClient(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.GetClient", "SELECT", "oauth_clients")
+	defer span.End()
+
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE client_id = $1`
+	client, err := scanOAuthClient(r.db.QueryRowContext(ctx, query, clientID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return client, err
+}
+
+func (r *OAuthRepository) ListClientsByOwner(ctx context.Context, ownerID int64) ([]*models.OAuthClient, error) {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.ListClientsByOwner", "SELECT", "oauth_clients")
+	defer span.End()
+
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE owner_id = $1 ORDER BY created_at DESC`
+	rows, err := r.db.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*models.OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// DeleteClient removes one of the owner's clients along with its consents,
+// codes and tokens. It returns sql.ErrNoRows if the owner has no such
+// client.
+func (r *OAuthRepository) DeleteClient(ctx context.Context, ownerID int64, clientID string) error {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.DeleteClient", "DELETE", "oauth_clients")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM oauth_clients WHERE client_id = $1 AND owner_id = $2`, clientID, ownerID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func scanOAuthClient(row rowScanner) (*models.OAuthClient, error) {
+	client := &models.OAuthClient{}
+	err := row.Scan(
+		&client.ID,
+		&client.ClientID,
+		&client.SecretHash,
+		&client.Name,
+		&client.OwnerID,
+		pq.Array(&client.RedirectURIs),
+		pq.Array(&client.Scopes),
+		&client.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// GetConsent returns the scopes the user has granted the client, or nil.
+func (r *OAuthRepository) GetConsent(ctx context.Context, userID int64, clientID string) (*models.OAuthConsent, error) {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.GetConsent", "SELECT", "oauth_consents")
+	defer span.End()
+
+	consent := &models.OAuthConsent{}
+	query := `
+		SELECT c.user_id, c.client_id, oc.name, c.scopes, c.created_at, c.updated_at
+		FROM oauth_consents c
+		JOIN oauth_clients oc ON oc.client_id = c.client_id
+		WHERE c.user_id = $1 AND c.client_id = $2`
+	err := r.db.QueryRowContext(ctx, query, userID, clientID).Scan(
+		&consent.UserID,
+		&consent.ClientID,
+		&consent.ClientName,
+		pq.Array(&consent.Scopes),
+		&consent.CreatedAt,
+		&consent.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return consent, err
+}
+
+func (r *OAuthRepository) ListConsents(ctx context.Context, userID int64) ([]*models.OAuthConsent, error) {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.ListConsents", "SELECT", "oauth_consents")
+	defer span.End()
+
+	query := `
+		SELECT c.user_id, c.client_id, oc.name, c.scopes, c.created_at, c.updated_at
+		FROM oauth_consents c
+		JOIN oauth_clients oc ON oc.client_id = c.client_id
+		WHERE c.user_id = $1
+		ORDER BY c.updated_at DESC`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	consents := []*models.OAuthConsent{}
+	for rows.Next() {
+		consent := &models.OAuthConsent{}
+		if err := rows.Scan(
+			&consent.UserID,
+			&consent.ClientID,
+			&consent.ClientName,
+			pq.Array(&consent.Scopes),
+			&consent.CreatedAt,
+			&consent.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		consents = append(consents, consent)
+	}
+	return consents, rows.Err()
+}
+
+// SaveConsent records that the user granted scopes to the client,
+// replacing any earlier consent.
+func (r *OAuthRepository) SaveConsent(ctx context.Context, userID int64, clientID string, scopes []string) error {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.SaveConsent", "UPSERT", "oauth_consents")
+	defer span.End()
+
+	query := `
+		INSERT INTO oauth_consents (user_id, client_id, scopes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, client_id)
+		DO UPDATE SET scopes = EXCLUDED.scopes, updated_at = NOW()`
+	_, err := r.db.ExecContext(ctx, query, userID, clientID, pq.Array(scopes))
+	return err
+}
+
+// RevokeConsent withdraws the user's consent and revokes every token the
+// client holds for them. It returns sql.ErrNoRows if there was no consent.
+func (r *OAuthRepository) RevokeConsent(ctx context.Context, userID int64, clientID string) error {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.RevokeConsent", "DELETE", "oauth_consents")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM oauth_consents WHERE user_id = $1 AND client_id = $2`, userID, clientID)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(result); err != nil {
+		return err
+	}
+	query := `
+		UPDATE oauth_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND client_id = $2 AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, userID, clientID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *OAuthRepository) CreateCode(ctx context.Context, code *models.OAuthAuthorizationCode) error {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.CreateCode", "INSERT", "oauth_authorization_codes")
+	defer span.End()
+
+	query := `
+		INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := r.db.ExecContext(
+		ctx,
+		query,
+		code.CodeHash,
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		pq.Array(code.Scopes),
+		code.CodeChallenge,
+		code.ExpiresAt,
+	)
+	return err
+}
+
+// TakeCode deletes and returns an authorization code so it can be
+// redeemed only once. It returns nil if there is no such code. Expired
+// codes are removed along the way.
+func (r *OAuthRepository) TakeCode(ctx context.Context, codeHash string) (*models.OAuthAuthorizationCode, error) {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.TakeCode", "DELETE", "oauth_authorization_codes")
+	defer span.End()
+
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM oauth_authorization_codes WHERE expires_at < NOW()`); err != nil {
+		return nil, err
+	}
+
+	code := &models.OAuthAuthorizationCode{}
+	query := `
+		DELETE FROM oauth_authorization_codes
+		WHERE code_hash = $1
+		RETURNING code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at`
+	err := r.db.QueryRowContext(ctx, query, codeHash).Scan(
+		&code.CodeHash,
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		pq.Array(&code.Scopes),
+		&code.CodeChallenge,
+		&code.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return code, err
+}
+
+func (r *OAuthRepository) CreateToken(ctx context.Context, token *models.OAuthToken) error {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.CreateToken", "INSERT", "oauth_tokens")
+	defer span.End()
+
+	query := `
+		INSERT INTO oauth_tokens (token_id, kind, grant_id, client_id, user_id, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		token.TokenID,
+		token.Kind,
+		token.GrantID,
+		token.ClientID,
+		token.UserID,
+		pq.Array(token.Scopes),
+		token.ExpiresAt,
+	).Scan(&token.ID, &token.CreatedAt)
+}
+
+// GetToken returns the token with the given token ID, or nil.
+func (r *OAuthRepository) GetToken(ctx context.Context, tokenID string) (*models.OAuthToken, error) {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.GetToken", "SELECT", "oauth_tokens")
+	defer span.End()
+
+	token := &models.OAuthToken{}
+	query := `
+		SELECT id, token_id, kind, grant_id, client_id, user_id, scopes, expires_at, revoked_at, created_at
+		FROM oauth_tokens
+		WHERE token_id = $1`
+	err := r.db.QueryRowContext(ctx, query, tokenID).Scan(
+		&token.ID,
+		&token.TokenID,
+		&token.Kind,
+		&token.GrantID,
+		&token.ClientID,
+		&token.UserID,
+		pq.Array(&token.Scopes),
+		&token.ExpiresAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return token, err
+}
+
+// RevokeToken revokes a single token. It reports whether the token was
+// still active, which lets refresh token rotation detect a token being
+// used twice.
+func (r *OAuthRepository) RevokeToken(ctx context.Context, id int64) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.RevokeToken", "UPDATE", "oauth_tokens")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE oauth_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeGrant revokes every token descending from one authorization.
+func (r *OAuthRepository) RevokeGrant(ctx context.Context, grantID string) error {
+	ctx, span := tracing.StartQuery(ctx, "OAuthRepository.RevokeGrant", "UPDATE", "oauth_tokens")
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx, `UPDATE oauth_tokens SET revoked_at = NOW() WHERE grant_id = $1 AND revoked_at IS NULL`, grantID)
+	return err
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/events"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+	"github.com/lib/pq"
+)
+
+// recordEvent writes event to the outbox as part of tx, so it is
+// dispatched if and only if tx commits.
+func recordEvent(ctx context.Context, tx *sql.Tx, event events.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO outbox_events (type, payload) VALUES ($1, $2)`, event.Type(), payload)
+	return err
+}
+
+// OutboxRepository reads the outbox for the dispatcher. Events are added
+// by the repositories that make the changes they describe.
+type OutboxRepository struct {
+	db *sql.DB
+}
+
+func NewOutboxRepository(db *sql.DB) *OutboxRepository {
+	return &OutboxRepository{db: db}
+}
+
+// Claim leases the event that has been due longest until now+lease,
+// counting the attempt, or returns nil when none is due. Concurrent
+// callers never claim the same event.
+func (r *OutboxRepository) Claim(ctx context.Context, lease time.Duration) (*models.OutboxEvent, error) {
+	ctx, span := tracing.StartQuery(ctx, "OutboxRepository.Claim", "UPDATE", "outbox_events")
+	defer span.End()
+
+	query := `
+		UPDATE outbox_events o SET attempts = attempts + 1, locked_until = $1
+		WHERE o.id = (
+			SELECT id FROM outbox_events
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			  AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY next_attempt_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING o.id, o.type, o.payload, o.status, o.done, o.attempts, o.created_at`
+
+	event := &models.OutboxEvent{}
+	err := r.db.QueryRowContext(ctx, query, time.Now().Add(lease)).Scan(
+		&event.ID,
+		&event.Type,
+		&event.Payload,
+		&event.Status,
+		pq.Array(&event.Done),
+		&event.Attempts,
+		&event.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// Processed records that every subscriber has handled the event.
+func (r *OutboxRepository) Processed(ctx context.Context, event *models.OutboxEvent) error {
+	ctx, span := tracing.StartQuery(ctx, "OutboxRepository.Processed", "UPDATE", "outbox_events")
+	defer span.End()
+
+	query := `
+		UPDATE outbox_events
+		SET status = 'processed', done = $1, error = '', locked_until = NULL, processed_at = NOW()
+		WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, pq.Array(event.Done), event.ID)
+	return err
+}
+
+// Retry records the subscribers that have handled the event so far and
+// makes it due again at retryAt for the others.
+func (r *OutboxRepository) Retry(ctx context.Context, event *models.OutboxEvent, retryAt time.Time, reason string) error {
+	ctx, span := tracing.StartQuery(ctx, "OutboxRepository.Retry", "UPDATE", "outbox_events")
+	defer span.End()
+
+	query := `
+		UPDATE outbox_events
+		SET done = $1, error = $2, next_attempt_at = $3, locked_until = NULL
+		WHERE id = $4`
+	_, err := r.db.ExecContext(ctx, query, pq.Array(event.Done), reason, retryAt, event.ID)
+	return err
+}
+
+// Fail gives up on the subscribers that have not handled the event.
+func (r *OutboxRepository) Fail(ctx context.Context, event *models.OutboxEvent, reason string) error {
+	ctx, span := tracing.StartQuery(ctx, "OutboxRepository.Fail", "UPDATE", "outbox_events")
+	defer span.End()
+
+	query := `
+		UPDATE outbox_events
+		SET status = 'failed', done = $1, error = $2, locked_until = NULL, processed_at = NOW()
+		WHERE id = $3`
+	_, err := r.db.ExecContext(ctx, query, pq.Array(event.Done), reason, event.ID)
+	return err
+}
+
+// Prune removes events that finished before t and returns how many were
+// removed.
+func (r *OutboxRepository) Prune(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := tracing.StartQuery(ctx, "OutboxRepository.Prune", "DELETE", "outbox_events")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM outbox_events WHERE processed_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -1,10 +1,13 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
+	"github.com/anoying-kid/go-apps/blogAPI/internal/events"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
 )
 
 type PasswordResetRepository struct {
@@ -15,31 +18,85 @@ func NewPasswordResetRepository(db *sql.DB) *PasswordResetRepository {
     return &PasswordResetRepository{db: db}
 }
 
-func (r *PasswordResetRepository) Create(reset *models.PasswordResetToken) error {
+// Create stores a reset without its emailed token and records
+// PasswordResetRequested, whose subscriber makes the token and emails it.
+func (r *PasswordResetRepository) Create(ctx context.Context, reset *models.PasswordResetToken) error {
+    ctx, span := tracing.StartQuery(ctx, "PasswordResetRepository.Create", "INSERT", "password_reset_tokens")
+    defer span.End()
+
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
     query := `
-        INSERT INTO password_reset_tokens (user_id, token, expired_at, created_at)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO password_reset_tokens (user_id, expired_at, created_at)
+        VALUES ($1, $2, $3)
         RETURNING id`
 
-    return r.db.QueryRow(
+    err = tx.QueryRowContext(
+        ctx,
         query,
         reset.UserID,
-        reset.Token,
         reset.ExpiredAt,
         time.Now(),
     ).Scan(&reset.ID)
+    if err != nil {
+        return err
+    }
+    if err := recordEvent(ctx, tx, events.PasswordResetRequested{UserID: reset.UserID, ResetID: reset.ID}); err != nil {
+        return err
+    }
+    return tx.Commit()
 }
 
-func (r *PasswordResetRepository) GetByToken(token string) (*models.PasswordResetToken, error) {
-	reset := &models.PasswordResetToken{}
+// GetByID returns the reset token with the given ID, or nil if there is
+// none.
+func (r *PasswordResetRepository) GetByID(ctx context.Context, id int64) (*models.PasswordResetToken, error) {
+	ctx, span := tracing.StartQuery(ctx, "PasswordResetRepository.GetByID", "SELECT", "password_reset_tokens")
+	defer span.End()
+
+	query := `
+	SELECT id, user_id, COALESCE(token_hash, ''), expired_at, used, created_at
+	FROM password_reset_tokens
+	WHERE id = $1`
+	return scanPasswordReset(r.db.QueryRowContext(ctx, query, id))
+}
+
+// GetByToken finds a reset by the hash of its emailed token, or returns
+// nil.
+func (r *PasswordResetRepository) GetByToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+	ctx, span := tracing.StartQuery(ctx, "PasswordResetRepository.GetByToken", "SELECT", "password_reset_tokens")
+	defer span.End()
+
 	query := `
-	SELECT id, user_id, token, expired_at, used, created_at
+	SELECT id, user_id, token_hash, expired_at, used, created_at
 	FROM password_reset_tokens
-	WHERE token = $1`
-	err := r.db.QueryRow(query, token).Scan(
+	WHERE token_hash = $1`
+	return scanPasswordReset(r.db.QueryRowContext(ctx, query, tokenHash))
+}
+
+// SetToken stores the hash of the token emailed for a reset that has not
+// been used yet, replacing any earlier one.
+func (r *PasswordResetRepository) SetToken(ctx context.Context, id int64, tokenHash string) error {
+	ctx, span := tracing.StartQuery(ctx, "PasswordResetRepository.SetToken", "UPDATE", "password_reset_tokens")
+	defer span.End()
+
+	query := `UPDATE password_reset_tokens SET token_hash = $2 WHERE id = $1 AND NOT used`
+	result, err := r.db.ExecContext(ctx, query, id, tokenHash)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func scanPasswordReset(row *sql.Row) (*models.PasswordResetToken, error) {
+	reset := &models.PasswordResetToken{}
+	err := row.Scan(
 		&reset.ID,
 		&reset.UserID,
-		&reset.Token,
+		&reset.TokenHash,
 		&reset.ExpiredAt,
 		&reset.Used,
 		&reset.CreatedAt,
@@ -50,8 +107,11 @@ func (r *PasswordResetRepository) GetByToken(token string) (*models.PasswordRese
 	return reset, err
 }
 
-func (r *PasswordResetRepository) MarkAsUsed(id int64) error {
+func (r *PasswordResetRepository) MarkAsUsed(ctx context.Context, id int64) error {
+	ctx, span := tracing.StartQuery(ctx, "PasswordResetRepository.MarkAsUsed", "UPDATE", "password_reset_tokens")
+	defer span.End()
+
 	query := `UPDATE password_reset_tokens SET used = true WHERE id = $1`
-	_, err := r.db.Exec(query, id)
+	_, err := r.db.ExecContext(ctx, query, id)
 	return err
 }
\ No newline at end of file
@@ -1,11 +1,16 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/anoying-kid/go-apps/blogAPI/internal/events"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/slug"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
 )
 
 type PostRepository struct {
@@ -16,42 +21,78 @@ func NewPostRepository(db *sql.DB) *PostRepository {
 	return &PostRepository{db: db}
 }
 
-func (r *PostRepository) Create(post *models.Post) error {
+// Create stores the post together with its attached media, giving it a
+// slug made from its title, and records PostPublished.
+func (r *PostRepository) Create(ctx context.Context, post *models.Post) error {
+	ctx, span := tracing.StartQuery(ctx, "PostRepository.Create", "INSERT", "posts")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	post.Slug, err = allocateSlug(ctx, tx, 0, slug.Make(post.Title))
+	if err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO posts (title, body, author_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`
+		INSERT INTO posts (title, slug, body, author_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at`
 
 	now := time.Now()
-	return r.db.QueryRow(
+	err = tx.QueryRowContext(
+		ctx,
 		query,
 		post.Title,
+		post.Slug,
 		post.Body,
 		post.AuthorID,
 		now,
 		now,
-	).Scan(&post.ID)
+	).Scan(&post.ID, &post.CreatedAt, &post.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO post_slugs (slug, post_id) VALUES ($1, $2)`, post.Slug, post.ID); err != nil {
+		return err
+	}
+	if err := setPostMedia(ctx, tx, post); err != nil {
+		return err
+	}
+	if err := recordEvent(ctx, tx, events.PostPublished{PostID: post.ID, AuthorID: post.AuthorID}); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *PostRepository) GetByID(id int64) (*models.Post, error) {
+func (r *PostRepository) GetByID(ctx context.Context, id int64) (*models.Post, error) {
+    ctx, span := tracing.StartQuery(ctx, "PostRepository.GetByID", "SELECT", "posts")
+    defer span.End()
+
     post := &models.Post{}
     query := `
-        SELECT p.id, p.title, p.body, p.author_id, p.created_at, p.updated_at,
-               u.username, u.email
+        SELECT p.id, p.title, p.slug, p.body, p.author_id, p.created_at, p.updated_at,
+               u.username, u.display_name, u.avatar_url
         FROM posts p
         JOIN users u ON p.author_id = u.id
         WHERE p.id = $1`
     
     var author models.User
-    err := r.db.QueryRow(query, id).Scan(
+    err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&post.ID,
         &post.Title,
+        &post.Slug,
         &post.Body,
 		&post.AuthorID,
         &post.CreatedAt,
         &post.UpdatedAt,
         &author.Username,
-        &author.Email,
+        &author.DisplayName,
+        &author.AvatarURL,
     )
     if err == sql.ErrNoRows {
         return nil, nil
@@ -65,16 +106,19 @@ func (r *PostRepository) GetByID(id int64) (*models.Post, error) {
     return post, nil
 }
 
-func (r *PostRepository) List(limit, offset int) ([]*models.Post, error) {
+func (r *PostRepository) List(ctx context.Context, limit, offset int) ([]*models.Post, error) {
+    ctx, span := tracing.StartQuery(ctx, "PostRepository.List", "SELECT", "posts")
+    defer span.End()
+
     query := `
-        SELECT p.id, p.title, p.body, p.author_id, p.created_at, p.updated_at,
-               u.username, u.email
+        SELECT p.id, p.title, p.slug, p.body, p.author_id, p.created_at, p.updated_at,
+               u.username, u.display_name, u.avatar_url
         FROM posts p
         JOIN users u ON p.author_id = u.id
         ORDER BY p.created_at DESC
         LIMIT $1 OFFSET $2`
     
-    rows, err := r.db.Query(query, limit, offset)
+    rows, err := r.db.QueryContext(ctx, query, limit, offset)
     if err != nil {
         return nil, err
     }
@@ -88,12 +132,14 @@ func (r *PostRepository) List(limit, offset int) ([]*models.Post, error) {
         err := rows.Scan(
             &post.ID,
             &post.Title,
+            &post.Slug,
             &post.Body,
             &post.AuthorID,
             &post.CreatedAt,
             &post.UpdatedAt,
             &author.Username,
-            &author.Email,
+            &author.DisplayName,
+            &author.AvatarURL,
         )
         if err != nil {
             return nil, err
@@ -107,15 +153,43 @@ func (r *PostRepository) List(limit, offset int) ([]*models.Post, error) {
     return posts, nil
 }
 
-func (r *PostRepository) Update(post *models.Post) error {
+// Update saves the post's title, body and attached media, and records
+// PostUpdated. A new title gives the post a new slug; the old one is kept
+// for redirects.
+func (r *PostRepository) Update(ctx context.Context, post *models.Post) error {
+    ctx, span := tracing.StartQuery(ctx, "PostRepository.Update", "UPDATE", "posts")
+    defer span.End()
+
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    base := slug.Make(post.Title)
+    if !hasSlugBase(post.Slug, base) {
+        post.Slug, err = allocateSlug(ctx, tx, post.ID, base)
+        if err != nil {
+            return err
+        }
+        _, err = tx.ExecContext(ctx,
+            `INSERT INTO post_slugs (slug, post_id) VALUES ($1, $2) ON CONFLICT (slug) DO NOTHING`,
+            post.Slug, post.ID)
+        if err != nil {
+            return err
+        }
+    }
+
     query := `
         UPDATE posts 
-        SET title = $1, body = $2, updated_at = $3
-        WHERE id = $4 AND author_id = $5`
+        SET title = $1, slug = $2, body = $3, updated_at = $4
+        WHERE id = $5 AND author_id = $6`
     
-    result, err := r.db.Exec(
+    result, err := tx.ExecContext(
+        ctx,
         query,
         post.Title,
+        post.Slug,
         post.Body,
         time.Now(),
         post.ID,
@@ -134,5 +208,271 @@ func (r *PostRepository) Update(post *models.Post) error {
         return fmt.Errorf("no post found with ID %d", post.ID)
     }
 
+    if err := setPostMedia(ctx, tx, post); err != nil {
+        return err
+    }
+    if err := recordEvent(ctx, tx, events.PostUpdated{PostID: post.ID, AuthorID: post.AuthorID}); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// hasSlugBase reports whether s is base or base with a collision suffix,
+// in which case a retitled post keeps it.
+func hasSlugBase(s, base string) bool {
+    if s == base {
+        return true
+    }
+    rest, ok := strings.CutPrefix(s, base+"-")
+    if !ok || rest == "" {
+        return false
+    }
+    for _, c := range rest {
+        if c < '0' || c > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// allocateSlug returns the first of base, base-2, base-3... that no post
+// other than postID has ever had. Allocations of the same base are
+// serialized until the transaction ends, so concurrent posts with the same
+// title get different suffixes.
+func allocateSlug(ctx context.Context, tx *sql.Tx, postID int64, base string) (string, error) {
+    if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('post_slug:' || $1))`, base); err != nil {
+        return "", err
+    }
+
+    // Slugs are [a-z0-9-], so base holds no LIKE wildcards.
+    rows, err := tx.QueryContext(ctx,
+        `SELECT slug FROM post_slugs WHERE (slug = $1 OR slug LIKE $1 || '-%') AND post_id <> $2`,
+        base, postID)
+    if err != nil {
+        return "", err
+    }
+    defer rows.Close()
+
+    taken := make(map[string]bool)
+    for rows.Next() {
+        var s string
+        if err := rows.Scan(&s); err != nil {
+            return "", err
+        }
+        taken[s] = true
+    }
+    if err := rows.Err(); err != nil {
+        return "", err
+    }
+
+    for n := 1; ; n++ {
+        if candidate := slug.WithSuffix(base, n); !taken[candidate] {
+            return candidate, nil
+        }
+    }
+}
+
+// GetBySlug returns the post that has or once had the slug, or nil when
+// no post ever had it. Compare post.Slug to tell an old slug.
+func (r *PostRepository) GetBySlug(ctx context.Context, s string) (*models.Post, error) {
+    ctx, span := tracing.StartQuery(ctx, "PostRepository.GetBySlug", "SELECT", "post_slugs")
+    defer span.End()
+
+    var postID int64
+    err := r.db.QueryRowContext(ctx, `SELECT post_id FROM post_slugs WHERE slug = $1`, s).Scan(&postID)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return r.GetByID(ctx, postID)
+}
+
+// setPostMedia replaces the post's attachments with post.Media, marking
+// post.FeaturedImage, which is attached too if it is not already.
+func setPostMedia(ctx context.Context, tx *sql.Tx, post *models.Post) error {
+    if _, err := tx.ExecContext(ctx, `DELETE FROM post_media WHERE post_id = $1`, post.ID); err != nil {
+        return err
+    }
+
+    media := post.Media
+    if f := post.FeaturedImage; f != nil {
+        attached := false
+        for _, m := range media {
+            attached = attached || m.ID == f.ID
+        }
+        if !attached {
+            media = append(media, f)
+            post.Media = media
+        }
+    }
+    for i, m := range media {
+        featured := post.FeaturedImage != nil && m.ID == post.FeaturedImage.ID
+        _, err := tx.ExecContext(ctx,
+            `INSERT INTO post_media (post_id, media_id, position, featured) VALUES ($1, $2, $3, $4)`,
+            post.ID, m.ID, i, featured)
+        if err != nil {
+            return err
+        }
+    }
     return nil
-}
\ No newline at end of file
+}
+
+// ListByAuthor returns every post written by the user, oldest first.
+func (r *PostRepository) ListByAuthor(ctx context.Context, authorID int64) ([]*models.Post, error) {
+    ctx, span := tracing.StartQuery(ctx, "PostRepository.ListByAuthor", "SELECT", "posts")
+    defer span.End()
+
+    query := `
+        SELECT id, title, slug, body, author_id, created_at, updated_at
+        FROM posts
+        WHERE author_id = $1
+        ORDER BY created_at, id`
+
+    rows, err := r.db.QueryContext(ctx, query, authorID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var posts []*models.Post
+    for rows.Next() {
+        post := &models.Post{}
+        if err := rows.Scan(&post.ID, &post.Title, &post.Slug, &post.Body, &post.AuthorID, &post.CreatedAt, &post.UpdatedAt); err != nil {
+            return nil, err
+        }
+        posts = append(posts, post)
+    }
+    return posts, rows.Err()
+}
+
+// Timeline returns up to limit posts by the authors userID follows, newest
+// first, starting after the cursor.
+//
+// Each followed author's newest posts before the cursor are read from
+// posts_author_created_idx and only those candidates are merged, so a page
+// costs a short index scan per followed author however many posts they
+// have written.
+func (r *PostRepository) Timeline(ctx context.Context, userID int64, after *Cursor, limit int) ([]*models.Post, error) {
+    ctx, span := tracing.StartQuery(ctx, "PostRepository.Timeline", "SELECT", "posts")
+    defer span.End()
+
+    query := `
+        SELECT p.id, p.title, p.slug, p.body, p.author_id, p.created_at, p.updated_at,
+               u.username, u.display_name, u.avatar_url
+        FROM follows f
+        CROSS JOIN LATERAL (
+            SELECT id, title, slug, body, author_id, created_at, updated_at
+            FROM posts
+            WHERE author_id = f.followee_id AND (created_at, id) < ($2, $3)
+            ORDER BY created_at DESC, id DESC
+            LIMIT $4
+        ) p
+        JOIN users u ON p.author_id = u.id
+        WHERE f.follower_id = $1
+        ORDER BY p.created_at DESC, p.id DESC
+        LIMIT $4`
+
+    at, id := after.after()
+    rows, err := r.db.QueryContext(ctx, query, userID, at, id, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var posts []*models.Post
+    for rows.Next() {
+        post := &models.Post{}
+        author := &models.User{}
+        err := rows.Scan(
+            &post.ID,
+            &post.Title,
+            &post.Slug,
+            &post.Body,
+            &post.AuthorID,
+            &post.CreatedAt,
+            &post.UpdatedAt,
+            &author.Username,
+            &author.DisplayName,
+            &author.AvatarURL,
+        )
+        if err != nil {
+            return nil, err
+        }
+        author.ID = post.AuthorID
+        post.Author = author
+        posts = append(posts, post)
+    }
+    return posts, rows.Err()
+}
+
+// SitemapChunk is a run of consecutive posts, in ID order, listed in one
+// sitemap.
+type SitemapChunk struct {
+	FirstID      int64
+	LastModified time.Time
+}
+
+// SitemapChunks splits every post, in ID order, into runs of at most size
+// and returns where each starts and when a post in it last changed.
+func (r *PostRepository) SitemapChunks(ctx context.Context, size int) ([]SitemapChunk, error) {
+	ctx, span := tracing.StartQuery(ctx, "PostRepository.SitemapChunks", "SELECT", "posts")
+	defer span.End()
+
+	query := `
+		SELECT min(id), max(updated_at)
+		FROM (
+			SELECT id, updated_at, (row_number() OVER (ORDER BY id) - 1) / $1 AS chunk
+			FROM posts
+		) numbered
+		GROUP BY chunk
+		ORDER BY chunk`
+	rows, err := r.db.QueryContext(ctx, query, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []SitemapChunk
+	for rows.Next() {
+		var c SitemapChunk
+		if err := rows.Scan(&c.FirstID, &c.LastModified); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// EachForSitemap calls fn with up to limit posts, in ID order, starting
+// at firstID. Only the ID, slug and timestamps are read, and each row is
+// handed to fn as it arrives rather than collected first. It stops at the
+// first error from fn.
+func (r *PostRepository) EachForSitemap(ctx context.Context, firstID int64, limit int, fn func(*models.Post) error) error {
+	ctx, span := tracing.StartQuery(ctx, "PostRepository.EachForSitemap", "SELECT", "posts")
+	defer span.End()
+
+	query := `
+		SELECT id, slug, created_at, updated_at
+		FROM posts
+		WHERE id >= $1
+		ORDER BY id
+		LIMIT $2`
+	rows, err := r.db.QueryContext(ctx, query, firstID, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		post := &models.Post{}
+		if err := rows.Scan(&post.ID, &post.Slug, &post.CreatedAt, &post.UpdatedAt); err != nil {
+			return err
+		}
+		if err := fn(post); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasSlugBase(t *testing.T) {
+	assert.True(t, hasSlugBase("hello", "hello"))
+	assert.True(t, hasSlugBase("hello-2", "hello"))
+	assert.True(t, hasSlugBase("hello-17", "hello"))
+	assert.False(t, hasSlugBase("hello-world", "hello"))
+	assert.False(t, hasSlugBase("hello-", "hello"))
+	assert.False(t, hasSlugBase("hello", "hello-world"))
+	assert.False(t, hasSlugBase("hell", "hello"))
+}
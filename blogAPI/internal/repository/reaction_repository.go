@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+	"github.com/lib/pq"
+)
+
+type ReactionRepository struct {
+	db *sql.DB
+}
+
+func NewReactionRepository(db *sql.DB) *ReactionRepository {
+	return &ReactionRepository{db: db}
+}
+
+// Add gives the post the user's reaction of kind. Reacting the same way
+// again changes nothing. The totals are kept by a trigger on
+// post_reactions.
+func (r *ReactionRepository) Add(ctx context.Context, postID, userID int64, kind string) error {
+	ctx, span := tracing.StartQuery(ctx, "ReactionRepository.Add", "INSERT", "post_reactions")
+	defer span.End()
+
+	query := `
+		INSERT INTO post_reactions (post_id, user_id, kind)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+	_, err := r.db.ExecContext(ctx, query, postID, userID, kind)
+	return err
+}
+
+// Remove takes back the user's reaction of kind, if there is one.
+func (r *ReactionRepository) Remove(ctx context.Context, postID, userID int64, kind string) error {
+	ctx, span := tracing.StartQuery(ctx, "ReactionRepository.Remove", "DELETE", "post_reactions")
+	defer span.End()
+
+	query := `DELETE FROM post_reactions WHERE post_id = $1 AND user_id = $2 AND kind = $3`
+	_, err := r.db.ExecContext(ctx, query, postID, userID, kind)
+	return err
+}
+
+// Load fills in the reaction totals of posts in one query. Reacted is set
+// for the reactions viewerID gave; pass 0 for an anonymous viewer.
+func (r *ReactionRepository) Load(ctx context.Context, viewerID int64, posts ...*models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ctx, span := tracing.StartQuery(ctx, "ReactionRepository.Load", "SELECT", "post_reaction_counts")
+	defer span.End()
+
+	byID := make(map[int64]*models.Post, len(posts))
+	ids := make([]int64, 0, len(posts))
+	for _, post := range posts {
+		post.Reactions = nil
+		byID[post.ID] = post
+		ids = append(ids, post.ID)
+	}
+
+	query := `
+		SELECT c.post_id, c.kind, c.count,
+		       EXISTS (
+		           SELECT 1 FROM post_reactions r
+		           WHERE r.post_id = c.post_id AND r.kind = c.kind AND r.user_id = $2
+		       )
+		FROM post_reaction_counts c
+		WHERE c.post_id = ANY($1) AND c.count > 0`
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(ids), viewerID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int64
+		var reaction models.ReactionCount
+		if err := rows.Scan(&postID, &reaction.Kind, &reaction.Count, &reaction.Reacted); err != nil {
+			return err
+		}
+		if post := byID[postID]; post != nil {
+			post.Reactions = append(post.Reactions, reaction)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		sort.Slice(post.Reactions, func(i, j int) bool {
+			return reactionOrder(post.Reactions[i].Kind) < reactionOrder(post.Reactions[j].Kind)
+		})
+	}
+	return nil
+}
+
+func reactionOrder(kind string) int {
+	for i, k := range models.ReactionKinds {
+		if k == kind {
+			return i
+		}
+	}
+	return len(models.ReactionKinds)
+}
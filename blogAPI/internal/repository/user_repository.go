@@ -1,12 +1,29 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"time"
 
+	"github.com/anoying-kid/go-apps/blogAPI/internal/events"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
 	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+	"github.com/lib/pq"
 )
 
+// ErrUsernameTaken and ErrEmailTaken are returned when another account
+// already uses the username or email.
+var (
+	ErrUsernameTaken = errors.New("username is already taken")
+	ErrEmailTaken    = errors.New("email is already in use")
+)
+
+// ErrLoginLocked is returned by BeginLoginAttempt while the account is
+// locked.
+var ErrLoginLocked = errors.New("account is locked")
+
 type UserRepository struct {
 	db *sql.DB
 }
@@ -16,33 +33,108 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (r *UserRepository) Create(user *models.User) error {
+// Create stores a new account and records UserRegistered.
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	ctx, span := tracing.StartQuery(ctx, "UserRepository.Create", "INSERT", "users")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO users (username, email, password, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO users (username, email, password, email_verified_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id`
 
 	now := time.Now()
-	return r.db.QueryRow(
+	err = tx.QueryRowContext(
+		ctx,
 		query,
 		user.Username,
 		user.Email,
 		user.Password,
+		user.EmailVerifiedAt,
 		now,
 		now,
 	).Scan(&user.ID)
+	if err != nil {
+		return userConflict(err)
+	}
+	if err := recordEvent(ctx, tx, events.UserRegistered{UserID: user.ID}); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
+// userConflict turns a unique violation on users into ErrUsernameTaken or
+// ErrEmailTaken.
+func userConflict(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		switch pqErr.Constraint {
+		case "users_username_lower_key":
+			return ErrUsernameTaken
+		case "users_email_key":
+			return ErrEmailTaken
+		}
+	}
+	return err
+}
+
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+    ctx, span := tracing.StartQuery(ctx, "UserRepository.GetByEmail", "SELECT", "users")
+    defer span.End()
+
+    query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+    return scanUser(r.db.QueryRowContext(ctx, query, email))
+}
+
+func (r *UserRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
+    ctx, span := tracing.StartQuery(ctx, "UserRepository.GetByID", "SELECT", "users")
+    defer span.End()
+
+    query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+    return scanUser(r.db.QueryRowContext(ctx, query, id))
+}
+
+// GetByUsername finds a user by username, ignoring case.
+func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+    ctx, span := tracing.StartQuery(ctx, "UserRepository.GetByUsername", "SELECT", "users")
+    defer span.End()
+
+    query := `SELECT ` + userColumns + ` FROM users WHERE LOWER(username) = LOWER($1)`
+    return scanUser(r.db.QueryRowContext(ctx, query, username))
+}
+
+const userColumns = `
+    id, username, email, email_verified_at, password, role, display_name, bio, website, avatar_url,
+    created_at, updated_at, deletion_scheduled_at, is_placeholder,
+    failed_login_attempts, locked_until`
+
+// scanUser scans a row selected with userColumns, returning nil when there
+// is no row.
+func scanUser(row *sql.Row) (*models.User, error) {
     user := &models.User{}
-    query := `SELECT id, username, email, password, created_at, updated_at FROM users WHERE email = $1`
-    err := r.db.QueryRow(query, email).Scan(
+    err := row.Scan(
         &user.ID,
         &user.Username,
         &user.Email,
+        &user.EmailVerifiedAt,
         &user.Password,
+        &user.Role,
+        &user.DisplayName,
+        &user.Bio,
+        &user.Website,
+        &user.AvatarURL,
         &user.CreatedAt,
         &user.UpdatedAt,
+        &user.DeletionScheduledAt,
+        &user.IsPlaceholder,
+        &user.FailedLoginAttempts,
+        &user.LockedUntil,
     )
     if err == sql.ErrNoRows {
         return nil, nil
@@ -50,13 +142,16 @@ func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
     return user, err
 }
 
-func (r *UserRepository) UpdatePassword(userID int64, hashedPassword string) error {
+func (r *UserRepository) UpdatePassword(ctx context.Context, userID int64, hashedPassword string) error {
+    ctx, span := tracing.StartQuery(ctx, "UserRepository.UpdatePassword", "UPDATE", "users")
+    defer span.End()
+
     query := `
         UPDATE users 
         SET password = $1, updated_at = $2 
         WHERE id = $3`
 
-    result, err := r.db.Exec(query, hashedPassword, time.Now(), userID)
+    result, err := r.db.ExecContext(ctx, query, hashedPassword, time.Now(), userID)
     if err != nil {
         return err
     }
@@ -70,5 +165,205 @@ func (r *UserRepository) UpdatePassword(userID int64, hashedPassword string) err
         return sql.ErrNoRows
     }
 
+    logging.FromContext(ctx).Info("user password updated", "user_id", userID)
     return nil
-}
\ No newline at end of file
+}
+
+// UpdateProfile saves the user's public profile fields.
+func (r *UserRepository) UpdateProfile(ctx context.Context, user *models.User) error {
+    ctx, span := tracing.StartQuery(ctx, "UserRepository.UpdateProfile", "UPDATE", "users")
+    defer span.End()
+
+    query := `
+        UPDATE users
+        SET display_name = $1, bio = $2, website = $3, avatar_url = $4, updated_at = $5
+        WHERE id = $6`
+
+    result, err := r.db.ExecContext(ctx, query,
+        user.DisplayName, user.Bio, user.Website, user.AvatarURL, time.Now(), user.ID)
+    if err != nil {
+        return err
+    }
+    return requireRow(result)
+}
+
+// BeginLoginAttempt counts a login attempt against the user before the
+// credentials are checked and returns the new count of consecutive
+// failures. It also applies the lock that lockFor, given that count,
+// says a failed attempt earns; a zero time means none. The row is
+// locked while this happens, so parallel attempts cannot all get past a
+// lock the first of them should have applied. While a lock is in force
+// it returns ErrLoginLocked and when the lock ends.
+func (r *UserRepository) BeginLoginAttempt(ctx context.Context, userID int64, lockFor func(failures int) time.Time) (int, time.Time, error) {
+    ctx, span := tracing.StartQuery(ctx, "UserRepository.BeginLoginAttempt", "UPDATE", "users")
+    defer span.End()
+
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, time.Time{}, err
+    }
+    defer tx.Rollback()
+
+    var failures int
+    var lockedUntil sql.NullTime
+    query := `
+        SELECT failed_login_attempts, CASE WHEN locked_until > NOW() THEN locked_until END
+        FROM users
+        WHERE id = $1
+        FOR UPDATE`
+    if err := tx.QueryRowContext(ctx, query, userID).Scan(&failures, &lockedUntil); err != nil {
+        return 0, time.Time{}, err
+    }
+    if lockedUntil.Valid {
+        return 0, lockedUntil.Time, ErrLoginLocked
+    }
+
+    failures++
+    var until *time.Time
+    if t := lockFor(failures); !t.IsZero() {
+        until = &t
+    }
+    query = `UPDATE users SET failed_login_attempts = $1, locked_until = $2 WHERE id = $3`
+    if _, err := tx.ExecContext(ctx, query, failures, until, userID); err != nil {
+        return 0, time.Time{}, err
+    }
+    return failures, time.Time{}, tx.Commit()
+}
+
+// EndLoginAttempt takes back an attempt counted by BeginLoginAttempt
+// whose credentials turned out right, along with the lock it applied.
+// It does nothing once later attempts have been counted, as those are
+// judged on their own.
+func (r *UserRepository) EndLoginAttempt(ctx context.Context, userID int64, failures int) error {
+    ctx, span := tracing.StartQuery(ctx, "UserRepository.EndLoginAttempt", "UPDATE", "users")
+    defer span.End()
+
+    query := `
+        UPDATE users
+        SET failed_login_attempts = failed_login_attempts - 1, locked_until = NULL
+        WHERE id = $1 AND failed_login_attempts = $2`
+    _, err := r.db.ExecContext(ctx, query, userID, failures)
+    return err
+}
+
+// MarkEmailVerified records that the user has shown email is theirs,
+// unless the account has moved to another email since. It keeps the time
+// of an earlier verification.
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, userID int64, email string) error {
+    ctx, span := tracing.StartQuery(ctx, "UserRepository.MarkEmailVerified", "UPDATE", "users")
+    defer span.End()
+
+    query := `
+        UPDATE users SET email_verified_at = NOW()
+        WHERE id = $1 AND email = $2 AND email_verified_at IS NULL`
+    _, err := r.db.ExecContext(ctx, query, userID, email)
+    return err
+}
+
+// ResetFailedLogins clears the failed login count and any lock.
+func (r *UserRepository) ResetFailedLogins(ctx context.Context, userID int64) error {
+    ctx, span := tracing.StartQuery(ctx, "UserRepository.ResetFailedLogins", "UPDATE", "users")
+    defer span.End()
+
+    query := `UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = $1`
+    _, err := r.db.ExecContext(ctx, query, userID)
+    return err
+}
+
+// ScheduleDeletion sets when the user's account is erased; nil cancels a
+// scheduled deletion.
+func (r *UserRepository) ScheduleDeletion(ctx context.Context, userID int64, at *time.Time) error {
+    ctx, span := tracing.StartQuery(ctx, "UserRepository.ScheduleDeletion", "UPDATE", "users")
+    defer span.End()
+
+    query := `UPDATE users SET deletion_scheduled_at = $1 WHERE id = $2 AND NOT is_placeholder`
+    result, err := r.db.ExecContext(ctx, query, at, userID)
+    if err != nil {
+        return err
+    }
+    return requireRow(result)
+}
+
+// ListDueForDeletion returns up to limit users whose scheduled deletion is
+// due at now.
+func (r *UserRepository) ListDueForDeletion(ctx context.Context, now time.Time, limit int) ([]int64, error) {
+    ctx, span := tracing.StartQuery(ctx, "UserRepository.ListDueForDeletion", "SELECT", "users")
+    defer span.End()
+
+    query := `
+        SELECT id FROM users
+        WHERE deletion_scheduled_at <= $1 AND NOT is_placeholder
+        ORDER BY deletion_scheduled_at
+        LIMIT $2`
+    rows, err := r.db.QueryContext(ctx, query, now, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ids []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
+
+// Erase deletes a user whose scheduled deletion is due, along with
+// everything that cascades from the account. Their posts are moved to the
+// placeholder account when keepPosts is set and deleted otherwise. It
+// returns false when the deletion was cancelled in the meantime.
+func (r *UserRepository) Erase(ctx context.Context, userID int64, keepPosts bool) (bool, error) {
+    ctx, span := tracing.StartQuery(ctx, "UserRepository.Erase", "DELETE", "users")
+    defer span.End()
+
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return false, err
+    }
+    defer tx.Rollback()
+
+    // Lock the row so a cancellation either lands first or waits
+    var due bool
+    err = tx.QueryRowContext(ctx,
+        `SELECT COALESCE(deletion_scheduled_at <= NOW(), FALSE) FROM users WHERE id = $1 AND NOT is_placeholder FOR UPDATE`,
+        userID).Scan(&due)
+    if err == sql.ErrNoRows || (err == nil && !due) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+
+    if keepPosts {
+        // Images in kept posts go with them; the rest are deleted with
+        // the user
+        _, err = tx.ExecContext(ctx, `
+            WITH placeholder AS (SELECT id FROM users WHERE is_placeholder ORDER BY id LIMIT 1),
+            moved AS (
+                UPDATE posts SET author_id = (SELECT id FROM placeholder)
+                WHERE author_id = $1
+                RETURNING id
+            )
+            UPDATE media SET owner_id = (SELECT id FROM placeholder)
+            WHERE owner_id = $1 AND id IN (SELECT media_id FROM post_media WHERE post_id IN (SELECT id FROM moved))`, userID)
+    } else {
+        _, err = tx.ExecContext(ctx, `DELETE FROM posts WHERE author_id = $1`, userID)
+    }
+    if err != nil {
+        return false, err
+    }
+
+    if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID); err != nil {
+        return false, err
+    }
+    if err := tx.Commit(); err != nil {
+        return false, err
+    }
+
+    logging.FromContext(ctx).Info("user erased", "user_id", userID, "posts_kept", keepPosts)
+    return true, nil
+}
@@ -0,0 +1,372 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/secretbox"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tracing"
+	"github.com/lib/pq"
+)
+
+// WebhookRepository stores webhook subscriptions and their deliveries,
+// whose pending rows double as the retry queue. Signing secrets are
+// encrypted with box.
+type WebhookRepository struct {
+	db  *sql.DB
+	box *secretbox.Box
+}
+
+func NewWebhookRepository(db *sql.DB, box *secretbox.Box) *WebhookRepository {
+	return &WebhookRepository{db: db, box: box}
+}
+
+const subscriptionColumns = `
+	id, url, events, secret, description, active, consecutive_failures,
+	disabled_reason, created_by, created_at, updated_at`
+
+func (r *WebhookRepository) CreateSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.CreateSubscription", "INSERT", "webhook_subscriptions")
+	defer span.End()
+
+	sealed, err := r.box.Seal(sub.Secret)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO webhook_subscriptions (url, events, secret, description, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, active, created_at, updated_at`
+	return r.db.QueryRowContext(ctx, query, sub.URL, pq.Array(sub.Events), sealed, sub.Description, sub.CreatedBy).
+		Scan(&sub.ID, &sub.Active, &sub.CreatedAt, &sub.UpdatedAt)
+}
+
+// GetSubscription returns the subscription with its secret, or nil if
+// there is none.
+func (r *WebhookRepository) GetSubscription(ctx context.Context, id int64) (*models.WebhookSubscription, error) {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.GetSubscription", "SELECT", "webhook_subscriptions")
+	defer span.End()
+
+	query := `SELECT ` + subscriptionColumns + ` FROM webhook_subscriptions WHERE id = $1`
+	sub, err := r.scanSubscription(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return sub, err
+}
+
+func (r *WebhookRepository) ListSubscriptions(ctx context.Context) ([]*models.WebhookSubscription, error) {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.ListSubscriptions", "SELECT", "webhook_subscriptions")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+subscriptionColumns+` FROM webhook_subscriptions ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []*models.WebhookSubscription
+	for rows.Next() {
+		sub, err := r.scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// UpdateSubscription saves the URL, events, description and active flag
+// of a subscription. Reactivating one clears its failure count and the
+// reason it was disabled. It returns sql.ErrNoRows if the subscription
+// does not exist.
+func (r *WebhookRepository) UpdateSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.UpdateSubscription", "UPDATE", "webhook_subscriptions")
+	defer span.End()
+
+	query := `
+		UPDATE webhook_subscriptions SET
+			url = $1, events = $2, description = $3, active = $4,
+			consecutive_failures = CASE WHEN $4 AND NOT active THEN 0 ELSE consecutive_failures END,
+			disabled_reason = CASE WHEN $4 THEN '' ELSE disabled_reason END,
+			updated_at = NOW()
+		WHERE id = $5
+		RETURNING consecutive_failures, disabled_reason, updated_at`
+	return r.db.QueryRowContext(ctx, query, sub.URL, pq.Array(sub.Events), sub.Description, sub.Active, sub.ID).
+		Scan(&sub.ConsecutiveFailures, &sub.DisabledReason, &sub.UpdatedAt)
+}
+
+// DeleteSubscription removes a subscription and its delivery log. It
+// returns sql.ErrNoRows if there is no such subscription.
+func (r *WebhookRepository) DeleteSubscription(ctx context.Context, id int64) error {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.DeleteSubscription", "DELETE", "webhook_subscriptions")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (r *WebhookRepository) scanSubscription(row interface{ Scan(...interface{}) error }) (*models.WebhookSubscription, error) {
+	sub := &models.WebhookSubscription{}
+	var sealed string
+	err := row.Scan(
+		&sub.ID,
+		&sub.URL,
+		pq.Array(&sub.Events),
+		&sealed,
+		&sub.Description,
+		&sub.Active,
+		&sub.ConsecutiveFailures,
+		&sub.DisabledReason,
+		&sub.CreatedBy,
+		&sub.CreatedAt,
+		&sub.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Secret, err = r.box.Open(sealed); err != nil {
+		return nil, fmt.Errorf("error decrypting webhook secret: %w", err)
+	}
+	return sub, nil
+}
+
+const deliveryColumns = `
+	d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+	d.next_attempt_at, d.response_status, d.response_body, d.error, d.duration_ms,
+	d.created_at, d.completed_at`
+
+// Enqueue queues payload for every active subscription to eventType that
+// does not have the event yet, and returns how many deliveries were
+// queued.
+func (r *WebhookRepository) Enqueue(ctx context.Context, eventID, eventType, payload string) (int64, error) {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.Enqueue", "INSERT", "webhook_deliveries")
+	defer span.End()
+
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+		SELECT s.id, $1, $2, $3 FROM webhook_subscriptions s
+		WHERE s.active AND $2 = ANY(s.events)
+		  AND NOT EXISTS (
+			SELECT 1 FROM webhook_deliveries d WHERE d.event_id = $1 AND d.subscription_id = s.id
+		  )`
+	result, err := r.db.ExecContext(ctx, query, eventID, eventType, payload)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Claim leases the delivery that has been due longest until now+lease,
+// counting the attempt, or returns nil when none is due. Deliveries of
+// inactive subscriptions wait until the subscription is reactivated.
+// Concurrent callers never claim the same delivery.
+func (r *WebhookRepository) Claim(ctx context.Context, lease time.Duration) (*models.WebhookDelivery, error) {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.Claim", "UPDATE", "webhook_deliveries")
+	defer span.End()
+
+	query := `
+		UPDATE webhook_deliveries d SET attempts = attempts + 1, locked_until = $1
+		WHERE d.id = (
+			SELECT q.id FROM webhook_deliveries q
+			JOIN webhook_subscriptions s ON s.id = q.subscription_id
+			WHERE q.status = 'pending' AND q.next_attempt_at <= NOW()
+			  AND (q.locked_until IS NULL OR q.locked_until < NOW())
+			  AND s.active
+			ORDER BY q.next_attempt_at
+			FOR UPDATE OF q SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + deliveryColumns
+
+	d, err := scanDelivery(r.db.QueryRowContext(ctx, query, time.Now().Add(lease)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return d, err
+}
+
+// Delivered records a successful attempt and resets the failure count of
+// the subscription.
+func (r *WebhookRepository) Delivered(ctx context.Context, d *models.WebhookDelivery) error {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.Delivered", "UPDATE", "webhook_deliveries")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	d.Status = models.WebhookDelivered
+	if err := recordAttempt(ctx, tx, d, nil); err != nil {
+		return err
+	}
+	query := `UPDATE webhook_subscriptions SET consecutive_failures = 0 WHERE id = $1 AND consecutive_failures > 0`
+	if _, err := tx.ExecContext(ctx, query, d.SubscriptionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Failed records a failed attempt. The delivery is retried at retryAt,
+// or marked failed when retryAt is nil. The subscription is deactivated
+// when this makes disableAfter failures in a row; Failed reports whether
+// it was.
+func (r *WebhookRepository) Failed(ctx context.Context, d *models.WebhookDelivery, retryAt *time.Time, disableAfter int) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.Failed", "UPDATE", "webhook_deliveries")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	d.Status = models.WebhookFailed
+	if retryAt != nil {
+		d.Status = models.WebhookPending
+	}
+	if err := recordAttempt(ctx, tx, d, retryAt); err != nil {
+		return false, err
+	}
+
+	reason := fmt.Sprintf("disabled after %d failed delivery attempts in a row", disableAfter)
+	query := `
+		UPDATE webhook_subscriptions SET
+			consecutive_failures = consecutive_failures + 1,
+			active = active AND ($2 = 0 OR consecutive_failures + 1 < $2),
+			disabled_reason = CASE
+				WHEN active AND $2 > 0 AND consecutive_failures + 1 >= $2 THEN $3
+				ELSE disabled_reason END,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING NOT active AND consecutive_failures = $2 AND disabled_reason = $3`
+	var disabled bool
+	err = tx.QueryRowContext(ctx, query, d.SubscriptionID, disableAfter, reason).Scan(&disabled)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	return disabled, tx.Commit()
+}
+
+// recordAttempt saves the outcome of the latest attempt at d and releases
+// its lease.
+func recordAttempt(ctx context.Context, tx *sql.Tx, d *models.WebhookDelivery, retryAt *time.Time) error {
+	query := `
+		UPDATE webhook_deliveries SET
+			status = $1, next_attempt_at = COALESCE($2, next_attempt_at), locked_until = NULL,
+			response_status = $3, response_body = $4, error = $5, duration_ms = $6,
+			completed_at = CASE WHEN $1 = 'pending' THEN NULL ELSE NOW() END
+		WHERE id = $7
+		RETURNING next_attempt_at, completed_at`
+	err := tx.QueryRowContext(ctx, query,
+		d.Status, retryAt, d.ResponseStatus, d.ResponseBody, d.Error, d.Duration.Milliseconds(), d.ID,
+	).Scan(&d.NextAttemptAt, &d.CompletedAt)
+	if err == sql.ErrNoRows {
+		// The subscription was deleted during the attempt
+		return nil
+	}
+	return err
+}
+
+// ListDeliveries returns the delivery log of a subscription, newest first.
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, subscriptionID int64, after *Cursor, limit int) ([]*models.WebhookDelivery, error) {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.ListDeliveries", "SELECT", "webhook_deliveries")
+	defer span.End()
+
+	query := `
+		SELECT ` + deliveryColumns + `
+		FROM webhook_deliveries d
+		WHERE d.subscription_id = $1 AND (d.created_at, d.id) < ($2, $3)
+		ORDER BY d.created_at DESC, d.id DESC
+		LIMIT $4`
+	at, id := after.after()
+	rows, err := r.db.QueryContext(ctx, query, subscriptionID, at, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*models.WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// GetDelivery returns a delivery of the subscription, or nil if it has
+// none with that ID.
+func (r *WebhookRepository) GetDelivery(ctx context.Context, subscriptionID, id int64) (*models.WebhookDelivery, error) {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.GetDelivery", "SELECT", "webhook_deliveries")
+	defer span.End()
+
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries d WHERE d.id = $1 AND d.subscription_id = $2`
+	d, err := scanDelivery(r.db.QueryRowContext(ctx, query, id, subscriptionID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return d, err
+}
+
+// Replay queues the payload of a logged delivery again under the same
+// event ID, so receivers that deduplicate events still can.
+func (r *WebhookRepository) Replay(ctx context.Context, d *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.Replay", "INSERT", "webhook_deliveries")
+	defer span.End()
+
+	query := `
+		INSERT INTO webhook_deliveries AS d (subscription_id, event_id, event_type, payload)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + deliveryColumns
+	return scanDelivery(r.db.QueryRowContext(ctx, query, d.SubscriptionID, d.EventID, d.EventType, d.Payload))
+}
+
+// PruneDeliveries removes deliveries that finished before t and returns
+// how many were removed.
+func (r *WebhookRepository) PruneDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := tracing.StartQuery(ctx, "WebhookRepository.PruneDeliveries", "DELETE", "webhook_deliveries")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE completed_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func scanDelivery(row interface{ Scan(...interface{}) error }) (*models.WebhookDelivery, error) {
+	d := &models.WebhookDelivery{}
+	var durationMS int64
+	err := row.Scan(
+		&d.ID,
+		&d.SubscriptionID,
+		&d.EventID,
+		&d.EventType,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&d.NextAttemptAt,
+		&d.ResponseStatus,
+		&d.ResponseBody,
+		&d.Error,
+		&durationMS,
+		&d.CreatedAt,
+		&d.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	d.Duration = time.Duration(durationMS) * time.Millisecond
+	return d, nil
+}
@@ -0,0 +1,30 @@
+// Package retry spaces out the retries of background work that failed,
+// such as webhook deliveries and outbox events.
+package retry
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff is the wait before retrying after the nth failed attempt: base,
+// doubling with each attempt up to max.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	wait := base
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
+
+// Jitter adds up to a tenth of wait at random, so work that failed
+// together is not all retried together.
+func Jitter(wait time.Duration) time.Duration {
+	if wait < 10 {
+		return wait
+	}
+	return wait + rand.N(wait/10)
+}
@@ -0,0 +1,27 @@
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	base, max := 30*time.Second, time.Hour
+	assert.Equal(t, 30*time.Second, Backoff(1, base, max))
+	assert.Equal(t, time.Minute, Backoff(2, base, max))
+	assert.Equal(t, 2*time.Minute, Backoff(3, base, max))
+	assert.Equal(t, 32*time.Minute, Backoff(7, base, max))
+	assert.Equal(t, time.Hour, Backoff(8, base, max))
+	assert.Equal(t, time.Hour, Backoff(1000, base, max))
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		got := Jitter(time.Minute)
+		assert.GreaterOrEqual(t, got, time.Minute)
+		assert.Less(t, got, time.Minute+6*time.Second)
+	}
+	assert.Equal(t, time.Duration(5), Jitter(5))
+}
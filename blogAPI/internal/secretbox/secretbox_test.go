@@ -0,0 +1,71 @@
+package secretbox
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	box, err := New("test-key")
+	require.NoError(t, err)
+
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, "JBSWY3DPEHPK3PXP")
+
+	opened, err := box.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", opened)
+
+	// Every seal uses a fresh nonce
+	again, err := box.Seal("JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again)
+
+	empty, err := box.Seal("")
+	require.NoError(t, err)
+	opened, err = box.Open(empty)
+	require.NoError(t, err)
+	assert.Empty(t, opened)
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	box, err := New("test-key")
+	require.NoError(t, err)
+	sealed, err := box.Seal("whsec_secret")
+	require.NoError(t, err)
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	require.NoError(t, err)
+	for _, i := range []int{0, len(data) / 2, len(data) - 1} {
+		tampered := append([]byte(nil), data...)
+		tampered[i] ^= 0x01
+		_, err := box.Open(base64.StdEncoding.EncodeToString(tampered))
+		assert.Error(t, err, "byte %d", i)
+	}
+
+	_, err = box.Open(base64.StdEncoding.EncodeToString(data[:4]))
+	assert.Error(t, err)
+	_, err = box.Open("not base64!")
+	assert.Error(t, err)
+}
+
+func TestOpenRejectsWrongKey(t *testing.T) {
+	box, err := New("test-key")
+	require.NoError(t, err)
+	other, err := New("other-key")
+	require.NoError(t, err)
+
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	_, err = other.Open(sealed)
+	assert.Error(t, err)
+}
+
+func TestNewRejectsEmptyKey(t *testing.T) {
+	_, err := New("")
+	assert.Error(t, err)
+}
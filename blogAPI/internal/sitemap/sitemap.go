@@ -0,0 +1,80 @@
+// Package sitemap writes sitemaps and sitemap indexes in the format of
+// https://www.sitemaps.org/protocol.html, one entry at a time so a large
+// site never has to be held in memory.
+package sitemap
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"time"
+)
+
+// MaxURLs is the most entries the protocol allows in one sitemap or
+// sitemap index.
+const MaxURLs = 50000
+
+const namespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// ErrFull is returned by Add once a writer holds MaxURLs entries.
+var ErrFull = errors.New("sitemap: too many entries")
+
+// Writer streams the entries of a sitemap or sitemap index.
+type Writer struct {
+	enc   *xml.Encoder
+	root  string
+	entry string
+	n     int
+}
+
+// NewURLSet starts a sitemap of pages on w.
+func NewURLSet(w io.Writer) (*Writer, error) {
+	return start(w, "urlset", "url")
+}
+
+// NewIndex starts a sitemap index, which lists other sitemaps, on w.
+func NewIndex(w io.Writer) (*Writer, error) {
+	return start(w, "sitemapindex", "sitemap")
+}
+
+func start(w io.Writer, root, entry string) (*Writer, error) {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return nil, err
+	}
+	enc := xml.NewEncoder(w)
+	err := enc.EncodeToken(xml.StartElement{
+		Name: xml.Name{Local: root},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: namespace}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{enc: enc, root: root, entry: entry}, nil
+}
+
+type entry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Add writes an entry for the absolute URL loc. A zero lastMod is left
+// out.
+func (w *Writer) Add(loc string, lastMod time.Time) error {
+	if w.n == MaxURLs {
+		return ErrFull
+	}
+	w.n++
+	e := entry{Loc: loc}
+	if !lastMod.IsZero() {
+		e.LastMod = lastMod.UTC().Format(time.RFC3339)
+	}
+	return w.enc.EncodeElement(e, xml.StartElement{Name: xml.Name{Local: w.entry}})
+}
+
+// Close ends the document and flushes it to the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: w.root}}); err != nil {
+		return err
+	}
+	return w.enc.Flush()
+}
@@ -0,0 +1,56 @@
+package sitemap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLSet(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewURLSet(&buf)
+	require.NoError(t, err)
+	modified := time.Date(2024, 5, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	require.NoError(t, w.Add("https://example.com/posts/a?x=1&y=2", modified))
+	require.NoError(t, w.Add("https://example.com/posts/b", time.Time{}))
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, xml.Header+`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`+
+		`<url><loc>https://example.com/posts/a?x=1&amp;y=2</loc><lastmod>2024-05-01T10:30:00Z</lastmod></url>`+
+		`<url><loc>https://example.com/posts/b</loc></url>`+
+		`</urlset>`, buf.String())
+}
+
+func TestIndex(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewIndex(&buf)
+	require.NoError(t, err)
+	require.NoError(t, w.Add("https://example.com/sitemaps/posts-1.xml", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)))
+	require.NoError(t, w.Close())
+
+	var index struct {
+		XMLName  xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+		Sitemaps []struct {
+			Loc     string `xml:"loc"`
+			LastMod string `xml:"lastmod"`
+		} `xml:"sitemap"`
+	}
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &index))
+	require.Len(t, index.Sitemaps, 1)
+	assert.Equal(t, "https://example.com/sitemaps/posts-1.xml", index.Sitemaps[0].Loc)
+	assert.Equal(t, "2024-05-01T00:00:00Z", index.Sitemaps[0].LastMod)
+}
+
+func TestLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewURLSet(&buf)
+	require.NoError(t, err)
+	for i := 0; i < MaxURLs; i++ {
+		require.NoError(t, w.Add("https://example.com/", time.Time{}))
+	}
+	assert.ErrorIs(t, w.Add("https://example.com/", time.Time{}), ErrFull)
+}
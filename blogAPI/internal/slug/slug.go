@@ -0,0 +1,129 @@
+// Package slug turns post titles into the readable identifiers used in
+// permalinks.
+package slug
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxLength bounds the length of a slug made from a title. A collision
+// suffix may add a few characters.
+const MaxLength = 80
+
+// Fallback is the slug of a title with nothing left to transliterate,
+// such as one written only in a script without a table below.
+const Fallback = "post"
+
+// The permalink schemes.
+const (
+	// SchemeSlug addresses posts as /posts/{slug}.
+	SchemeSlug = "slug"
+	// SchemeDate addresses posts as /{yyyy}/{mm}/{dd}/{slug}, using the
+	// day the post was created in UTC.
+	SchemeDate = "date"
+)
+
+var valid = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// transliterations spells letters that do not decompose into ASCII.
+var transliterations = map[rune]string{
+	// Latin
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'ł': "l",
+	'þ': "th", 'ı': "i", 'ħ': "h", 'ŋ': "ng",
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g", 'д': "d", 'е': "e",
+	'ё': "yo", 'є': "ye", 'ж': "zh", 'з': "z", 'и': "i", 'і': "i", 'ї': "yi",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p",
+	'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e",
+	'ю': "yu", 'я': "ya",
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+	'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// Make returns the slug of title: lower-case ASCII letters and digits in
+// words separated by single hyphens. Accents are dropped, Cyrillic and
+// Greek are transliterated, and anything else separates words. It returns
+// Fallback when nothing is left.
+func Make(title string) string {
+	var b strings.Builder
+	hyphen := false
+	write := func(s string) {
+		if s == "" {
+			return
+		}
+		if hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		hyphen = false
+		b.WriteString(s)
+	}
+
+	for _, r := range title {
+		r = unicode.ToLower(r)
+		if t, ok := transliterations[r]; ok {
+			write(t)
+			continue
+		}
+		for _, d := range norm.NFKD.String(string(r)) {
+			switch {
+			case 'a' <= d && d <= 'z' || '0' <= d && d <= '9':
+				write(string(d))
+			case unicode.Is(unicode.Mn, d):
+			default:
+				if t, ok := transliterations[unicode.ToLower(d)]; ok {
+					write(t)
+				} else if d != '\'' && d != '’' {
+					// Apostrophes join rather than split words, so
+					// "don't" becomes dont.
+					hyphen = true
+				}
+			}
+		}
+	}
+
+	s := b.String()
+	if len(s) > MaxLength {
+		s = s[:MaxLength]
+		if i := strings.LastIndexByte(s, '-'); i > 0 {
+			s = s[:i]
+		}
+		s = strings.TrimSuffix(s, "-")
+	}
+	if s == "" {
+		return Fallback
+	}
+	return s
+}
+
+// WithSuffix returns the nth candidate for a slug whose base is taken:
+// base itself for n < 2, otherwise base-n.
+func WithSuffix(base string, n int) string {
+	if n < 2 {
+		return base
+	}
+	return base + "-" + strconv.Itoa(n)
+}
+
+// Valid reports whether s could be a slug, so malformed paths are refused
+// before reaching the database.
+func Valid(s string) bool {
+	return len(s) <= MaxLength+12 && valid.MatchString(s)
+}
+
+// Path returns the permalink path of a post under scheme.
+func Path(scheme, slug string, createdAt time.Time) string {
+	if scheme == SchemeDate {
+		return createdAt.UTC().Format("/2006/01/02/") + slug
+	}
+	return "/posts/" + slug
+}
@@ -0,0 +1,64 @@
+package slug
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  Go 1.23 --- what's new?  ", "go-1-23-whats-new"},
+		{"Crème Brûlée à la française", "creme-brulee-a-la-francaise"},
+		{"Straße und Ærø", "strasse-und-aero"},
+		{"Łódź", "lodz"},
+		{"Привет, мир", "privet-mir"},
+		{"Щука и ёж", "shchuka-i-yozh"},
+		{"Καλημέρα κόσμε", "kalimera-kosme"},
+		{"ﬁne ①", "fine-1"},
+		{"日本語", Fallback},
+		{"", Fallback},
+		{"!!!", Fallback},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Make(tt.title), tt.title)
+		assert.True(t, Valid(Make(tt.title)), tt.title)
+	}
+}
+
+func TestMakeTruncatesAtWordBoundary(t *testing.T) {
+	s := Make(strings.Repeat("word ", 40))
+	assert.LessOrEqual(t, len(s), MaxLength)
+	assert.True(t, strings.HasSuffix(s, "-word"), s)
+	assert.True(t, Valid(s))
+
+	s = Make(strings.Repeat("a", 100))
+	assert.Len(t, s, MaxLength)
+}
+
+func TestWithSuffix(t *testing.T) {
+	assert.Equal(t, "hello", WithSuffix("hello", 1))
+	assert.Equal(t, "hello-2", WithSuffix("hello", 2))
+	assert.Equal(t, "hello-10", WithSuffix("hello", 10))
+}
+
+func TestValid(t *testing.T) {
+	for _, s := range []string{"a", "hello-world", "go-1-23"} {
+		assert.True(t, Valid(s), s)
+	}
+	for _, s := range []string{"", "-a", "a-", "a--b", "Hello", "a_b", "a/b", "%2e%2e"} {
+		assert.False(t, Valid(s), s)
+	}
+}
+
+func TestPath(t *testing.T) {
+	created := time.Date(2024, 3, 9, 23, 30, 0, 0, time.FixedZone("EST", -5*3600))
+	assert.Equal(t, "/posts/hello", Path(SchemeSlug, "hello", created))
+	assert.Equal(t, "/2024/03/10/hello", Path(SchemeDate, "hello", created))
+}
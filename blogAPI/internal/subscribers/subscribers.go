@@ -0,0 +1,182 @@
+// Package subscribers holds the side effects of domain events: the work
+// that follows a change but is not part of it, such as emails and
+// webhooks. Each runs from the outbox, after the change has committed,
+// and may run more than once for an event.
+package subscribers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/events"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/metrics"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/outbox"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/tokens"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/views"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/webhook"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/utils"
+)
+
+type Subscribers struct {
+	userRepo  *repository.UserRepository
+	postRepo  *repository.PostRepository
+	mediaRepo *repository.MediaRepository
+	resetRepo *repository.PasswordResetRepository
+	magicRepo *repository.MagicLinkRepository
+	webhooks  *webhook.Dispatcher
+	config    config.Config
+}
+
+func New(
+	userRepo *repository.UserRepository,
+	postRepo *repository.PostRepository,
+	mediaRepo *repository.MediaRepository,
+	resetRepo *repository.PasswordResetRepository,
+	magicRepo *repository.MagicLinkRepository,
+	webhooks *webhook.Dispatcher,
+	config config.Config) *Subscribers {
+
+	return &Subscribers{
+		userRepo:  userRepo,
+		postRepo:  postRepo,
+		mediaRepo: mediaRepo,
+		resetRepo: resetRepo,
+		magicRepo: magicRepo,
+		webhooks:  webhooks,
+		config:    config,
+	}
+}
+
+// Register subscribes every side effect to bus.
+func (s *Subscribers) Register(bus *outbox.Dispatcher) {
+	outbox.Subscribe(bus, "password_reset_email", s.sendPasswordReset)
+	outbox.Subscribe(bus, "magic_link_email", s.sendMagicLink)
+	outbox.Subscribe(bus, "webhooks", s.userRegisteredWebhook)
+	outbox.Subscribe(bus, "webhooks", s.postPublishedWebhook)
+	outbox.Subscribe(bus, "webhooks", s.postUpdatedWebhook)
+}
+
+// sendPasswordReset makes the reset token and emails it. Handling the
+// event again replaces the token, so only the last one sent works. A reset
+// that was used or has expired by then is not sent.
+func (s *Subscribers) sendPasswordReset(ctx context.Context, m outbox.Message[events.PasswordResetRequested]) error {
+	reset, err := s.resetRepo.GetByID(ctx, m.Event.ResetID)
+	if err != nil {
+		return err
+	}
+	if reset == nil || reset.Used || time.Now().After(reset.ExpiredAt) {
+		return nil
+	}
+	user, err := s.userRepo.GetByID(ctx, reset.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	token, err := tokens.Generate()
+	if err != nil {
+		return err
+	}
+	if err := s.resetRepo.SetToken(ctx, reset.ID, tokens.Hash(token)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Used since it was loaded
+			return nil
+		}
+		return err
+	}
+	if err := utils.SendPasswordResetEmail(ctx, user.Email, token, s.config); err != nil {
+		return fmt.Errorf("sending password reset email: %w", err)
+	}
+	metrics.ResetEmailsSent.Inc()
+	logging.FromContext(ctx).Info("password reset email sent", "user_id", user.ID)
+	return nil
+}
+
+// sendMagicLink makes the token for a passwordless sign-in and emails the
+// link. Handling the event again replaces the token, so only the last
+// link sent works. A sign-in that was confirmed, used or has expired by
+// then is not sent.
+func (s *Subscribers) sendMagicLink(ctx context.Context, m outbox.Message[events.MagicLinkRequested]) error {
+	link, err := s.magicRepo.GetByID(ctx, m.Event.LinkID)
+	if err != nil {
+		return err
+	}
+	if link == nil || link.ConfirmedAt != nil || link.Used || time.Now().After(link.ExpiredAt) {
+		return nil
+	}
+	user, err := s.userRepo.GetByID(ctx, link.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	token, err := tokens.Generate()
+	if err != nil {
+		return err
+	}
+	if err := s.magicRepo.SetToken(ctx, link.ID, tokens.Hash(token)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Confirmed or used since it was loaded
+			return nil
+		}
+		return err
+	}
+	if err := utils.SendMagicLinkEmail(ctx, user.Email, token, link.ExpiredAt, s.config); err != nil {
+		return fmt.Errorf("sending magic link email: %w", err)
+	}
+	logging.FromContext(ctx).Info("magic link email sent", "user_id", user.ID)
+	return nil
+}
+
+func (s *Subscribers) userRegisteredWebhook(ctx context.Context, m outbox.Message[events.UserRegistered]) error {
+	user, err := s.userRepo.GetByID(ctx, m.Event.UserID)
+	if err != nil || user == nil {
+		return err
+	}
+	return s.sendWebhooks(ctx, m.ID, m.OccurredAt, views.NewAuthor(user), webhook.EventUserRegistered)
+}
+
+// postPublishedWebhook sends both post.created and post.published, as
+// posts are published when they are created.
+func (s *Subscribers) postPublishedWebhook(ctx context.Context, m outbox.Message[events.PostPublished]) error {
+	return s.postWebhooks(ctx, m.ID, m.OccurredAt, m.Event.PostID, webhook.EventPostCreated, webhook.EventPostPublished)
+}
+
+func (s *Subscribers) postUpdatedWebhook(ctx context.Context, m outbox.Message[events.PostUpdated]) error {
+	return s.postWebhooks(ctx, m.ID, m.OccurredAt, m.Event.PostID, webhook.EventPostUpdated)
+}
+
+// postWebhooks sends the post as it is now, so a post deleted since the
+// event sends nothing.
+func (s *Subscribers) postWebhooks(ctx context.Context, id int64, at time.Time, postID int64, types ...string) error {
+	post, err := s.postRepo.GetByID(ctx, postID)
+	if err != nil || post == nil {
+		return err
+	}
+	if err := s.mediaRepo.LoadForPosts(ctx, post); err != nil {
+		return err
+	}
+	return s.sendWebhooks(ctx, id, at, views.NewPost(post), types...)
+}
+
+// sendWebhooks queues data under each of the webhook event types. The
+// webhook event IDs are derived from the outbox event, so handling it
+// again queues nothing new.
+func (s *Subscribers) sendWebhooks(ctx context.Context, id int64, at time.Time, data interface{}, types ...string) error {
+	for _, typ := range types {
+		if err := s.webhooks.Enqueue(ctx, fmt.Sprintf("evt_%d_%s", id, typ), at, typ, data); err != nil {
+			return fmt.Errorf("queueing %s webhooks: %w", typ, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package views
+
+import (
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+)
+
+// DataExport is what a user sees about an archive of their data. The
+// download token is only ever emailed.
+type DataExport struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	Status      string     `json:"status"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (DataExport) view() {}
+
+func NewDataExport(export *models.DataExport) DataExport {
+	return DataExport{
+		ID:          export.ID,
+		UserID:      export.UserID,
+		Status:      export.Status,
+		ExpiresAt:   export.ExpiresAt,
+		CompletedAt: export.CompletedAt,
+		CreatedAt:   export.CreatedAt,
+	}
+}
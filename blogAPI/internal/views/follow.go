@@ -0,0 +1,21 @@
+package views
+
+import "github.com/anoying-kid/go-apps/blogAPI/internal/models"
+
+// Follows is a page of a user's followers, or of the users they follow,
+// with the total count.
+type Follows struct {
+	Count int64 `json:"count"`
+	Page[Author]
+}
+
+// NewFollows builds the view of one page of follows.
+func NewFollows(count int64, follows []*models.Follow, nextCursor string) Follows {
+	return Follows{
+		Count: count,
+		Page: Page[Author]{
+			Items:      NewList(follows, func(f *models.Follow) Author { return NewAuthor(f.User) }),
+			NextCursor: nextCursor,
+		},
+	}
+}
@@ -0,0 +1,68 @@
+package views
+
+import (
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+)
+
+// mediaBaseURL is prefixed to storage keys to form media URLs.
+var mediaBaseURL = "/media/"
+
+// SetMediaBaseURL sets the prefix of media URLs from configuration. It is
+// called once at startup.
+func SetMediaBaseURL(base string) {
+	if base != "" && base[len(base)-1] != '/' {
+		base += "/"
+	}
+	mediaBaseURL = base
+}
+
+func mediaURL(key string) string {
+	return mediaBaseURL + key
+}
+
+// Media is an uploaded image. Its variants are listed once the status is
+// ready; until then, or if making them failed, only the original is
+// available.
+type Media struct {
+	ID          int64              `json:"id"`
+	URL         string             `json:"url"`
+	ContentType string             `json:"content_type"`
+	Width       int                `json:"width"`
+	Height      int                `json:"height"`
+	Size        int64              `json:"size"`
+	Status      string             `json:"status"`
+	Variants    List[MediaVariant] `json:"variants"`
+	CreatedAt   time.Time          `json:"created_at"`
+}
+
+func (Media) view() {}
+
+func NewMedia(media *models.Media) Media {
+	return Media{
+		ID:          media.ID,
+		URL:         mediaURL(media.Key),
+		ContentType: media.ContentType,
+		Width:       media.Width,
+		Height:      media.Height,
+		Size:        media.Size,
+		Status:      media.Status,
+		Variants:    NewList(media.Variants, NewMediaVariant),
+		CreatedAt:   media.CreatedAt,
+	}
+}
+
+type MediaVariant struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
+func (MediaVariant) view() {}
+
+func NewMediaVariant(v models.MediaVariant) MediaVariant {
+	return MediaVariant{Name: v.Name, URL: mediaURL(v.Key), ContentType: v.ContentType, Width: v.Width, Height: v.Height}
+}
@@ -0,0 +1,76 @@
+package views
+
+import (
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/slug"
+)
+
+// permalinkScheme is the slug scheme used to build post permalinks.
+var permalinkScheme = slug.SchemeSlug
+
+// SetPermalinkScheme sets the permalink scheme from configuration. It is
+// called once at startup.
+func SetPermalinkScheme(scheme string) {
+	permalinkScheme = scheme
+}
+
+type Post struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Slug  string `json:"slug"`
+	// Permalink is the post's path on the site, such as /posts/{slug}.
+	Permalink string  `json:"permalink"`
+	Body      string  `json:"body"`
+	AuthorID  int64   `json:"author_id"`
+	Author    *Author `json:"author,omitempty"`
+	// Reactions lists the kinds of reaction the post has had, with totals.
+	Reactions List[Reaction] `json:"reactions"`
+	// Media lists the images attached to the post; FeaturedImage is the
+	// one to show in previews, or null.
+	Media         List[Media] `json:"media"`
+	FeaturedImage *Media      `json:"featured_image"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+}
+
+func (Post) view() {}
+
+func NewPost(post *models.Post) Post {
+	v := Post{
+		ID:        post.ID,
+		Title:     post.Title,
+		Slug:      post.Slug,
+		Permalink: slug.Path(permalinkScheme, post.Slug, post.CreatedAt),
+		Body:      post.Body,
+		AuthorID:  post.AuthorID,
+		CreatedAt: post.CreatedAt,
+		UpdatedAt: post.UpdatedAt,
+		Reactions: NewList(post.Reactions, NewReaction),
+		Media:     NewList(post.Media, NewMedia),
+	}
+	if post.FeaturedImage != nil {
+		featured := NewMedia(post.FeaturedImage)
+		v.FeaturedImage = &featured
+	}
+	if post.Author != nil {
+		author := NewAuthor(post.Author)
+		v.Author = &author
+	}
+	return v
+}
+
+// Reaction is the total of one kind of reaction to a post. Reacted is set
+// when the user viewing the post gave it.
+type Reaction struct {
+	Kind    string `json:"kind"`
+	Count   int64  `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+func (Reaction) view() {}
+
+func NewReaction(r models.ReactionCount) Reaction {
+	return Reaction{Kind: r.Kind, Count: r.Count, Reacted: r.Reacted}
+}
@@ -0,0 +1,74 @@
+package views
+
+import (
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+)
+
+// Author is what anyone may see about an account: its public profile, as
+// shown on posts and at /api/users/{username}.
+type Author struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	Website     string `json:"website"`
+	AvatarURL   string `json:"avatar_url"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func (Author) view() {}
+
+func NewAuthor(user *models.User) Author {
+	return Author{
+		ID:          user.ID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		Website:     user.Website,
+		AvatarURL:   user.AvatarURL,
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+// Self is what users see about their own account.
+type Self struct {
+	Author
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	UpdatedAt string `json:"updated_at"`
+	// PendingEmail is set right after asking to change the email, until
+	// the new address is confirmed.
+	PendingEmail string `json:"pending_email,omitempty"`
+	// DeletionScheduledAt is when the account will be erased, unless the
+	// user cancels first.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+func NewSelf(user *models.User) Self {
+	return Self{
+		Author:    NewAuthor(user),
+		Email:     user.Email,
+		Role:      user.Role,
+		UpdatedAt: user.UpdatedAt,
+
+		DeletionScheduledAt: user.DeletionScheduledAt,
+	}
+}
+
+// Admin is what administrators see about any account, including its login
+// protection state. It still never includes the password hash.
+type Admin struct {
+	Self
+	FailedLoginAttempts int        `json:"failed_login_attempts"`
+	LockedUntil         *time.Time `json:"locked_until"`
+}
+
+func NewAdmin(user *models.User) Admin {
+	return Admin{
+		Self:                NewSelf(user),
+		FailedLoginAttempts: user.FailedLoginAttempts,
+		LockedUntil:         user.LockedUntil,
+	}
+}
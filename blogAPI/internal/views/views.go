@@ -0,0 +1,47 @@
+// Package views holds the shapes in which domain models leave the API.
+// Each view lists exactly the fields its audience may see, so adding a
+// field to a model never changes a response by accident; it has to be
+// added to a view too.
+package views
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// View is implemented only by the types in this package, so JSON cannot be
+// handed a domain model.
+type View interface {
+	view()
+}
+
+// JSON writes v as the response body with the given status.
+func JSON(w http.ResponseWriter, status int, v View) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// List is a list of views of the same kind.
+type List[T View] []T
+
+func (List[T]) view() {}
+
+// NewList builds the views of items with fn. It never returns nil, so an
+// empty list is written as [] rather than null.
+func NewList[M any, T View](items []M, fn func(M) T) List[T] {
+	list := make(List[T], 0, len(items))
+	for _, item := range items {
+		list = append(list, fn(item))
+	}
+	return list
+}
+
+// Page is one page of a list read with a cursor. NextCursor is empty on
+// the last page.
+type Page[T View] struct {
+	Items      List[T] `json:"items"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+func (Page[T]) view() {}
@@ -0,0 +1,155 @@
+package views
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const passwordHash = "$argon2id$v=19$m=65536,t=3,p=4$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNo"
+
+func testUser() *models.User {
+	locked := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	deletion := time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &models.User{
+		ID:                  7,
+		Username:            "alice",
+		Password:            passwordHash,
+		Email:               "alice@example.com",
+		Role:                models.RoleAdmin,
+		DisplayName:         "Alice",
+		Bio:                 "Writes about Go.",
+		Website:             "https://alice.example.com",
+		AvatarURL:           "https://alice.example.com/me.png",
+		CreatedAt:           "2024-01-01T00:00:00Z",
+		UpdatedAt:           "2024-02-01T00:00:00Z",
+		FailedLoginAttempts: 4,
+		LockedUntil:         &locked,
+		DeletionScheduledAt: &deletion,
+	}
+}
+
+func keys(t *testing.T, v interface{}) []string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TestViewFields pins the fields of every view, so exposing another one
+// takes a deliberate change here.
+func TestViewFields(t *testing.T) {
+	author := []string{"avatar_url", "bio", "created_at", "display_name", "id", "username", "website"}
+	self := append([]string{"deletion_scheduled_at", "email", "role", "updated_at"}, author...)
+	admin := append([]string{"failed_login_attempts", "locked_until"}, self...)
+	sort.Strings(self)
+	sort.Strings(admin)
+
+	user := testUser()
+	assert.Equal(t, author, keys(t, NewAuthor(user)))
+	assert.Equal(t, self, keys(t, NewSelf(user)))
+	assert.Equal(t, admin, keys(t, NewAdmin(user)))
+
+	image := &models.Media{
+		ID: 2, OwnerID: user.ID, Key: "media/ab/original.jpg", ContentType: "image/jpeg",
+		Variants: []models.MediaVariant{{Name: "thumbnail", Key: "media/ab/thumbnail.jpg"}},
+	}
+	post := NewPost(&models.Post{
+		ID: 1, Title: "Hello", AuthorID: user.ID, Author: user,
+		Reactions:     []models.ReactionCount{{Kind: models.ReactionLike, Count: 2, Reacted: true}},
+		Media:         []*models.Media{image},
+		FeaturedImage: image,
+	})
+	assert.Equal(t, []string{"author", "author_id", "body", "created_at", "featured_image", "id", "media", "permalink", "reactions", "slug", "title", "updated_at"}, keys(t, post))
+	assert.Equal(t, author, keys(t, post.Author))
+	assert.Equal(t, []string{"count", "kind", "reacted"}, keys(t, post.Reactions[0]))
+	mediaKeys := []string{"content_type", "created_at", "height", "id", "size", "status", "url", "variants", "width"}
+	assert.Equal(t, mediaKeys, keys(t, post.Media[0]))
+	assert.Equal(t, mediaKeys, keys(t, post.FeaturedImage))
+	assert.Equal(t, []string{"content_type", "height", "name", "url", "width"}, keys(t, post.Media[0].Variants[0]))
+
+	export := NewDataExport(&models.DataExport{ID: 5, UserID: user.ID, Status: models.ExportReady})
+	assert.Equal(t, []string{"completed_at", "created_at", "expires_at", "id", "status", "user_id"}, keys(t, export))
+}
+
+func TestViewsNeverIncludeSecrets(t *testing.T) {
+	user := testUser()
+	post := &models.Post{ID: 1, Title: "Hello", AuthorID: user.ID, Author: user}
+	sub := &models.WebhookSubscription{ID: 3, URL: "https://hooks.example.com", Secret: "whsec_abc"}
+	export := &models.DataExport{ID: 5, UserID: user.ID, TokenHash: "f00dfeed", FilePath: "/var/exports/5.zip", Error: "disk full"}
+
+	tests := []struct {
+		name    string
+		view    View
+		private []string
+	}{
+		{"author", NewAuthor(user), []string{"alice@example.com", "2030-01-01", "2031-01-01"}},
+		{"self", NewSelf(user), []string{"2030-01-01"}},
+		{"admin", NewAdmin(user), nil},
+		{"post", NewPost(post), []string{"alice@example.com", "2031-01-01"}},
+		{"post list", NewList([]*models.Post{post}, NewPost), []string{"alice@example.com", "2031-01-01"}},
+		{"webhook subscription", NewWebhookSubscription(sub), []string{"whsec_abc"}},
+		{"data export", NewDataExport(export), []string{"f00dfeed", "/var/exports", "disk full"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			require.NoError(t, JSON(rr, 200, tt.view))
+
+			body := rr.Body.String()
+			assert.NotContains(t, body, passwordHash)
+			assert.NotContains(t, body, "password")
+			for _, s := range tt.private {
+				assert.NotContains(t, body, s)
+			}
+		})
+	}
+}
+
+func TestModelsCannotBeSerialized(t *testing.T) {
+	user := testUser()
+
+	_, err := json.Marshal(user)
+	assert.ErrorIs(t, err, models.ErrUserNotSerializable)
+
+	_, err = json.Marshal(&models.Post{Author: user})
+	assert.ErrorIs(t, err, models.ErrUserNotSerializable)
+}
+
+func TestEmptyListIsArray(t *testing.T) {
+	rr := httptest.NewRecorder()
+	require.NoError(t, JSON(rr, 200, NewList([]*models.Post(nil), NewPost)))
+	assert.JSONEq(t, "[]", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	require.NoError(t, JSON(rr, 200, NewPost(&models.Post{ID: 1})))
+	assert.Contains(t, rr.Body.String(), `"reactions":[]`)
+	assert.Contains(t, rr.Body.String(), `"media":[]`)
+	assert.Contains(t, rr.Body.String(), `"featured_image":null`)
+}
+
+func TestMediaURL(t *testing.T) {
+	defer SetMediaBaseURL("/media/")
+	media := &models.Media{Key: "media/ab/original.png", Variants: []models.MediaVariant{{Key: "media/ab/webp.webp"}}}
+
+	SetMediaBaseURL("https://cdn.example.com/blog")
+	v := NewMedia(media)
+	assert.Equal(t, "https://cdn.example.com/blog/media/ab/original.png", v.URL)
+	assert.Equal(t, "https://cdn.example.com/blog/media/ab/webp.webp", v.Variants[0].URL)
+}
@@ -0,0 +1,96 @@
+package views
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+)
+
+// WebhookSubscription is what admins see about a subscription. The
+// signing secret is only in NewWebhookSubscriptionCreated.
+type WebhookSubscription struct {
+	ID          int64    `json:"id"`
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description"`
+	Active      bool     `json:"active"`
+	// ConsecutiveFailures counts failed attempts since the last success;
+	// DisabledReason is set when that deactivated the subscription.
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	DisabledReason      string    `json:"disabled_reason,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+func (WebhookSubscription) view() {}
+
+func NewWebhookSubscription(sub *models.WebhookSubscription) WebhookSubscription {
+	return WebhookSubscription{
+		ID:                  sub.ID,
+		URL:                 sub.URL,
+		Events:              sub.Events,
+		Description:         sub.Description,
+		Active:              sub.Active,
+		ConsecutiveFailures: sub.ConsecutiveFailures,
+		DisabledReason:      sub.DisabledReason,
+		CreatedAt:           sub.CreatedAt,
+		UpdatedAt:           sub.UpdatedAt,
+	}
+}
+
+// WebhookSubscriptionCreated is returned once, when a subscription is
+// created, and is the only time its secret is shown.
+type WebhookSubscriptionCreated struct {
+	WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+func (WebhookSubscriptionCreated) view() {}
+
+func NewWebhookSubscriptionCreated(sub *models.WebhookSubscription) WebhookSubscriptionCreated {
+	return WebhookSubscriptionCreated{WebhookSubscription: NewWebhookSubscription(sub), Secret: sub.Secret}
+}
+
+// WebhookDelivery is an entry in the delivery log of a subscription, with
+// the outcome of its latest attempt. NextAttemptAt is set while it is
+// still pending.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	ResponseBody   string          `json:"response_body,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	DurationMS     int64           `json:"duration_ms"`
+	CreatedAt      time.Time       `json:"created_at"`
+	CompletedAt    *time.Time      `json:"completed_at"`
+}
+
+func (WebhookDelivery) view() {}
+
+func NewWebhookDelivery(d *models.WebhookDelivery) WebhookDelivery {
+	v := WebhookDelivery{
+		ID:             d.ID,
+		EventID:        d.EventID,
+		EventType:      d.EventType,
+		Payload:        json.RawMessage(d.Payload),
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: d.ResponseStatus,
+		ResponseBody:   d.ResponseBody,
+		Error:          d.Error,
+		DurationMS:     d.Duration.Milliseconds(),
+		CreatedAt:      d.CreatedAt,
+		CompletedAt:    d.CompletedAt,
+	}
+	if d.Status == models.WebhookPending {
+		next := d.NextAttemptAt
+		v.NextAttemptAt = &next
+	}
+	return v
+}
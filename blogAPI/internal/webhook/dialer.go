@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrAddressNotAllowed is returned for deliveries to addresses inside the
+// server's own network, which a subscription could otherwise use to read
+// internal services through the delivery log.
+var ErrAddressNotAllowed = errors.New("webhook address is not allowed")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which is
+// not public either.
+var sharedAddressSpace = &net.IPNet{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(10, 32)}
+
+// addressPolicy refuses loopback, private, link-local and other non-public
+// addresses unless they are in one of the allowed networks.
+type addressPolicy struct {
+	allowed []*net.IPNet
+}
+
+func newAddressPolicy(cidrs []string) addressPolicy {
+	var p addressPolicy
+	for _, cidr := range cidrs {
+		// Validated with the configuration
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			p.allowed = append(p.allowed, network)
+		}
+	}
+	return p
+}
+
+func (p addressPolicy) allows(ip net.IP) bool {
+	for _, network := range p.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// control checks the address a connection is about to be made to, after
+// the host name was resolved, so a name cannot point somewhere else once
+// the subscription has been checked.
+func (p addressPolicy) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !p.allows(ip) {
+		return fmt.Errorf("%w: %s", ErrAddressNotAllowed, host)
+	}
+	return nil
+}
+
+// checkURL refuses URLs naming a host that the dialer would refuse
+// anyway, so such subscriptions are rejected when they are saved. Names
+// are checked again at each delivery.
+func (p addressPolicy) checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		host = "127.0.0.1"
+	}
+	if ip := net.ParseIP(host); ip != nil && !p.allows(ip) {
+		return fmt.Errorf("%w: %s", ErrAddressNotAllowed, host)
+	}
+	return nil
+}
+
+// transport dials only addresses the policy allows. It never uses a
+// proxy, which would make the connection on its behalf.
+func (p addressPolicy) transport() *http.Transport {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: p.control}
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
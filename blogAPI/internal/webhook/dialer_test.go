@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressPolicy(t *testing.T) {
+	policy := newAddressPolicy(nil)
+	for _, ip := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"fe80::1", "fd00::1", "0.0.0.0", "::", "100.64.0.1", "224.0.0.1", "::ffff:127.0.0.1"} {
+		assert.False(t, policy.allows(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"93.184.216.34", "2606:2800:220:1::1", "100.128.0.1"} {
+		assert.True(t, policy.allows(net.ParseIP(ip)), ip)
+	}
+
+	allowed := newAddressPolicy([]string{"10.0.0.0/8"})
+	assert.True(t, allowed.allows(net.ParseIP("10.1.2.3")))
+	assert.False(t, allowed.allows(net.ParseIP("192.168.1.1")))
+}
+
+func TestCheckURL(t *testing.T) {
+	policy := newAddressPolicy(nil)
+	assert.ErrorIs(t, policy.checkURL("http://169.254.169.254/latest/meta-data"), ErrAddressNotAllowed)
+	assert.ErrorIs(t, policy.checkURL("http://localhost:8080/hook"), ErrAddressNotAllowed)
+	assert.ErrorIs(t, policy.checkURL("http://[::1]/hook"), ErrAddressNotAllowed)
+	// Names are only resolved when delivering
+	assert.NoError(t, policy.checkURL("https://hooks.example.com/hook"))
+	assert.NoError(t, newAddressPolicy([]string{"127.0.0.0/8"}).checkURL("http://localhost:8080/hook"))
+}
+
+func TestSendRefusesInternalAddresses(t *testing.T) {
+	var hits atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write([]byte("internal secret"))
+	}))
+	defer receiver.Close()
+
+	sub := &models.WebhookSubscription{URL: receiver.URL, Secret: "whsec_test"}
+	send := func(cfg config.Config) *models.WebhookDelivery {
+		delivery := &models.WebhookDelivery{EventID: "evt_1", EventType: EventPostCreated, Payload: `{}`}
+		NewDispatcher(nil, cfg).send(context.Background(), sub, delivery)
+		return delivery
+	}
+
+	delivery := send(*config.Default())
+	assert.Contains(t, delivery.Error, ErrAddressNotAllowed.Error())
+	assert.Empty(t, delivery.ResponseBody)
+	assert.Zero(t, hits.Load())
+
+	cfg := *config.Default()
+	cfg.Webhooks.AllowedNetworks = []string{"127.0.0.0/8"}
+	delivery = send(cfg)
+	assert.Empty(t, delivery.Error)
+	assert.Equal(t, http.StatusOK, delivery.ResponseStatus)
+	assert.Equal(t, "internal secret", delivery.ResponseBody)
+}
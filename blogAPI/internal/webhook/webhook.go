@@ -0,0 +1,245 @@
+// Package webhook sends post and user events to the endpoints subscribed
+// to them, signing each payload and retrying failed deliveries with
+// exponential backoff.
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/internal/logging"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/models"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/repository"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/retry"
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+)
+
+// Event types subscriptions can ask for. Posts are published as soon as
+// they are created, so a new post sends both post.created and
+// post.published.
+const (
+	EventPostCreated    = "post.created"
+	EventPostUpdated    = "post.updated"
+	EventPostPublished  = "post.published"
+	EventUserRegistered = "user.registered"
+)
+
+// Events lists every event type.
+var Events = []string{EventPostCreated, EventPostUpdated, EventPostPublished, EventUserRegistered}
+
+// ValidEvent reports whether event is one of Events.
+func ValidEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// maxResponseBody is how much of a receiver's response is kept in the
+// delivery log.
+const maxResponseBody = 1024
+
+// Envelope is the JSON body of every delivery. ID is the same for every
+// delivery and replay of one event, so receivers can ignore repeats.
+type Envelope struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Dispatcher queues events for the subscriptions that want them and
+// delivers the queue in the background.
+type Dispatcher struct {
+	webhooks *repository.WebhookRepository
+	client   *http.Client
+	policy   addressPolicy
+	config   config.Config
+	notify   chan struct{}
+}
+
+func NewDispatcher(webhooks *repository.WebhookRepository, config config.Config) *Dispatcher {
+	policy := newAddressPolicy(config.Webhooks.AllowedNetworks)
+	client := &http.Client{
+		Timeout:   config.Webhooks.Timeout,
+		Transport: policy.transport(),
+		// A redirect is reported as a failure rather than followed, so a
+		// subscription cannot be bounced to another host.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &Dispatcher{webhooks: webhooks, client: client, policy: policy, config: config, notify: make(chan struct{}, 1)}
+}
+
+// CheckURL reports ErrAddressNotAllowed for a URL whose host is an
+// address deliveries may not be sent to.
+func (d *Dispatcher) CheckURL(raw string) error {
+	return d.policy.checkURL(raw)
+}
+
+// Enqueue queues an event that happened at the given time, with data as
+// its payload, for every active subscription to eventType, and wakes the
+// worker to send it. Queueing an event ID again is a no-op for the
+// subscriptions that already have it, so callers may retry.
+func (d *Dispatcher) Enqueue(ctx context.Context, id string, at time.Time, eventType string, data interface{}) error {
+	payload, err := json.Marshal(Envelope{ID: id, Type: eventType, CreatedAt: at.UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+	queued, err := d.webhooks.Enqueue(ctx, id, eventType, string(payload))
+	if err != nil {
+		return err
+	}
+	if queued > 0 {
+		d.Notify()
+	}
+	return nil
+}
+
+// Notify wakes the worker so queued deliveries are sent without waiting
+// for the next tick. It never blocks.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries when notified and every WorkerInterval until
+// ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.Webhooks.WorkerInterval)
+	defer ticker.Stop()
+	for {
+		d.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.notify:
+		}
+	}
+}
+
+// RunOnce sends every due delivery, Workers at a time, and prunes the log
+// of deliveries older than Retention.
+func (d *Dispatcher) RunOnce(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	// A lease outlasts the attempt, so an instance that dies mid-way
+	// leaves the delivery to be retried soon after.
+	lease := d.config.Webhooks.Timeout + time.Minute
+
+	var wg sync.WaitGroup
+	for i := 0; i < d.config.Webhooks.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				delivery, err := d.webhooks.Claim(ctx, lease)
+				if err != nil {
+					logger.Warn("error claiming webhook delivery", "error", err)
+					return
+				}
+				if delivery == nil {
+					return
+				}
+				d.deliver(ctx, delivery)
+			}
+		}()
+	}
+	wg.Wait()
+
+	pruned, err := d.webhooks.PruneDeliveries(ctx, time.Now().Add(-d.config.Webhooks.Retention))
+	if err != nil && ctx.Err() == nil {
+		logger.Warn("error pruning webhook deliveries", "error", err)
+	} else if pruned > 0 {
+		logger.Info("pruned webhook deliveries", "count", pruned)
+	}
+}
+
+// deliver makes one attempt at a claimed delivery and records the
+// outcome, scheduling a retry or giving up when it fails.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	logger := logging.FromContext(ctx).With("delivery_id", delivery.ID, "subscription_id", delivery.SubscriptionID)
+
+	sub, err := d.webhooks.GetSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		logger.Warn("error loading webhook subscription", "error", err)
+		return
+	}
+	if sub == nil {
+		// Deleted since the claim, taking the delivery with it
+		return
+	}
+
+	d.send(ctx, sub, delivery)
+	if delivery.Error == "" {
+		if err := d.webhooks.Delivered(ctx, delivery); err != nil {
+			logger.Warn("error recording webhook delivery", "error", err)
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	if delivery.Attempts < d.config.Webhooks.MaxAttempts {
+		at := time.Now().Add(retry.Jitter(retry.Backoff(delivery.Attempts, d.config.Webhooks.BackoffBase, d.config.Webhooks.BackoffMax)))
+		retryAt = &at
+	}
+	logger.Info("webhook delivery failed", "attempt", delivery.Attempts, "error", delivery.Error, "retry_at", retryAt)
+	disabled, err := d.webhooks.Failed(ctx, delivery, retryAt, d.config.Webhooks.DisableAfter)
+	if err != nil {
+		logger.Warn("error recording webhook delivery", "error", err)
+		return
+	}
+	if disabled {
+		logger.Warn("webhook subscription disabled after repeated failures", "url", sub.URL)
+	}
+}
+
+// send posts the payload of delivery to the subscription, filling in the
+// response, or the error when it is not a 2xx.
+func (d *Dispatcher) send(ctx context.Context, sub *models.WebhookSubscription, delivery *models.WebhookDelivery) {
+	delivery.ResponseStatus, delivery.ResponseBody, delivery.Error = 0, "", ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		delivery.Error = err.Error()
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "blogAPI-Webhooks/1.0")
+	req.Header.Set(HeaderEventID, delivery.EventID)
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	// Signed at each attempt, so retries are not refused as stale
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, time.Now(), []byte(delivery.Payload)))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	if err != nil {
+		delivery.Duration = time.Since(start)
+		delivery.Error = err.Error()
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	// Drain a little more so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	delivery.Duration = time.Since(start)
+
+	delivery.ResponseStatus = resp.StatusCode
+	delivery.ResponseBody = strings.ToValidUTF8(string(body), "�")
+	switch {
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		delivery.Error = fmt.Sprintf("unexpected response status %s", resp.Status)
+	case err != nil:
+		delivery.Error = err.Error()
+	}
+}
@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	payload := []byte(`{"id":"evt_1"}`)
+
+	h := hmac.New(sha256.New, []byte("whsec_test"))
+	h.Write([]byte(`1700000000.{"id":"evt_1"}`))
+	assert.Equal(t, "t=1700000000,v1="+hex.EncodeToString(h.Sum(nil)), Sign("whsec_test", at, payload))
+}
+
+func TestVerify(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	payload := []byte(`{"id":"evt_1"}`)
+	header := Sign("whsec_test", at, payload)
+
+	assert.NoError(t, Verify("whsec_test", header, payload, 5*time.Minute, at.Add(time.Minute)))
+	// A rotated secret can be checked alongside the old one
+	assert.NoError(t, Verify("whsec_test", "v1=00,"+header, payload, 5*time.Minute, at))
+
+	assert.ErrorIs(t, Verify("whsec_other", header, payload, 5*time.Minute, at), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_test", header, []byte(`{"id":"evt_2"}`), 5*time.Minute, at), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_test", "t=1700000000", payload, 5*time.Minute, at), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_test", "", payload, 5*time.Minute, at), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_test", header, payload, 5*time.Minute, at.Add(10*time.Minute)), ErrExpiredSignature)
+	assert.ErrorIs(t, Verify("whsec_test", header, payload, 5*time.Minute, at.Add(-10*time.Minute)), ErrExpiredSignature)
+
+	// The timestamp is signed too
+	forged := strings.Replace(header, "t=1700000000", "t=1700000600", 1)
+	assert.ErrorIs(t, Verify("whsec_test", forged, payload, 5*time.Minute, at.Add(10*time.Minute)), ErrInvalidSignature)
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret()
+	require.NoError(t, err)
+	b, err := NewSecret()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(a, "whsec_"))
+	assert.Len(t, a, len("whsec_")+43)
+	assert.NotEqual(t, a, b)
+}
+
+func TestValidEvent(t *testing.T) {
+	assert.True(t, ValidEvent(EventPostPublished))
+	assert.False(t, ValidEvent("post.deleted"))
+	assert.False(t, ValidEvent(""))
+}
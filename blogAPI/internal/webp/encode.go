@@ -0,0 +1,235 @@
+// Package webp encodes images as lossless WebP (VP8L).
+//
+// The encoder favours simplicity over size: it applies the subtract-green
+// and a single gradient predictor transform and then Huffman codes every
+// pixel as a literal, without backward references or a color cache. Files
+// are larger than a full encoder would make them, but the encoder stays
+// small enough to audit; golang.org/x/image/webp decodes its output in
+// tests.
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// MaxDimension is the largest width or height VP8L can store.
+const MaxDimension = 1 << 14
+
+// predictorBits sets the predictor block size to 1<<predictorBits pixels.
+// Every block uses the same predictor, so the largest block keeps the
+// predictor image smallest.
+const predictorBits = 9
+
+// predictorGradient is VP8L predictor 12, Clamp(L + T - TL) per channel.
+const predictorGradient = 12
+
+// Encode writes img to w as a lossless WebP image.
+func Encode(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > MaxDimension || height > MaxDimension {
+		return errors.New("webp: image dimensions out of range")
+	}
+
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || nrgba.Rect.Min != (image.Point{}) {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(nrgba, nrgba.Rect, img, b.Min, draw.Src)
+	}
+
+	argb := make([]uint32, width*height)
+	opaque := true
+	for y := 0; y < height; y++ {
+		row := nrgba.Pix[y*nrgba.Stride:]
+		for x := 0; x < width; x++ {
+			p := row[x*4:]
+			argb[y*width+x] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+			if p[3] != 0xff {
+				opaque = false
+			}
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8) // VP8L signature
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if opaque {
+		bw.write(0, 1)
+	} else {
+		bw.write(1, 1)
+	}
+	bw.write(0, 3) // version
+
+	// Transforms are listed in the order they were applied; the decoder
+	// undoes them in reverse.
+	subtractGreen(argb)
+	bw.write(1, 1)
+	bw.write(transformSubtractGreen, 2)
+
+	blocks := blockCount(width)
+	modes := make([]uint32, blocks*blockCount(height))
+	for i := range modes {
+		modes[i] = 0xff000000 | predictorGradient<<8
+	}
+	bw.write(1, 1)
+	bw.write(transformPredictor, 2)
+	bw.write(predictorBits-2, 3)
+	writeImage(bw, modes, false)
+	residuals := predict(argb, width, height)
+
+	bw.write(0, 1) // no more transforms
+	writeImage(bw, residuals, true)
+	data := bw.flush()
+
+	chunk := len(data)
+	riff := 4 + 8 + chunk + chunk&1
+	var header [20]byte
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(riff))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(chunk))
+
+	var buf bytes.Buffer
+	buf.Grow(len(header) + chunk + 1)
+	buf.Write(header[:])
+	buf.Write(data)
+	if chunk&1 == 1 {
+		buf.WriteByte(0)
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+const (
+	transformPredictor     = 0
+	transformSubtractGreen = 2
+)
+
+func blockCount(n int) int {
+	return (n + 1<<predictorBits - 1) >> predictorBits
+}
+
+func subtractGreen(argb []uint32) {
+	for i, p := range argb {
+		g := (p >> 8) & 0xff
+		r := ((p >> 16) - g) & 0xff
+		b := (p - g) & 0xff
+		argb[i] = p&0xff00ff00 | r<<16 | b
+	}
+}
+
+// predict returns the residuals of the gradient predictor, following the
+// VP8L rules for the first row and column.
+func predict(argb []uint32, width, height int) []uint32 {
+	out := make([]uint32, len(argb))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			var pred uint32
+			switch {
+			case x == 0 && y == 0:
+				pred = 0xff000000
+			case y == 0:
+				pred = argb[i-1]
+			case x == 0:
+				pred = argb[i-width]
+			default:
+				pred = clampAddSubtractFull(argb[i-1], argb[i-width], argb[i-width-1])
+			}
+			out[i] = sub(argb[i], pred)
+		}
+	}
+	return out
+}
+
+func clampAddSubtractFull(l, t, tl uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		v := int((l>>shift)&0xff) + int((t>>shift)&0xff) - int((tl>>shift)&0xff)
+		if v < 0 {
+			v = 0
+		} else if v > 255 {
+			v = 255
+		}
+		out |= uint32(v) << shift
+	}
+	return out
+}
+
+// sub subtracts b from a per channel, modulo 256.
+func sub(a, b uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		out |= (((a >> shift) - (b >> shift)) & 0xff) << shift
+	}
+	return out
+}
+
+// writeImage writes an entropy-coded image: five Huffman codes followed by
+// every pixel as literals. The main image also carries the (unused) meta
+// prefix flag.
+func writeImage(bw *bitWriter, argb []uint32, main bool) {
+	bw.write(0, 1) // no color cache
+	if main {
+		bw.write(0, 1) // a single prefix code group
+	}
+
+	// Green, red, blue, alpha and distance. Without backward references
+	// green only uses its 256 literal symbols and distance is unused.
+	var hist [4][256]int
+	for _, p := range argb {
+		hist[0][(p>>8)&0xff]++
+		hist[1][(p>>16)&0xff]++
+		hist[2][p&0xff]++
+		hist[3][p>>24]++
+	}
+	var codes [4]huffmanCode
+	for i := range codes {
+		alphabet := 256
+		if i == 0 {
+			alphabet = 256 + 24 // literals and length prefixes
+		}
+		counts := make([]int, alphabet)
+		copy(counts, hist[i][:])
+		codes[i] = writeHuffmanCode(bw, counts)
+	}
+	writeHuffmanCode(bw, make([]int, 40))
+
+	for _, p := range argb {
+		codes[0].write(bw, (p>>8)&0xff)
+		codes[1].write(bw, (p>>16)&0xff)
+		codes[2].write(bw, p&0xff)
+		codes[3].write(bw, p>>24)
+	}
+}
+
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+// write appends the low n bits of v, least significant bit first.
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v&(1<<n-1)) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) flush() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+	return w.buf
+}
@@ -0,0 +1,86 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xwebp "golang.org/x/image/webp"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	gradient := image.NewNRGBA(image.Rect(0, 0, 700, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 700; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y * 2), uint8(x ^ y), 255})
+		}
+	}
+	noisy := image.NewNRGBA(image.Rect(0, 0, 37, 53))
+	rng.Read(noisy.Pix)
+	flat := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := range flat.Pix {
+		flat.Pix[i] = 0x80
+	}
+	offset := image.NewRGBA(image.Rect(10, 20, 30, 25))
+	rng.Read(offset.Pix)
+	for i := 3; i < len(offset.Pix); i += 4 {
+		offset.Pix[i] = 255
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"gradient", gradient},
+		{"noise with alpha", noisy},
+		{"single color", flat},
+		{"one pixel", image.NewNRGBA(image.Rect(0, 0, 1, 1))},
+		{"offset bounds", offset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, Encode(&buf, tt.img))
+
+			got, err := xwebp.Decode(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+
+			b := tt.img.Bounds()
+			require.Equal(t, b.Dx(), got.Bounds().Dx())
+			require.Equal(t, b.Dy(), got.Bounds().Dy())
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					want := color.NRGBAModel.Convert(tt.img.At(b.Min.X+x, b.Min.Y+y))
+					have := color.NRGBAModel.Convert(got.At(got.Bounds().Min.X+x, got.Bounds().Min.Y+y))
+					if !assert.Equal(t, want, have, "pixel %d,%d", x, y) {
+						return
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeCompressesSmoothImages(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8((x + y) / 2), 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, img))
+	// Literal-only coding spends at least a bit per channel.
+	assert.Less(t, buf.Len(), len(img.Pix)/8)
+}
+
+func TestEncodeRejectsOversizedImages(t *testing.T) {
+	err := Encode(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, MaxDimension+1, 1)))
+	assert.Error(t, err)
+}
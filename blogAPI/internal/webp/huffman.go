@@ -0,0 +1,200 @@
+package webp
+
+import "sort"
+
+const (
+	maxCodeLength           = 15
+	maxCodeLengthCodeLength = 7
+)
+
+// codeLengthCodeOrder is the order in which the lengths of the code length
+// code are stored.
+var codeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// huffmanCode maps symbols to canonical prefix codes, stored bit-reversed
+// so they can be written least significant bit first.
+type huffmanCode struct {
+	lengths []uint8
+	codes   []uint32
+}
+
+func (c huffmanCode) write(bw *bitWriter, symbol uint32) {
+	if n := c.lengths[symbol]; n > 0 {
+		bw.write(c.codes[symbol], uint(n))
+	}
+}
+
+// writeHuffmanCode writes a code for a symbol histogram and returns it.
+// One or two small symbols use the compact "simple" form, in which a lone
+// symbol takes no bits at all.
+func writeHuffmanCode(bw *bitWriter, counts []int) huffmanCode {
+	var used []int
+	for s, n := range counts {
+		if n > 0 {
+			used = append(used, s)
+		}
+	}
+
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		bw.write(1, 1)
+		bw.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		lengths := make([]uint8, len(counts))
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return canonical(lengths)
+	}
+
+	lengths := codeLengths(counts, maxCodeLength)
+	bw.write(0, 1)
+
+	// The code lengths are themselves Huffman coded, as plain literals
+	// 0-15 without the repeat codes.
+	clCounts := make([]int, 19)
+	for _, l := range lengths {
+		clCounts[l]++
+	}
+	clLengths := codeLengths(clCounts, maxCodeLengthCodeLength)
+	n := len(codeLengthCodeOrder)
+	for n > 4 && clLengths[codeLengthCodeOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(uint32(n-4), 4)
+	for _, s := range codeLengthCodeOrder[:n] {
+		bw.write(uint32(clLengths[s]), 3)
+	}
+	bw.write(0, 1) // every symbol's length follows
+
+	clCode := canonical(clLengths)
+	for _, l := range lengths {
+		clCode.write(bw, uint32(l))
+	}
+	return canonical(lengths)
+}
+
+// codeLengths returns Huffman code lengths for counts, no longer than
+// limit. At least two symbols always get a code, so the result is a
+// complete prefix code.
+func codeLengths(counts []int, limit int) []uint8 {
+	counts = append([]int(nil), counts...)
+	var used int
+	for _, n := range counts {
+		if n > 0 {
+			used++
+		}
+	}
+	for i := 0; used < 2; i++ {
+		if counts[i] == 0 {
+			counts[i] = 1
+			used++
+		}
+	}
+
+	for {
+		lengths := huffmanLengths(counts)
+		longest := uint8(0)
+		for _, l := range lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+		if int(longest) <= limit {
+			return lengths
+		}
+		// Flatten the distribution and try again; rare symbols get
+		// shorter codes at a small cost to common ones.
+		for i, n := range counts {
+			if n > 0 {
+				counts[i] = n/2 + 1
+			}
+		}
+	}
+}
+
+type huffmanNode struct {
+	weight      int
+	symbol      int
+	left, right *huffmanNode
+}
+
+func huffmanLengths(counts []int) []uint8 {
+	var leaves []*huffmanNode
+	for s, n := range counts {
+		if n > 0 {
+			leaves = append(leaves, &huffmanNode{weight: n, symbol: s})
+		}
+	}
+	sort.SliceStable(leaves, func(i, j int) bool { return leaves[i].weight < leaves[j].weight })
+
+	// Two-queue construction: leaves in weight order, and internal nodes,
+	// which are created in weight order too.
+	var internal []*huffmanNode
+	next := func() *huffmanNode {
+		if len(internal) == 0 || (len(leaves) > 0 && leaves[0].weight <= internal[0].weight) {
+			n := leaves[0]
+			leaves = leaves[1:]
+			return n
+		}
+		n := internal[0]
+		internal = internal[1:]
+		return n
+	}
+	for len(leaves)+len(internal) > 1 {
+		a, b := next(), next()
+		internal = append(internal, &huffmanNode{weight: a.weight + b.weight, symbol: -1, left: a, right: b})
+	}
+
+	lengths := make([]uint8, len(counts))
+	var walk func(n *huffmanNode, depth uint8)
+	walk = func(n *huffmanNode, depth uint8) {
+		if n.left == nil {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(internal[0], 0)
+	return lengths
+}
+
+// canonical assigns canonical codes to lengths, as in DEFLATE.
+func canonical(lengths []uint8) huffmanCode {
+	var count [maxCodeLength + 1]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [maxCodeLength + 2]uint32
+	code := uint32(0)
+	for l := 1; l <= maxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+
+	codes := make([]uint32, len(lengths))
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		var rev uint32
+		for i := uint8(0); i < l; i++ {
+			rev = rev<<1 | (c>>i)&1
+		}
+		codes[s] = rev
+	}
+	return huffmanCode{lengths: lengths, codes: codes}
+}
@@ -1,93 +1,759 @@
 package config
 
-
 import (
-    "fmt"
-    "os"
-    "strconv"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
 
-    "github.com/joho/godotenv"
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
 )
 
+// Config is the typed schema for every setting the API reads at boot. Each
+// leaf field can be set, in increasing order of precedence, by its default,
+// by a YAML or TOML config file (using the yaml/toml key), by an environment
+// variable (the env tag) and by a command-line flag named after its dotted
+// file path, e.g. -database.max_open_conns. Fields tagged secret are
+// redacted by `config print --redacted`.
 type Config struct {
-    Port     string
-    Database DatabaseConfig
-    Email    EmailConfig
-    JWT      JWTConfig
-    Frontend FrontendConfig
+	Env       string          `yaml:"env" toml:"env" env:"APP_ENV"`
+	Port      string          `yaml:"port" toml:"port" env:"PORT"`
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+	Metrics   MetricsConfig   `yaml:"metrics" toml:"metrics"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Health    HealthConfig    `yaml:"health" toml:"health"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	Password  PasswordConfig  `yaml:"password" toml:"password"`
+	Lockout   LockoutConfig   `yaml:"lockout" toml:"lockout"`
+	MFA       MFAConfig       `yaml:"mfa" toml:"mfa"`
+	MagicLink MagicLinkConfig `yaml:"magic_link" toml:"magic_link"`
+	OIDC      OIDCConfig      `yaml:"oidc" toml:"oidc"`
+	OAuth     OAuthConfig     `yaml:"oauth" toml:"oauth"`
+	Privacy   PrivacyConfig   `yaml:"privacy" toml:"privacy"`
+	Media     MediaConfig     `yaml:"media" toml:"media"`
+	Posts     PostsConfig     `yaml:"posts" toml:"posts"`
+	SEO       SEOConfig       `yaml:"seo" toml:"seo"`
+	Webhooks  WebhooksConfig  `yaml:"webhooks" toml:"webhooks"`
+	Events    EventsConfig    `yaml:"events" toml:"events"`
+	Database  DatabaseConfig  `yaml:"database" toml:"database"`
+	Email     EmailConfig     `yaml:"email" toml:"email"`
+	JWT       JWTConfig       `yaml:"jwt" toml:"jwt"`
+	Frontend  FrontendConfig  `yaml:"frontend" toml:"frontend"`
+}
+
+// ServerConfig holds the timeouts applied to the HTTP server.
+type ServerConfig struct {
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	// ShutdownTimeout bounds how long in-flight requests may take to drain
+	// once a shutdown signal has been received.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+	// ShutdownDelay is how long /readyz reports not-ready before the
+	// server stops accepting connections, giving load balancers time to
+	// notice.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay" env:"SERVER_SHUTDOWN_DELAY"`
+	// TrustProxyHeaders takes the client IP from X-Forwarded-For or
+	// X-Real-IP. Enable it only behind a reverse proxy that sets them.
+	TrustProxyHeaders bool `yaml:"trust_proxy_headers" toml:"trust_proxy_headers" env:"SERVER_TRUST_PROXY_HEADERS"`
+	// TrustedProxyHops is how many proxies in front of the API append to
+	// X-Forwarded-For. The client IP is taken that many entries from the
+	// right, skipping any the client sent itself.
+	TrustedProxyHops int `yaml:"trusted_proxy_hops" toml:"trusted_proxy_hops" env:"SERVER_TRUSTED_PROXY_HOPS"`
+}
+
+type LogConfig struct {
+	// Level is one of debug, info, warn or error.
+	Level string `yaml:"level" toml:"level" env:"LOG_LEVEL"`
+	// Format is json or text; it defaults to json in production.
+	Format string `yaml:"format" toml:"format" env:"LOG_FORMAT"`
+}
+
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled" env:"METRICS_ENABLED"`
+	Path    string `yaml:"path" toml:"path" env:"METRICS_PATH"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, stdout (for local development) or otlp.
+	Exporter    string `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER"`
+	ServiceName string `yaml:"service_name" toml:"service_name" env:"OTEL_SERVICE_NAME"`
+	// Endpoint is the OTLP/HTTP collector host:port. When empty the
+	// exporter honours the standard OTEL_EXPORTER_OTLP_* variables.
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure" env:"TRACING_OTLP_INSECURE"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+type HealthConfig struct {
+	// CheckTimeout bounds each readiness check.
+	CheckTimeout time.Duration `yaml:"check_timeout" toml:"check_timeout" env:"HEALTH_CHECK_TIMEOUT"`
+	// RequireMailer makes an unreachable SMTP server fail readiness rather
+	// than only being reported.
+	RequireMailer bool `yaml:"require_mailer" toml:"require_mailer" env:"HEALTH_REQUIRE_MAILER"`
+}
+
+// RateLimitConfig throttles the auth endpoints. Limits are written as
+// "<requests>/<period>", e.g. "5/1m"; an empty limit disables that rule.
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" env:"RATE_LIMIT_ENABLED"`
+	// Store is memory (per replica) or postgres (shared by all replicas).
+	Store string `yaml:"store" toml:"store" env:"RATE_LIMIT_STORE"`
+
+	LoginIP            string `yaml:"login_ip" toml:"login_ip" env:"RATE_LIMIT_LOGIN_IP"`
+	LoginAccount       string `yaml:"login_account" toml:"login_account" env:"RATE_LIMIT_LOGIN_ACCOUNT"`
+	RegisterIP         string `yaml:"register_ip" toml:"register_ip" env:"RATE_LIMIT_REGISTER_IP"`
+	PasswordResetIP    string `yaml:"password_reset_ip" toml:"password_reset_ip" env:"RATE_LIMIT_PASSWORD_RESET_IP"`
+	PasswordResetEmail string `yaml:"password_reset_account" toml:"password_reset_account" env:"RATE_LIMIT_PASSWORD_RESET_ACCOUNT"`
+}
+
+// PasswordConfig chooses how new passwords are hashed and which ones are
+// accepted. Hashes made with other settings keep working and are upgraded
+// when their user logs in.
+type PasswordConfig struct {
+	// Algorithm is argon2id or bcrypt.
+	Algorithm string `yaml:"algorithm" toml:"algorithm" env:"PASSWORD_ALGORITHM"`
+	// Argon2Memory is in KiB.
+	Argon2Memory  int `yaml:"argon2_memory" toml:"argon2_memory" env:"PASSWORD_ARGON2_MEMORY"`
+	Argon2Time    int `yaml:"argon2_time" toml:"argon2_time" env:"PASSWORD_ARGON2_TIME"`
+	Argon2Threads int `yaml:"argon2_threads" toml:"argon2_threads" env:"PASSWORD_ARGON2_THREADS"`
+	BcryptCost    int `yaml:"bcrypt_cost" toml:"bcrypt_cost" env:"PASSWORD_BCRYPT_COST"`
+	MinLength     int `yaml:"min_length" toml:"min_length" env:"PASSWORD_MIN_LENGTH"`
+	// MinScore is the lowest strength accepted, from 0 (trivially
+	// guessable) to 4 (very unguessable), as scored by zxcvbn.
+	MinScore int `yaml:"min_score" toml:"min_score" env:"PASSWORD_MIN_SCORE"`
+	// BreachedList is a file built with `blogAPI breached-list`. New
+	// passwords found in it are refused; empty disables the check.
+	BreachedList string `yaml:"breached_list" toml:"breached_list" env:"PASSWORD_BREACHED_LIST"`
+}
+
+// LockoutConfig controls how an account is protected against password
+// guessing once its consecutive failed logins pile up.
+type LockoutConfig struct {
+	// FreeAttempts failures are allowed before logins are delayed.
+	FreeAttempts int `yaml:"free_attempts" toml:"free_attempts" env:"LOCKOUT_FREE_ATTEMPTS"`
+	// BaseDelay doubles with every further failure, up to MaxDelay.
+	BaseDelay time.Duration `yaml:"base_delay" toml:"base_delay" env:"LOCKOUT_BASE_DELAY"`
+	MaxDelay  time.Duration `yaml:"max_delay" toml:"max_delay" env:"LOCKOUT_MAX_DELAY"`
+	// Threshold failures lock the account for Duration and email an
+	// unlock link.
+	Threshold int           `yaml:"threshold" toml:"threshold" env:"LOCKOUT_THRESHOLD"`
+	Duration  time.Duration `yaml:"duration" toml:"duration" env:"LOCKOUT_DURATION"`
+	// NotifyNewLogin emails users when they sign in from a new IP address
+	// or browser.
+	NotifyNewLogin bool `yaml:"notify_new_login" toml:"notify_new_login" env:"LOCKOUT_NOTIFY_NEW_LOGIN"`
+}
+
+type MFAConfig struct {
+	// Issuer is the account name shown in authenticator apps.
+	Issuer string `yaml:"issuer" toml:"issuer" env:"MFA_ISSUER"`
+	// EncryptionKey encrypts TOTP secrets at rest. It defaults to the JWT
+	// secret outside production.
+	EncryptionKey string `yaml:"encryption_key" toml:"encryption_key" env:"MFA_ENCRYPTION_KEY" secret:"true"`
+	// ChallengeTTL is how long a password login may wait for its code.
+	ChallengeTTL time.Duration `yaml:"challenge_ttl" toml:"challenge_ttl" env:"MFA_CHALLENGE_TTL"`
+	// EnrollmentTTL is how long a user whose role requires two-factor login
+	// has to set it up after signing in with only a password.
+	EnrollmentTTL time.Duration `yaml:"enrollment_ttl" toml:"enrollment_ttl" env:"MFA_ENROLLMENT_TTL"`
+}
+
+// MagicLinkConfig controls passwordless sign-in by email.
+type MagicLinkConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" env:"MAGIC_LINK_ENABLED"`
+	// TTL is how long the emailed link, and the sign-in it starts, stay
+	// valid.
+	TTL time.Duration `yaml:"ttl" toml:"ttl" env:"MAGIC_LINK_TTL"`
+	// CodeAttempts wrong codes end the sign-in.
+	CodeAttempts int `yaml:"code_attempts" toml:"code_attempts" env:"MAGIC_LINK_CODE_ATTEMPTS"`
+}
+
+// OIDCConfig enables signing in with OpenID Connect providers. A provider
+// is enabled by giving it a client ID.
+type OIDCConfig struct {
+	// StateTTL is how long a user has to finish signing in at the
+	// provider.
+	StateTTL time.Duration      `yaml:"state_ttl" toml:"state_ttl" env:"OIDC_STATE_TTL"`
+	Google   OIDCProviderConfig `yaml:"google" toml:"google" env:"OIDC_GOOGLE_"`
+	// Generic is any other OpenID provider, such as Keycloak or Auth0,
+	// served under the name given in its Name field.
+	Generic OIDCProviderConfig `yaml:"generic" toml:"generic" env:"OIDC_GENERIC_"`
+}
+
+type OIDCProviderConfig struct {
+	Name         string `yaml:"name" toml:"name" env:"NAME"`
+	Issuer       string `yaml:"issuer" toml:"issuer" env:"ISSUER"`
+	ClientID     string `yaml:"client_id" toml:"client_id" env:"CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret" env:"CLIENT_SECRET" secret:"true"`
+	// RedirectURL must point at /api/auth/oidc/<name>/callback and be
+	// registered with the provider.
+	RedirectURL string   `yaml:"redirect_url" toml:"redirect_url" env:"REDIRECT_URL"`
+	Scopes      []string `yaml:"scopes" toml:"scopes" env:"SCOPES"`
+}
+
+// Enabled reports whether the provider has been configured.
+func (p OIDCProviderConfig) Enabled() bool {
+	return p.ClientID != ""
+}
+
+// OAuthConfig sets token lifetimes for the OAuth2 authorization server
+// that third-party clients use.
+type OAuthConfig struct {
+	CodeTTL         time.Duration `yaml:"code_ttl" toml:"code_ttl" env:"OAUTH_CODE_TTL"`
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" toml:"access_token_ttl" env:"OAUTH_ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" toml:"refresh_token_ttl" env:"OAUTH_REFRESH_TOKEN_TTL"`
 }
 
 type DatabaseConfig struct {
-    Host     string
-    Port     int
-    User     string
-    Password string
-    DBName   string
-    SSLMode  string
+	Host     string `yaml:"host" toml:"host" env:"DB_HOST"`
+	Port     int    `yaml:"port" toml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" toml:"user" env:"DB_USER"`
+	Password string `yaml:"password" toml:"password" env:"DB_PASSWORD" secret:"true"`
+	DBName   string `yaml:"name" toml:"name" env:"DB_NAME"`
+	SSLMode  string `yaml:"ssl_mode" toml:"ssl_mode" env:"DB_SSL_MODE"`
+
+	// Pool sizing and timeouts, applied to the *sql.DB after it is opened.
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout" toml:"connect_timeout" env:"DB_CONNECT_TIMEOUT"`
+
+	// AutoMigrate applies pending migrations at boot. When disabled, run
+	// `blogAPI migrate` before starting the server.
+	AutoMigrate bool `yaml:"auto_migrate" toml:"auto_migrate" env:"DB_AUTO_MIGRATE"`
 }
 
 type EmailConfig struct {
-    Host     string
-    Port     int
-    Username string
-    Password string
-    From     string
+	Host     string `yaml:"host" toml:"host" env:"SMTP_HOST"`
+	Port     int    `yaml:"port" toml:"port" env:"SMTP_PORT"`
+	Username string `yaml:"username" toml:"username" env:"GMAIL_USER"`
+	Password string `yaml:"password" toml:"password" env:"GMAIL_APP_PASSWORD" secret:"true"`
+	// From defaults to Username when left empty.
+	From string `yaml:"from" toml:"from" env:"SMTP_FROM"`
 }
 
 type JWTConfig struct {
-    Secret string
+	Secret string `yaml:"secret" toml:"secret" env:"JWT_SECRET" secret:"true"`
+}
+
+// PrivacyConfig controls account data exports and account deletion.
+type PrivacyConfig struct {
+	// ExportDir holds export archives until their download link expires.
+	ExportDir string        `yaml:"export_dir" toml:"export_dir" env:"PRIVACY_EXPORT_DIR"`
+	ExportTTL time.Duration `yaml:"export_ttl" toml:"export_ttl" env:"PRIVACY_EXPORT_TTL"`
+	// DeletionGracePeriod is how long a user can cancel the deletion of
+	// their account before its data is erased.
+	DeletionGracePeriod time.Duration `yaml:"deletion_grace_period" toml:"deletion_grace_period" env:"PRIVACY_DELETION_GRACE_PERIOD"`
+	// DeletedPosts is reassign, which keeps the posts of erased accounts
+	// under a "deleted user" placeholder, or delete.
+	DeletedPosts string `yaml:"deleted_posts" toml:"deleted_posts" env:"PRIVACY_DELETED_POSTS"`
+	// JobInterval is how often queued exports and due deletions are
+	// processed.
+	JobInterval time.Duration `yaml:"job_interval" toml:"job_interval" env:"PRIVACY_JOB_INTERVAL"`
+}
+
+// MediaConfig controls image uploads and where they are stored.
+type MediaConfig struct {
+	// Store is local, which keeps files under LocalDir, or s3.
+	Store    string `yaml:"store" toml:"store" env:"MEDIA_STORE"`
+	LocalDir string `yaml:"local_dir" toml:"local_dir" env:"MEDIA_LOCAL_DIR"`
+	// BaseURL is prefixed to storage keys to form media URLs. The default
+	// serves media from the API's own /media/ route; set it to a CDN or
+	// public bucket URL to serve them from there instead.
+	BaseURL        string `yaml:"base_url" toml:"base_url" env:"MEDIA_BASE_URL"`
+	MaxUploadBytes int64  `yaml:"max_upload_bytes" toml:"max_upload_bytes" env:"MEDIA_MAX_UPLOAD_BYTES"`
+	// MaxPixels refuses images that would take too much memory to decode,
+	// however small the file.
+	MaxPixels int `yaml:"max_pixels" toml:"max_pixels" env:"MEDIA_MAX_PIXELS"`
+	// ThumbnailSize and DisplaySize bound the longer side of the generated
+	// thumbnail and WebP variants.
+	ThumbnailSize int `yaml:"thumbnail_size" toml:"thumbnail_size" env:"MEDIA_THUMBNAIL_SIZE"`
+	DisplaySize   int `yaml:"display_size" toml:"display_size" env:"MEDIA_DISPLAY_SIZE"`
+	// WorkerInterval is how often the variant worker looks for work it was
+	// not told about, such as uploads handled by another instance.
+	WorkerInterval time.Duration `yaml:"worker_interval" toml:"worker_interval" env:"MEDIA_WORKER_INTERVAL"`
+	S3             MediaS3Config `yaml:"s3" toml:"s3" env:"MEDIA_S3_"`
+}
+
+type MediaS3Config struct {
+	Endpoint        string `yaml:"endpoint" toml:"endpoint" env:"ENDPOINT"`
+	Region          string `yaml:"region" toml:"region" env:"REGION"`
+	Bucket          string `yaml:"bucket" toml:"bucket" env:"BUCKET"`
+	AccessKeyID     string `yaml:"access_key_id" toml:"access_key_id" env:"ACCESS_KEY_ID"`
+	SecretAccessKey string `yaml:"secret_access_key" toml:"secret_access_key" env:"SECRET_ACCESS_KEY" secret:"true"`
+	PathStyle       bool   `yaml:"path_style" toml:"path_style" env:"PATH_STYLE"`
+}
+
+// PostsConfig controls how posts are addressed.
+type PostsConfig struct {
+	// Permalinks is slug, for /posts/{slug}, or date, for
+	// /{yyyy}/{mm}/{dd}/{slug}. With date the API also looks posts up at
+	// /api/posts/{yyyy}/{mm}/{dd}/{slug}.
+	Permalinks string `yaml:"permalinks" toml:"permalinks" env:"POSTS_PERMALINKS"`
+}
+
+// SEOConfig controls /sitemap.xml and /robots.txt. Both must be served
+// from the site's own host for crawlers to trust them, so route those
+// paths from the site to the API.
+type SEOConfig struct {
+	// SiteURL is the public address of the site, prefixed to permalinks
+	// and sitemap links. It defaults to frontend.url.
+	SiteURL string `yaml:"site_url" toml:"site_url" env:"SEO_SITE_URL"`
+	// RobotsDisallow lists the path prefixes crawlers are asked to skip.
+	RobotsDisallow []string `yaml:"robots_disallow" toml:"robots_disallow" env:"SEO_ROBOTS_DISALLOW"`
+	// RobotsBlockAll asks every crawler to skip the whole site, for
+	// staging environments.
+	RobotsBlockAll bool `yaml:"robots_block_all" toml:"robots_block_all" env:"SEO_ROBOTS_BLOCK_ALL"`
+}
+
+// WebhooksConfig controls how events are sent to webhook subscriptions.
+type WebhooksConfig struct {
+	// EncryptionKey encrypts the signing secrets of subscriptions. It
+	// defaults to mfa.encryption_key.
+	EncryptionKey string `yaml:"encryption_key" toml:"encryption_key" env:"WEBHOOKS_ENCRYPTION_KEY" secret:"true"`
+	// Timeout bounds one delivery attempt, including reading the response.
+	Timeout time.Duration `yaml:"timeout" toml:"timeout" env:"WEBHOOKS_TIMEOUT"`
+	// MaxAttempts is how many times a delivery is tried before it is
+	// marked failed. The wait before each retry doubles from BackoffBase
+	// up to BackoffMax.
+	MaxAttempts int           `yaml:"max_attempts" toml:"max_attempts" env:"WEBHOOKS_MAX_ATTEMPTS"`
+	BackoffBase time.Duration `yaml:"backoff_base" toml:"backoff_base" env:"WEBHOOKS_BACKOFF_BASE"`
+	BackoffMax  time.Duration `yaml:"backoff_max" toml:"backoff_max" env:"WEBHOOKS_BACKOFF_MAX"`
+	// DisableAfter deactivates a subscription after this many failed
+	// attempts in a row, whatever the event. 0 never does.
+	DisableAfter int `yaml:"disable_after" toml:"disable_after" env:"WEBHOOKS_DISABLE_AFTER"`
+	// Workers is how many deliveries are sent at once by each instance.
+	Workers int `yaml:"workers" toml:"workers" env:"WEBHOOKS_WORKERS"`
+	// WorkerInterval is how often the queue is checked for retries that
+	// have come due and events queued by other instances.
+	WorkerInterval time.Duration `yaml:"worker_interval" toml:"worker_interval" env:"WEBHOOKS_WORKER_INTERVAL"`
+	// Retention is how long finished deliveries stay in the log.
+	Retention time.Duration `yaml:"retention" toml:"retention" env:"WEBHOOKS_RETENTION"`
+	// AllowedNetworks lists CIDR ranges that may receive deliveries even
+	// though they are loopback, private or link-local addresses, which
+	// are refused otherwise. Only list deliberate internal targets.
+	AllowedNetworks []string `yaml:"allowed_networks" toml:"allowed_networks" env:"WEBHOOKS_ALLOWED_NETWORKS"`
+}
+
+// EventsConfig controls how domain events in the outbox are handed to
+// their subscribers.
+type EventsConfig struct {
+	// PollInterval is how often the outbox is checked for new events.
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval" env:"EVENTS_POLL_INTERVAL"`
+	// MaxAttempts is how many times a subscriber is given an event before
+	// it is given up on. The wait before each retry doubles from
+	// BackoffBase up to BackoffMax.
+	MaxAttempts int           `yaml:"max_attempts" toml:"max_attempts" env:"EVENTS_MAX_ATTEMPTS"`
+	BackoffBase time.Duration `yaml:"backoff_base" toml:"backoff_base" env:"EVENTS_BACKOFF_BASE"`
+	BackoffMax  time.Duration `yaml:"backoff_max" toml:"backoff_max" env:"EVENTS_BACKOFF_MAX"`
+	// Retention is how long handled events stay in the outbox.
+	Retention time.Duration `yaml:"retention" toml:"retention" env:"EVENTS_RETENTION"`
 }
 
 type FrontendConfig struct {
-    URL string
+	URL string `yaml:"url" toml:"url" env:"FRONTEND_URL"`
+}
+
+// Default returns the configuration used before any file, environment
+// variable or flag is applied.
+func Default() *Config {
+	return &Config{
+		Env:  EnvDevelopment,
+		Port: "8080",
+		Server: ServerConfig{
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+			TrustedProxyHops:  1,
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Path:    "/metrics",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "blogAPI",
+			SampleRatio: 1,
+		},
+		Health: HealthConfig{
+			CheckTimeout: 2 * time.Second,
+		},
+		Password: PasswordConfig{
+			Algorithm:     "argon2id",
+			Argon2Memory:  64 * 1024,
+			Argon2Time:    3,
+			Argon2Threads: 4,
+			BcryptCost:    10,
+			MinLength:     10,
+			MinScore:      3,
+		},
+		Lockout: LockoutConfig{
+			FreeAttempts:   3,
+			BaseDelay:      time.Second,
+			MaxDelay:       time.Minute,
+			Threshold:      10,
+			Duration:       30 * time.Minute,
+			NotifyNewLogin: true,
+		},
+		MFA: MFAConfig{
+			Issuer:        "Blog API",
+			ChallengeTTL:  5 * time.Minute,
+			EnrollmentTTL: 15 * time.Minute,
+		},
+		MagicLink: MagicLinkConfig{
+			Enabled:      true,
+			TTL:          15 * time.Minute,
+			CodeAttempts: 5,
+		},
+		OIDC: OIDCConfig{
+			StateTTL: 10 * time.Minute,
+			Google: OIDCProviderConfig{
+				Name:   "google",
+				Issuer: "https://accounts.google.com",
+				Scopes: []string{"email", "profile"},
+			},
+			Generic: OIDCProviderConfig{
+				Name:   "oidc",
+				Scopes: []string{"email", "profile"},
+			},
+		},
+		OAuth: OAuthConfig{
+			CodeTTL:         10 * time.Minute,
+			AccessTokenTTL:  time.Hour,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+		},
+		Privacy: PrivacyConfig{
+			ExportDir:           "exports",
+			ExportTTL:           7 * 24 * time.Hour,
+			DeletionGracePeriod: 30 * 24 * time.Hour,
+			DeletedPosts:        "reassign",
+			JobInterval:         time.Minute,
+		},
+		Media: MediaConfig{
+			Store:          "local",
+			LocalDir:       "media",
+			BaseURL:        "/media/",
+			MaxUploadBytes: 10 << 20,
+			MaxPixels:      40_000_000,
+			ThumbnailSize:  320,
+			DisplaySize:    1600,
+			WorkerInterval: 30 * time.Second,
+			S3: MediaS3Config{
+				Region: "us-east-1",
+			},
+		},
+		Posts: PostsConfig{
+			Permalinks: "slug",
+		},
+		SEO: SEOConfig{
+			RobotsDisallow: []string{"/api/"},
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:        10 * time.Second,
+			MaxAttempts:    8,
+			BackoffBase:    30 * time.Second,
+			BackoffMax:     6 * time.Hour,
+			DisableAfter:   25,
+			Workers:        4,
+			WorkerInterval: 10 * time.Second,
+			Retention:      30 * 24 * time.Hour,
+		},
+		Events: EventsConfig{
+			PollInterval: time.Second,
+			MaxAttempts:  10,
+			BackoffBase:  5 * time.Second,
+			BackoffMax:   30 * time.Minute,
+			Retention:    7 * 24 * time.Hour,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:            true,
+			Store:              "memory",
+			LoginIP:            "20/1m",
+			LoginAccount:       "5/1m",
+			RegisterIP:         "5/1h",
+			PasswordResetIP:    "5/1h",
+			PasswordResetEmail: "3/1h",
+		},
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            5432,
+			User:            "postgres",
+			DBName:          "userdb",
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			ConnectTimeout:  5 * time.Second,
+			AutoMigrate:     true,
+		},
+		Email: EmailConfig{
+			Host: "smtp.gmail.com",
+			Port: 587,
+		},
+		Frontend: FrontendConfig{
+			URL: "http://localhost:3000",
+		},
+	}
 }
 
+// LoadConfig loads the configuration without any command-line flags.
 func LoadConfig() (*Config, error) {
-    err := godotenv.Load()
-    if err != nil {
-        return nil, fmt.Errorf("error loading .env file: %w", err)
-    }
-
-    dbPort, err := strconv.Atoi(getEnvOrDefault("DB_PORT", "5432"))
-    if err != nil {
-        return nil, fmt.Errorf("invalid DB_PORT: %w", err)
-    }
-
-    smtpPort, err := strconv.Atoi(getEnvOrDefault("SMTP_PORT", "587"))
-    if err != nil {
-        return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
-    }
-
-    return &Config{
-        Port: getEnvOrDefault("PORT", "8080"),
-        Database: DatabaseConfig{
-            Host:     getEnvOrDefault("DB_HOST", "localhost"),
-            Port:     dbPort,
-            User:     getEnvOrDefault("DB_USER", "postgres"),
-            Password: os.Getenv("DB_PASSWORD"),
-            DBName:   getEnvOrDefault("DB_NAME", "userdb"),
-            SSLMode:  getEnvOrDefault("DB_SSL_MODE", "disable"),
-        },
-        Email: EmailConfig{
-            Host:     getEnvOrDefault("SMTP_HOST", "smtp.gmail.com"),
-            Port:     smtpPort,
-            Username: os.Getenv("GMAIL_USER"),
-            Password: os.Getenv("GMAIL_APP_PASSWORD"),
-            From:     os.Getenv("GMAIL_USER"),
-        },
-        JWT: JWTConfig{
-            Secret: getEnvOrDefault("JWT_SECRET", "your-default-secret"),
-        },
-        Frontend: FrontendConfig{
-            URL: getEnvOrDefault("FRONTEND_URL", "http://localhost:3000"),
-        },
-    }, nil
-}
-
-func getEnvOrDefault(key, defaultValue string) string {
-    value := os.Getenv(key)
-    if value == "" {
-        return defaultValue
-    }
-    return value
-}
\ No newline at end of file
+	return Load(nil)
+}
+
+// DSN returns the lib/pq connection string for the database.
+func (c DatabaseConfig) DSN() string {
+	dsn := fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		c.Host,
+		c.Port,
+		c.User,
+		c.Password,
+		c.DBName,
+		c.SSLMode,
+	)
+	if c.ConnectTimeout > 0 {
+		// lib/pq only accepts whole seconds
+		dsn += fmt.Sprintf(" connect_timeout=%d", int(c.ConnectTimeout.Seconds()))
+	}
+	return dsn
+}
+
+// IsProduction reports whether the API runs in production mode.
+func (c *Config) IsProduction() bool {
+	return c.Env == EnvProduction
+}
+
+func (c *Config) normalize() {
+	if c.MFA.EncryptionKey == "" && !c.IsProduction() {
+		c.MFA.EncryptionKey = c.JWT.Secret
+	}
+	if c.Webhooks.EncryptionKey == "" {
+		c.Webhooks.EncryptionKey = c.MFA.EncryptionKey
+	}
+	if c.Log.Format == "" {
+		c.Log.Format = "text"
+		if c.IsProduction() {
+			c.Log.Format = "json"
+		}
+	}
+	if c.Email.From == "" {
+		c.Email.From = c.Email.Username
+	}
+}
+
+// Validate checks the configuration for values the API cannot run with.
+// Production mode is stricter and refuses weak or placeholder secrets.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		add("env must be %q or %q, got %q", EnvDevelopment, EnvProduction, c.Env)
+	}
+	if c.Port == "" {
+		add("port is required")
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		add("log.level must be one of debug, info, warn or error, got %q", c.Log.Level)
+	}
+	if f := strings.ToLower(c.Log.Format); f != "" && f != "json" && f != "text" {
+		add("log.format must be json or text, got %q", c.Log.Format)
+	}
+	if c.Metrics.Enabled && !strings.HasPrefix(c.Metrics.Path, "/") {
+		add("metrics.path must start with /, got %q", c.Metrics.Path)
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		add("tracing.exporter must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sample_ratio must be between 0 and 1")
+	}
+	if c.RateLimit.Store != "memory" && c.RateLimit.Store != "postgres" {
+		add("rate_limit.store must be memory or postgres, got %q", c.RateLimit.Store)
+	}
+	switch c.Password.Algorithm {
+	case "argon2id":
+		if c.Password.Argon2Memory < 8*c.Password.Argon2Threads || c.Password.Argon2Time < 1 ||
+			c.Password.Argon2Threads < 1 || c.Password.Argon2Threads > 255 {
+			add("password.argon2_* parameters are out of range")
+		}
+	case "bcrypt":
+	default:
+		add("password.algorithm must be argon2id or bcrypt, got %q", c.Password.Algorithm)
+	}
+	if c.Password.BcryptCost < 4 || c.Password.BcryptCost > 31 {
+		add("password.bcrypt_cost must be between 4 and 31")
+	}
+	if c.Password.MinLength < 1 {
+		add("password.min_length must be at least 1")
+	}
+	if c.Password.MinScore < 0 || c.Password.MinScore > 4 {
+		add("password.min_score must be between 0 and 4")
+	}
+	if c.Lockout.Threshold > 0 && c.Lockout.Threshold <= c.Lockout.FreeAttempts {
+		add("lockout.threshold must be greater than lockout.free_attempts")
+	}
+	if c.Privacy.DeletedPosts != "reassign" && c.Privacy.DeletedPosts != "delete" {
+		add("privacy.deleted_posts must be reassign or delete, got %q", c.Privacy.DeletedPosts)
+	}
+	if c.Privacy.ExportDir == "" || c.Privacy.ExportTTL <= 0 || c.Privacy.DeletionGracePeriod < 0 || c.Privacy.JobInterval <= 0 {
+		add("privacy.export_dir, export_ttl and job_interval must be set and deletion_grace_period must not be negative")
+	}
+	switch c.Media.Store {
+	case "local":
+		if c.Media.LocalDir == "" {
+			add("media.local_dir is required when media.store is local")
+		}
+	case "s3":
+		if c.Media.S3.Endpoint == "" || c.Media.S3.Bucket == "" || c.Media.S3.Region == "" {
+			add("media.s3.endpoint, bucket and region are required when media.store is s3")
+		}
+	default:
+		add("media.store must be local or s3, got %q", c.Media.Store)
+	}
+	if c.Media.MaxUploadBytes <= 0 || c.Media.MaxPixels <= 0 || c.Media.WorkerInterval <= 0 {
+		add("media.max_upload_bytes, max_pixels and worker_interval must be positive")
+	}
+	if c.Media.ThumbnailSize <= 0 || c.Media.DisplaySize < c.Media.ThumbnailSize || c.Media.DisplaySize > 16384 {
+		add("media.thumbnail_size must be positive and no larger than media.display_size, which is at most 16384")
+	}
+	if c.Posts.Permalinks != "slug" && c.Posts.Permalinks != "date" {
+		add("posts.permalinks must be slug or date, got %q", c.Posts.Permalinks)
+	}
+	if c.SEO.SiteURL != "" {
+		if u, err := url.Parse(c.SEO.SiteURL); err != nil || u.Scheme == "" || u.Host == "" {
+			add("seo.site_url %q must be an absolute URL", c.SEO.SiteURL)
+		}
+	}
+	w := c.Webhooks
+	if w.Timeout <= 0 || w.MaxAttempts <= 0 || w.BackoffBase <= 0 || w.Workers <= 0 || w.WorkerInterval <= 0 || w.Retention <= 0 {
+		add("webhooks.timeout, max_attempts, backoff_base, workers, worker_interval and retention must be positive")
+	}
+	if w.BackoffMax < w.BackoffBase || w.DisableAfter < 0 {
+		add("webhooks.backoff_max must be at least webhooks.backoff_base and webhooks.disable_after must not be negative")
+	}
+	for _, cidr := range w.AllowedNetworks {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			add("webhooks.allowed_networks: %q is not a CIDR range", cidr)
+		}
+	}
+	e := c.Events
+	if e.PollInterval <= 0 || e.MaxAttempts <= 0 || e.BackoffBase <= 0 || e.BackoffMax < e.BackoffBase || e.Retention <= 0 {
+		add("events.poll_interval, max_attempts, backoff_base and retention must be positive, and events.backoff_max at least events.backoff_base")
+	}
+	if c.MagicLink.Enabled && (c.MagicLink.TTL <= 0 || c.MagicLink.CodeAttempts <= 0) {
+		add("magic_link.ttl and magic_link.code_attempts must be positive")
+	}
+	if c.OAuth.CodeTTL <= 0 || c.OAuth.AccessTokenTTL <= 0 || c.OAuth.RefreshTokenTTL <= 0 {
+		add("oauth token lifetimes must be positive")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		add("server.shutdown_timeout must be positive")
+	}
+	if c.Server.TrustProxyHeaders && c.Server.TrustedProxyHops <= 0 {
+		add("server.trusted_proxy_hops must be positive when trusting proxy headers")
+	}
+	if c.Database.Port <= 0 || c.Database.Port > 65535 {
+		add("database.port %d is out of range", c.Database.Port)
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		add("database pool sizes must not be negative")
+	}
+	if c.Email.Port <= 0 || c.Email.Port > 65535 {
+		add("email.port %d is out of range", c.Email.Port)
+	}
+	if u, err := url.Parse(c.Frontend.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		add("frontend.url %q must be an absolute URL", c.Frontend.URL)
+	}
+	if c.JWT.Secret == "" {
+		add("jwt.secret is required")
+	}
+	for _, p := range []OIDCProviderConfig{c.OIDC.Google, c.OIDC.Generic} {
+		if !p.Enabled() {
+			continue
+		}
+		if p.Name == "" || p.Issuer == "" || p.RedirectURL == "" {
+			add("oidc provider %q needs a name, issuer and redirect_url", p.Name)
+		}
+	}
+	if c.OIDC.Google.Enabled() && c.OIDC.Generic.Enabled() && c.OIDC.Google.Name == c.OIDC.Generic.Name {
+		add("oidc providers must have different names")
+	}
+
+	if c.IsProduction() {
+		if c.JWT.Secret != "" && isWeakSecret(c.JWT.Secret) {
+			add("jwt.secret is too weak for production: use at least %d random characters", minSecretLength)
+		}
+		if isWeakSecret(c.MFA.EncryptionKey) {
+			add("mfa.encryption_key is too weak for production: use at least %d random characters", minSecretLength)
+		}
+		if c.Webhooks.EncryptionKey != "" && isWeakSecret(c.Webhooks.EncryptionKey) {
+			add("webhooks.encryption_key is too weak for production: use at least %d random characters", minSecretLength)
+		}
+		if c.Database.Password == "" {
+			add("database.password is required in production")
+		}
+		if c.Database.SSLMode == "disable" {
+			add("database.ssl_mode must not be \"disable\" in production")
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: errs}
+}
+
+// ValidationError lists every problem found by Validate.
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	msg := "invalid configuration:"
+	for _, err := range e.Errors {
+		msg += "\n  - " + err.Error()
+	}
+	return msg
+}
+
+const minSecretLength = 32
+
+// weakSecrets are placeholders that have shipped in examples and docs.
+var weakSecrets = map[string]bool{
+	"your-default-secret": true,
+	"super-secret-key":    true,
+	"secret":              true,
+	"changeme":            true,
+}
+
+func isWeakSecret(secret string) bool {
+	if len(secret) < minSecretLength || weakSecrets[secret] {
+		return true
+	}
+	// A long run of one repeated character is not a secret either
+	distinct := make(map[rune]bool)
+	for _, r := range secret {
+		distinct[r] = true
+	}
+	return len(distinct) < 8
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadLayersFileEnvAndFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "port: \"9000\"\ndatabase:\n  host: file-host\n  user: file-user\nserver:\n  read_timeout: 3s\n"
+	assert.NoError(t, os.WriteFile(path, []byte(file), 0o600))
+
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("DB_HOST", "env-host")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "google-client")
+	t.Setenv("OIDC_GOOGLE_REDIRECT_URL", "http://localhost:8080/api/auth/oidc/google/callback")
+
+	cfg, err := Load([]string{"-config", path, "-database.user", "flag-user"})
+	assert.NoError(t, err)
+	assert.Equal(t, "9000", cfg.Port)
+	assert.Equal(t, "env-host", cfg.Database.Host)
+	assert.Equal(t, "flag-user", cfg.Database.User)
+	assert.Equal(t, 3*time.Second, cfg.Server.ReadTimeout)
+	assert.Equal(t, 5*time.Second, cfg.Server.ReadHeaderTimeout)
+	assert.Equal(t, "google-client", cfg.OIDC.Google.ClientID)
+	assert.Equal(t, "", cfg.OIDC.Generic.ClientID)
+}
+
+func TestValidateRefusesWeakSecretInProduction(t *testing.T) {
+	cfg := Default()
+	cfg.Env = EnvProduction
+	cfg.JWT.Secret = "your-default-secret"
+	cfg.Database.Password = "pw"
+	cfg.Database.SSLMode = "require"
+	cfg.MFA.EncryptionKey = "Zr8u1QeX4mWn7bKc2TgY5pLs9HdJ3vFa"
+	assert.Error(t, cfg.Validate())
+
+	cfg.JWT.Secret = "k3J9v2LxQ8pR7tW1mZ5nB4cY6hD0fG2s"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Env = EnvDevelopment
+	cfg.JWT.Secret = "dev"
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestRedactedHidesSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.JWT.Secret = "top-secret"
+	cfg.Database.Password = "pw"
+
+	out := cfg.Redacted()
+	assert.Equal(t, redacted, out.JWT.Secret)
+	assert.Equal(t, redacted, out.Database.Password)
+	assert.Equal(t, "", out.Email.Password)
+	assert.Equal(t, "top-secret", cfg.JWT.Secret)
+}
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from defaults, an optional config file,
+// environment variables and the given command-line arguments, then
+// validates it.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("blogAPI", flag.ContinueOnError)
+	return LoadWithFlags(fs, args)
+}
+
+// LoadWithFlags is Load with a caller-supplied FlagSet, so commands can
+// register flags of their own next to the configuration flags.
+func LoadWithFlags(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := Default()
+
+	configFile := fs.String("config", "", "path to a YAML or TOML config file (or CONFIG_FILE)")
+	flagValues := make(map[string]*string)
+	walk(reflect.ValueOf(cfg).Elem(), "", "", func(path, _ string, field reflect.StructField, _ reflect.Value) {
+		flagValues[path] = fs.String(path, "", fmt.Sprintf("override %s", path))
+	})
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// A missing .env file is fine, the variables may come from the
+	// environment itself.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error loading .env file: %w", err)
+	}
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(cfg, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		raw, ok := flagValues[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		flagErr = setPath(cfg, f.Name, *raw)
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	cfg.normalize()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		_, err = toml.Decode(string(data), cfg)
+	default:
+		return fmt.Errorf("unsupported config file format %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func applyEnv(cfg *Config) error {
+	var err error
+	walk(reflect.ValueOf(cfg).Elem(), "", "", func(path, key string, field reflect.StructField, value reflect.Value) {
+		if key == "" || err != nil {
+			return
+		}
+		raw := os.Getenv(key)
+		if raw == "" {
+			return
+		}
+		if setErr := setValue(value, raw); setErr != nil {
+			err = fmt.Errorf("invalid %s: %w", key, setErr)
+		}
+	})
+	return err
+}
+
+func setPath(cfg *Config, path, raw string) error {
+	var err error
+	found := false
+	walk(reflect.ValueOf(cfg).Elem(), "", "", func(p, _ string, _ reflect.StructField, value reflect.Value) {
+		if p != path {
+			return
+		}
+		found = true
+		if setErr := setValue(value, raw); setErr != nil {
+			err = fmt.Errorf("invalid -%s: %w", path, setErr)
+		}
+	})
+	if !found {
+		return fmt.Errorf("unknown config key %q", path)
+	}
+	return err
+}
+
+// walk calls fn for every leaf field of the struct v, passing the dotted
+// path made of the fields' yaml keys and the field's environment variable.
+// An env tag on a nested struct prefixes the variables of its fields, so
+// a struct type can be used more than once.
+func walk(v reflect.Value, prefix, envPrefix string, fn func(path, env string, field reflect.StructField, value reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct {
+			walk(value, path, envPrefix+field.Tag.Get("env"), fn)
+			continue
+		}
+		env := field.Tag.Get("env")
+		if env != "" {
+			env = envPrefix + env
+		}
+		fn(path, env, field, value)
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported slice type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the configuration with every secret field
+// that has a value replaced by a placeholder.
+func (c *Config) Redacted() *Config {
+	clone := *c
+	walk(reflect.ValueOf(&clone).Elem(), "", "", func(_, _ string, field reflect.StructField, value reflect.Value) {
+		if field.Tag.Get("secret") == "true" && value.Kind() == reflect.String && value.String() != "" {
+			value.SetString(redacted)
+		}
+	})
+	return &clone
+}
+
+// YAML renders the configuration in the config file format.
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
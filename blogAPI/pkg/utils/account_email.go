@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+)
+
+// SendAccountLockedEmail tells the user their account was locked after too
+// many failed logins and links to the unlock page.
+func SendAccountLockedEmail(ctx context.Context, email, unlockToken string, lockedUntil time.Time, config config.Config) error {
+	content := `
+        <h2>Your account has been locked</h2>
+        <p>Hello,</p>
+        <p>We locked your account after several failed login attempts. It will unlock automatically at {{.LockedUntil}}.</p>
+        <p>If this was you, you can unlock it right away:</p>
+        <p>
+            <a href="{{.UnlockLink}}" class="button">Unlock Account</a>
+        </p>
+        <p>Or copy and paste this link in your browser:</p>
+        <p>{{.UnlockLink}}</p>
+        <p>If this wasn't you, someone may be trying to guess your password. Consider resetting it.</p>`
+
+	data := struct {
+		UnlockLink  string
+		LockedUntil string
+	}{
+		UnlockLink:  fmt.Sprintf("%s/unlock-account?token=%s", config.Frontend.URL, unlockToken),
+		LockedUntil: lockedUntil.UTC().Format("2006-01-02 15:04 MST"),
+	}
+
+	return sendTemplate(ctx, email, EmailTemplate{Subject: "Your account has been locked", Body: content}, data, config)
+}
+
+// SendNewLoginEmail tells the user about a successful login from an IP
+// address or browser we have not seen for their account before.
+func SendNewLoginEmail(ctx context.Context, email, ip, userAgent string, at time.Time, config config.Config) error {
+	content := `
+        <h2>New sign-in to your account</h2>
+        <p>Hello,</p>
+        <p>Your account was just signed in to from a new device or location:</p>
+        <p>
+            Time: {{.Time}}<br>
+            IP address: {{.IP}}<br>
+            Browser: {{.UserAgent}}
+        </p>
+        <p>If this was you, you can ignore this email.</p>
+        <p>If it wasn't, reset your password immediately:</p>
+        <p>
+            <a href="{{.ResetLink}}" class="button">Reset Password</a>
+        </p>`
+
+	data := struct {
+		Time      string
+		IP        string
+		UserAgent string
+		ResetLink string
+	}{
+		Time:      at.UTC().Format("2006-01-02 15:04 MST"),
+		IP:        ip,
+		UserAgent: userAgent,
+		ResetLink: fmt.Sprintf("%s/forgot-password", config.Frontend.URL),
+	}
+
+	return sendTemplate(ctx, email, EmailTemplate{Subject: "New sign-in to your account", Body: content}, data, config)
+}
+
+// SendMagicLinkEmail sends a passwordless sign-in link. The link opens a
+// confirmation page rather than signing in directly, so mail scanners
+// that follow links cannot use it.
+func SendMagicLinkEmail(ctx context.Context, email, token string, expiresAt time.Time, config config.Config) error {
+	content := `
+        <h2>Sign in to your account</h2>
+        <p>Hello,</p>
+        <p>Someone asked to sign in to your account without a password. If it was you, open this link and confirm:</p>
+        <p>
+            <a href="{{.Link}}" class="button">Sign In</a>
+        </p>
+        <p>Or copy and paste this link in your browser:</p>
+        <p>{{.Link}}</p>
+        <p>The link expires at {{.ExpiresAt}}. You will be shown a code to enter on the device where you asked to sign in.</p>
+        <p>If you didn't ask to sign in, ignore this email and never share the code.</p>`
+
+	data := struct {
+		Link      string
+		ExpiresAt string
+	}{
+		Link:      fmt.Sprintf("%s/magic-link?token=%s", config.Frontend.URL, url.QueryEscape(token)),
+		ExpiresAt: expiresAt.UTC().Format("2006-01-02 15:04 MST"),
+	}
+
+	return sendTemplate(ctx, email, EmailTemplate{Subject: "Your sign-in link", Body: content}, data, config)
+}
+
+// SendEmailChangeEmail asks the user to confirm a new email address by
+// following a link sent to it.
+func SendEmailChangeEmail(ctx context.Context, newEmail, token string, expiresAt time.Time, config config.Config) error {
+	content := `
+        <h2>Confirm your new email address</h2>
+        <p>Hello,</p>
+        <p>You asked to use this address for your account. Confirm it to finish the change:</p>
+        <p>
+            <a href="{{.Link}}" class="button">Confirm Email</a>
+        </p>
+        <p>Or copy and paste this link in your browser:</p>
+        <p>{{.Link}}</p>
+        <p>The link expires at {{.ExpiresAt}}. If you didn't ask for this, ignore this email.</p>`
+
+	data := struct {
+		Link      string
+		ExpiresAt string
+	}{
+		Link:      fmt.Sprintf("%s/confirm-email?token=%s", config.Frontend.URL, url.QueryEscape(token)),
+		ExpiresAt: expiresAt.UTC().Format("2006-01-02 15:04 MST"),
+	}
+
+	return sendTemplate(ctx, newEmail, EmailTemplate{Subject: "Confirm your new email address", Body: content}, data, config)
+}
+
+// SendEmailChangeRequestedEmail warns the current address that a change
+// to another one was requested, in case someone else has the session.
+func SendEmailChangeRequestedEmail(ctx context.Context, email, newEmail string, config config.Config) error {
+	content := `
+        <h2>Email change requested</h2>
+        <p>Hello,</p>
+        <p>Someone asked to change the email address of your account to {{.NewEmail}}. The change only happens once that address is confirmed.</p>
+        <p>If this wasn't you, reset your password immediately:</p>
+        <p>
+            <a href="{{.ResetLink}}" class="button">Reset Password</a>
+        </p>`
+
+	data := struct {
+		NewEmail  string
+		ResetLink string
+	}{
+		NewEmail:  newEmail,
+		ResetLink: fmt.Sprintf("%s/forgot-password", config.Frontend.URL),
+	}
+
+	return sendTemplate(ctx, email, EmailTemplate{Subject: "Email change requested", Body: content}, data, config)
+}
+
+// SendDataExportEmail sends the link to download an account data export.
+// The link only works while signed in to the account.
+func SendDataExportEmail(ctx context.Context, email, token string, expiresAt time.Time, config config.Config) error {
+	content := `
+        <h2>Your data export is ready</h2>
+        <p>Hello,</p>
+        <p>The copy of your data you asked for is ready to download:</p>
+        <p>
+            <a href="{{.Link}}" class="button">Download Your Data</a>
+        </p>
+        <p>Or copy and paste this link in your browser:</p>
+        <p>{{.Link}}</p>
+        <p>You will need to be signed in. The link expires at {{.ExpiresAt}}.</p>`
+
+	data := struct {
+		Link      string
+		ExpiresAt string
+	}{
+		Link:      fmt.Sprintf("%s/account/export?token=%s", config.Frontend.URL, url.QueryEscape(token)),
+		ExpiresAt: expiresAt.UTC().Format("2006-01-02 15:04 MST"),
+	}
+
+	return sendTemplate(ctx, email, EmailTemplate{Subject: "Your data export is ready", Body: content}, data, config)
+}
+
+// SendAccountDeletionScheduledEmail confirms that the account will be
+// deleted and tells the user how long they can change their mind.
+func SendAccountDeletionScheduledEmail(ctx context.Context, email string, at time.Time, config config.Config) error {
+	content := `
+        <h2>Your account will be deleted</h2>
+        <p>Hello,</p>
+        <p>As you asked, your account and personal data will be erased at {{.At}}.</p>
+        <p>Changed your mind? Sign in and cancel the deletion before then:</p>
+        <p>
+            <a href="{{.Link}}" class="button">Keep My Account</a>
+        </p>
+        <p>If you didn't ask for this, sign in, cancel the deletion and reset your password.</p>`
+
+	data := struct {
+		At   string
+		Link string
+	}{
+		At:   at.UTC().Format("2006-01-02 15:04 MST"),
+		Link: fmt.Sprintf("%s/account", config.Frontend.URL),
+	}
+
+	return sendTemplate(ctx, email, EmailTemplate{Subject: "Your account will be deleted", Body: content}, data, config)
+}
@@ -2,11 +2,16 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"html/template"
 	"net/smtp"
 
 	"github.com/anoying-kid/go-apps/blogAPI/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type EmailTemplate struct {
@@ -14,9 +19,8 @@ type EmailTemplate struct {
     Body    string
 }
 
-func SendPasswordResetEmail(email, resetToken string, config config.Config) error {
-    // Create HTML template for the email
-    templateStr := `
+// emailLayout wraps the "content" template of every email.
+const emailLayout = `
 <!DOCTYPE html>
 <html>
 <head>
@@ -42,6 +46,15 @@ func SendPasswordResetEmail(email, resetToken string, config config.Config) erro
 </head>
 <body>
     <div class="container">
+        {{template "content" .}}
+        <br>
+        <p>Best regards,<br>Your Application Team</p>
+    </div>
+</body>
+</html>`
+
+func SendPasswordResetEmail(ctx context.Context, email, resetToken string, config config.Config) error {
+    content := `
         <h2>Password Reset Request</h2>
         <p>Hello,</p>
         <p>You have requested to reset your password. Click the button below to set a new password:</p>
@@ -51,37 +64,44 @@ func SendPasswordResetEmail(email, resetToken string, config config.Config) erro
         <p>Or copy and paste this link in your browser:</p>
         <p>{{.ResetLink}}</p>
         <p>This link will expire in 1 hour.</p>
-        <p>If you didn't request this, please ignore this email.</p>
-        <br>
-        <p>Best regards,<br>Your Application Team</p>
-    </div>
-</body>
-</html>`
-
-    // Parse the template
-    t, err := template.New("resetEmail").Parse(templateStr)
-    if err != nil {
-        return fmt.Errorf("error parsing email template: %w", err)
-    }
+        <p>If you didn't request this, please ignore this email.</p>`
 
-    // Prepare template data
     data := struct {
         ResetLink string
     }{
         ResetLink: fmt.Sprintf("%s/reset-password?token=%s", config.Frontend.URL, resetToken),
     }
 
+    return sendTemplate(ctx, email, EmailTemplate{Subject: "Password Reset Request", Body: content}, data, config)
+}
+
+// sendTemplate renders tmpl.Body inside the email layout with data and
+// sends the result to a single recipient.
+func sendTemplate(ctx context.Context, to string, tmpl EmailTemplate, data interface{}, config config.Config) error {
+    // Parse the template
+    t, err := template.New("email").Parse(emailLayout)
+    if err == nil {
+        _, err = t.New("content").Parse(tmpl.Body)
+    }
+    if err != nil {
+        return fmt.Errorf("error parsing email template: %w", err)
+    }
+
     // Execute the template
     var body bytes.Buffer
     if err := t.Execute(&body, data); err != nil {
         return fmt.Errorf("error executing email template: %w", err)
     }
 
+    return sendEmail(ctx, to, tmpl.Subject, body.String(), config)
+}
+
+func sendEmail(ctx context.Context, to, subject, htmlBody string, config config.Config) error {
     // Prepare email headers and body
     headers := make(map[string]string)
-    headers["From"] = config.Email.Username
-    headers["To"] = email
-    headers["Subject"] = "Password Reset Request"
+    headers["From"] = config.Email.From
+    headers["To"] = to
+    headers["Subject"] = subject
     headers["MIME-Version"] = "1.0"
     headers["Content-Type"] = "text/html; charset=utf-8"
 
@@ -90,7 +110,7 @@ func SendPasswordResetEmail(email, resetToken string, config config.Config) erro
     for k, v := range headers {
         message += fmt.Sprintf("%s: %s\r\n", k, v)
     }
-    message += "\r\n" + body.String()
+    message += "\r\n" + htmlBody
 
     // Authentication
     auth := smtp.PlainAuth(
@@ -101,17 +121,25 @@ func SendPasswordResetEmail(email, resetToken string, config config.Config) erro
     )
 
     // Send email
-    err = smtp.SendMail(
+    _, span := otel.Tracer("github.com/anoying-kid/go-apps/blogAPI/pkg/utils").Start(ctx, "smtp.SendMail",
+        trace.WithSpanKind(trace.SpanKindClient),
+        trace.WithAttributes(attribute.String("smtp.host", config.Email.Host)),
+    )
+    defer span.End()
+
+    err := smtp.SendMail(
         fmt.Sprintf("%s:%d", config.Email.Host, config.Email.Port),
         auth,
-        config.Email.Username,
-        []string{email},
+        config.Email.From,
+        []string{to},
         []byte(message),
     )
 
     if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
         return fmt.Errorf("error sending email: %w", err)
     }
 
     return nil
-}
\ No newline at end of file
+}
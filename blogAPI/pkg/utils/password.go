@@ -1,13 +1,49 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"github.com/anoying-kid/go-apps/blogAPI/internal/passhash"
+	"github.com/anoying-kid/go-apps/blogAPI/internal/passpolicy"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordHasher is replaced at boot by SetPasswordHasher with one built
+// from the configuration.
+var passwordHasher = passhash.NewSet(passhash.DefaultArgon2id, passhash.Bcrypt{Cost: bcrypt.DefaultCost})
+
+// SetPasswordHasher sets how passwords are hashed and verified.
+func SetPasswordHasher(set *passhash.Set) {
+	passwordHasher = set
+}
+
+// passwordPolicy is replaced at boot by SetPasswordPolicy with one built
+// from the configuration.
+var passwordPolicy = passpolicy.DefaultPolicy
+
+// SetPasswordPolicy sets the rules new passwords must follow.
+func SetPasswordPolicy(policy *passpolicy.Policy) {
+	passwordPolicy = policy
+}
+
+// ValidatePassword checks a new password against the password policy for
+// an account described by userInputs, such as its username and email. It
+// returns a *passpolicy.Violation when the password is refused.
+func ValidatePassword(password string, userInputs ...string) error {
+	return passwordPolicy.Check(password, userInputs...)
+}
 
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+	return passwordHasher.Hash(password)
 }
 
 func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
\ No newline at end of file
+	ok, _ := VerifyPassword(password, hash)
+	return ok
+}
+
+// VerifyPassword checks password against hash. needsRehash reports that
+// the password matched but hash uses an outdated algorithm or parameters
+// and should be replaced by a fresh HashPassword.
+func VerifyPassword(password, hash string) (ok, needsRehash bool) {
+	ok, needsRehash, err := passwordHasher.Verify(password, hash)
+	return ok && err == nil, needsRehash
+}